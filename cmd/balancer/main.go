@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"go-balancer/internal/audit"
 	"go-balancer/internal/balancer"
 	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/discovery"
 	"math/rand"
 	"net/http"
 	"os"
@@ -27,24 +30,69 @@ func main() {
 	configPath := args[0]
 
 	// pull config
-	config, err := config.ReadConfig(configPath)
+	cfg, err := config.ReadConfig(configPath)
 	if err != nil {
 		fmt.Printf("Error opening config file: %s", err.Error())
 		os.Exit(1)
 	}
 
-	b, err := balancer.NewBalancer(config)
+	// runtime changes saved to a state file override the config file
+	persister, err := config.NewPersister(cfg.Admin.Persist, configPath)
+	if err != nil {
+		fmt.Printf("Error setting up persistence: %s", err.Error())
+		os.Exit(1)
+	}
+	err = persister.Restore(&cfg)
+	if err != nil {
+		fmt.Printf("Error restoring saved state: %s", err.Error())
+		os.Exit(1)
+	}
+
+	b, err := balancer.NewBalancer(cfg)
 	if err != nil {
 		fmt.Printf("Error creating balancer: %s", err.Error())
 		os.Exit(1)
 	}
 
-	modServer := balancer.NewModificationServer(&b)
-	modServer.Start()
+	// discover backends in the background, alongside the static ones
+	providers, err := discovery.NewProviders(cfg.Discovery)
+	if err != nil {
+		fmt.Printf("Error creating discovery: %s", err.Error())
+		os.Exit(1)
+	}
+	for _, p := range providers {
+		discovery.NewReconciler(p, b).Start(context.Background())
+	}
+
+	auditLog, err := audit.NewLog(cfg.Admin.Audit)
+	if err != nil {
+		fmt.Printf("Error opening audit log: %s", err.Error())
+		os.Exit(1)
+	}
+
+	modServer := balancer.NewModificationServer(b, cfg.Admin, auditLog, persister)
+	err = modServer.Start()
+	if err != nil {
+		fmt.Printf("Error starting modification server: %s", err.Error())
+		os.Exit(1)
+	}
+
+	handler := http.HandlerFunc(b.ServeHTTP)
+	s := balancer.NewServer(cfg.Server, cfg.Port, handler)
+
+	// HTTPS is served alongside, if configured
+	if cfg.Server.TLS.Port != 0 {
+		tlsServer, err := balancer.NewTLSServer(cfg.Server, handler)
+		if err != nil {
+			fmt.Printf("Error creating TLS server: %s", err.Error())
+			os.Exit(1)
+		}
 
-	s := &http.Server{
-		Addr:    fmt.Sprintf(":%d", config.Port),
-		Handler: http.HandlerFunc(b.ServeHTTP),
+		go func() {
+			err := tlsServer.ListenAndServeTLS("", "")
+			fmt.Printf("Error serving TLS: %s", err.Error())
+			os.Exit(1)
+		}()
 	}
 
 	// Listens on port for http connections
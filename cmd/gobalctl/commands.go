@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-balancer/internal/adminclient"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A command, which registers its own flags and returns the function running it.
+type command struct {
+	// The command's arguments, shown in its usage.
+	args  string
+	setup func(fs *flag.FlagSet) func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"backends list":       {"[--tag TAG] [--zone ZONE] [--alive true|false]", listBackends},
+	"backends add":        {"HOST:PORT [--id ID] [--name NAME] [--weight N] [--zone ZONE] [--tags A,B] [--protocol http1|h2c|h2]", addBackend},
+	"backends remove":     {"ID...", removeBackends},
+	"backends drain":      {"ID", drainBackend(true)},
+	"backends enable":     {"ID", drainBackend(false)},
+	"backends set-weight": {"ID WEIGHT", setBackendWeight},
+	"strategy get":        {"", getStrategy},
+	"strategy set":        {"NAME [--properties JSON]", setStrategy},
+	"health":              {"", getHealth},
+	"stats":               {"", getStats},
+	"config export":       {"", exportConfig},
+}
+
+func listBackends(fs *flag.FlagSet) func(c *cli, args []string) error {
+	tag := fs.String("tag", "", "")
+	zone := fs.String("zone", "", "")
+	alive := fs.String("alive", "", "")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return usageError("Unexpected arguments.")
+		}
+		if *alive != "" && *alive != "true" && *alive != "false" {
+			return usageError(fmt.Sprintf("Invalid --alive '%s', expected true or false.", *alive))
+		}
+
+		query := url.Values{}
+		for key, value := range map[string]string{"tag": *tag, "zone": *zone, "alive": *alive} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+
+		backends, raw, err := c.client.ListBackends(query)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.writeJSON(raw)
+		}
+		return writeBackendTable(c.out, backends)
+	}
+}
+
+func addBackend(fs *flag.FlagSet) func(c *cli, args []string) error {
+	id := fs.String("id", "", "")
+	name := fs.String("name", "", "")
+	weight := fs.Int("weight", 0, "")
+	zone := fs.String("zone", "", "")
+	tags := fs.String("tags", "", "")
+	protocol := fs.String("protocol", "", "")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usageError("Expected the backend's HOST:PORT.")
+		}
+
+		host, portString, err := net.SplitHostPort(args[0])
+		if err != nil {
+			return usageError(fmt.Sprintf("Invalid address '%s', expected HOST:PORT.", args[0]))
+		}
+		port, err := strconv.Atoi(portString)
+		if err != nil {
+			return usageError(fmt.Sprintf("Invalid port '%s'.", portString))
+		}
+
+		var info config.BackendInfo
+		info.ID = *id
+		info.Name = *name
+		info.Host = host
+		info.Port = port
+		info.Weight = *weight
+		info.Zone = *zone
+		info.Protocol = *protocol
+		if *tags != "" {
+			info.Tags = strings.Split(*tags, ",")
+		}
+
+		added, raw, err := c.client.AddBackend(info)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.writeJSON(raw)
+		}
+		return writeBackendTable(c.out, []adminclient.Backend{added})
+	}
+}
+
+// Removes each backend in turn, stopping at the first which fails.
+func removeBackends(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) == 0 {
+			return usageError("Expected at least one backend ID.")
+		}
+
+		for _, id := range args {
+			err := c.client.RemoveBackend(id)
+			if err != nil {
+				return fmt.Errorf("Failed to remove backend '%s': %s", id, err.Error())
+			}
+			if !c.json {
+				fmt.Fprintf(c.out, "Removed backend %s\n", id)
+			}
+		}
+		return nil
+	}
+}
+
+// Drains a backend, or enables it again.
+func drainBackend(drain bool) func(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(fs *flag.FlagSet) func(c *cli, args []string) error {
+		return func(c *cli, args []string) error {
+			if len(args) != 1 {
+				return usageError("Expected one backend ID.")
+			}
+			return c.updateBackend(args[0], adminclient.BackendUpdate{Drain: &drain})
+		}
+	}
+}
+
+func setBackendWeight(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) != 2 {
+			return usageError("Expected a backend ID and weight.")
+		}
+
+		weight, err := strconv.Atoi(args[1])
+		if err != nil || weight < 0 {
+			return usageError(fmt.Sprintf("Invalid weight '%s', expected a whole number of 0 or more.", args[1]))
+		}
+		return c.updateBackend(args[0], adminclient.BackendUpdate{Weight: &weight})
+	}
+}
+
+func (c *cli) updateBackend(id string, update adminclient.BackendUpdate) error {
+	updated, raw, err := c.client.UpdateBackend(id, update)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(raw)
+	}
+	return writeBackendTable(c.out, []adminclient.Backend{updated})
+}
+
+func getStrategy(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return usageError("Unexpected arguments.")
+		}
+
+		strategy, raw, err := c.client.GetStrategy()
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.writeJSON(raw)
+		}
+		return writeStrategy(c.out, strategy)
+	}
+}
+
+func setStrategy(fs *flag.FlagSet) func(c *cli, args []string) error {
+	properties := fs.String("properties", "", "")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usageError("Expected the strategy's name.")
+		}
+
+		strategy := config.StrategyConfig{Name: args[0]}
+		if *properties != "" {
+			err := json.Unmarshal([]byte(*properties), &strategy.Properties)
+			if err != nil {
+				return usageError(fmt.Sprintf("Invalid --properties, expected JSON: %s", err.Error()))
+			}
+		}
+
+		changed, raw, err := c.client.SetStrategy(strategy)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.writeJSON(raw)
+		}
+		return writeStrategy(c.out, changed)
+	}
+}
+
+// Shows the balancer's health, failing if it is unhealthy so scripts can check it.
+func getHealth(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return usageError("Unexpected arguments.")
+		}
+
+		health, raw, err := c.client.Health()
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			err = c.writeJSON(raw)
+		} else {
+			err = writeHealth(c.out, health)
+		}
+		if err != nil {
+			return err
+		}
+
+		if health.Status == "unhealthy" {
+			return errUnhealthy
+		}
+		return nil
+	}
+}
+
+func getStats(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return usageError("Unexpected arguments.")
+		}
+
+		stats, raw, err := c.client.Stats()
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.writeJSON(raw)
+		}
+		return writeStats(c.out, stats)
+	}
+}
+
+// Prints the config as YAML, whatever the output, so it can be saved as a config file.
+func exportConfig(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return usageError("Unexpected arguments.")
+		}
+
+		document, err := c.client.ExportConfig()
+		if err != nil {
+			return err
+		}
+		_, err = c.out.Write(document)
+		return err
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go-balancer/internal/adminclient"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: gobalctl [flags] <command> [args]
+
+Commands:
+  backends list [--tag TAG] [--zone ZONE] [--alive true|false]
+  backends add HOST:PORT [--id ID] [--name NAME] [--weight N] [--zone ZONE] [--tags A,B] [--protocol http1|h2c|h2]
+  backends remove ID...
+  backends drain ID
+  backends enable ID
+  backends set-weight ID WEIGHT
+  strategy get
+  strategy set NAME [--properties JSON]
+  health
+  stats
+  config export
+
+Flags, which can be given anywhere:
+  --addr ADDR     the admin address: host:port, a url or unix:/path/to.sock
+                  (default $GOBAL_ADDR, or localhost:44444)
+  --token TOKEN   the admin token (default $GOBAL_TOKEN)
+  --actor NAME    who changes are made by, for the audit log (default $GOBAL_ACTOR, or $USER)
+  -o, --output    table or json (default table)
+`
+
+// Options shared by every command.
+type options struct {
+	addr   string
+	token  string
+	actor  string
+	output string
+}
+
+// The global flags which take a value, so their values arent mistaken for command names.
+var valueFlags = map[string]bool{"addr": true, "token": true, "actor": true, "o": true, "output": true}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Runs a command, returning the exit code:
+// 0 on success, 1 if the command failed (or the balancer is unhealthy) and 2 for invalid usage.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	name, cmd, ok := findCommand(args)
+	if !ok {
+		if name == "" || name == "help" {
+			fmt.Fprint(stdout, usage)
+			return 0
+		}
+		fmt.Fprintf(stderr, "Unknown command '%s'.\n\n%s", name, usage)
+		return 2
+	}
+
+	var opts options
+	fs := newFlagSet(name, &opts)
+	action := cmd.setup(fs)
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n\n%s", err.Error(), usage)
+		return 2
+	}
+	// drop the command's own words
+	positional = positional[len(strings.Fields(name)):]
+
+	if opts.output != "table" && opts.output != "json" {
+		fmt.Fprintf(stderr, "Unknown output '%s', expected table or json.\n", opts.output)
+		return 2
+	}
+
+	client, err := adminclient.New(opts.addr, opts.token, opts.actor)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err.Error())
+		return 2
+	}
+
+	c := &cli{client: client, out: stdout, json: opts.output == "json"}
+	err = action(c, positional)
+
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "%s\nUsage: gobalctl %s %s\n", usageErr.Error(), name, cmd.args)
+		return 2
+	case errors.Is(err, errUnhealthy):
+		return 1
+	case err != nil:
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// Finds the command named by the first words of the arguments which arent flags.
+// Returns the words tried if there is no such command.
+func findCommand(args []string) (string, command, bool) {
+	words := []string{}
+	for i := 0; i < len(args) && len(words) < 2; i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			// skip the value of a global flag given as a separate argument
+			flagName := strings.TrimLeft(arg, "-")
+			if valueFlags[flagName] && !strings.Contains(arg, "=") {
+				i++
+			}
+			continue
+		}
+		words = append(words, arg)
+
+		if cmd, ok := commands[strings.Join(words, " ")]; ok {
+			return strings.Join(words, " "), cmd, true
+		}
+	}
+	return strings.Join(words, " "), command{}, false
+}
+
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	defaultActor := os.Getenv("GOBAL_ACTOR")
+	if defaultActor == "" {
+		defaultActor = os.Getenv("USER")
+	}
+
+	fs.StringVar(&opts.addr, "addr", envOr("GOBAL_ADDR", "localhost:44444"), "")
+	fs.StringVar(&opts.token, "token", os.Getenv("GOBAL_TOKEN"), "")
+	fs.StringVar(&opts.actor, "actor", defaultActor, "")
+	fs.StringVar(&opts.output, "output", "table", "")
+	fs.StringVar(&opts.output, "o", "table", "")
+
+	return fs
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Parses flags given anywhere among the arguments, not only before the first positional one.
+// Returns the positional arguments, in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// An error in how a command was used, shown with the command's usage.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// Returned by the health command when the balancer is unhealthy, to exit with 1 without an error message.
+var errUnhealthy = errors.New("Unhealthy.")
+
+// Where commands run, with the client and how to show results.
+type cli struct {
+	client *adminclient.Client
+	out    io.Writer
+	json   bool
+}
+
+// Writes a raw JSON response, indented.
+func (c *cli) writeJSON(raw []byte) error {
+	var v interface{}
+	err := json.Unmarshal(raw, &v)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-balancer/internal/adminclient"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func newTable(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+}
+
+func writeBackendTable(out io.Writer, backends []adminclient.Backend) error {
+	table := newTable(out)
+	fmt.Fprintln(table, "ID\tNAME\tADDRESS\tWEIGHT\tSTATUS\tCONNECTIONS\tUPGRADED\tCIRCUIT\tZONE\tTAGS\tSOURCE")
+
+	for _, b := range backends {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			b.ID,
+			orDash(b.Name),
+			net.JoinHostPort(b.Host, strconv.Itoa(b.Port)),
+			b.Weight,
+			backendStatus(b),
+			b.Connections,
+			b.UpgradedConnections,
+			b.Circuit,
+			orDash(b.Zone),
+			orDash(strings.Join(b.Tags, ",")),
+			orDash(b.Source),
+		)
+	}
+
+	return table.Flush()
+}
+
+// Describes whether a backend gets requests. A draining backend isnt alive, but isnt dead either.
+func backendStatus(b adminclient.Backend) string {
+	switch {
+	case b.Draining:
+		return "draining"
+	case b.Alive:
+		return "alive"
+	default:
+		return "dead"
+	}
+}
+
+func writeStrategy(out io.Writer, strategy config.StrategyConfig) error {
+	table := newTable(out)
+	fmt.Fprintf(table, "Strategy:\t%s\n", strategy.Name)
+
+	if strategy.Properties != nil {
+		properties, err := json.Marshal(strategy.Properties)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(table, "Properties:\t%s\n", properties)
+	}
+
+	return table.Flush()
+}
+
+func writeHealth(out io.Writer, health adminclient.Health) error {
+	table := newTable(out)
+	fmt.Fprintf(table, "Status:\t%s\n", health.Status)
+	fmt.Fprintf(table, "Backends:\t%d\n", health.Backends.Total)
+	fmt.Fprintf(table, "  Alive:\t%d\n", health.Backends.Alive)
+	fmt.Fprintf(table, "  Dead:\t%d\n", health.Backends.Dead)
+	fmt.Fprintf(table, "  Circuit open:\t%d\n", health.Backends.CircuitOpen)
+	return table.Flush()
+}
+
+func writeStats(out io.Writer, stats adminclient.Stats) error {
+	table := newTable(out)
+	fmt.Fprintf(table, "Uptime:\t%s\n", time.Duration(stats.UptimeSeconds)*time.Second)
+	fmt.Fprintf(table, "Goroutines:\t%d\n", stats.Goroutines)
+	fmt.Fprintf(table, "Requests:\t%d\n", stats.Requests.Total)
+	fmt.Fprintf(table, "  Rate limited:\t%d\n", stats.Requests.RateLimited)
+	fmt.Fprintf(table, "  Queue rejected:\t%d\n", stats.Requests.QueueRejected)
+	fmt.Fprintf(table, "  No backend:\t%d\n", stats.Requests.NoBackend)
+	fmt.Fprintf(table, "  Failed:\t%d\n", stats.Requests.Failed)
+	fmt.Fprintf(table, "Queue:\t%d/%d\n", stats.Queue.Depth, stats.Queue.MaxDepth)
+	fmt.Fprintf(table, "  Average wait:\t%dms\n", stats.Queue.AverageWaitMs)
+	fmt.Fprintf(table, "  Rejected:\t%d\n", stats.Queue.Rejected)
+	fmt.Fprintf(table, "  Timed out:\t%d\n", stats.Queue.TimedOut)
+	fmt.Fprintf(table, "  Cancelled:\t%d\n", stats.Queue.Cancelled)
+	err := table.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(stats.Backends) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	table = newTable(out)
+	fmt.Fprintln(table, "ID\tALIVE\tCONNECTIONS\tREQUESTS\tFAILURES\tCIRCUIT")
+	for _, b := range stats.Backends {
+		fmt.Fprintf(table, "%s\t%t\t%d\t%d\t%d\t%s\n", b.ID, b.Alive, b.Connections, b.Requests, b.Failures, b.Circuit)
+	}
+	return table.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -2,6 +2,14 @@ module go-balancer
 
 go 1.19
 
-require gopkg.in/yaml.v3 v3.0.1 // direct
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/net v0.35.0
+	gopkg.in/yaml.v3 v3.0.1 // direct
+)
 
-require gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+require (
+	golang.org/x/text v0.22.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
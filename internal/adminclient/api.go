@@ -0,0 +1,149 @@
+package adminclient
+
+import (
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/url"
+)
+
+// A backend, as the API describes it: its info along with its current state.
+type Backend struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Zone   string            `json:"zone,omitempty"`
+
+	MaxRequestsPerSecond float64                   `json:"maxRequestsPerSecond,omitempty"`
+	MaxConnections       int                       `json:"maxConnections"`
+	HealthCheck          *config.HealthCheckConfig `json:"healthCheck,omitempty"`
+	Protocol             string                    `json:"protocol"`
+	TLS                  *config.BackendTLSConfig  `json:"tls,omitempty"`
+	Source               string                    `json:"source,omitempty"`
+
+	Alive               bool   `json:"alive"`
+	Draining            bool   `json:"draining"`
+	Connections         int    `json:"connections"`
+	UpgradedConnections int    `json:"upgradedConnections"`
+	Circuit             string `json:"circuit"`
+}
+
+type backendList struct {
+	Backends []Backend `json:"backends"`
+}
+
+// Changes to a backend, where nil fields are left as they are.
+type BackendUpdate struct {
+	Weight *int  `json:"weight,omitempty"`
+	Drain  *bool `json:"drain,omitempty"`
+}
+
+type Health struct {
+	// "healthy", "degraded" or "unhealthy"
+	Status   string `json:"status"`
+	Backends struct {
+		Total       int `json:"total"`
+		Alive       int `json:"alive"`
+		Dead        int `json:"dead"`
+		CircuitOpen int `json:"circuitOpen"`
+	} `json:"backends"`
+}
+
+type Stats struct {
+	UptimeSeconds int64 `json:"uptimeSeconds"`
+	Goroutines    int   `json:"goroutines"`
+
+	Requests struct {
+		Total         uint64 `json:"total"`
+		RateLimited   uint64 `json:"rateLimited"`
+		QueueRejected uint64 `json:"queueRejected"`
+		NoBackend     uint64 `json:"noBackend"`
+		Failed        uint64 `json:"failed"`
+	} `json:"requests"`
+
+	Queue struct {
+		Depth         int   `json:"depth"`
+		MaxDepth      int   `json:"maxDepth"`
+		TimeoutMs     int64 `json:"timeoutMs"`
+		AverageWaitMs int64 `json:"averageWaitMs"`
+		Rejected      int   `json:"rejected"`
+		TimedOut      int   `json:"timedOut"`
+		Cancelled     int   `json:"cancelled"`
+	} `json:"queue"`
+
+	Backends []struct {
+		ID          string `json:"id"`
+		Alive       bool   `json:"alive"`
+		Connections int    `json:"connections"`
+		Requests    uint64 `json:"requests"`
+		Failures    uint64 `json:"failures"`
+		Circuit     string `json:"circuit"`
+	} `json:"backends"`
+}
+
+// Each method returns the decoded response along with the raw response, so it can be shown as sent.
+
+// Lists the backends, filtered by the tag, zone and alive query parameters if given.
+func (c *Client) ListBackends(query url.Values) ([]Backend, []byte, error) {
+	path := "/backends"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var list backendList
+	raw, err := c.doJSON(http.MethodGet, path, nil, &list)
+	return list.Backends, raw, err
+}
+
+func (c *Client) AddBackend(info config.BackendInfo) (Backend, []byte, error) {
+	var added Backend
+	raw, err := c.doJSON(http.MethodPost, "/backends", info, &added)
+	return added, raw, err
+}
+
+func (c *Client) RemoveBackend(id string) error {
+	_, err := c.Do(http.MethodDelete, backendPath(id), nil)
+	return err
+}
+
+// Changes a backend's weight, or drains or enables it.
+func (c *Client) UpdateBackend(id string, update BackendUpdate) (Backend, []byte, error) {
+	var updated Backend
+	raw, err := c.doJSON(http.MethodPatch, backendPath(id), update, &updated)
+	return updated, raw, err
+}
+
+func (c *Client) GetStrategy() (config.StrategyConfig, []byte, error) {
+	var strategy config.StrategyConfig
+	raw, err := c.doJSON(http.MethodGet, "/strategy", nil, &strategy)
+	return strategy, raw, err
+}
+
+func (c *Client) SetStrategy(strategy config.StrategyConfig) (config.StrategyConfig, []byte, error) {
+	var changed config.StrategyConfig
+	raw, err := c.doJSON(http.MethodPut, "/strategy", strategy, &changed)
+	return changed, raw, err
+}
+
+// Gets the balancer's health. An unhealthy balancer isnt an error.
+func (c *Client) Health() (Health, []byte, error) {
+	var health Health
+	raw, err := c.doJSON(http.MethodGet, "/health", nil, &health, http.StatusOK, http.StatusServiceUnavailable)
+	return health, raw, err
+}
+
+func (c *Client) Stats() (Stats, []byte, error) {
+	var stats Stats
+	raw, err := c.doJSON(http.MethodGet, "/stats", nil, &stats)
+	return stats, raw, err
+}
+
+// Exports the current config as YAML, with secrets hidden.
+func (c *Client) ExportConfig() ([]byte, error) {
+	return c.Do(http.MethodGet, "/config", nil)
+}
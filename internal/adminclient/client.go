@@ -0,0 +1,166 @@
+package adminclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// A client of the balancer's modification API.
+type Client struct {
+	// The url the API's paths are relative to, e.g. "http://localhost:44444/api/v1".
+	baseURL string
+	client  *http.Client
+
+	// Sent as a bearer token, if set.
+	token string
+	// Who changes are made by, recorded in the audit log. Empty for the server's default.
+	actor string
+}
+
+// Creates a client of the modification server at an address, which is one of:
+//   - a url, e.g. "http://localhost:44444" or "https://gobal.internal"
+//   - a host and port, e.g. "localhost:44444", using http
+//   - a unix socket path, e.g. "unix:/run/gobal.sock"
+func New(addr string, token string, actor string) (*Client, error) {
+	c := &Client{
+		client: &http.Client{Timeout: defaultTimeout},
+		token:  token,
+		actor:  actor,
+	}
+
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		if path == "" {
+			return nil, fmt.Errorf("Missing unix socket path in '%s'.", addr)
+		}
+
+		var dialer net.Dialer
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		// the host is ignored, every request goes to the socket
+		c.baseURL = "http://gobal/api/v1"
+
+	case strings.Contains(addr, "://"):
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid address '%s', expected an http or https url.", addr)
+		}
+		c.baseURL = strings.TrimSuffix(u.String(), "/") + "/api/v1"
+
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("Invalid address '%s', expected host:port, a url or unix:/path.", addr)
+		}
+		c.baseURL = "http://" + addr + "/api/v1"
+	}
+
+	return c, nil
+}
+
+// An error response from the API.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// Sends a request to a path of the API, with a JSON body unless body is nil.
+// Returns the response body, or an *APIError if the response has an unexpected status.
+// Any of the accepted statuses are successes, or any 2xx status if none are given.
+func (c *Client) Do(method string, path string, body interface{}, accepted ...int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.actor != "" {
+		req.Header.Set("X-Actor", c.actor)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if isAccepted(res.StatusCode, accepted) {
+		return data, nil
+	}
+
+	var envelope struct {
+		Error APIError `json:"error"`
+	}
+	if json.Unmarshal(data, &envelope) != nil || envelope.Error.Message == "" {
+		envelope.Error = APIError{Status: res.StatusCode, Message: fmt.Sprintf("Unexpected response '%s'", res.Status)}
+	}
+	return nil, &envelope.Error
+}
+
+func isAccepted(status int, accepted []int) bool {
+	if len(accepted) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range accepted {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a request as Do, decoding the JSON response into out.
+// Returns the raw response too, so it can be shown as it was sent.
+func (c *Client) doJSON(method string, path string, body interface{}, out interface{}, accepted ...int) ([]byte, error) {
+	data, err := c.Do(method, path, body, accepted...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid response from %s %s: %s", method, path, err.Error())
+	}
+	return data, nil
+}
+
+// Escapes a backend ID for use in a path.
+func backendPath(id string) string {
+	return "/backends/" + url.PathEscape(id)
+}
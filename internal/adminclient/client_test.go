@@ -0,0 +1,147 @@
+package adminclient
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// A request as the test server saw it.
+type seenRequest struct {
+	method string
+	path   string
+	auth   string
+	actor  string
+	body   string
+}
+
+// Starts a server which records each request and responds with the status and body given.
+func newTestServer(t *testing.T, status int, body string) (*httptest.Server, *seenRequest) {
+	seen := &seenRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		*seen = seenRequest{r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), r.Header.Get("X-Actor"), string(data)}
+
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, seen
+}
+
+func TestNew(t *testing.T) {
+	cases := map[string]string{
+		"localhost:44444":         "http://localhost:44444/api/v1",
+		"http://localhost:44444":  "http://localhost:44444/api/v1",
+		"https://gobal.internal/": "https://gobal.internal/api/v1",
+		"unix:/run/gobal.sock":    "http://gobal/api/v1",
+		"unix:///run/gobal.sock":  "http://gobal/api/v1",
+	}
+	for addr, expected := range cases {
+		c, err := New(addr, "", "")
+		if err != nil || c.baseURL != expected {
+			t.Errorf("Failed %s: got %v %v expected %s", addr, c, err, expected)
+		}
+	}
+
+	for _, addr := range []string{"localhost", "ftp://localhost", "unix:", "http://"} {
+		_, err := New(addr, "", "")
+		if err == nil {
+			t.Errorf("Failed invalid address %s: got no error expected one", addr)
+		}
+	}
+}
+
+func TestClientRequest(t *testing.T) {
+	server, seen := newTestServer(t, http.StatusOK, `{"id":"a b","weight":3,"draining":true}`)
+
+	c, err := New(server.URL, "secret", "alice")
+	if err != nil {
+		t.Fatalf("Failed creating client: %s", err.Error())
+	}
+
+	weight := 3
+	updated, raw, err := c.UpdateBackend("a b", BackendUpdate{Weight: &weight})
+	if err != nil {
+		t.Fatalf("Failed update: %s", err.Error())
+	}
+
+	expected := seenRequest{http.MethodPatch, "/api/v1/backends/a%20b", "Bearer secret", "alice", `{"weight":3}`}
+	if *seen != expected {
+		t.Errorf("Failed request: got %+v expected %+v", *seen, expected)
+	}
+	if updated.ID != "a b" || updated.Weight != 3 || !updated.Draining {
+		t.Errorf("Failed decoding response: got %+v expected a b with weight 3 draining", updated)
+	}
+	if string(raw) != `{"id":"a b","weight":3,"draining":true}` {
+		t.Errorf("Failed raw response: got %s expected it as sent", raw)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusNotFound, `{"error":{"status":404,"code":"not_found","message":"No backend with id 'x'."}}`)
+
+	c, _ := New(server.URL, "", "")
+	err := c.RemoveBackend("x")
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 404 || apiErr.Code != "not_found" {
+		t.Fatalf("Failed error envelope: got %v expected a 404 not_found APIError", err)
+	}
+	if err.Error() != "No backend with id 'x'. (404)" {
+		t.Errorf("Failed error message: got %s expected No backend with id 'x'. (404)", err.Error())
+	}
+
+	// errors without an envelope still have their status
+	server, _ = newTestServer(t, http.StatusBadGateway, "bad gateway")
+	c, _ = New(server.URL, "", "")
+	_, _, err = c.Stats()
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadGateway {
+		t.Errorf("Failed plain error: got %v expected a 502 APIError", err)
+	}
+}
+
+// Tests an unhealthy balancer's 503 is returned as health, not an error.
+func TestClientUnhealthy(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusServiceUnavailable, `{"status":"unhealthy","backends":{"total":1,"dead":1}}`)
+
+	c, _ := New(server.URL, "", "")
+	health, _, err := c.Health()
+	if err != nil || health.Status != "unhealthy" || health.Backends.Dead != 1 {
+		t.Errorf("Failed unhealthy: got %+v %v expected unhealthy with 1 dead", health, err)
+	}
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed listening on unix socket: %s", err.Error())
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "ROUND_ROBIN", "properties": map[string]string{"path": r.URL.Path}})
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	c, err := New("unix:"+path, "", "")
+	if err != nil {
+		t.Fatalf("Failed creating client: %s", err.Error())
+	}
+
+	strategy, _, err := c.GetStrategy()
+	if err != nil || strategy.Name != "ROUND_ROBIN" {
+		t.Fatalf("Failed request over unix socket: got %+v %v expected ROUND_ROBIN", strategy, err)
+	}
+	if path := strategy.Properties.(map[string]interface{})["path"]; path != "/api/v1/strategy" {
+		t.Errorf("Failed unix socket path: got %v expected /api/v1/strategy", path)
+	}
+}
@@ -0,0 +1,201 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxEntries = 1000
+
+// A record of one change made through the modification API.
+type Entry struct {
+	// Increases by one with each entry, including across restarts when written to a file.
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+
+	// Who made the change, as they identified themselves.
+	Actor string `json:"actor"`
+	// The IP address the change was requested from.
+	RemoteIP string `json:"remoteIp"`
+
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// The generation of the config after the change.
+	Generation uint64   `json:"generation"`
+	Changes    []Change `json:"changes"`
+}
+
+// Filters entries when querying the log. Zero values match every entry.
+type Query struct {
+	// Only entries with IDs after this.
+	AfterID uint64
+	// Only entries at or after this time.
+	Since time.Time
+	Actor string
+	// Only entries with request paths starting with this.
+	PathPrefix string
+	// The most entries returned, keeping the most recent.
+	Limit int
+}
+
+// An append-only log of changes.
+//
+// Entries are appended to a file as JSON lines, if one is configured,
+// and the most recent are kept in memory to be queried.
+type Log struct {
+	file *os.File
+
+	// The most recent entries, oldest first
+	entries    []Entry
+	maxEntries int
+	nextID     uint64
+
+	m sync.Mutex
+}
+
+// Creates a log from its config.
+// If the file already exists, its most recent entries are loaded and new entries are appended after them.
+func NewLog(cfg config.AuditConfig) (*Log, error) {
+	l := &Log{
+		maxEntries: cfg.MaxEntries,
+		nextID:     1,
+	}
+	if l.maxEntries <= 0 {
+		l.maxEntries = defaultMaxEntries
+	}
+
+	if cfg.Path == "" {
+		return l, nil
+	}
+
+	err := l.load(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	l.file, err = os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Opening audit log failed: %s", err.Error())
+	}
+
+	return l, nil
+}
+
+// Reads the entries already in a log file.
+func (l *Log) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Opening audit log failed: %s", err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return fmt.Errorf("Parsing audit log %s failed on line %d: %s", path, line, err.Error())
+		}
+		l.keep(entry)
+	}
+
+	return scanner.Err()
+}
+
+// Keeps an entry in memory, forgetting the oldest if there are too many.
+// Assumes the caller holds the lock, or the log isnt shared yet.
+func (l *Log) keep(entry Entry) {
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.maxEntries {
+		l.entries = l.entries[len(l.entries)-l.maxEntries:]
+	}
+
+	if entry.ID >= l.nextID {
+		l.nextID = entry.ID + 1
+	}
+}
+
+// Appends an entry, giving it the next ID and the current time.
+// The entry is kept in memory even if writing it to the file fails.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	entry.ID = l.nextID
+	entry.Time = time.Now().UTC()
+	if entry.Changes == nil {
+		entry.Changes = []Change{}
+	}
+	l.keep(entry)
+
+	if l.file == nil {
+		return entry, nil
+	}
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	_, err = l.file.Write(append(encoded, '\n'))
+	if err != nil {
+		return entry, fmt.Errorf("Writing audit log failed: %s", err.Error())
+	}
+	return entry, nil
+}
+
+// Gets the entries kept in memory which match a query, oldest first.
+func (l *Log) Query(q Query) []Entry {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	matched := []Entry{}
+	for _, entry := range l.entries {
+		if entry.ID <= q.AfterID || entry.Time.Before(q.Since) {
+			continue
+		}
+		if q.Actor != "" && entry.Actor != q.Actor {
+			continue
+		}
+		if !strings.HasPrefix(entry.Path, q.PathPrefix) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	return matched
+}
+
+// Closes the log's file. Later entries are only kept in memory.
+func (l *Log) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"go-balancer/internal/balancer/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkEntryIDs(t *testing.T, name string, entries []Entry, expected []uint64) {
+	got := make([]uint64, len(entries))
+	for i, entry := range entries {
+		got[i] = entry.ID
+	}
+
+	if len(got) != len(expected) {
+		t.Errorf("Failed %s: got %v expected %v", name, got, expected)
+		return
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("Failed %s: got %v expected %v", name, got, expected)
+			return
+		}
+	}
+}
+
+func TestLogQuery(t *testing.T) {
+	l, _ := NewLog(config.AuditConfig{})
+
+	l.Append(Entry{Actor: "alice", Method: "POST", Path: "/api/v1/backends"})
+	l.Append(Entry{Actor: "bob", Method: "PUT", Path: "/api/v1/strategy"})
+	l.Append(Entry{Actor: "alice", Method: "DELETE", Path: "/api/v1/backends/a"})
+
+	checkEntryIDs(t, "all entries", l.Query(Query{}), []uint64{1, 2, 3})
+	checkEntryIDs(t, "actor filter", l.Query(Query{Actor: "alice"}), []uint64{1, 3})
+	checkEntryIDs(t, "path filter", l.Query(Query{PathPrefix: "/api/v1/backends"}), []uint64{1, 3})
+	checkEntryIDs(t, "after filter", l.Query(Query{AfterID: 1}), []uint64{2, 3})
+	checkEntryIDs(t, "limit", l.Query(Query{Limit: 2}), []uint64{2, 3})
+	checkEntryIDs(t, "since filter", l.Query(Query{Since: time.Now().Add(time.Hour)}), []uint64{})
+}
+
+func TestLogMaxEntries(t *testing.T) {
+	l, _ := NewLog(config.AuditConfig{MaxEntries: 2})
+
+	for i := 0; i < 5; i++ {
+		l.Append(Entry{Actor: "alice"})
+	}
+
+	// the oldest are forgotten, but IDs keep increasing
+	checkEntryIDs(t, "max entries", l.Query(Query{}), []uint64{4, 5})
+}
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := NewLog(config.AuditConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed opening log: %s", err.Error())
+	}
+	l.Append(Entry{Actor: "alice", Changes: []Change{{Path: "strategy.name", Before: "ROUND_ROBIN", After: "LEAST_CONN"}}})
+	l.Append(Entry{Actor: "bob"})
+	l.Close()
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"after":"LEAST_CONN"`) {
+		t.Fatalf("Failed writing log: got %s expected 2 JSON lines", data)
+	}
+
+	// reopening keeps the history and carries on from the last ID
+	l, err = NewLog(config.AuditConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed reopening log: %s", err.Error())
+	}
+	defer l.Close()
+
+	entry, _ := l.Append(Entry{Actor: "carol"})
+	if entry.ID != 3 {
+		t.Errorf("Failed continuing ids: got %d expected 3", entry.ID)
+	}
+
+	entries := l.Query(Query{})
+	checkEntryIDs(t, "reopened log", entries, []uint64{1, 2, 3})
+	if entries[0].Changes[0].Before != "ROUND_ROBIN" {
+		t.Errorf("Failed reading changes: got %+v expected ROUND_ROBIN before", entries[0].Changes)
+	}
+}
+
+func TestLogInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	os.WriteFile(path, []byte("not json\n"), 0600)
+
+	_, err := NewLog(config.AuditConfig{Path: path})
+	if err == nil {
+		t.Errorf("Failed invalid file: got no error")
+	}
+}
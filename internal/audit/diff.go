@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// A value which changed, by its dotted path in the state, e.g. "backends.web-1.weight".
+// Before is missing if the value was added, and After if it was removed.
+type Change struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Gets the values which differ between two states, ordered by path.
+// The states are compared by their JSON form, so only exported fields are compared.
+func Diff(before interface{}, after interface{}) ([]Change, error) {
+	beforeValues, err := flatten(before)
+	if err != nil {
+		return nil, err
+	}
+	afterValues, err := flatten(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	for path, value := range beforeValues {
+		if afterValue, ok := afterValues[path]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changes = append(changes, Change{Path: path, Before: value, After: afterValue})
+		}
+	}
+	for path, value := range afterValues {
+		if _, ok := beforeValues[path]; !ok {
+			changes = append(changes, Change{Path: path, After: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// Gets the leaf values of a state's JSON form by their dotted paths.
+// Empty objects and lists are leaves, so adding an empty one is still a change.
+func flatten(state interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	flattenInto(values, "", decoded)
+	return values, nil
+}
+
+func flattenInto(values map[string]interface{}, path string, value interface{}) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && path != "" {
+			values[path] = v
+		}
+		for key, child := range v {
+			flattenInto(values, join(key), child)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			values[path] = v
+		}
+		for i, child := range v {
+			flattenInto(values, join(strconv.Itoa(i)), child)
+		}
+	default:
+		values[path] = v
+	}
+}
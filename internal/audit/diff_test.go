@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testState struct {
+	Backends map[string]testBackend `json:"backends"`
+	Strategy string                 `json:"strategy"`
+	Limits   []int                  `json:"limits"`
+}
+
+type testBackend struct {
+	Host   string `json:"host"`
+	Weight int    `json:"weight"`
+}
+
+func TestDiff(t *testing.T) {
+	before := testState{
+		Backends: map[string]testBackend{
+			"a": {Host: "10.0.0.1", Weight: 1},
+			"b": {Host: "10.0.0.2", Weight: 1},
+		},
+		Strategy: "ROUND_ROBIN",
+		Limits:   []int{10},
+	}
+	after := testState{
+		Backends: map[string]testBackend{
+			"a": {Host: "10.0.0.1", Weight: 3},
+			"c": {Host: "10.0.0.3", Weight: 1},
+		},
+		Strategy: "ROUND_ROBIN",
+		Limits:   []int{},
+	}
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Failed diff: %s", err.Error())
+	}
+
+	expected := []Change{
+		{Path: "backends.a.weight", Before: 1.0, After: 3.0},
+		{Path: "backends.b.host", Before: "10.0.0.2"},
+		{Path: "backends.b.weight", Before: 1.0},
+		{Path: "backends.c.host", After: "10.0.0.3"},
+		{Path: "backends.c.weight", After: 1.0},
+		{Path: "limits", After: []interface{}{}},
+		{Path: "limits.0", Before: 10.0},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Failed diff: got %+v expected %+v", changes, expected)
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	state := testState{Strategy: "LEAST_CONN", Limits: []int{1, 2}}
+
+	changes, err := Diff(state, state)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Failed unchanged diff: got %+v %v expected no changes", changes, err)
+	}
+}
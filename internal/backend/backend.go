@@ -2,20 +2,69 @@ package backend
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/ratelimit"
+	"io"
+	"math"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type backend struct {
+	// A stable identifier, which doesnt change as other backends are added or removed
+	id string
+
 	host string
 	port int
 
 	url *url.URL
 
+	// The fields below the address can be changed in place, while requests are being served,
+	// so the backend keeps its health, connections and statistics. See update.
+
+	weight atomic.Int64
+
+	// The info the backend was last created or updated from, for its descriptive fields (name, tags, zone, ...)
+	info atomic.Pointer[config.BackendInfo]
+
+	// How the backend is health checked, zero values use the monitor's defaults
+	healthCheck atomic.Pointer[config.HealthCheckConfig]
+
+	// Caps the rate of requests sent to the backend, nil if uncapped.
+	rateCap atomic.Pointer[ratelimit.TokenBucket]
+
+	// Sends requests to the backend with its protocol, nil to use the default HTTP/1.1 transport.
+	transport http.RoundTripper
+	// Sends upgrade requests, which need HTTP/1.1 whatever the backend's protocol, nil to use the default transport.
+	upgradeTransport http.RoundTripper
+
+	// The number of requests currently being served by the backend.
+	// Upgraded connections, such as WebSockets, are counted in upgrades instead once the backend accepts them.
+	activeConnections atomic.Int64
+	// The connections upgraded to another protocol, which are closed when the backend is removed or drained.
+	upgrades *upgradeSet
+	// The number of requests sent to the backend, and how many of them failed to get a response.
+	requests atomic.Uint64
+	failures atomic.Uint64
+	// The total time spent serving requests, in nanoseconds.
+	latency atomic.Int64
+	// Caps the number of requests served at once, 0 if uncapped.
+	maxConnections atomic.Int64
+
+	// Whether the backend is draining, so it gets no new requests.
+	// Changed in place, so the requests in flight can still be seen finishing.
+	draining atomic.Bool
+
+	// Blocks requests while the backend is failing, nil if disabled.
+	// Atomic so it can be replaced while requests are being served.
+	breaker atomic.Pointer[circuitBreaker]
+
 	alive  bool
 	rwLock sync.RWMutex
 }
@@ -23,75 +72,468 @@ type backend struct {
 type BackendRef = *backend
 
 // Creates a new backend from a BackendInfo object
-func newBackend(info config.BackendInfo) *backend {
-	return &backend{
-		host:  info.Host,
-		port:  info.Port,
-		url:   info.URL,
-		alive: true,
+func newBackend(info config.BackendInfo, breakerCfg config.CircuitBreakerConfig) *backend {
+	b := &backend{
+		id:       info.GetID(),
+		host:     info.Host,
+		port:     info.Port,
+		url:      info.URL,
+		upgrades: newUpgradeSet(),
+		alive:    true,
+	}
+	b.transport, b.upgradeTransport = newTransports(info)
+	b.breaker.Store(newCircuitBreaker(breakerCfg))
+	b.draining.Store(info.Drain)
+	b.update(info)
+
+	return b
+}
+
+// Reports whether the backend can be changed to match an info in place, rather than being replaced.
+// Only its ID and how it is connected to (its address, protocol and TLS) need to stay the same.
+func (b *backend) canUpdateTo(info config.BackendInfo) bool {
+	current := b.info.Load()
+	return b.id == info.GetID() && b.host == info.Host && b.port == info.Port &&
+		current.GetProtocol() == info.GetProtocol() && reflect.DeepEqual(current.TLS, info.TLS)
+}
+
+// Changes the backend's settings (weight, caps, health check, descriptive fields) to match an info,
+// keeping its health, circuit breaker, connections and statistics.
+// The info must describe the same address, see canUpdateTo. Draining is set separately.
+func (b *backend) update(info config.BackendInfo) {
+	previous := b.info.Load()
+	b.info.Store(&info)
+
+	b.weight.Store(int64(info.GetWeight()))
+	b.maxConnections.Store(int64(info.MaxConnections))
+
+	healthCheck := config.HealthCheckConfig{}
+	if info.HealthCheck != nil {
+		healthCheck = *info.HealthCheck
+	}
+	b.healthCheck.Store(&healthCheck)
+
+	// an unchanged cap keeps its bucket, so changing anything else doesnt refill it
+	if previous == nil || previous.MaxRequestsPerSecond != info.MaxRequestsPerSecond {
+		b.rateCap.Store(newRateCap(info.MaxRequestsPerSecond))
 	}
 }
 
-// Copies from another backend with a new mutex
-func copyBackend(other *backend) backend {
-	return backend{
-		host:  other.host,
-		port:  other.port,
-		url:   other.url,
-		alive: true,
+// Creates a token bucket capping requests per second, or nil if there is no cap.
+// Allows bursts of up to a second's worth of requests.
+func newRateCap(maxRequestsPerSecond float64) *ratelimit.TokenBucket {
+	if maxRequestsPerSecond <= 0 {
+		return nil
 	}
+
+	return ratelimit.NewTokenBucket(maxRequestsPerSecond, int(math.Ceil(maxRequestsPerSecond)))
 }
 
 func (b *backend) Equal(other *backend) bool {
-	return b.host == other.host && b.port == other.port
+	return b.id == other.id
+}
+
+// The JSON form of a backend, its info along with its current state.
+type backendJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Zone   string            `json:"zone,omitempty"`
+
+	MaxRequestsPerSecond float64                   `json:"maxRequestsPerSecond,omitempty"`
+	MaxConnections       int                       `json:"maxConnections"`
+	HealthCheck          *config.HealthCheckConfig `json:"healthCheck,omitempty"`
+	Protocol             string                    `json:"protocol"`
+	TLS                  *config.BackendTLSConfig  `json:"tls,omitempty"`
+	// The discovery provider which found the backend, if it was discovered
+	Source string `json:"source,omitempty"`
+
+	Alive       bool `json:"alive"`
+	Draining    bool `json:"draining"`
+	Connections int  `json:"connections"`
+	// Connections upgraded to another protocol, such as WebSockets
+	UpgradedConnections int    `json:"upgradedConnections"`
+	Circuit             string `json:"circuit"`
 }
 
 func (b *backend) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("{\"host\":\"%s\",\"port\":\"%d\",\"alive\":%t}", b.host, b.port, b.alive)), nil
+	info := b.info.Load()
+
+	return json.Marshal(backendJSON{
+		ID:                   b.id,
+		Name:                 info.Name,
+		Host:                 b.host,
+		Port:                 b.port,
+		Weight:               b.GetWeight(),
+		Tags:                 info.Tags,
+		Labels:               info.Labels,
+		Zone:                 info.Zone,
+		MaxRequestsPerSecond: info.MaxRequestsPerSecond,
+		MaxConnections:       info.MaxConnections,
+		HealthCheck:          info.HealthCheck,
+		Protocol:             info.GetProtocol(),
+		TLS:                  info.TLS,
+		Source:               info.Source,
+		Alive:                b.isAlive(),
+		Draining:             b.draining.Load(),
+		Connections:          b.GetActiveConnections(),
+		UpgradedConnections:  b.GetUpgradedConnections(),
+		Circuit:              b.GetCircuitState(),
+	})
+}
+
+// Gets the state of the backend's circuit breaker as a string.
+// Always "closed" if the breaker is disabled.
+func (b *backend) GetCircuitState() string {
+	breaker := b.breaker.Load()
+	if breaker == nil {
+		return circuitClosed.String()
+	}
+	return breaker.getState().String()
+}
+
+// Gets a stable identifier for the backend.
+// Unlike its index, this does not change as other backends are added or removed.
+func (b *backend) GetID() string {
+	return b.id
 }
 
 func (b *backend) GetURL() *url.URL {
 	return b.url
 }
 
+// Gets the backend's name, or its ID if it has no name.
+func (b *backend) GetName() string {
+	if name := b.info.Load().Name; name != "" {
+		return name
+	}
+	return b.id
+}
+
+// Gets the backend's share of requests relative to other backends, at least 1.
+func (b *backend) GetWeight() int {
+	return int(b.weight.Load())
+}
+
+func (b *backend) GetZone() string {
+	return b.info.Load().Zone
+}
+
+// Reports whether the backend has a tag.
+func (b *backend) HasTag(tag string) bool {
+	for _, t := range b.info.Load().Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the value of a label, or false if the backend doesnt have it.
+func (b *backend) GetLabel(key string) (string, bool) {
+	value, ok := b.info.Load().Labels[key]
+	return value, ok
+}
+
+// Gets the info the backend was created or last updated from, with whether it is currently draining.
+func (b *backend) GetInfo() config.BackendInfo {
+	info := *b.info.Load()
+	info.Drain = b.draining.Load()
+	return info
+}
+
+// Gets how the backend is health checked, zero values use the monitor's defaults.
+func (b *backend) getHealthCheck() config.HealthCheckConfig {
+	return *b.healthCheck.Load()
+}
+
+// Reports whether the backend is draining.
+func (b *backend) GetDraining() bool {
+	return b.draining.Load()
+}
+
+// Sets whether the backend is draining, reporting whether this changed it.
+// Upgraded connections are asked to close when it starts draining, as they would otherwise never finish.
+func (b *backend) setDraining(draining bool) bool {
+	changed := b.draining.Swap(draining) != draining
+	if changed && draining {
+		b.upgrades.shutdownAll()
+	}
+	return changed
+}
+
+// Reports whether the backend can currently be given requests.
+//
+// This is false if the backend is dead or draining, its circuit breaker is open,
+// or it has reached its requests per second or connection cap.
+// Strategies use this to skip backends when choosing.
 func (b *backend) GetAlive() bool {
+	if !b.isAlive() || b.draining.Load() {
+		return false
+	}
+
+	if breaker := b.breaker.Load(); breaker != nil && !breaker.canAttempt() {
+		return false
+	}
+
+	if maxConnections := b.maxConnections.Load(); maxConnections > 0 && b.activeConnections.Load() >= maxConnections {
+		return false
+	}
+
+	rateCap := b.rateCap.Load()
+	return rateCap == nil || rateCap.Available()
+}
+
+// Reserves one of the backend's connection slots for a request, reporting false if it is at its connection cap.
+// The slot is held until the request is served, so a backend is never sent more requests at once than its cap,
+// however many requests saw it as available at the same time.
+func (b *backend) ReserveConnection() bool {
+	maxConnections := b.maxConnections.Load()
+	if maxConnections <= 0 {
+		b.activeConnections.Add(1)
+		return true
+	}
+
+	for {
+		current := b.activeConnections.Load()
+		if current >= maxConnections {
+			return false
+		}
+		if b.activeConnections.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+// Gives back a connection slot reserved with ReserveConnection.
+func (b *backend) releaseConnection() {
+	b.activeConnections.Add(-1)
+}
+
+// Reports whether the backend is alive, ignoring its circuit breaker and caps.
+// Unlike GetAlive, this only changes with health checks and failed requests.
+func (b *backend) GetHealthy() bool {
+	return b.isAlive()
+}
+
+// Gets the number of requests currently being served by the backend.
+func (b *backend) GetActiveConnections() int {
+	return int(b.activeConnections.Load())
+}
+
+// Gets the number of connections to the backend upgraded to another protocol, such as WebSockets.
+func (b *backend) GetUpgradedConnections() int {
+	return b.upgrades.count()
+}
+
+// Gets the number of requests sent to the backend, and how many of them failed to get a response.
+func (b *backend) GetRequestCounts() (uint64, uint64) {
+	return b.requests.Load(), b.failures.Load()
+}
+
+// Gets the total time spent serving requests sent to the backend.
+func (b *backend) GetTotalLatency() time.Duration {
+	return time.Duration(b.latency.Load())
+}
+
+// Closes the backend's idle connections, once it has been removed.
+// Connections in use are closed once their requests finish and they have been idle a while.
+func (b *backend) closeIdleConnections() {
+	for _, transport := range []http.RoundTripper{b.transport, b.upgradeTransport} {
+		if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+// Reports whether the backend is alive, ignoring any caps.
+func (b *backend) isAlive() bool {
 	b.rwLock.RLock()
 	defer b.rwLock.RUnlock()
 
 	return b.alive
 }
 
-func (b *backend) setAlive(alive bool) {
+// Sets whether the backend is alive, reporting whether this changed it.
+func (b *backend) setAlive(alive bool) bool {
 	b.rwLock.Lock()
+	defer b.rwLock.Unlock()
 
+	changed := b.alive != alive
 	b.alive = alive
 
-	b.rwLock.Unlock()
+	return changed
 }
 
-type reverseProxyErrorHandler = func(http.ResponseWriter, *http.Request, error)
-
-func (b *backend) newProxy(errorHandler reverseProxyErrorHandler) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(b.url)
-	proxy.ErrorHandler = errorHandler
+// Reverse proxies the request to the backend, flushing and limiting the bodies as the proxy config describes.
+// The caller must have reserved a connection slot with ReserveConnection, which is released once the request is served.
+// modifyResponse may be nil, else it is called on the backend response before it is copied to w.
+// onUpgrade may be nil, else it is called once the backend accepts an upgrade, when the request
+// becomes a long lived connection. The request is counted as finished then, rather than once the connection closes.
+//
+// Returns ErrCircuitOpen without sending the request if the circuit breaker does not allow it.
+// Returns ErrRequestTooLarge or ErrResponseTooLarge if a body is over its limit, which arent counted as failures,
+// nor are errors from the request being cancelled, e.g. by the client going away.
+func (b *backend) serveHTTP(w http.ResponseWriter, r *http.Request, cfg config.ProxyConfig, modifyResponse reverseProxyResponseModifier, onUpgrade func()) error {
+	// the connection the request was upgraded to, if it was
+	var upgraded *upgradedConn
 
-	return proxy
-}
+	// upgraded requests give back their slot once the backend accepts the upgrade
+	defer func() {
+		if upgraded == nil {
+			b.releaseConnection()
+		}
+	}()
 
-func (b *backend) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 	var proxyError error = nil
+	// an error caused by the request rather than the backend
+	var requestError error = nil
+
+	// limit the request body, rejecting it straight away if it is already known to be too large
+	var requestBody *limitedBody
+	if cfg.MaxRequestBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > cfg.MaxRequestBodySize {
+			return ErrRequestTooLarge
+		}
+
+		requestBody = newLimitedBody(r.Body, cfg.MaxRequestBodySize, ErrRequestTooLarge)
+		r = r.WithContext(r.Context())
+		r.Body = requestBody
+	}
+
+	// when the request was sent, and how long it took to be served
+	var served time.Time
+	latency := time.Duration(0)
+
+	upgrade := IsUpgradeRequest(r)
+	if upgrade {
+		recorder := &hijackRecorder{ResponseWriter: w}
+		w = recorder
+
+		innerModifyResponse := modifyResponse
+		modifyResponse = func(res *http.Response) error {
+			if innerModifyResponse != nil {
+				err := innerModifyResponse(res)
+				if err != nil {
+					return err
+				}
+			}
+
+			// the proxy reports a body it cant use itself
+			body, ok := res.Body.(io.ReadWriteCloser)
+			if res.StatusCode != http.StatusSwitchingProtocols || !ok {
+				return nil
+			}
+
+			upgraded = newUpgradedConn(body, isWebSocketResponse(res), b.upgrades)
+			recorder.upgraded = upgraded
+			res.Body = upgraded
+
+			// the request is done, the connection is counted as upgraded from now on
+			b.releaseConnection()
+			latency = time.Since(served)
+			if onUpgrade != nil {
+				onUpgrade()
+			}
+			return nil
+		}
+	}
+
+	if breaker := b.breaker.Load(); breaker != nil {
+		token, ok := breaker.acquire()
+		if !ok {
+			return ErrCircuitOpen
+		}
+
+		// record the time to the response headers, and whether the backend errored
+		start := time.Now()
+		latency := time.Duration(0)
+		status := 0
+		recorded := false
+
+		innerModifyResponse := modifyResponse
+		modifyResponse = func(res *http.Response) error {
+			latency = time.Since(start)
+			status = res.StatusCode
+
+			if innerModifyResponse != nil {
+				err := innerModifyResponse(res)
+				if err != nil {
+					return err
+				}
+			}
+
+			// upgrades succeed once accepted, rather than holding up the breaker while open
+			if upgraded != nil {
+				breaker.record(token, true, latency)
+				recorded = true
+			}
+			return nil
+		}
+
+		defer func() {
+			if recorded {
+				return
+			}
+			// the request rather than the backend was the problem, so the backend has neither passed nor failed
+			if proxyError == nil && requestError != nil {
+				breaker.release(token)
+				return
+			}
+			if latency == 0 {
+				latency = time.Since(start)
+			}
+			breaker.record(token, proxyError == nil && status < 500, latency)
+		}()
+	}
+
+	// count the request against the cap
+	// this can overshoot slightly if concurrent requests both saw the backend as available
+	if rateCap := b.rateCap.Load(); rateCap != nil {
+		rateCap.Take()
+	}
 
 	// Create a new proxy for the backend, attaching an error handler
-	proxy := b.newProxy(func(w http.ResponseWriter, r *http.Request, err error) {
-		proxyError = err
-	})
+	proxy := b.newProxy(cfg, upgrade, func(_ http.ResponseWriter, _ *http.Request, err error) {
+		switch {
+		case requestBody != nil && requestBody.exceeded.Load():
+			requestError = ErrRequestTooLarge
+		case errors.Is(err, ErrResponseTooLarge), r.Context().Err() != nil:
+			requestError = err
+		default:
+			proxyError = err
+		}
+	}, modifyResponse)
 
 	// Use the proxy to serve the request
+	b.requests.Add(1)
+	served = time.Now()
 	proxy.ServeHTTP(w, r)
 
-	return proxyError
+	if upgraded != nil {
+		// the proxy doesnt close it if it failed to switch protocols
+		upgraded.Close()
+	} else {
+		latency = time.Since(served)
+	}
+	b.latency.Add(int64(latency))
+
+	if proxyError != nil {
+		b.failures.Add(1)
+		return proxyError
+	}
+
+	return requestError
 }
 
+// A read only view of a snapshot of the backend list.
+// The snapshot never changes, so indexes into it stay valid while the backend manager is modified.
 type ReadonlyBackendList struct {
 	list *[]*backend
 }
@@ -115,6 +557,17 @@ func (l ReadonlyBackendList) IndexOf(other *backend) int {
 	return -1
 }
 
+// Linear search for the backend with an ID
+// Returns -1 if not present
+func (l ReadonlyBackendList) IndexOfID(id string) int {
+	for i := range *l.list {
+		if (*l.list)[i].GetID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
 func (l ReadonlyBackendList) MarshalJSON() ([]byte, error) {
 	encodedObjs := make([][]byte, len(*l.list))
 
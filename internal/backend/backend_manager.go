@@ -5,20 +5,35 @@ import (
 	"go-balancer/internal/balancer/config"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type BackendManager struct {
-	backends []*backend
+	// The current snapshot of the backend list.
+	// Modifications publish a new list rather than changing this one, so readers never need a lock.
+	backends atomic.Pointer[[]*backend]
 
 	monitor backendMonitor
 
-	ConnectionStartCallback func(backendIndex int)
-	ConnectionEndCallback   func(backendIndex int)
+	// Called on the backend's response before it is written back to the client.
+	// Returning an error fails the request as if the backend errored.
+	// Must be set before any requests are served.
+	ModifyResponseCallback func(b BackendRef, res *http.Response) error
 
-	ModifyRequestCallback func(backendIndex int, r *http.Request) *http.Request
+	// Called when a backend is reported dead, or alive again.
+	// Must be set before any requests are served.
+	HealthChangeCallback func(b BackendRef, alive bool)
 
-	modifyMutex *sync.RWMutex
+	// The config used to create circuit breakers for new backends.
+	circuitBreakerConfig config.CircuitBreakerConfig
+
+	// How requests and responses are proxied.
+	// Must be set before any requests are served.
+	proxyConfig config.ProxyConfig
+
+	// Serialises modifications to the backend list
+	modifyMutex sync.Mutex
 }
 
 // Creates a new backend manager, with backends from a list of config.BackendInfo's
@@ -26,13 +41,11 @@ func NewBackendManager(infos []config.BackendInfo) *BackendManager {
 	backends := make([]*backend, len(infos))
 
 	for i, u := range infos {
-		backends[i] = newBackend(u)
+		backends[i] = newBackend(u, config.CircuitBreakerConfig{})
 	}
 
-	bm := &BackendManager{
-		backends:    backends,
-		modifyMutex: &sync.RWMutex{},
-	}
+	bm := &BackendManager{}
+	bm.backends.Store(&backends)
 
 	bm.monitor = backendMonitor{
 		// Taking this ptr to the "local" bm variable is ok,
@@ -49,118 +62,269 @@ func NewBackendManager(infos []config.BackendInfo) *BackendManager {
 	return bm
 }
 
+// Sets the circuit breaker config, replacing the breakers of all current backends.
+// The new breakers start closed.
+func (bm *BackendManager) SetCircuitBreakerConfig(cfg config.CircuitBreakerConfig) {
+	bm.modifyMutex.Lock()
+	defer bm.modifyMutex.Unlock()
+
+	bm.circuitBreakerConfig = cfg
+
+	for _, b := range *bm.backends.Load() {
+		b.breaker.Store(newCircuitBreaker(cfg))
+	}
+}
+
+// Sets how requests and responses are proxied, such as how often responses are flushed.
+// Must be called before any requests are served.
+func (bm *BackendManager) SetProxyConfig(cfg config.ProxyConfig) {
+	bm.proxyConfig = cfg
+}
+
 func (bm *BackendManager) GetBackendCount() int {
-	return len(bm.backends)
+	return len(*bm.backends.Load())
 }
 
+// Gets the current snapshot of the backend list.
+// Later modifications do not affect it.
 func (bm *BackendManager) GetBackends() ReadonlyBackendList {
 	return ReadonlyBackendList{
-		list: &bm.backends,
+		list: bm.backends.Load(),
 	}
 }
 
+// Gets a backend from the current snapshot of the backend list.
 func (bm *BackendManager) GetBackend(index int) *backend {
-	return bm.backends[index]
+	return (*bm.backends.Load())[index]
 }
 
-func (bm *BackendManager) ServeRequestWithBackend(backendIndex int, w http.ResponseWriter, r *http.Request) error {
-	bm.modifyMutex.RLock()
-	defer bm.modifyMutex.RUnlock()
-
-	if bm.ConnectionStartCallback != nil {
-		bm.ConnectionStartCallback(backendIndex)
+// Reports whether any backend is alive, even if it is currently at a cap.
+func (bm *BackendManager) HasLiveBackends() bool {
+	for _, b := range *bm.backends.Load() {
+		if b.isAlive() {
+			return true
+		}
 	}
+	return false
+}
 
-	if bm.ModifyRequestCallback != nil {
-		r = bm.ModifyRequestCallback(backendIndex, r)
+// Reverse proxies a request to a backend, which the caller has reserved a connection slot on with ReserveConnection.
+// The slot is released once the request is served.
+// The backend does not need to still be in the backend list, so requests can finish on removed backends.
+// onUpgrade may be nil, else it is called if the backend accepts an upgrade (e.g. to a WebSocket),
+// once the request has become a long lived connection.
+func (bm *BackendManager) ServeRequestWithBackend(b BackendRef, w http.ResponseWriter, r *http.Request, onUpgrade func()) error {
+	var modifyResponse reverseProxyResponseModifier = nil
+	if bm.ModifyResponseCallback != nil {
+		modifyResponse = func(res *http.Response) error {
+			return bm.ModifyResponseCallback(b, res)
+		}
 	}
 
-	err := bm.backends[backendIndex].serveHTTP(w, r)
-
-	if bm.ConnectionEndCallback != nil {
-		bm.ConnectionEndCallback(backendIndex)
-	}
+	return b.serveHTTP(w, r, bm.proxyConfig, modifyResponse, onUpgrade)
+}
 
-	return err
+// Reports whether a backend response is streamed to the client rather than sent in one go,
+// as it was upgraded to a long lived connection or is a stream such as server-sent events.
+func (bm *BackendManager) IsStreamedResponse(res *http.Response) bool {
+	return res.StatusCode == http.StatusSwitchingProtocols || isStreamed(res, bm.proxyConfig.StreamContentTypes)
 }
 
 // Sets the status of a backend to dead.
 // Used when a request to a backend fails, so we want to mark it as dead and not use it in future.
 // This also starts a dead checker, periodically testing the backend to see if it comes back up.
 //
-// Assumes no changes will be made to the backend list between calling and finishing
-// (the caller should have already locked the bm)
-func (bm *BackendManager) ReportBackendDead(index int) {
-	if bm.backends[index].alive {
-		bm.backends[index].setAlive(false)
-		bm.monitor.BackendDead(bm.backends[index])
+// If the backend has been removed, it is not checked.
+func (bm *BackendManager) ReportBackendDead(b BackendRef) {
+	if !b.setAlive(false) {
+		return
+	}
+	bm.healthChanged(b, false)
+
+	if bm.GetBackends().IndexOf(b) != -1 {
+		bm.monitor.BackendDead(b)
 	}
 }
 
 // Sets the status of a backend to alive.
 // Used when a previously dead backend is succesfully accessed by the BackendMonitor.
 // If the backend given has been deleted, nothing happens.
-//
-// Assumes no changes will be made to the backend list between calling and finishing
-// (the caller should have already locked the bm)
-func (bm *BackendManager) ReportBackendAlive(index int) {
-	bm.backends[index].setAlive(true)
+func (bm *BackendManager) ReportBackendAlive(b BackendRef) {
+	if b.setAlive(true) {
+		bm.healthChanged(b, true)
+	}
+}
+
+func (bm *BackendManager) healthChanged(b BackendRef, alive bool) {
+	if bm.HealthChangeCallback != nil {
+		bm.HealthChangeCallback(b, alive)
+	}
+}
+
+// Sets whether a backend is draining, in place, reporting whether this changed it.
+// A draining backend gets no new requests, but those in flight finish.
+// Its upgraded connections, such as WebSockets, are asked to close.
+func (bm *BackendManager) SetBackendDraining(b BackendRef, draining bool) bool {
+	return b.setDraining(draining)
+}
+
+// Returned when adding a backend whose ID or url is already used by another backend.
+type BackendExistsError struct {
+	// The ID of the backend being added
+	ID string
+	// The url of the backend being added, set if it was the url that clashed
+	URL string
+}
+
+func (e *BackendExistsError) Error() string {
+	if e.URL != "" {
+		return fmt.Sprintf("Error adding backends: url '%s' already exists.", e.URL)
+	}
+	return fmt.Sprintf("Error adding backends: id '%s' already exists.", e.ID)
 }
 
 // Creates new backends and adds them to the list
-// Returns an error if a url already exists in a backend
+// Returns a *BackendExistsError, adding none of them, if an ID or url already exists in a backend
 func (bm *BackendManager) AddBackends(infos []config.BackendInfo) error {
+	_, _, _, err := bm.ModifyBackends(nil, infos)
+	return err
+}
+
+// Removes backends by ID, or by url for infos without an ID
+// Returns the backends which were removed, in the order they were in the list.
+// Requests already using them are allowed to finish.
+// No errors: if a url doesnt exist, it is skipped silently
+func (bm *BackendManager) RemoveBackends(infos []config.BackendInfo) []BackendRef {
+	removed, _, _, _ := bm.ModifyBackends(infos, nil)
+	return removed
+}
+
+// Removes and adds backends in one step, so requests never see the list part way through.
+// Backends to remove are matched as by RemoveBackends, and those to add are checked against the backends left.
+//
+// A backend which is removed and added again with the same ID, address, protocol and TLS (e.g. to change its weight)
+// is updated in place instead, keeping its place in the list, health, circuit breaker, connections and statistics.
+//
+// Returns the removed, added and updated backends.
+// If any added backend clashes, returns a *BackendExistsError and nothing is changed.
+//
+// Upgraded connections to removed backends are asked to close, unless the backend is replaced by one
+// with the same ID and address (e.g. to change its protocol), which takes them over.
+func (bm *BackendManager) ModifyBackends(remove []config.BackendInfo, add []config.BackendInfo) ([]BackendRef, []BackendRef, []BackendRef, error) {
 	bm.modifyMutex.Lock()
 	defer bm.modifyMutex.Unlock()
 
-	newBackends := make([]*backend, len(infos))
-	for i, bi := range infos {
-		for _, bj := range bm.backends {
+	current := *bm.backends.Load()
+
+	// build a new list, so requests using the current snapshot are unaffected
+	backends := make([]*backend, 0, len(current)+len(add))
+	removed := make([]BackendRef, 0, len(remove))
+
+	// backends removed and added again which can be updated in place, with the info they are updated to
+	updated := make([]BackendRef, 0)
+	updates := make([]config.BackendInfo, 0)
+	toAdd := make([]config.BackendInfo, 0, len(add))
+
+	for _, bj := range current {
+		isRemoved := false
+		for _, bi := range remove {
 			if compareBackendToInfo(bj, &bi) {
-				return fmt.Errorf("Error adding backends: url '%s' already exists.", bi.URL.String())
+				isRemoved = true
+				break
 			}
 		}
-		newBackends[i] = newBackend(bi)
+
+		if isRemoved {
+			for _, bi := range add {
+				if bj.canUpdateTo(bi) {
+					updated = append(updated, bj)
+					updates = append(updates, bi)
+					isRemoved = false
+					break
+				}
+			}
+		}
+
+		if isRemoved {
+			removed = append(removed, bj)
+		} else {
+			backends = append(backends, bj)
+		}
 	}
 
-	bm.backends = append(bm.backends, newBackends...)
+	for _, bi := range add {
+		isUpdate := false
+		for _, info := range updates {
+			if info.GetID() == bi.GetID() {
+				isUpdate = true
+				break
+			}
+		}
 
-	return nil
-}
+		if !isUpdate {
+			toAdd = append(toAdd, bi)
+		}
+	}
 
-// Removes backends by url
-// Returns a sorted list of the indexes of backends which were removed (indexes into the list pre-removal)
-// No errors: if a url doesnt exist, it is skipped silently
-func (bm *BackendManager) RemoveBackends(infos []config.BackendInfo) []int {
-	bm.modifyMutex.Lock()
-	defer bm.modifyMutex.Unlock()
+	added := make([]BackendRef, 0, len(toAdd))
 
-	removedIndices := make([]int, 0, len(bm.backends))
-	nextRemoved, nextKept := 0, 0
+	for _, bi := range toAdd {
+		for _, bj := range backends {
+			if bj.id == bi.GetID() {
+				return nil, nil, nil, &BackendExistsError{ID: bi.GetID()}
+			}
+			if bj.host == bi.Host && bj.port == bi.Port {
+				return nil, nil, nil, &BackendExistsError{ID: bi.GetID(), URL: bi.URL.String()}
+			}
+		}
 
-	for i, bj := range bm.backends {
-		removed := false
-		for _, bi := range infos {
-			if compareBackendToInfo(bj, &bi) {
-				removedIndices = append(removedIndices, i)
-				nextRemoved++
-				removed = true
-				bm.monitor.RemoveBackend(bj)
-				break
+		b := newBackend(bi, bm.circuitBreakerConfig)
+		backends = append(backends, b)
+		added = append(added, b)
+	}
+
+	// backends replaced at the same address keep their upgraded connections, unless they start draining
+	closing := []*upgradeSet{}
+	for _, old := range removed {
+		kept := false
+		for _, b := range added {
+			if old.id == b.id && old.host == b.host && old.port == b.port {
+				b.upgrades = old.upgrades
+				kept = !b.GetDraining() || old.GetDraining()
 			}
 		}
 
-		if !removed {
-			bm.backends[nextKept] = bj
-			nextKept++
+		if !kept {
+			closing = append(closing, old.upgrades)
 		}
 	}
 
-	bm.backends = bm.backends[:nextKept]
+	for _, b := range removed {
+		bm.monitor.RemoveBackend(b)
+		b.closeIdleConnections()
+	}
+
+	// nothing can fail now, so the updates can be made
+	for i, b := range updated {
+		b.update(updates[i])
+		b.setDraining(updates[i].Drain)
+	}
 
-	return removedIndices
+	bm.backends.Store(&backends)
+
+	for _, upgrades := range closing {
+		upgrades.shutdownAll()
+	}
+
+	return removed, added, updated, nil
 }
 
+// Reports whether a backend is the one an info describes.
+// Infos with an ID match by ID, so a backend can be removed without knowing its address.
+// Otherwise they match by host and port.
 func compareBackendToInfo(b *backend, info *config.BackendInfo) bool {
+	if info.ID != "" {
+		return b.id == info.ID
+	}
 	return b.host == info.Host && b.port == info.Port
 }
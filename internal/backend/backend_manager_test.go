@@ -1,8 +1,14 @@
 package backend
 
 import (
+	"encoding/json"
 	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestBackendManagerAddBackends(t *testing.T) {
@@ -58,3 +64,172 @@ func TestBackendManagerRemoveBackends(t *testing.T) {
 		}
 	}
 }
+
+func TestBackendRateCap(t *testing.T) {
+	capped := config.NewBackendInfo("abc", 80)
+	capped.MaxRequestsPerSecond = 2
+
+	bm := NewBackendManager([]config.BackendInfo{capped})
+	b := bm.GetBackend(0)
+
+	for i := 0; i < 2; i++ {
+		if !b.GetAlive() {
+			t.Errorf("Failed rate cap: backend unavailable after %d requests", i)
+		}
+		b.rateCap.Load().Take()
+	}
+
+	if b.GetAlive() {
+		t.Error("Failed rate cap: backend available after reaching cap")
+	}
+}
+
+func TestBackendMaxConnections(t *testing.T) {
+	capped := config.NewBackendInfo("abc", 80)
+	capped.MaxConnections = 1
+
+	bm := NewBackendManager([]config.BackendInfo{capped})
+	b := bm.GetBackend(0)
+
+	if !b.GetAlive() {
+		t.Error("Failed max connections: backend unavailable with no connections")
+	}
+
+	if !b.ReserveConnection() {
+		t.Error("Failed max connections: could not reserve the only slot")
+	}
+	if b.ReserveConnection() {
+		t.Error("Failed max connections: reserved a slot past the cap")
+	}
+	if b.GetAlive() {
+		t.Error("Failed max connections: backend available at connection cap")
+	}
+	if !bm.HasLiveBackends() {
+		t.Error("Failed max connections: backend at cap not counted as live")
+	}
+
+	b.releaseConnection()
+	if !b.ReserveConnection() {
+		t.Error("Failed max connections: could not reserve a released slot")
+	}
+}
+
+func TestBackendManagerIDs(t *testing.T) {
+	named := config.NewBackendInfo("abc", 80)
+	named.ID = "web-1"
+
+	bm := NewBackendManager([]config.BackendInfo{
+		named,
+		config.NewBackendInfo("def", 80),
+	})
+
+	if id := bm.GetBackend(0).GetID(); id != "web-1" {
+		t.Errorf("Failed explicit ID: got %s expected web-1", id)
+	}
+	if id := bm.GetBackend(1).GetID(); id != "def:80" {
+		t.Errorf("Failed default ID: got %s expected def:80", id)
+	}
+
+	duplicate := config.NewBackendInfo("ghi", 80)
+	duplicate.ID = "web-1"
+	if err := bm.AddBackends([]config.BackendInfo{duplicate}); err == nil {
+		t.Error("Failed adding duplicate ID: got no error")
+	}
+
+	// removing by ID doesnt need the backend's address
+	byID := config.NewBackendInfo("other", 1)
+	byID.ID = "web-1"
+	removed := bm.RemoveBackends([]config.BackendInfo{byID})
+
+	if len(removed) != 1 || removed[0].GetID() != "web-1" {
+		t.Errorf("Failed remove by ID: got %d removed expected 1", len(removed))
+	}
+	if l := bm.GetBackendCount(); l != 1 {
+		t.Errorf("Failed length check after remove by ID: got %d expected 1", l)
+	}
+}
+
+func TestBackendMarshalJSON(t *testing.T) {
+	info := config.NewBackendInfo("abc", 8080)
+	info.Name = "primary"
+	info.Weight = 3
+	info.Tags = []string{"canary"}
+	info.Zone = "eu-west-1a"
+	info.HealthCheck = &config.HealthCheckConfig{Path: "/health", Interval: 5 * time.Second}
+
+	bm := NewBackendManager([]config.BackendInfo{info})
+
+	data, err := json.Marshal(bm.GetBackend(0))
+	if err != nil {
+		t.Fatalf("Failed marshalling backend: %s", err.Error())
+	}
+
+	var got map[string]interface{}
+	json.Unmarshal(data, &got)
+
+	expected := map[string]interface{}{
+		"id":     "abc:8080",
+		"name":   "primary",
+		"host":   "abc",
+		"port":   float64(8080),
+		"weight": float64(3),
+		"zone":   "eu-west-1a",
+		"alive":  true,
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Errorf("Failed backend JSON %s: got %v expected %v", key, got[key], value)
+		}
+	}
+
+	healthCheck, _ := got["healthCheck"].(map[string]interface{})
+	if healthCheck["path"] != "/health" || healthCheck["interval"] != "5s" {
+		t.Errorf("Failed backend JSON healthCheck: got %v", got["healthCheck"])
+	}
+}
+
+func TestBackendHealthCheckOverride(t *testing.T) {
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.Path
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	info := config.NewBackendInfo(u.Hostname(), port)
+	info.HealthCheck = &config.HealthCheckConfig{Path: "/health", Method: http.MethodGet}
+
+	bm := NewBackendManager([]config.BackendInfo{info})
+
+	err := bm.monitor.checkBackend(bm.GetBackend(0))
+	if err != nil {
+		t.Fatalf("Failed health check: %s", err.Error())
+	}
+	if method != http.MethodGet || path != "/health" {
+		t.Errorf("Failed health check override: got %s %s expected GET /health", method, path)
+	}
+}
+
+// Tests the health change callback is only called when a backend's health changes.
+func TestBackendManagerHealthChangeCallback(t *testing.T) {
+	bm := NewBackendManager([]config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+	})
+
+	changes := []bool{}
+	bm.HealthChangeCallback = func(b BackendRef, alive bool) {
+		changes = append(changes, alive)
+	}
+
+	b := bm.GetBackend(0)
+	bm.ReportBackendAlive(b)
+	bm.ReportBackendDead(b)
+	bm.ReportBackendDead(b)
+	bm.ReportBackendAlive(b)
+
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("Failed health changes: got %v expected [false true]", changes)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"hash/maphash"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -45,13 +46,20 @@ func (bdm *backendDurationMap) Delete(b *backend) {
 	delete(bdm.m, maphash.String(bdm.hashSeed, b.url.String()))
 }
 
+// How often the monitor looks for backends due a heartbeat.
+// Backends can have their own heartbeat intervals, so this is the finest interval supported.
+const heartbeatTick = time.Second
+
+// The timeout of health checks, for backends which dont set their own.
+const defaultHealthCheckTimeout = time.Second * 5
+
 // Performs regular heartbeat tests to ensure backends are alive.
 // Also, performs dead checks on reported dead servers to check if they come back.
 type backendMonitor struct {
 	// The backend manager on which to test backends.
 	bm *BackendManager
 
-	// Time between each heartbeat to alive backends.
+	// Time between each heartbeat to alive backends, for backends which dont set their own.
 	timeBetweenHeartbeat time.Duration
 
 	// The time between the first dead checks.
@@ -68,39 +76,102 @@ func (monitor *backendMonitor) StartHeartbeats() {
 	fmt.Println("Started heartbeats.")
 
 	go func() {
+		// when each backend was last heartbeated, only used by this goroutine
+		lastHeartbeats := make(map[*backend]time.Time)
+
 		for true {
-			time.Sleep(monitor.timeBetweenHeartbeat)
+			time.Sleep(heartbeatTick)
 
-			fmt.Println("Heartbeat")
-			monitor.performHeartbeats()
+			monitor.performHeartbeats(time.Now(), lastHeartbeats)
 		}
 	}()
 }
 
-// Loops over every current backend and polls it to ensure it is alive.
-func (monitor *backendMonitor) performHeartbeats() {
-	// Have to aquire a lock for reading from the bm, as this could take some time
-	// During which someone might try to make changes to the backend list
-	monitor.bm.modifyMutex.RLock()
-	defer monitor.bm.modifyMutex.RUnlock()
+// Gets the time between heartbeats to a backend.
+func (monitor *backendMonitor) heartbeatInterval(b *backend) time.Duration {
+	if interval := b.getHealthCheck().Interval; interval > 0 {
+		return interval
+	}
+	return monitor.timeBetweenHeartbeat
+}
 
+// Loops over every current backend, polling those due a heartbeat to ensure they are alive.
+// Backends are first heartbeated one interval after they are seen.
+func (monitor *backendMonitor) performHeartbeats(now time.Time, lastHeartbeats map[*backend]time.Time) {
+	// The snapshot doesnt change, even if backends are added or removed while heartbeating
 	backends := monitor.bm.GetBackends()
 
+	current := make(map[*backend]bool, backends.Len())
+
 	for i := 0; i < backends.Len(); i++ {
 		b := backends.Get(i)
+		current[b] = true
 
-		// skip dead backends
-		if !b.GetAlive() {
+		last, ok := lastHeartbeats[b]
+		if !ok {
+			lastHeartbeats[b] = now
 			continue
 		}
 
+		// skip dead backends, backends with health checks disabled, and those not due a heartbeat
+		if !b.isAlive() || b.getHealthCheck().Disabled || now.Sub(last) < monitor.heartbeatInterval(b) {
+			continue
+		}
+		lastHeartbeats[b] = now
+
 		fmt.Printf("Heartbeating %s\n", b.url.String())
-		_, err := http.Head(b.url.String())
+		err := monitor.checkBackend(b)
 		if err != nil {
 			fmt.Println("dead")
-			monitor.bm.ReportBackendDead(backends.IndexOf(b))
+			monitor.bm.ReportBackendDead(b)
+		}
+	}
+
+	// forget removed backends
+	for b := range lastHeartbeats {
+		if !current[b] {
+			delete(lastHeartbeats, b)
+		}
+	}
+}
+
+// Sends a health check request to a backend, using its health check config.
+// Returns an error if the backend couldnt be reached.
+func (monitor *backendMonitor) checkBackend(b *backend) error {
+	hc := b.getHealthCheck()
+
+	target := b.url
+	if hc.Path != "" {
+		ref, err := url.Parse(hc.Path)
+		if err != nil {
+			return fmt.Errorf("Invalid health check path '%s'.", hc.Path)
 		}
+		target = b.url.ResolveReference(ref)
+	}
+
+	method := hc.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
 	}
+
+	req, err := http.NewRequest(method, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: timeout, Transport: b.transport}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
 }
 
 func (monitor *backendMonitor) RemoveBackend(b *backend) {
@@ -135,19 +206,14 @@ func (monitor *backendMonitor) deadChecker(b *backend) {
 
 		fmt.Printf("Dead checking %s\n", b.url.String())
 
-		// Lock bm to read and find the backends index
-		monitor.bm.modifyMutex.RLock()
-
 		// now, check if the backend is up
-		_, err := http.Head(b.url.String())
+		err := monitor.checkBackend(b)
 
 		if err == nil {
 			// back up!
 			fmt.Println("up!")
 			monitor.currentDeadCheckTimers.Delete(b)
-			monitor.bm.ReportBackendAlive(monitor.bm.GetBackends().IndexOf(b))
-
-			monitor.bm.modifyMutex.RUnlock()
+			monitor.bm.ReportBackendAlive(b)
 
 			return
 		}
@@ -158,8 +224,5 @@ func (monitor *backendMonitor) deadChecker(b *backend) {
 			newDur = monitor.maximumDeadCheckTimer
 		}
 		monitor.currentDeadCheckTimers.Set(b, newDur)
-
-		// Release the bm mutex
-		monitor.bm.modifyMutex.RUnlock()
 	}
 }
@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"errors"
+	"go-balancer/internal/balancer/config"
+	"sync"
+	"time"
+)
+
+// Returned when a request is not sent to a backend because its circuit breaker is open.
+var ErrCircuitOpen = errors.New("Circuit breaker is open.")
+
+// The number of buckets the rolling window is split into.
+const circuitWindowBuckets = 10
+
+const (
+	defaultCircuitWindow             = time.Second * 10
+	defaultCircuitMinRequests        = 10
+	defaultCircuitErrorRateThreshold = 0.5
+	defaultCircuitOpenDuration       = time.Second * 30
+	defaultCircuitHalfOpenRequests   = 1
+)
+
+type circuitState int
+
+const (
+	// Requests flow normally, and results are measured.
+	circuitClosed circuitState = iota
+	// Requests are blocked until the open duration passes.
+	circuitOpen
+	// A limited number of trial requests are let through to test the backend.
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Request results over one slice of the rolling window.
+type circuitWindowBucket struct {
+	start time.Time
+
+	requests     int
+	failures     int
+	totalLatency time.Duration
+}
+
+// A circuit breaker, blocking requests to a backend which is failing or slow.
+//
+// Closed until the error rate or average latency over a rolling window crosses a threshold,
+// then open for a while before going half-open and allowing a few trial requests.
+// If the trials succeed it closes again, else it reopens.
+type circuitBreaker struct {
+	cfg config.CircuitBreakerConfig
+
+	state circuitState
+	// When the breaker last opened
+	openedAt time.Time
+	// Counts state changes, so results of requests let through in an earlier state can be told apart
+	epoch uint64
+
+	// Ring of buckets making up the rolling window
+	buckets      [circuitWindowBuckets]circuitWindowBucket
+	bucketLength time.Duration
+
+	// Trial requests currently in flight, and how many have succeeded, while half-open
+	trialsInFlight int
+	trialSuccesses int
+
+	// Gets the current time, replaceable for testing
+	now func() time.Time
+
+	m sync.Mutex
+}
+
+// Fills in defaults for any unset values in a circuit breaker config.
+func circuitBreakerConfigWithDefaults(cfg config.CircuitBreakerConfig) config.CircuitBreakerConfig {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultCircuitWindow
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultCircuitMinRequests
+	}
+	if cfg.ErrorRateThreshold <= 0 {
+		cfg.ErrorRateThreshold = defaultCircuitErrorRateThreshold
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = defaultCircuitOpenDuration
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultCircuitHalfOpenRequests
+	}
+	return cfg
+}
+
+// Creates a closed circuit breaker, or returns nil if the config is not enabled.
+func newCircuitBreaker(cfg config.CircuitBreakerConfig) *circuitBreaker {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	cfg = circuitBreakerConfigWithDefaults(cfg)
+
+	return &circuitBreaker{
+		cfg:          cfg,
+		state:        circuitClosed,
+		bucketLength: cfg.Window / circuitWindowBuckets,
+		now:          time.Now,
+	}
+}
+
+// Given out by acquire for each request let through, and handed back to record with its result.
+type circuitToken struct {
+	// The breaker's epoch when the request was let through
+	epoch uint64
+	// Whether the request was a half-open trial
+	trial bool
+}
+
+// Reports whether a request could currently be let through, without changing state.
+func (cb *circuitBreaker) canAttempt() bool {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		return cb.now().Sub(cb.openedAt) >= cb.cfg.OpenDuration
+	case circuitHalfOpen:
+		return cb.trialsInFlight < cb.cfg.HalfOpenRequests
+	}
+	return true
+}
+
+// Attempts to let a request through.
+//
+// Moves from open to half-open once the open duration has passed.
+// Returns false if the request should not be sent.
+// If true is returned, the result must be reported with record, or the token given back with release.
+func (cb *circuitBreaker) acquire() (circuitToken, bool) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.state == circuitOpen {
+		if cb.now().Sub(cb.openedAt) < cb.cfg.OpenDuration {
+			return circuitToken{}, false
+		}
+
+		cb.setState(circuitHalfOpen)
+		cb.trialsInFlight = 0
+		cb.trialSuccesses = 0
+	}
+
+	if cb.state == circuitHalfOpen {
+		if cb.trialsInFlight >= cb.cfg.HalfOpenRequests {
+			return circuitToken{}, false
+		}
+		cb.trialsInFlight++
+		return circuitToken{epoch: cb.epoch, trial: true}, true
+	}
+
+	return circuitToken{epoch: cb.epoch}, true
+}
+
+// Records the result of a request let through by acquire.
+//
+// Results are only counted in the state the request was let through in.
+// Slow requests let through while closed may finish after the breaker has opened,
+// and must not count as trials, nor be counted in a window which was cleared when it closed again.
+func (cb *circuitBreaker) record(token circuitToken, success bool, latency time.Duration) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if token.epoch != cb.epoch {
+		return
+	}
+
+	switch cb.state {
+	case circuitHalfOpen:
+		cb.trialsInFlight--
+
+		if !success {
+			cb.trip()
+			return
+		}
+
+		cb.trialSuccesses++
+		if cb.trialSuccesses >= cb.cfg.HalfOpenRequests {
+			cb.close()
+		}
+	case circuitClosed:
+		bucket := cb.currentBucket()
+		bucket.requests++
+		bucket.totalLatency += latency
+		if !success {
+			bucket.failures++
+		}
+
+		if cb.shouldTrip() {
+			cb.trip()
+		}
+	}
+}
+
+// Gives back a token without a result, for requests which ended without the backend answering them (e.g. the client went away).
+// A half-open trial is freed up for another request, rather than counting as a success or failure.
+func (cb *circuitBreaker) release(token circuitToken) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if token.epoch == cb.epoch && token.trial {
+		cb.trialsInFlight--
+	}
+}
+
+// Gets the bucket for the current time, clearing it if it held an old slice of the window.
+// Assumes the caller holds the lock.
+func (cb *circuitBreaker) currentBucket() *circuitWindowBucket {
+	now := cb.now()
+	start := now.Truncate(cb.bucketLength)
+
+	bucket := &cb.buckets[(start.UnixNano()/int64(cb.bucketLength))%circuitWindowBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitWindowBucket{start: start}
+	}
+
+	return bucket
+}
+
+// Checks the rolling window against the thresholds.
+// Assumes the caller holds the lock.
+func (cb *circuitBreaker) shouldTrip() bool {
+	windowStart := cb.now().Add(-cb.cfg.Window)
+
+	requests, failures := 0, 0
+	totalLatency := time.Duration(0)
+
+	for _, bucket := range cb.buckets {
+		if bucket.start.Before(windowStart) {
+			continue
+		}
+		requests += bucket.requests
+		failures += bucket.failures
+		totalLatency += bucket.totalLatency
+	}
+
+	if requests < cb.cfg.MinRequests {
+		return false
+	}
+
+	if float64(failures)/float64(requests) >= cb.cfg.ErrorRateThreshold {
+		return true
+	}
+
+	return cb.cfg.LatencyThreshold > 0 && totalLatency/time.Duration(requests) >= cb.cfg.LatencyThreshold
+}
+
+// Opens the breaker.
+// Assumes the caller holds the lock.
+func (cb *circuitBreaker) trip() {
+	cb.setState(circuitOpen)
+	cb.openedAt = cb.now()
+}
+
+// Closes the breaker, forgetting the measurements from before it opened.
+// Assumes the caller holds the lock.
+func (cb *circuitBreaker) close() {
+	cb.setState(circuitClosed)
+	cb.buckets = [circuitWindowBuckets]circuitWindowBucket{}
+}
+
+// Changes state, starting a new epoch.
+// Assumes the caller holds the lock.
+func (cb *circuitBreaker) setState(state circuitState) {
+	cb.state = state
+	cb.epoch++
+}
+
+func (cb *circuitBreaker) getState() circuitState {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	return cb.state
+}
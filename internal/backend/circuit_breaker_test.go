@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"context"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestCircuitBreaker(cfg config.CircuitBreakerConfig) (*circuitBreaker, *time.Time) {
+	now := time.Now()
+
+	cfg.Enabled = true
+	cb := newCircuitBreaker(cfg)
+	cb.now = func() time.Time { return now }
+
+	return cb, &now
+}
+
+// Lets a request through the breaker and records its result.
+func attempt(cb *circuitBreaker, success bool, latency time.Duration) {
+	token, ok := cb.acquire()
+	if ok {
+		cb.record(token, success, latency)
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		MinRequests:        4,
+		ErrorRateThreshold: 0.5,
+		OpenDuration:       time.Second * 5,
+		HalfOpenRequests:   2,
+	})
+
+	// 1 failure in 4 is under the threshold
+	for i := 0; i < 3; i++ {
+		attempt(cb, true, time.Millisecond)
+	}
+	attempt(cb, false, time.Millisecond)
+
+	if s := cb.getState(); s != circuitClosed {
+		t.Errorf("Failed stay closed under threshold: got %s", s)
+	}
+
+	// 3 failures in 6 reaches it
+	for i := 0; i < 2; i++ {
+		attempt(cb, false, time.Millisecond)
+	}
+
+	if s := cb.getState(); s != circuitOpen {
+		t.Errorf("Failed trip on error rate: got %s expected open", s)
+	}
+	if _, ok := cb.acquire(); cb.canAttempt() || ok {
+		t.Error("Failed block requests when open")
+	}
+
+	// after the open duration, only half open requests allowed
+	*now = now.Add(time.Second * 5)
+	if !cb.canAttempt() {
+		t.Error("Failed allow attempt after open duration")
+	}
+	first, firstOk := cb.acquire()
+	second, secondOk := cb.acquire()
+	if !firstOk || !secondOk {
+		t.Error("Failed allow trial requests when half open")
+	}
+	if s := cb.getState(); s != circuitHalfOpen {
+		t.Errorf("Failed move to half open: got %s", s)
+	}
+	if _, ok := cb.acquire(); cb.canAttempt() || ok {
+		t.Error("Failed limit trial requests when half open")
+	}
+
+	// both trials succeeding closes
+	cb.record(first, true, time.Millisecond)
+	if s := cb.getState(); s != circuitHalfOpen {
+		t.Errorf("Failed stay half open until trials succeed: got %s", s)
+	}
+	cb.record(second, true, time.Millisecond)
+	if s := cb.getState(); s != circuitClosed {
+		t.Errorf("Failed close after successful trials: got %s", s)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		MinRequests:  1,
+		OpenDuration: time.Second,
+	})
+
+	attempt(cb, false, time.Millisecond)
+	if s := cb.getState(); s != circuitOpen {
+		t.Errorf("Failed trip: got %s expected open", s)
+	}
+
+	*now = now.Add(time.Second)
+	attempt(cb, false, time.Millisecond)
+
+	if s := cb.getState(); s != circuitOpen {
+		t.Errorf("Failed reopen on trial failure: got %s expected open", s)
+	}
+	if cb.canAttempt() {
+		t.Error("Failed reset open duration on reopen")
+	}
+}
+
+func TestCircuitBreakerLatencyAndWindow(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		Window:           time.Second * 10,
+		MinRequests:      3,
+		LatencyThreshold: time.Millisecond * 100,
+	})
+
+	// slow requests which fall out of the window before enough are seen
+	for i := 0; i < 2; i++ {
+		attempt(cb, true, time.Second)
+	}
+	*now = now.Add(time.Second * 11)
+	attempt(cb, true, time.Second)
+
+	if s := cb.getState(); s != circuitClosed {
+		t.Errorf("Failed forget requests outside window: got %s expected closed", s)
+	}
+
+	for i := 0; i < 2; i++ {
+		attempt(cb, true, time.Second)
+	}
+
+	if s := cb.getState(); s != circuitOpen {
+		t.Errorf("Failed trip on latency: got %s expected open", s)
+	}
+}
+
+// Tests requests let through while closed which finish after the breaker has opened
+// dont count as half-open trials.
+func TestCircuitBreakerStaleResults(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		MinRequests:      1,
+		OpenDuration:     time.Second,
+		HalfOpenRequests: 1,
+	})
+
+	// slow requests let through while closed
+	slow := make([]circuitToken, 3)
+	for i := range slow {
+		slow[i], _ = cb.acquire()
+	}
+
+	attempt(cb, false, time.Millisecond)
+	*now = now.Add(time.Second)
+
+	trial, ok := cb.acquire()
+	if !ok || !trial.trial {
+		t.Fatal("Failed allow trial request when half open")
+	}
+
+	// the slow requests finish while the trial is in flight
+	for _, token := range slow {
+		cb.record(token, true, time.Millisecond)
+	}
+
+	if s := cb.getState(); s != circuitHalfOpen {
+		t.Errorf("Failed ignore results from before opening: got %s expected half-open", s)
+	}
+	if _, ok := cb.acquire(); ok {
+		t.Error("Failed ignore results from before opening: got more trials let through than allowed")
+	}
+	if cb.trialsInFlight != 1 || cb.trialSuccesses != 0 {
+		t.Errorf("Failed trial counts: got %d in flight %d succeeded expected 1 and 0", cb.trialsInFlight, cb.trialSuccesses)
+	}
+
+	// only the trial itself closes the breaker
+	cb.record(trial, true, time.Millisecond)
+	if s := cb.getState(); s != circuitClosed {
+		t.Errorf("Failed close after trial: got %s expected closed", s)
+	}
+
+	// a stale failure doesnt trip it again, as the window was cleared when it closed
+	cb.record(slow[0], false, time.Millisecond)
+	if s := cb.getState(); s != circuitClosed {
+		t.Errorf("Failed ignore stale failure after closing: got %s expected closed", s)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	if cb := newCircuitBreaker(config.CircuitBreakerConfig{}); cb != nil {
+		t.Error("Failed disabled breaker: expected nil")
+	}
+
+	bm := NewBackendManager([]config.BackendInfo{config.NewBackendInfo("abc", 80)})
+	if s := bm.GetBackend(0).GetCircuitState(); s != "closed" {
+		t.Errorf("Failed disabled breaker state: got %s expected closed", s)
+	}
+}
+
+// Tests a trial request cancelled by the client neither closes nor reopens the breaker, and frees up the trial.
+func TestCircuitBreakerCancelledTrial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	bm := NewBackendManager([]config.BackendInfo{config.NewBackendInfo(u.Hostname(), port)})
+	bm.SetCircuitBreakerConfig(config.CircuitBreakerConfig{Enabled: true, OpenDuration: time.Second})
+	b := bm.GetBackend(0)
+
+	// open the breaker long enough ago that the next request is a trial
+	cb := b.breaker.Load()
+	cb.m.Lock()
+	cb.trip()
+	cb.openedAt = time.Now().Add(-time.Minute)
+	cb.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	r := httptest.NewRequest("GET", "http://localhost/", nil).WithContext(ctx)
+
+	b.ReserveConnection()
+	err := bm.ServeRequestWithBackend(b, httptest.NewRecorder(), r, nil)
+	if err == nil {
+		t.Fatal("Failed cancelled trial: got no error")
+	}
+
+	if s := cb.getState(); s != circuitHalfOpen {
+		t.Errorf("Failed cancelled trial: got %s expected half-open", s)
+	}
+	if _, ok := cb.acquire(); !ok {
+		t.Error("Failed cancelled trial: expected the trial to be freed up for another request")
+	}
+}
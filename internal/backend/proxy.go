@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"errors"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+)
+
+// Returned when a request body is larger than the proxy config allows.
+// The request is the problem rather than the backend, so it shouldnt be retried.
+var ErrRequestTooLarge = errors.New("Request body is too large.")
+
+// Returned when a response is larger than the proxy config allows, and none of it has been sent yet.
+// Responses found to be too large part way through are cut off instead.
+var ErrResponseTooLarge = errors.New("Response body is too large.")
+
+// The content type of server-sent events, which are always streamed.
+const eventStreamContentType = "text/event-stream"
+
+type reverseProxyErrorHandler = func(http.ResponseWriter, *http.Request, error)
+type reverseProxyResponseModifier = func(*http.Response) error
+
+// Creates a reverse proxy to the backend using its protocol, which flushes and limits responses as the proxy config describes.
+// Upgrade requests are always sent over HTTP/1.1, as HTTP/2 cant upgrade connections.
+// modifyResponse is called first, and may be nil.
+func (b *backend) newProxy(cfg config.ProxyConfig, upgrade bool, errorHandler reverseProxyErrorHandler, modifyResponse reverseProxyResponseModifier) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(b.url)
+	proxy.ErrorHandler = errorHandler
+	proxy.FlushInterval = cfg.FlushInterval
+
+	if upgrade {
+		proxy.Transport = b.upgradeTransport
+	} else {
+		proxy.Transport = b.transport
+	}
+
+	// the proxy is only used for one request, so its flush interval can be set from the response
+	proxy.ModifyResponse = func(res *http.Response) error {
+		if modifyResponse != nil {
+			err := modifyResponse(res)
+			if err != nil {
+				return err
+			}
+		}
+
+		// upgraded connections are copied as they arrive, and last as long as they like
+		if res.StatusCode == http.StatusSwitchingProtocols {
+			return nil
+		}
+
+		if isStreamed(res, cfg.StreamContentTypes) {
+			proxy.FlushInterval = -1
+		}
+
+		if cfg.MaxResponseBodySize > 0 {
+			if res.ContentLength > cfg.MaxResponseBodySize {
+				return ErrResponseTooLarge
+			}
+			res.Body = newLimitedBody(res.Body, cfg.MaxResponseBodySize, ErrResponseTooLarge)
+		}
+		return nil
+	}
+
+	return proxy
+}
+
+// Reports whether a response should be flushed after every write, as it is a stream such as server-sent events.
+func isStreamed(res *http.Response, streamContentTypes []string) bool {
+	contentType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	if contentType == eventStreamContentType {
+		return true
+	}
+	for _, streamed := range streamContentTypes {
+		if strings.EqualFold(contentType, streamed) {
+			return true
+		}
+	}
+	return false
+}
+
+// A body which fails with an error once more than a limit has been read from it.
+type limitedBody struct {
+	body io.ReadCloser
+	// The bytes left before the limit is passed
+	remaining int64
+	// Returned once the limit is passed
+	err error
+
+	// Set once the limit is passed.
+	// Request bodies are read by the transport, so this is checked from another goroutine.
+	exceeded atomic.Bool
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64, err error) *limitedBody {
+	return &limitedBody{
+		body:      body,
+		remaining: limit,
+		err:       err,
+	}
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.exceeded.Load() {
+		return 0, l.err
+	}
+
+	// read one byte past the limit, to find whether the body passes it
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.body.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		l.exceeded.Store(true)
+		return n, l.err
+	}
+
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
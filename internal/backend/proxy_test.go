@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLimitedBody(t *testing.T) {
+	cases := []struct {
+		body     string
+		limit    int64
+		exceeded bool
+	}{
+		{"", 4, false},
+		{"abc", 4, false},
+		{"abcd", 4, false},
+		{"abcde", 4, true},
+		{strings.Repeat("x", 100000), 50000, true},
+	}
+
+	for _, c := range cases {
+		body := newLimitedBody(io.NopCloser(strings.NewReader(c.body)), c.limit, ErrRequestTooLarge)
+		read, err := io.ReadAll(body)
+
+		if c.exceeded {
+			if !errors.Is(err, ErrRequestTooLarge) || !body.exceeded.Load() {
+				t.Errorf("Failed body of %d with limit %d: got %v expected ErrRequestTooLarge", len(c.body), c.limit, err)
+			}
+			if int64(len(read)) != c.limit {
+				t.Errorf("Failed body of %d with limit %d: read %d expected %d", len(c.body), c.limit, len(read), c.limit)
+			}
+		} else if err != nil || string(read) != c.body || body.exceeded.Load() {
+			t.Errorf("Failed body of %d with limit %d: got %s %v expected the whole body", len(c.body), c.limit, read, err)
+		}
+	}
+}
+
+func TestIsStreamed(t *testing.T) {
+	streamContentTypes := []string{"application/x-ndjson"}
+
+	cases := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"text/event-stream", true},
+		{"text/event-stream; charset=utf-8", true},
+		{"application/x-ndjson", true},
+		{"Application/X-NDJSON", true},
+		{"application/json", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		res := &http.Response{Header: http.Header{}}
+		res.Header.Set("Content-Type", c.contentType)
+
+		if got := isStreamed(res, streamContentTypes); got != c.expected {
+			t.Errorf("Failed content type '%s': got %t expected %t", c.contentType, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// How long idle HTTP/2 connections to a backend are kept open, as with the default transport's.
+const http2IdleConnTimeout = 90 * time.Second
+
+// Creates the transports requests are sent to a backend with, for its protocol:
+// one for requests, and one for upgrade requests such as WebSockets, which need HTTP/1.1.
+// Either is nil if the default HTTP/1.1 transport is used.
+//
+// HTTP/2 transports keep trailers, so gRPC works through the proxy.
+func newTransports(info config.BackendInfo) (http.RoundTripper, http.RoundTripper) {
+	switch info.GetProtocol() {
+	case config.ProtocolH2C:
+		transport := &http2.Transport{
+			AllowHTTP:       true,
+			IdleConnTimeout: http2IdleConnTimeout,
+			// h2c connections are cleartext, so are dialed without TLS
+			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+		return transport, nil
+
+	case config.ProtocolH2:
+		tlsConfig := newBackendTLSConfig(info)
+
+		transport := &http2.Transport{
+			IdleConnTimeout: http2IdleConnTimeout,
+			TLSClientConfig: tlsConfig,
+		}
+		// without ForceAttemptHTTP2 this only negotiates HTTP/1.1
+		upgradeTransport := &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			IdleConnTimeout: http2IdleConnTimeout,
+			TLSClientConfig: tlsConfig.Clone(),
+		}
+		return transport, upgradeTransport
+	}
+
+	return nil, nil
+}
+
+// Creates the TLS config used to connect to a backend over TLS.
+func newBackendTLSConfig(info config.BackendInfo) *tls.Config {
+	tlsConfig := &tls.Config{}
+	if info.TLS == nil {
+		return tlsConfig
+	}
+
+	tlsConfig.ServerName = info.TLS.ServerName
+	tlsConfig.InsecureSkipVerify = info.TLS.InsecureSkipVerify
+
+	// the file was read when the backend was parsed, so this only fails if it has changed since
+	// without the CAs the backend's certificate fails to verify, so requests fail rather than trusting it
+	rootCAs, err := info.TLS.LoadRootCAs()
+	if err != nil {
+		fmt.Printf("Error loading CAs for backend '%s': %s\n", info.GetID(), err.Error())
+		rootCAs = x509.NewCertPool()
+	}
+	tlsConfig.RootCAs = rootCAs
+
+	return tlsConfig
+}
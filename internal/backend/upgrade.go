@@ -0,0 +1,429 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// How long upgraded connections have to close once asked to, before they are closed forcibly.
+const upgradeCloseTimeout = 5 * time.Second
+
+// The WebSocket close code sent to clients when their backend is removed or drained.
+const closeGoingAway = 1001
+
+// Reports whether a request asks to upgrade its connection to another protocol, such as a WebSocket.
+// Upgraded connections last until either side closes them, so are counted apart from requests.
+func IsUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade")
+}
+
+// The upgraded connections to a backend, so they can be counted and closed when it is removed or drained.
+// A backend replacing another at the same address, e.g. to change its weight, takes over its set.
+type upgradeSet struct {
+	conns map[*upgradedConn]struct{}
+	lock  sync.Mutex
+}
+
+func newUpgradeSet() *upgradeSet {
+	return &upgradeSet{
+		conns: make(map[*upgradedConn]struct{}),
+	}
+}
+
+func (s *upgradeSet) add(c *upgradedConn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.conns[c] = struct{}{}
+}
+
+func (s *upgradeSet) remove(c *upgradedConn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.conns, c)
+}
+
+func (s *upgradeSet) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.conns)
+}
+
+// Asks every connection to close, see upgradedConn.shutdown.
+func (s *upgradeSet) shutdownAll() {
+	s.lock.Lock()
+	conns := make([]*upgradedConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		c.shutdown()
+	}
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// The backend side of an upgraded connection, which the reverse proxy copies to and from the client.
+//
+// Reads from the backend are made in the background, so the connection can be shut down between them.
+// For WebSockets the backend's frames are followed, so on shutdown the client is sent a close frame
+// once the frame in progress has been sent, rather than having its connection cut part way through one.
+// Other protocols are just closed.
+type upgradedConn struct {
+	backend   io.ReadWriteCloser
+	websocket bool
+	set       *upgradeSet
+
+	// Reads made in the background, taken by Read.
+	reads chan readResult
+	// Data and the error read from the backend, but not yet returned by Read.
+	pending    []byte
+	pendingErr error
+
+	// Follows the frames the backend sends.
+	frames frameTracker
+	// Set once Read has seen the shutdown, after which it stops at the next frame boundary.
+	closing bool
+	// Set once the close frame has replaced the backend's data.
+	closeSent bool
+	// Set once the close frame has been read, and the rest of the backend's data is being dropped.
+	finishing bool
+
+	// Closed to ask the connection to close.
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	// Closed once the connection is closed, stopping the background reads.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// The client's connection, once the proxy has hijacked it.
+	client net.Conn
+	// Closes the connection if it hasnt closed in time after a shutdown.
+	forceTimer *time.Timer
+	closed     bool
+	lock       sync.Mutex
+}
+
+// Wraps the backend connection of a switching protocols response, adding it to the set until it is closed.
+func newUpgradedConn(backend io.ReadWriteCloser, websocket bool, set *upgradeSet) *upgradedConn {
+	c := &upgradedConn{
+		backend:    backend,
+		websocket:  websocket,
+		set:        set,
+		reads:      make(chan readResult),
+		shutdownCh: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	set.add(c)
+
+	go c.readBackend()
+
+	return c
+}
+
+func (c *upgradedConn) readBackend() {
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := c.backend.Read(buf)
+
+		select {
+		case c.reads <- readResult{buf[:n], err}:
+		case <-c.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.pendingErr != nil {
+			if c.closeSent && !c.finishing {
+				c.finishing = true
+				go c.finish()
+			}
+			return 0, c.pendingErr
+		}
+
+		shutdown := c.shutdownCh
+		if c.closing {
+			shutdown = nil
+		}
+
+		select {
+		case res := <-c.reads:
+			c.pending, c.pendingErr = res.data, res.err
+
+		case <-shutdown:
+			c.closing = true
+			if c.frames.atBoundary() {
+				c.sendClose()
+			}
+
+		case <-c.done:
+			return 0, net.ErrClosed
+		}
+	}
+
+	n := copy(p, c.pending)
+
+	if !c.closeSent {
+		if c.closing {
+			// stop at the end of the frame in progress, dropping anything after it
+			var ended bool
+			n, ended = c.frames.advance(c.pending[:n])
+			if ended {
+				c.pending = nil
+				c.sendClose()
+				return n, nil
+			}
+		} else {
+			c.frames.advanceAll(c.pending[:n])
+		}
+	}
+
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Replaces the rest of the backend's data with a close frame, ending the stream after it.
+// The client replies to the backend, which finishes the closing handshake.
+func (c *upgradedConn) sendClose() {
+	reason := "Backend going away"
+
+	frame := []byte{0x88, byte(2 + len(reason)), closeGoingAway >> 8, closeGoingAway & 0xff}
+	c.pending = append(frame, reason...)
+	c.pendingErr = io.EOF
+	c.closeSent = true
+}
+
+// Drops whatever the backend sends after the close frame, such as its reply to the client's,
+// closing both sides once the backend has closed its own.
+func (c *upgradedConn) finish() {
+	for {
+		select {
+		case res := <-c.reads:
+			if res.err != nil {
+				c.forceClose()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	return c.backend.Write(p)
+}
+
+// Closes the connection, once the proxy is done with it.
+func (c *upgradedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.closed = true
+		if c.forceTimer != nil {
+			c.forceTimer.Stop()
+		}
+		c.lock.Unlock()
+
+		c.set.remove(c)
+		err = c.backend.Close()
+		close(c.done)
+	})
+	return err
+}
+
+// Asks the connection to close, closing it forcibly if it hasnt within upgradeCloseTimeout.
+// Protocols other than WebSockets have no way to be asked, so are closed straight away.
+func (c *upgradedConn) shutdown() {
+	c.shutdownOnce.Do(func() {
+		if !c.websocket {
+			go c.forceClose()
+			return
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if c.closed {
+			return
+		}
+		c.forceTimer = time.AfterFunc(upgradeCloseTimeout, c.forceClose)
+		close(c.shutdownCh)
+	})
+}
+
+// Closes both sides of the connection, ending the proxy's copying.
+func (c *upgradedConn) forceClose() {
+	c.lock.Lock()
+	client := c.client
+	c.client = nil
+	c.lock.Unlock()
+
+	if client != nil {
+		client.Close()
+	}
+	c.Close()
+}
+
+// Remembers the client's connection, so it can be closed forcibly.
+// Closes it straight away if the connection was already closed forcibly.
+func (c *upgradedConn) setClient(client net.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		client.Close()
+		return
+	}
+	c.client = client
+}
+
+// Reports whether a switching protocols response is to a WebSocket.
+func isWebSocketResponse(res *http.Response) bool {
+	return strings.EqualFold(res.Header.Get("Upgrade"), "websocket")
+}
+
+// Records the client's connection when the proxy hijacks it for an upgrade, clearing its deadlines.
+type hijackRecorder struct {
+	http.ResponseWriter
+
+	// The upgraded connection the client's is proxied to, set once the backend switches protocols.
+	upgraded *upgradedConn
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return conn, brw, err
+	}
+
+	// the server's and route's timeouts are for requests, upgraded connections last until either side closes them
+	conn.SetDeadline(time.Time{})
+
+	if h.upgraded != nil {
+		h.upgraded.setClient(conn)
+	}
+	return conn, brw, nil
+}
+
+func (h *hijackRecorder) Flush() {
+	if flusher, ok := h.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (h *hijackRecorder) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
+
+// Follows the frames of a WebSocket stream, to find the boundaries between them.
+type frameTracker struct {
+	// The current frame's header, while it is being read
+	header []byte
+	// The bytes of the current frame's payload still to come
+	remaining uint64
+}
+
+func (t *frameTracker) atBoundary() bool {
+	return len(t.header) == 0 && t.remaining == 0
+}
+
+// Advances over data, stopping at the end of the first frame which ends in it.
+// Returns how much of data was advanced over, and whether a frame ended there.
+func (t *frameTracker) advance(data []byte) (int, bool) {
+	n := 0
+	for n < len(data) {
+		if t.remaining > 0 {
+			take := uint64(len(data) - n)
+			if take > t.remaining {
+				take = t.remaining
+			}
+			t.remaining -= take
+			n += int(take)
+
+			if t.remaining == 0 {
+				return n, true
+			}
+			continue
+		}
+
+		t.header = append(t.header, data[n])
+		n++
+
+		if length, ok := parseFrameHeader(t.header); ok {
+			t.header = t.header[:0]
+			t.remaining = length
+
+			if length == 0 {
+				return n, true
+			}
+		}
+	}
+	return n, false
+}
+
+func (t *frameTracker) advanceAll(data []byte) {
+	for len(data) > 0 {
+		n, _ := t.advance(data)
+		data = data[n:]
+	}
+}
+
+// Gets the payload length of a frame from its header.
+// Returns false if the header isnt complete yet.
+func parseFrameHeader(header []byte) (uint64, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	size := 2
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	// masked frames have a 4 byte key
+	if header[1]&0x80 != 0 {
+		size += 4
+	}
+
+	if len(header) < size {
+		return 0, false
+	}
+
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(header[2:10])
+	}
+	return length, true
+}
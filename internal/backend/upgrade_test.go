@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// Creates an unmasked WebSocket binary frame with a payload.
+func testFrame(payload []byte) []byte {
+	frame := []byte{0x82}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) < 65536:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, byte(len(payload)>>24), byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+	}
+	return append(frame, payload...)
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	cases := []struct {
+		upgrade    string
+		connection string
+		expected   bool
+	}{
+		{"websocket", "Upgrade", true},
+		{"websocket", "keep-alive, upgrade", true},
+		{"h2c", "HTTP2-Settings, Upgrade", true},
+		{"", "Upgrade", false},
+		{"websocket", "keep-alive", false},
+	}
+
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+		r.Header.Set("Upgrade", c.upgrade)
+		r.Header.Set("Connection", c.connection)
+
+		if got := IsUpgradeRequest(r); got != c.expected {
+			t.Errorf("Failed upgrade %s connection %s: got %t expected %t", c.upgrade, c.connection, got, c.expected)
+		}
+	}
+}
+
+func TestFrameTracker(t *testing.T) {
+	stream := append(testFrame([]byte("hi")), testFrame(bytes.Repeat([]byte("x"), 300))...)
+	stream = append(stream, testFrame(bytes.Repeat([]byte("y"), 70000))...)
+	stream = append(stream, testFrame(nil)...)
+	ends := []int{4, 4 + 304, 4 + 304 + 70010, 4 + 304 + 70010 + 2}
+
+	// feed the stream a byte at a time, so every header is split
+	var tracker frameTracker
+	found := []int{}
+	for i := range stream {
+		n, ended := tracker.advance(stream[i : i+1])
+		if n != 1 {
+			t.Fatalf("Failed advancing: got %d expected 1", n)
+		}
+		if ended {
+			found = append(found, i+1)
+		}
+	}
+
+	if len(found) != len(ends) {
+		t.Fatalf("Failed frame ends: got %v expected %v", found, ends)
+	}
+	for i := range ends {
+		if found[i] != ends[i] {
+			t.Errorf("Failed frame end %d: got %d expected %d", i, found[i], ends[i])
+		}
+	}
+
+	// all at once stops at the first end
+	tracker = frameTracker{}
+	n, ended := tracker.advance(stream)
+	if n != 4 || !ended {
+		t.Errorf("Failed advancing whole stream: got %d %t expected 4 true", n, ended)
+	}
+	tracker.advanceAll(stream[n:])
+	if !tracker.atBoundary() {
+		t.Errorf("Failed advancing all: got %+v expected a boundary", tracker)
+	}
+}
+
+// Tests a shutdown part way through a frame lets the frame finish before the close frame.
+func TestUpgradedConnShutdown(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	set := newUpgradeSet()
+	c := newUpgradedConn(client, true, set)
+	defer c.Close()
+
+	if set.count() != 1 {
+		t.Fatalf("Failed adding to set: got %d expected 1", set.count())
+	}
+
+	frame := testFrame(bytes.Repeat([]byte("x"), 200))
+
+	// send half the frame, then shut down
+	go server.Write(frame[:100])
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil || n != 100 {
+		t.Fatalf("Failed reading first half: got %d %v expected 100", n, err)
+	}
+
+	set.shutdownAll()
+
+	// the rest of the frame and the start of another, which is dropped
+	go server.Write(append(frame[100:], testFrame([]byte("dropped"))...))
+
+	received, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("Failed reading after shutdown: %s", err.Error())
+	}
+
+	if !bytes.Equal(received[:len(frame)-100], frame[100:]) {
+		t.Errorf("Failed finishing frame: got %v expected the rest of the frame", received)
+	}
+
+	closeFrame := received[len(frame)-100:]
+	if len(closeFrame) < 4 || closeFrame[0] != 0x88 || int(closeFrame[2])<<8|int(closeFrame[3]) != closeGoingAway {
+		t.Errorf("Failed close frame: got %v expected close %d", closeFrame, closeGoingAway)
+	}
+
+	c.Close()
+	if set.count() != 0 {
+		t.Errorf("Failed removing from set: got %d expected 0", set.count())
+	}
+}
+
+// Tests other protocols are closed straight away on shutdown, as they cant be asked to close.
+func TestUpgradedConnShutdownOtherProtocol(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	set := newUpgradeSet()
+	c := newUpgradedConn(client, false, set)
+
+	set.shutdownAll()
+
+	_, err := c.Read(make([]byte, 16))
+	if err == nil {
+		t.Errorf("Failed closing: got no error reading expected one")
+	}
+	if set.count() != 0 {
+		t.Errorf("Failed removing from set: got %d expected 0", set.count())
+	}
+}
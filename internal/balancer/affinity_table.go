@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// How often expired entries are swept from an affinity table.
+const affinitySweepInterval = time.Minute
+
+type affinityEntry struct {
+	key       string
+	backendID string
+	expires   time.Time
+}
+
+// An in-memory map from client keys to backend IDs.
+//
+// Entries expire after a TTL without being saved again.
+// When the table is full, the least recently saved entry is evicted.
+type affinityTable struct {
+	ttl        time.Duration
+	maxEntries int
+
+	entries map[string]*list.Element
+	// Entries ordered from most to least recently saved
+	order *list.List
+
+	// The last time expired entries were swept
+	lastSweep time.Time
+
+	// Gets the current time, replaceable for testing
+	now func() time.Time
+
+	m sync.Mutex
+}
+
+func newAffinityTable(ttl time.Duration, maxEntries int) *affinityTable {
+	return &affinityTable{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		lastSweep:  time.Now(),
+		now:        time.Now,
+	}
+}
+
+// Gets the backend ID for a key, if it has an entry which hasnt expired.
+func (t *affinityTable) Get(key string) (string, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	elem, ok := t.entries[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := elem.Value.(*affinityEntry)
+	if t.now().After(entry.expires) {
+		t.remove(elem)
+		return "", false
+	}
+
+	return entry.backendID, true
+}
+
+// Sets the backend ID for a key, refreshing its TTL.
+func (t *affinityTable) Set(key string, backendID string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := t.now()
+
+	if now.Sub(t.lastSweep) > affinitySweepInterval {
+		t.sweep(now)
+	}
+
+	if elem, ok := t.entries[key]; ok {
+		entry := elem.Value.(*affinityEntry)
+		entry.backendID = backendID
+		entry.expires = now.Add(t.ttl)
+		t.order.MoveToFront(elem)
+		return
+	}
+
+	if t.order.Len() >= t.maxEntries {
+		t.remove(t.order.Back())
+	}
+
+	t.entries[key] = t.order.PushFront(&affinityEntry{
+		key:       key,
+		backendID: backendID,
+		expires:   now.Add(t.ttl),
+	})
+}
+
+// Removes the entry for a key, if there is one.
+func (t *affinityTable) Delete(key string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if elem, ok := t.entries[key]; ok {
+		t.remove(elem)
+	}
+}
+
+func (t *affinityTable) Len() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.order.Len()
+}
+
+// Removes all expired entries.
+// As entries are ordered by when they were last saved, this stops at the first unexpired one.
+// Assumes the caller holds the lock.
+func (t *affinityTable) sweep(now time.Time) {
+	for elem := t.order.Back(); elem != nil; elem = t.order.Back() {
+		if now.Before(elem.Value.(*affinityEntry).expires) {
+			break
+		}
+		t.remove(elem)
+	}
+
+	t.lastSweep = now
+}
+
+// Assumes the caller holds the lock.
+func (t *affinityTable) remove(elem *list.Element) {
+	t.order.Remove(elem)
+	delete(t.entries, elem.Value.(*affinityEntry).key)
+}
@@ -0,0 +1,75 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAffinityTableTTL(t *testing.T) {
+	now := time.Now()
+
+	table := newAffinityTable(time.Minute, 10)
+	table.now = func() time.Time { return now }
+
+	table.Set("client", "a:80")
+	if id, ok := table.Get("client"); !ok || id != "a:80" {
+		t.Errorf("Failed get after set: got '%s' %t expected 'a:80'", id, ok)
+	}
+
+	// saving again refreshes the ttl
+	now = now.Add(time.Second * 45)
+	table.Set("client", "b:80")
+	now = now.Add(time.Second * 45)
+
+	if id, ok := table.Get("client"); !ok || id != "b:80" {
+		t.Errorf("Failed get after refresh: got '%s' %t expected 'b:80'", id, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := table.Get("client"); ok {
+		t.Error("Failed expire after ttl")
+	}
+	if l := table.Len(); l != 0 {
+		t.Errorf("Failed remove expired entry: got len %d expected 0", l)
+	}
+}
+
+func TestAffinityTableEviction(t *testing.T) {
+	table := newAffinityTable(time.Minute, 2)
+
+	table.Set("first", "a:80")
+	table.Set("second", "a:80")
+	// refresh first, so second is the least recently saved
+	table.Set("first", "a:80")
+	table.Set("third", "a:80")
+
+	if _, ok := table.Get("second"); ok {
+		t.Error("Failed evict least recently saved entry")
+	}
+	for _, key := range []string{"first", "third"} {
+		if _, ok := table.Get(key); !ok {
+			t.Errorf("Failed keep recently saved entry '%s'", key)
+		}
+	}
+
+	table.Delete("first")
+	if _, ok := table.Get("first"); ok {
+		t.Error("Failed delete entry")
+	}
+}
+
+func TestAffinityTableSweep(t *testing.T) {
+	now := time.Now()
+
+	table := newAffinityTable(time.Minute, 10)
+	table.now = func() time.Time { return now }
+
+	table.Set("old", "a:80")
+
+	now = now.Add(affinitySweepInterval * 2)
+	table.Set("new", "a:80")
+
+	if l := table.Len(); l != 1 {
+		t.Errorf("Failed sweep expired entries: got len %d expected 1", l)
+	}
+}
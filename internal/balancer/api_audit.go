@@ -0,0 +1,197 @@
+package balancer
+
+import (
+	"fmt"
+	"go-balancer/internal/audit"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The header clients identify themselves with for the audit log.
+const actorHeader = "X-Actor"
+
+// Formats a config generation as an ETag.
+func generationETag(generation uint64) string {
+	return fmt.Sprintf(`"%d"`, generation)
+}
+
+// Reports whether an If-Match header allows a change to a config generation.
+// A missing header always matches, so clients can opt out of the check.
+func ifMatchAllows(header string, generation uint64) bool {
+	if header == "" {
+		return true
+	}
+
+	current := generationETag(generation)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// Records the status of a response, setting its ETag to the config generation as it is written.
+type generationWriter struct {
+	http.ResponseWriter
+	balancer *balancer
+
+	status int
+}
+
+func (w *generationWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.Header().Set("ETag", generationETag(w.balancer.GetGeneration()))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *generationWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// The parts of the balancer's state which can be changed through the API, as recorded in the audit log.
+type adminState struct {
+	Backends   map[string]config.BackendInfo `json:"backends"`
+	Strategy   config.StrategyConfig         `json:"strategy"`
+	Sticky     interface{}                   `json:"sticky"`
+	RateLimits []config.RateLimitConfig      `json:"rateLimits"`
+}
+
+// Gets the current state, with the sticky session secret hidden.
+func (m *modificationServer) getAdminState() adminState {
+	state := adminState{
+		Backends:   make(map[string]config.BackendInfo),
+		Strategy:   m.balancer.GetStrategyConfig(),
+		RateLimits: m.balancer.GetRateLimits(),
+	}
+
+	list := m.balancer.backendManager.GetBackends()
+	for i := 0; i < list.Len(); i++ {
+		b := list.Get(i)
+		state.Backends[b.GetID()] = b.GetInfo()
+	}
+
+	sticky := m.balancer.GetStickyConfig()
+	if sticky.Cookie.Secret != "" {
+		sticky.Cookie.Secret = redactedSecret
+	}
+	config.CastProperties(sticky, &state.Sticky)
+
+	return state
+}
+
+// Wraps a handler which changes the balancer.
+//
+// Changes are made one at a time. If the request has an If-Match header which doesnt match the
+// current generation's ETag, it is rejected with 412 Precondition Failed and nothing is changed.
+// Successful changes are recorded in the audit log, with the difference they made, and persisted.
+func (m *modificationServer) mutation(handler apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		m.mutationMutex.Lock()
+		defer m.mutationMutex.Unlock()
+
+		generation := m.balancer.GetGeneration()
+		if !ifMatchAllows(r.Header.Get("If-Match"), generation) {
+			w.Header().Set("ETag", generationETag(generation))
+			writeAPIError(w, http.StatusPreconditionFailed, fmt.Sprintf("The config has changed, it is now at generation %d.", generation))
+			return
+		}
+
+		before := m.getAdminState()
+
+		gw := &generationWriter{ResponseWriter: w, balancer: m.balancer}
+		handler(gw, r, params)
+
+		if gw.status >= 300 || m.balancer.GetGeneration() == generation {
+			return
+		}
+
+		changes, err := audit.Diff(before, m.getAdminState())
+		if err != nil {
+			fmt.Printf("Diffing change for the audit log failed: %s\n", err.Error())
+		}
+
+		_, err = m.auditLog.Append(audit.Entry{
+			Actor:      requestActor(r),
+			RemoteIP:   requestIP(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Generation: m.balancer.GetGeneration(),
+			Changes:    changes,
+		})
+		if err != nil {
+			fmt.Printf("Recording change in the audit log failed: %s\n", err.Error())
+		}
+
+		err = m.persister.Save(m.balancer.GetRuntimeConfig())
+		if err != nil {
+			fmt.Printf("Saving change failed, it will be lost when restarting: %s\n", err.Error())
+		}
+	}
+}
+
+// Gets who made a request, as they identified themselves.
+func requestActor(r *http.Request) string {
+	if actor := strings.TrimSpace(r.Header.Get(actorHeader)); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// Gets the IP address a request came from.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditResponse struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+// Gets audit log entries, filtered by the after, since, actor, path and limit query parameters.
+func (m *modificationServer) getAudit(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	q := audit.Query{
+		Actor:      query.Get("actor"),
+		PathPrefix: query.Get("path"),
+		Limit:      100,
+	}
+
+	var err error
+	if after := query.Get("after"); after != "" {
+		q.AfterID, err = strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid after id '%s'.", after))
+			return
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		q.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since time '%s', expected RFC 3339.", since))
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit <= 0 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit '%s'.", limit))
+			return
+		}
+	}
+
+	writeAPIJSON(w, http.StatusOK, auditResponse{Entries: m.auditLog.Query(q)})
+}
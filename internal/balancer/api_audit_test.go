@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sends a request to the modification server's handler with extra headers, returning the response.
+func modificationRequestWithHeaders(m *modificationServer, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	m.handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIGenerationETag(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/strategy", "")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Failed ETag: got none")
+	}
+
+	w = modificationRequestWithHeaders(m, http.MethodPut, "/api/v1/strategy", `{"name": "LEAST_CONN"}`, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed matching change: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	// the change is a new generation
+	newETag := w.Header().Get("ETag")
+	if newETag == etag {
+		t.Errorf("Failed new generation: got ETag %s expected it to change", newETag)
+	}
+	if got := modificationRequest(m, http.MethodGet, "/api/v1/backends", "").Header().Get("ETag"); got != newETag {
+		t.Errorf("Failed ETag after change: got %s expected %s", got, newETag)
+	}
+
+	// a change based on the old generation conflicts, and changes nothing
+	w = modificationRequestWithHeaders(m, http.MethodDelete, "/api/v1/backends/a", "", map[string]string{"If-Match": etag})
+	checkAPIError(t, "stale change", w, http.StatusPreconditionFailed)
+	if w.Header().Get("ETag") != newETag {
+		t.Errorf("Failed conflict ETag: got %s expected %s", w.Header().Get("ETag"), newETag)
+	}
+	if m.balancer.backendManager.GetBackends().IndexOfID("a") == -1 {
+		t.Errorf("Failed stale change: backend a was removed")
+	}
+
+	w = modificationRequestWithHeaders(m, http.MethodDelete, "/api/v1/backends/a", "", map[string]string{"If-Match": "*"})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Failed wildcard If-Match: got %d expected 204", w.Code)
+	}
+}
+
+func TestAPIRateLimitsGeneration(t *testing.T) {
+	b, m := newTestAPI(t)
+	before := b.GetGeneration()
+
+	modificationRequest(m, http.MethodPut, "/api/v1/ratelimits", `[{"key": "ip", "rate": 10}]`)
+	if b.GetGeneration() != before+1 {
+		t.Errorf("Failed rate limit generation: got %d expected %d", b.GetGeneration(), before+1)
+	}
+
+	// rejected changes dont change the generation
+	modificationRequest(m, http.MethodPut, "/api/v1/ratelimits", `[{"key": "moon phase", "rate": 10}]`)
+	if b.GetGeneration() != before+1 {
+		t.Errorf("Failed rejected generation: got %d expected %d", b.GetGeneration(), before+1)
+	}
+}
+
+func TestAPIAuditLog(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	headers := map[string]string{"X-Actor": "alice"}
+	modificationRequestWithHeaders(m, http.MethodPut, "/api/v1/strategy", `{"name": "LEAST_CONN"}`, headers)
+	modificationRequestWithHeaders(m, http.MethodDelete, "/api/v1/backends/b", "", headers)
+	modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "cookie": {"secret": "hunter2"}}`)
+
+	// failed changes arent recorded
+	modificationRequest(m, http.MethodPut, "/api/v1/strategy", `{"name": "FASTEST"}`)
+
+	var response auditResponse
+	w := modificationRequest(m, http.MethodGet, "/api/v1/audit", "")
+	decodeResponse(t, w, &response)
+
+	if len(response.Entries) != 3 {
+		t.Fatalf("Failed audit entries: got %+v expected 3", response.Entries)
+	}
+
+	strategyEntry := response.Entries[0]
+	if strategyEntry.Actor != "alice" || strategyEntry.RemoteIP != "192.0.2.1" || strategyEntry.Method != http.MethodPut || strategyEntry.Path != "/api/v1/strategy" {
+		t.Errorf("Failed audit entry: got %+v expected a PUT /api/v1/strategy by alice from 192.0.2.1", strategyEntry)
+	}
+	if len(strategyEntry.Changes) != 1 || strategyEntry.Changes[0].Path != "strategy.name" || strategyEntry.Changes[0].After != "LEAST_CONN" {
+		t.Errorf("Failed strategy diff: got %+v expected strategy.name to LEAST_CONN", strategyEntry.Changes)
+	}
+
+	deleteEntry := response.Entries[1]
+	if len(deleteEntry.Changes) == 0 || !strings.HasPrefix(deleteEntry.Changes[0].Path, "backends.b.") || deleteEntry.Changes[0].After != nil {
+		t.Errorf("Failed delete diff: got %+v expected backend b removed", deleteEntry.Changes)
+	}
+
+	stickyEntry := response.Entries[2]
+	if stickyEntry.Actor != "anonymous" || strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Failed sticky entry: got %+v expected anonymous without the secret", stickyEntry)
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/audit?actor=alice&path=/api/v1/backends", "")
+	decodeResponse(t, w, &response)
+	if len(response.Entries) != 1 || response.Entries[0].Path != "/api/v1/backends/b" {
+		t.Errorf("Failed audit query: got %+v expected the delete", response.Entries)
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/audit?limit=none", "")
+	checkAPIError(t, "invalid audit limit", w, http.StatusBadRequest)
+}
@@ -0,0 +1,75 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// How long clients wait before reconnecting to the event stream after losing it.
+	eventRetryInterval = 3 * time.Second
+	// How often a comment is sent on idle event streams, so proxies dont close them.
+	eventKeepaliveInterval = 15 * time.Second
+)
+
+// Streams events as server-sent events, until the client disconnects.
+//
+// The stream starts with "backends" and "strategy" events describing the current state,
+// then sends them again as they change, "health" events as backends die or come back,
+// and a "stats" event with the queue and each backend's traffic every second.
+func (m *modificationServer) streamEvents(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "Streaming events is not supported.")
+		return
+	}
+
+	// subscribe before getting the current state, so no change is missed in between
+	events, unsubscribe := m.balancer.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryInterval.Milliseconds())
+	for _, e := range []struct {
+		eventType string
+		data      interface{}
+	}{
+		{"backends", m.balancer.getBackendsEvent()},
+		{"strategy", m.balancer.getStrategyEvent()},
+	} {
+		encoded, err := json.Marshal(e.data)
+		if err != nil {
+			fmt.Printf("Encoding %s event failed: %s\n", e.eventType, err.Error())
+			return
+		}
+		writeEvent(w, event{Type: e.eventType, Data: encoded})
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			writeEvent(w, e)
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// Writes an event in the server-sent events format.
+// The data is JSON, so it never has newlines needing their own data lines.
+func writeEvent(w io.Writer, e event) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+}
@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Handles an API request, given the values of the path's parameters.
+type apiHandler func(w http.ResponseWriter, r *http.Request, params map[string]string)
+
+type apiRoute struct {
+	method string
+	// The path split into segments, where segments like "{id}" are parameters
+	segments []string
+	handler  apiHandler
+}
+
+// Routes API requests by method and path.
+//
+// Paths can have parameters like "/backends/{id}", which match a single escaped segment,
+// so values containing "/" must be sent escaped as "%2F".
+// Unmatched paths get a 404, and unmatched methods a 405 listing the allowed methods, both as JSON errors.
+type apiRouter struct {
+	prefix string
+	routes []apiRoute
+}
+
+func newAPIRouter(prefix string) *apiRouter {
+	return &apiRouter{prefix: prefix}
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// Adds a route for a method and path, relative to the router's prefix.
+func (ar *apiRouter) handle(method string, path string, handler apiHandler) {
+	ar.routes = append(ar.routes, apiRoute{
+		method:   method,
+		segments: splitPath(path),
+		handler:  handler,
+	})
+}
+
+// Matches a route's segments against a request's, returning the parameters.
+func (route *apiRoute) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func (ar *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, ar.prefix) {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No API at '%s'.", r.URL.Path))
+		return
+	}
+	segments := splitPath(strings.TrimPrefix(path, ar.prefix))
+
+	allowed := []string{}
+	for i := range ar.routes {
+		route := &ar.routes[i]
+
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+
+		if route.method == r.Method {
+			route.handler(w, r, params)
+			return
+		}
+		allowed = append(allowed, route.method)
+	}
+
+	if len(allowed) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No API at '%s'.", r.URL.Path))
+		return
+	}
+
+	sort.Strings(allowed)
+	allowed = append(allowed, http.MethodOptions)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+	if r.Method == http.MethodOptions {
+		// a CORS preflight
+		headers := w.Header()
+		headers.Add("Vary", "Origin")
+		headers.Add("Vary", "Access-Control-Request-Method")
+		headers.Add("Vary", "Access-Control-Request-Headers")
+		headers.Set("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, Authorization, If-Match, X-Actor")
+		headers.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeAPIError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed on '%s'.", r.Method, r.URL.Path))
+}
+
+// The body of every API error response.
+type apiErrorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Writes a value as a JSON response.
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+// Writes an error response, as a JSON envelope describing the error.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	// the status text as a code, e.g. "Not Found" is "not_found"
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+
+	encoded, _ := json.Marshal(apiErrorEnvelope{
+		Error: apiError{
+			Status:  status,
+			Code:    code,
+			Message: message,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+// Decodes a JSON request body, rejecting unknown fields.
+// Writes a 400 response and returns false if the body is invalid.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, out interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(out)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err.Error()))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,643 @@
+package balancer
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The OpenAPI document describing the API.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Shown in place of the sticky session cookie secret, which is never sent by the API.
+// Sending it back keeps the current secret.
+const redactedSecret = "********"
+
+// Creates the router for version 1 of the modification API, under /api/v1.
+func (m *modificationServer) newAPIv1() *apiRouter {
+	ar := newAPIRouter("/api/v1")
+
+	ar.handle(http.MethodGet, "/backends", m.listBackends)
+	ar.handle(http.MethodPost, "/backends", m.mutation(m.addBackend))
+	ar.handle(http.MethodPut, "/backends", m.mutation(m.replaceBackends))
+	ar.handle(http.MethodPatch, "/backends", m.mutation(m.patchBackends))
+	ar.handle(http.MethodGet, "/backends/{id}", m.getBackend)
+	ar.handle(http.MethodPut, "/backends/{id}", m.mutation(m.updateBackend))
+	ar.handle(http.MethodPatch, "/backends/{id}", m.mutation(m.patchBackend))
+	ar.handle(http.MethodDelete, "/backends/{id}", m.mutation(m.deleteBackend))
+
+	ar.handle(http.MethodGet, "/strategy", m.getStrategy)
+	ar.handle(http.MethodPut, "/strategy", m.mutation(m.putStrategy))
+
+	ar.handle(http.MethodGet, "/pools", m.listPools)
+	ar.handle(http.MethodGet, "/pools/{name}", m.getPool)
+
+	ar.handle(http.MethodGet, "/sticky", m.getSticky)
+	ar.handle(http.MethodPut, "/sticky", m.mutation(m.putSticky))
+
+	ar.handle(http.MethodGet, "/ratelimits", m.getRateLimits)
+	ar.handle(http.MethodPut, "/ratelimits", m.mutation(m.putRateLimits))
+
+	ar.handle(http.MethodGet, "/audit", m.getAudit)
+	ar.handle(http.MethodGet, "/config", m.getConfig)
+
+	ar.handle(http.MethodGet, "/health", m.getHealth)
+	ar.handle(http.MethodGet, "/stats", m.getStats)
+	ar.handle(http.MethodGet, "/events", m.streamEvents)
+
+	ar.handle(http.MethodGet, "/openapi.yaml", m.getOpenAPISpec)
+
+	return ar
+}
+
+type backendListResponse struct {
+	Backends []backend.BackendRef `json:"backends"`
+	// The request queue, which requests wait in when every backend is at its connection limit
+	Queue requestQueueStats `json:"queue"`
+}
+
+// Gets the current backends, optionally filtered by the tag, zone and alive query parameters,
+// along with the request queue stats.
+func (m *modificationServer) listBackends(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+
+	backends := m.filterBackends(func(b backend.BackendRef) bool {
+		if tag := query.Get("tag"); tag != "" && !b.HasTag(tag) {
+			return false
+		}
+		if zone := query.Get("zone"); zone != "" && b.GetZone() != zone {
+			return false
+		}
+		if alive := query.Get("alive"); alive != "" && (alive == "true") != b.GetHealthy() {
+			return false
+		}
+		return true
+	})
+
+	writeAPIJSON(w, http.StatusOK, backendListResponse{
+		Backends: backends,
+		Queue:    m.balancer.queue.GetStats(),
+	})
+}
+
+// Gets the current backends accepted by a filter.
+func (m *modificationServer) filterBackends(accept func(backend.BackendRef) bool) []backend.BackendRef {
+	list := m.balancer.backendManager.GetBackends()
+
+	backends := []backend.BackendRef{}
+	for i := 0; i < list.Len(); i++ {
+		if b := list.Get(i); accept(b) {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+// Finds a current backend by ID, writing a 404 response if there isnt one.
+func (m *modificationServer) findBackend(w http.ResponseWriter, id string) (backend.BackendRef, bool) {
+	list := m.balancer.backendManager.GetBackends()
+
+	index := list.IndexOfID(id)
+	if index == -1 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No backend with id '%s'.", id))
+		return nil, false
+	}
+	return list.Get(index), true
+}
+
+// Writes the response to a failed backend modification.
+func writeModifyBackendsError(w http.ResponseWriter, err error) {
+	var exists *backend.BackendExistsError
+	if errors.As(err, &exists) {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, err.Error())
+}
+
+func (m *modificationServer) getBackend(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if b, ok := m.findBackend(w, params["id"]); ok {
+		writeAPIJSON(w, http.StatusOK, b)
+	}
+}
+
+// Adds a backend, responding with it as created.
+func (m *modificationServer) addBackend(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var info config.BackendInfo
+	if !decodeAPIBody(w, r, &info) {
+		return
+	}
+
+	err := m.balancer.AddBackends([]config.BackendInfo{info})
+	if err != nil {
+		writeModifyBackendsError(w, err)
+		return
+	}
+
+	if b, ok := m.findBackend(w, info.GetID()); ok {
+		writeAPIJSON(w, http.StatusCreated, b)
+	}
+}
+
+// Replaces a backend with the one in the request, which keeps the ID in the path.
+func (m *modificationServer) updateBackend(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id := params["id"]
+
+	var info config.BackendInfo
+	if !decodeAPIBody(w, r, &info) {
+		return
+	}
+
+	if info.ID != "" && info.ID != id {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Backend id '%s' doesnt match the path's '%s'.", info.ID, id))
+		return
+	}
+	info.ID = id
+
+	current, ok := m.findBackend(w, id)
+	if !ok {
+		return
+	}
+
+	m.applyBackendInfo(w, current, info)
+}
+
+// Changes to a backend, where missing fields are left as they are.
+type backendUpdate struct {
+	Weight *int  `json:"weight"`
+	Drain  *bool `json:"drain"`
+}
+
+// Changes a backend's weight, or whether it is draining, leaving the rest of it as it is.
+func (m *modificationServer) patchBackend(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var update backendUpdate
+	if !decodeAPIBody(w, r, &update) {
+		return
+	}
+
+	current, ok := m.findBackend(w, params["id"])
+	if !ok {
+		return
+	}
+
+	info := current.GetInfo()
+	if update.Weight != nil {
+		if *update.Weight < 0 {
+			writeAPIError(w, http.StatusBadRequest, "Backend weight must not be negative.")
+			return
+		}
+		info.Weight = *update.Weight
+	}
+	if update.Drain != nil {
+		info.Drain = *update.Drain
+	}
+
+	m.applyBackendInfo(w, current, info)
+}
+
+// Changes a backend to match an info with the same ID, responding with the backend as changed.
+// Changes are made in place, unless the backend's address, protocol or TLS change, which replaces it.
+func (m *modificationServer) applyBackendInfo(w http.ResponseWriter, current backend.BackendRef, info config.BackendInfo) {
+	id := current.GetID()
+
+	var err error
+	if sameBackendInfo(info, current) {
+		err = m.balancer.SetBackendDraining(id, info.Drain)
+	} else {
+		err = m.balancer.ModifyBackends([]config.BackendInfo{infoWithID(id)}, []config.BackendInfo{info})
+	}
+	if err != nil {
+		writeModifyBackendsError(w, err)
+		return
+	}
+
+	if b, ok := m.findBackend(w, id); ok {
+		writeAPIJSON(w, http.StatusOK, b)
+	}
+}
+
+// Reports whether an info describes a backend as it is, apart from whether it is draining,
+// which can be changed without replacing the backend.
+func sameBackendInfo(info config.BackendInfo, b backend.BackendRef) bool {
+	current := b.GetInfo()
+	current.Drain = info.Drain
+	return reflect.DeepEqual(info, current)
+}
+
+func (m *modificationServer) deleteBackend(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id := params["id"]
+
+	if _, ok := m.findBackend(w, id); !ok {
+		return
+	}
+
+	err := m.balancer.RemoveBackends([]config.BackendInfo{infoWithID(id)})
+	if err != nil {
+		writeModifyBackendsError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Creates an info which only matches the backend with an ID, for removing it.
+func infoWithID(id string) config.BackendInfo {
+	var info config.BackendInfo
+	info.ID = id
+	return info
+}
+
+// Replaces every backend with those in the request, in one modification.
+// Backends which are unchanged are kept as they are, along with their state.
+func (m *modificationServer) replaceBackends(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var infos []config.BackendInfo
+	if !decodeAPIBody(w, r, &infos) {
+		return
+	}
+
+	wanted := make(map[string]config.BackendInfo, len(infos))
+	for _, info := range infos {
+		if _, ok := wanted[info.GetID()]; ok {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Duplicate backend id '%s'.", info.GetID()))
+			return
+		}
+		wanted[info.GetID()] = info
+	}
+
+	list := m.balancer.backendManager.GetBackends()
+	remove := []config.BackendInfo{}
+	kept := make(map[string]bool)
+
+	for i := 0; i < list.Len(); i++ {
+		b := list.Get(i)
+		if info, ok := wanted[b.GetID()]; ok && sameBackendInfo(info, b) {
+			kept[b.GetID()] = true
+			continue
+		}
+		remove = append(remove, infoWithID(b.GetID()))
+	}
+
+	add := []config.BackendInfo{}
+	for _, info := range infos {
+		if !kept[info.GetID()] {
+			add = append(add, info)
+		}
+	}
+
+	err := m.balancer.ModifyBackends(remove, add)
+	if err != nil {
+		writeModifyBackendsError(w, err)
+		return
+	}
+
+	// kept backends are drained or enabled in place
+	for id := range kept {
+		err = m.balancer.SetBackendDraining(id, wanted[id].Drain)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	m.listBackends(w, r, params)
+}
+
+// A bulk change to the backends, applied in one modification.
+type backendPatch struct {
+	Add    []config.BackendInfo `json:"add"`
+	Remove []string             `json:"remove"`
+}
+
+// Adds and removes backends in one modification. Nothing is changed if any part of it fails.
+func (m *modificationServer) patchBackends(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var patch backendPatch
+	if !decodeAPIBody(w, r, &patch) {
+		return
+	}
+
+	list := m.balancer.backendManager.GetBackends()
+	remove := make([]config.BackendInfo, len(patch.Remove))
+	for i, id := range patch.Remove {
+		if list.IndexOfID(id) == -1 {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No backend with id '%s'.", id))
+			return
+		}
+		remove[i] = infoWithID(id)
+	}
+
+	err := m.balancer.ModifyBackends(remove, patch.Add)
+	if err != nil {
+		writeModifyBackendsError(w, err)
+		return
+	}
+
+	m.listBackends(w, r, params)
+}
+
+func (m *modificationServer) getStrategy(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeAPIJSON(w, http.StatusOK, m.balancer.GetStrategyConfig())
+}
+
+// Changes the strategy to the one in the request.
+// Invalid strategies are rejected, keeping the current one.
+func (m *modificationServer) putStrategy(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var cfg config.StrategyConfig
+	if !decodeAPIBody(w, r, &cfg) {
+		return
+	}
+
+	err := m.balancer.ChangeStrategy(cfg)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m.getStrategy(w, r, params)
+}
+
+// A group of backends sharing a tag.
+type poolSummary struct {
+	Name       string   `json:"name"`
+	BackendIDs []string `json:"backendIds"`
+	Total      int      `json:"total"`
+	Alive      int      `json:"alive"`
+}
+
+type poolListResponse struct {
+	Pools []poolSummary `json:"pools"`
+}
+
+type poolResponse struct {
+	Name     string               `json:"name"`
+	Backends []backend.BackendRef `json:"backends"`
+	Total    int                  `json:"total"`
+	Alive    int                  `json:"alive"`
+}
+
+// Gets the pools of backends, one for each tag, ordered by name.
+func (m *modificationServer) listPools(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	pools := make(map[string]*poolSummary)
+
+	for _, b := range m.filterBackends(func(backend.BackendRef) bool { return true }) {
+		for _, tag := range b.GetInfo().Tags {
+			pool, ok := pools[tag]
+			if !ok {
+				pool = &poolSummary{Name: tag, BackendIDs: []string{}}
+				pools[tag] = pool
+			}
+
+			pool.BackendIDs = append(pool.BackendIDs, b.GetID())
+			pool.Total++
+			if b.GetHealthy() {
+				pool.Alive++
+			}
+		}
+	}
+
+	response := poolListResponse{Pools: []poolSummary{}}
+	for _, pool := range pools {
+		response.Pools = append(response.Pools, *pool)
+	}
+	sort.Slice(response.Pools, func(i, j int) bool {
+		return response.Pools[i].Name < response.Pools[j].Name
+	})
+
+	writeAPIJSON(w, http.StatusOK, response)
+}
+
+// Gets the backends in a pool.
+func (m *modificationServer) getPool(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	name := params["name"]
+
+	backends := m.filterBackends(func(b backend.BackendRef) bool {
+		return b.HasTag(name)
+	})
+	if len(backends) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No pool named '%s'.", name))
+		return
+	}
+
+	response := poolResponse{Name: name, Backends: backends, Total: len(backends)}
+	for _, b := range backends {
+		if b.GetHealthy() {
+			response.Alive++
+		}
+	}
+
+	writeAPIJSON(w, http.StatusOK, response)
+}
+
+// Gets the sticky session config, in the same format as the config file.
+func (m *modificationServer) getSticky(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	cfg := m.balancer.GetStickyConfig()
+	if cfg.Cookie.Secret != "" {
+		cfg.Cookie.Secret = redactedSecret
+	}
+
+	// go through YAML so fields and durations (e.g. "15m0s") match the config file
+	var out interface{}
+	err := config.CastProperties(cfg, &out)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, out)
+}
+
+// Replaces the sticky sessions with ones from the config in the request.
+func (m *modificationServer) putSticky(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var body interface{}
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	cfg, err := castStrict[config.StickyConfig](body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if cfg.Cookie.Secret == redactedSecret {
+		cfg.Cookie.Secret = m.balancer.GetStickyConfig().Cookie.Secret
+	}
+
+	err = m.balancer.ChangeStickyConfig(cfg)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m.getSticky(w, r, params)
+}
+
+// Converts decoded JSON into a config type through YAML, rejecting unknown fields.
+func castStrict[T any](value interface{}) (T, error) {
+	var out T
+
+	marshalled, err := yaml.Marshal(value)
+	if err != nil {
+		return out, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(marshalled))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(&out)
+	if err != nil {
+		return out, fmt.Errorf("Invalid request body: %s", err.Error())
+	}
+	return out, nil
+}
+
+func (m *modificationServer) getRateLimits(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeAPIJSON(w, http.StatusOK, m.balancer.GetRateLimits())
+}
+
+// Replaces the rate limits with the list in the request.
+func (m *modificationServer) putRateLimits(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var cfgs []config.RateLimitConfig
+	if !decodeAPIBody(w, r, &cfgs) {
+		return
+	}
+
+	err := m.balancer.SetRateLimits(cfgs)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m.getRateLimits(w, r, params)
+}
+
+type healthResponse struct {
+	// "healthy" if every backend is alive, "degraded" if some are, or "unhealthy" if none are
+	Status   string `json:"status"`
+	Backends struct {
+		Total int `json:"total"`
+		Alive int `json:"alive"`
+		Dead  int `json:"dead"`
+		// Alive backends whose circuit breaker is open
+		CircuitOpen int `json:"circuitOpen"`
+	} `json:"backends"`
+}
+
+// Reports whether the balancer has backends to send requests to.
+// Responds 503 when it is unhealthy, so it can be used as a load balancer health check.
+func (m *modificationServer) getHealth(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var response healthResponse
+
+	for _, b := range m.filterBackends(func(backend.BackendRef) bool { return true }) {
+		response.Backends.Total++
+		if !b.GetHealthy() {
+			response.Backends.Dead++
+			continue
+		}
+		response.Backends.Alive++
+		if b.GetCircuitState() == "open" {
+			response.Backends.CircuitOpen++
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case response.Backends.Alive == 0:
+		response.Status = "unhealthy"
+		status = http.StatusServiceUnavailable
+	case response.Backends.Alive < response.Backends.Total:
+		response.Status = "degraded"
+	default:
+		response.Status = "healthy"
+	}
+
+	writeAPIJSON(w, status, response)
+}
+
+type backendStats struct {
+	ID          string `json:"id"`
+	Alive       bool   `json:"alive"`
+	Connections int    `json:"connections"`
+	Requests    uint64 `json:"requests"`
+	Failures    uint64 `json:"failures"`
+	Circuit     string `json:"circuit"`
+}
+
+type statsResponse struct {
+	UptimeSeconds int64 `json:"uptimeSeconds"`
+	Goroutines    int   `json:"goroutines"`
+
+	Requests struct {
+		Total         uint64 `json:"total"`
+		RateLimited   uint64 `json:"rateLimited"`
+		QueueRejected uint64 `json:"queueRejected"`
+		NoBackend     uint64 `json:"noBackend"`
+		Failed        uint64 `json:"failed"`
+	} `json:"requests"`
+
+	Queue    requestQueueStats `json:"queue"`
+	Backends []backendStats    `json:"backends"`
+}
+
+// Gets counts of the requests handled, the request queue's stats and each backend's load.
+func (m *modificationServer) getStats(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	stats := &m.balancer.stats
+
+	var response statsResponse
+	response.UptimeSeconds = int64(time.Since(stats.started).Seconds())
+	response.Goroutines = runtime.NumGoroutine()
+
+	response.Requests.Total = stats.requests.Load()
+	response.Requests.RateLimited = stats.rateLimited.Load()
+	response.Requests.QueueRejected = stats.queueRejected.Load()
+	response.Requests.NoBackend = stats.noBackend.Load()
+	response.Requests.Failed = stats.failed.Load()
+
+	response.Queue = m.balancer.queue.GetStats()
+
+	response.Backends = []backendStats{}
+	for _, b := range m.filterBackends(func(backend.BackendRef) bool { return true }) {
+		requests, failures := b.GetRequestCounts()
+		response.Backends = append(response.Backends, backendStats{
+			ID:          b.GetID(),
+			Alive:       b.GetHealthy(),
+			Connections: b.GetActiveConnections(),
+			Requests:    requests,
+			Failures:    failures,
+			Circuit:     b.GetCircuitState(),
+		})
+	}
+
+	writeAPIJSON(w, http.StatusOK, response)
+}
+
+// Exports the current config as YAML, with secrets hidden.
+// This is the config file with the sections changed at runtime replaced.
+func (m *modificationServer) getConfig(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	document, err := m.persister.Export(m.balancer.GetRuntimeConfig())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
+
+func (m *modificationServer) getOpenAPISpec(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
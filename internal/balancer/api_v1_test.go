@@ -0,0 +1,684 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-balancer/internal/audit"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sends a request to the modification server's handler, returning the response.
+func modificationRequest(m *modificationServer, method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	m.handler().ServeHTTP(w, r)
+	return w
+}
+
+// Creates a modification server with an audit log kept in memory.
+func newTestModificationServer(t *testing.T, b *balancer) *modificationServer {
+	auditLog, err := audit.NewLog(config.AuditConfig{})
+	if err != nil {
+		t.Fatalf("Failed creating audit log: %s", err.Error())
+	}
+
+	persister, err := config.NewPersister(config.PersistConfig{}, "")
+	if err != nil {
+		t.Fatalf("Failed creating persister: %s", err.Error())
+	}
+
+	m := NewModificationServer(b, config.AdminConfig{}, auditLog, persister)
+	return &m
+}
+
+// Decodes a response body, failing the test if it isnt valid JSON.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, out interface{}) {
+	err := json.Unmarshal(w.Body.Bytes(), out)
+	if err != nil {
+		t.Fatalf("Failed decoding response %s: %s", w.Body.String(), err.Error())
+	}
+}
+
+// Checks a response is an error envelope with the expected status.
+func checkAPIError(t *testing.T, name string, w *httptest.ResponseRecorder, status int) {
+	var envelope apiErrorEnvelope
+	json.Unmarshal(w.Body.Bytes(), &envelope)
+
+	if w.Code != status || envelope.Error.Status != status || envelope.Error.Message == "" {
+		t.Errorf("Failed %s: got %d %s expected %d error", name, w.Code, w.Body.String(), status)
+	}
+}
+
+type testBackendList struct {
+	Backends []struct {
+		ID     string
+		Name   string
+		Port   int
+		Weight int
+		Alive  bool
+	}
+	Queue *requestQueueStats
+}
+
+// Gets the IDs of the backends in a list response, ordered.
+func (l testBackendList) ids() []string {
+	ids := []string{}
+	for _, b := range l.Backends {
+		ids = append(ids, b.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func checkIDs(t *testing.T, name string, got []string, expected []string) {
+	sort.Strings(expected)
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Failed %s: got %v expected %v", name, got, expected)
+	}
+}
+
+// Creates a balancer with tagged backends "a" (web, eu), "b" (web, us) and "c" (api, eu), and a server for it.
+func newTestAPI(t *testing.T) (*balancer, *modificationServer) {
+	_, infos := newTestBackends(t, 3)
+
+	tags := [][]string{{"web"}, {"web"}, {"api"}}
+	zones := []string{"eu", "us", "eu"}
+	for i := range infos {
+		infos[i].ID = string(rune('a' + i))
+		infos[i].Tags = tags[i]
+		infos[i].Zone = zones[i]
+	}
+
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+	m := newTestModificationServer(t, b)
+	return b, m
+}
+
+func TestAPIListBackends(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	cases := map[string][]string{
+		"/api/v1/backends":                    {"a", "b", "c"},
+		"/api/v1/backends?tag=web":            {"a", "b"},
+		"/api/v1/backends?zone=eu":            {"a", "c"},
+		"/api/v1/backends?tag=web&zone=eu":    {"a"},
+		"/api/v1/backends?alive=false":        {},
+		"/api/v1/backends?tag=missing":        {},
+		"/api/v1/backends?alive=true&tag=api": {"c"},
+	}
+
+	for path, expected := range cases {
+		w := modificationRequest(m, http.MethodGet, path, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("Failed %s: got %d expected 200", path, w.Code)
+			continue
+		}
+
+		var list testBackendList
+		decodeResponse(t, w, &list)
+		checkIDs(t, path, list.ids(), expected)
+
+		if list.Queue == nil {
+			t.Errorf("Failed %s: got no queue stats expected them alongside the backends", path)
+		}
+	}
+}
+
+func TestAPIGetBackend(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/backends/b", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"b"`) {
+		t.Errorf("Failed get backend: got %d %s expected 200 with b", w.Code, w.Body.String())
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/backends/missing", "")
+	checkAPIError(t, "get missing backend", w, http.StatusNotFound)
+}
+
+func TestAPIAddBackend(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	_, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	body := fmt.Sprintf(`{"id": "consul/web-1", "host": "%s", "port": %d, "weight": 3}`, info.Host, info.Port)
+
+	w := modificationRequest(m, http.MethodPost, "/api/v1/backends", body)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"weight":3`) {
+		t.Fatalf("Failed add backend: got %d %s expected 201", w.Code, w.Body.String())
+	}
+	if b.backendManager.GetBackends().IndexOfID("consul/web-1") == -1 {
+		t.Errorf("Failed add backend: backend consul/web-1 missing")
+	}
+
+	// IDs containing "/" are escaped in paths
+	w = modificationRequest(m, http.MethodGet, "/api/v1/backends/consul%2Fweb-1", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed get escaped id: got %d expected 200", w.Code)
+	}
+
+	w = modificationRequest(m, http.MethodPost, "/api/v1/backends", body)
+	checkAPIError(t, "add existing backend", w, http.StatusConflict)
+
+	w = modificationRequest(m, http.MethodPost, "/api/v1/backends", `{"host": "localhost", "port": 80, "colour": "red"}`)
+	checkAPIError(t, "add with unknown field", w, http.StatusBadRequest)
+
+	w = modificationRequest(m, http.MethodPost, "/api/v1/backends", `{"host": "localhost", "port": 70000}`)
+	checkAPIError(t, "add with invalid port", w, http.StatusBadRequest)
+}
+
+func TestAPIUpdateBackend(t *testing.T) {
+	b, m := newTestAPI(t)
+	info := b.backendManager.GetBackends().Get(0).GetInfo()
+
+	body := fmt.Sprintf(`{"name": "renamed", "host": "%s", "port": %d, "weight": 5}`, info.Host, info.Port)
+	w := modificationRequest(m, http.MethodPut, "/api/v1/backends/a", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed update backend: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	list := b.backendManager.GetBackends()
+	updated := list.Get(list.IndexOfID("a"))
+	if updated.GetName() != "renamed" || updated.GetWeight() != 5 {
+		t.Errorf("Failed update backend: got %s weighted %d expected renamed weighted 5", updated.GetName(), updated.GetWeight())
+	}
+
+	// changing the protocol replaces the backend
+	body = fmt.Sprintf(`{"host": "%s", "port": %d, "protocol": "%s"}`, info.Host, info.Port, config.ProtocolH2C)
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends/a", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed update backend protocol: got %d %s expected 200", w.Code, w.Body.String())
+	}
+	list = b.backendManager.GetBackends()
+	if replaced := list.Get(list.IndexOfID("a")); replaced == updated || replaced.GetInfo().Protocol != config.ProtocolH2C {
+		t.Errorf("Failed update backend protocol: expected the backend to be replaced using h2c")
+	}
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends/a", `{"id": "z", "host": "localhost", "port": 80}`)
+	checkAPIError(t, "update with different id", w, http.StatusBadRequest)
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends/missing", `{"host": "localhost", "port": 80}`)
+	checkAPIError(t, "update missing backend", w, http.StatusNotFound)
+
+	// taking another backend's url is a conflict, and leaves the backend as it was
+	other := list.Get(list.IndexOfID("b")).GetInfo()
+	body = fmt.Sprintf(`{"host": "%s", "port": %d}`, other.Host, other.Port)
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends/a", body)
+	checkAPIError(t, "update to existing url", w, http.StatusConflict)
+
+	if b.backendManager.GetBackends().IndexOfID("a") == -1 {
+		t.Errorf("Failed conflicting update: backend a was removed")
+	}
+}
+
+func TestAPIPatchBackend(t *testing.T) {
+	b, m := newTestAPI(t)
+	list := b.backendManager.GetBackends()
+	a := list.Get(list.IndexOfID("a"))
+
+	// draining changes the backend in place, and it gets no new requests
+	w := modificationRequest(m, http.MethodPatch, "/api/v1/backends/a", `{"drain": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed drain backend: got %d %s expected 200", w.Code, w.Body.String())
+	}
+	list = b.backendManager.GetBackends()
+	if list.Get(list.IndexOfID("a")) != a || !a.GetDraining() || !a.GetInfo().Drain {
+		t.Errorf("Failed drain backend: backend a was recreated or isnt draining")
+	}
+
+	for i := 0; i < 6; i++ {
+		serveTestRequest(b, "/")
+	}
+	if requests, _ := a.GetRequestCounts(); requests != 0 {
+		t.Errorf("Failed drained backend: got %d requests expected 0", requests)
+	}
+
+	// changing the weight also changes the backend in place, so it stays draining, dead, and keeps its counts
+	other := list.Get(list.IndexOfID("b"))
+	serveTestRequest(b, "/")
+	otherRequests, _ := other.GetRequestCounts()
+	b.backendManager.ReportBackendDead(other)
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/a", `{"weight": 3}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed set weight: got %d %s expected 200", w.Code, w.Body.String())
+	}
+	list = b.backendManager.GetBackends()
+	updated := list.Get(list.IndexOfID("a"))
+	if updated != a || updated.GetWeight() != 3 || !updated.GetDraining() || updated.GetZone() != "eu" {
+		t.Errorf("Failed set weight: got weight %d draining %t zone %s expected the same backend weighted 3 draining in eu", updated.GetWeight(), updated.GetDraining(), updated.GetZone())
+	}
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/b", `{"weight": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed set weight of dead backend: got %d %s expected 200", w.Code, w.Body.String())
+	}
+	list = b.backendManager.GetBackends()
+	requests, _ := other.GetRequestCounts()
+	if list.Get(list.IndexOfID("b")) != other || other.GetAlive() || other.GetWeight() != 2 || requests != otherRequests {
+		t.Errorf("Failed set weight of dead backend: got alive %t weight %d with %d requests expected the same dead backend weighted 2 with %d requests", other.GetAlive(), other.GetWeight(), requests, otherRequests)
+	}
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/a", `{"drain": false}`)
+	if w.Code != http.StatusOK || updated.GetDraining() {
+		t.Errorf("Failed enable backend: got %d draining %t expected 200 not draining", w.Code, updated.GetDraining())
+	}
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/a", `{"weight": -1}`)
+	checkAPIError(t, "negative weight", w, http.StatusBadRequest)
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/a", `{"host": "elsewhere"}`)
+	checkAPIError(t, "patch unknown field", w, http.StatusBadRequest)
+
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends/missing", `{"drain": true}`)
+	checkAPIError(t, "patch missing backend", w, http.StatusNotFound)
+}
+
+func TestAPIDeleteBackend(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodDelete, "/api/v1/backends/a", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Failed delete backend: got %d expected 204", w.Code)
+	}
+	if b.backendManager.GetBackends().IndexOfID("a") != -1 {
+		t.Errorf("Failed delete backend: backend a still exists")
+	}
+
+	w = modificationRequest(m, http.MethodDelete, "/api/v1/backends/a", "")
+	checkAPIError(t, "delete missing backend", w, http.StatusNotFound)
+}
+
+func TestAPIReplaceBackends(t *testing.T) {
+	b, m := newTestAPI(t)
+	list := b.backendManager.GetBackends()
+	a := list.Get(list.IndexOfID("a"))
+
+	_, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	aInfo := a.GetInfo()
+	infos := []config.BackendInfo{aInfo, info}
+	body, _ := json.Marshal(infos)
+
+	w := modificationRequest(m, http.MethodPut, "/api/v1/backends", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed replace backends: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	var response testBackendList
+	decodeResponse(t, w, &response)
+	checkIDs(t, "replace backends", response.ids(), []string{"a", info.GetID()})
+
+	// unchanged backends are kept as they were
+	list = b.backendManager.GetBackends()
+	if list.Get(list.IndexOfID("a")) != a {
+		t.Errorf("Failed keeping unchanged backend: backend a was recreated")
+	}
+
+	// draining is changed in place too
+	infos[0].Drain = true
+	body, _ = json.Marshal(infos)
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends", string(body))
+	list = b.backendManager.GetBackends()
+	if w.Code != http.StatusOK || list.Get(list.IndexOfID("a")) != a || !a.GetDraining() {
+		t.Errorf("Failed draining with replace: got %d, backend a recreated or not draining", w.Code)
+	}
+
+	infos = append(infos, aInfo)
+	body, _ = json.Marshal(infos)
+	w = modificationRequest(m, http.MethodPut, "/api/v1/backends", string(body))
+	checkAPIError(t, "replace with duplicate ids", w, http.StatusBadRequest)
+}
+
+func TestAPIPatchBackends(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	_, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	body := fmt.Sprintf(`{"add": [{"id": "d", "host": "%s", "port": %d}], "remove": ["a", "b"]}`, info.Host, info.Port)
+
+	w := modificationRequest(m, http.MethodPatch, "/api/v1/backends", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed patch backends: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	var response testBackendList
+	decodeResponse(t, w, &response)
+	checkIDs(t, "patch backends", response.ids(), []string{"c", "d"})
+
+	// nothing changes if any part of the patch fails
+	w = modificationRequest(m, http.MethodPatch, "/api/v1/backends", `{"remove": ["c", "missing"]}`)
+	checkAPIError(t, "patch removing missing backend", w, http.StatusNotFound)
+
+	if b.backendManager.GetBackends().IndexOfID("c") == -1 {
+		t.Errorf("Failed failed patch: backend c was removed")
+	}
+}
+
+func TestAPIStrategy(t *testing.T) {
+	_, infos := newTestBackends(t, 2)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+	m := newTestModificationServer(t, b)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/strategy", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"ROUND_ROBIN"`) {
+		t.Errorf("Failed get strategy: got %d %s expected 200 with ROUND_ROBIN", w.Code, w.Body.String())
+	}
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/strategy", `{"name": "REQUEST_HASH", "properties": {"key": "path", "boundedLoad": true}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed put strategy: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	var cfg struct {
+		Name       string
+		Properties map[string]interface{}
+	}
+	decodeResponse(t, w, &cfg)
+	if cfg.Name != "REQUEST_HASH" || cfg.Properties["key"] != "path" {
+		t.Errorf("Failed put strategy response: got %+v expected REQUEST_HASH keyed by path", cfg)
+	}
+	if name := b.GetStrategyConfig().Name; name != "REQUEST_HASH" {
+		t.Errorf("Failed changing strategy: got %s expected REQUEST_HASH", name)
+	}
+
+	// the strategy is used for requests straight away
+	if code := serveTestRequest(b, "/"); code != http.StatusOK {
+		t.Errorf("Failed request after changing strategy: got %d expected 200", code)
+	}
+}
+
+func TestAPIInvalidStrategy(t *testing.T) {
+	_, infos := newTestBackends(t, 2)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+	m := newTestModificationServer(t, b)
+
+	invalid := []string{
+		`{"name": "FASTEST"}`,
+		`{"name": "REQUEST_HASH", "properties": {"key": "cookie"}}`,
+		`{"name": "ROUND_ROBIN", "properties": {"weights": "heavy"}}`,
+		`{"name": "ROUND_ROBIN", "extra": true}`,
+		`not json`,
+	}
+
+	for _, body := range invalid {
+		w := modificationRequest(m, http.MethodPut, "/api/v1/strategy", body)
+		checkAPIError(t, "invalid strategy "+body, w, http.StatusBadRequest)
+	}
+
+	// invalid strategies keep the current one
+	if name := b.GetStrategyConfig().Name; name != "ROUND_ROBIN" {
+		t.Errorf("Failed keeping strategy: got %s expected ROUND_ROBIN", name)
+	}
+}
+
+func TestAPIPools(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/pools", "")
+	var pools poolListResponse
+	decodeResponse(t, w, &pools)
+
+	if len(pools.Pools) != 2 || pools.Pools[0].Name != "api" || pools.Pools[1].Name != "web" {
+		t.Fatalf("Failed list pools: got %+v expected api and web", pools.Pools)
+	}
+	if web := pools.Pools[1]; web.Total != 2 || web.Alive != 2 {
+		t.Errorf("Failed web pool: got %d of %d alive expected 2 of 2", web.Alive, web.Total)
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/pools/web", "")
+	var list testBackendList
+	decodeResponse(t, w, &list)
+	checkIDs(t, "get pool", list.ids(), []string{"a", "b"})
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/pools/missing", "")
+	checkAPIError(t, "get missing pool", w, http.StatusNotFound)
+}
+
+func TestAPISticky(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "mode": "header", "header": "X-User", "ttl": "1m"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed put sticky: got %d %s expected 200", w.Code, w.Body.String())
+	}
+	if cfg := b.GetStickyConfig(); !cfg.Enabled || cfg.Mode != "header" || cfg.TTL.Seconds() != 60 {
+		t.Errorf("Failed put sticky: got %+v expected header mode with a 1m ttl", cfg)
+	}
+
+	// the secret is never shown, and sending it back keeps it
+	w = modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "cookie": {"secret": "hunter2"}}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Failed hiding secret: got %d %s expected 200 without the secret", w.Code, w.Body.String())
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/sticky", "")
+	modificationRequest(m, http.MethodPut, "/api/v1/sticky", w.Body.String())
+	if secret := b.GetStickyConfig().Cookie.Secret; secret != "hunter2" {
+		t.Errorf("Failed keeping secret: got %s expected hunter2", secret)
+	}
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "mode": "telepathy"}`)
+	checkAPIError(t, "put invalid sticky mode", w, http.StatusBadRequest)
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "colour": "red"}`)
+	checkAPIError(t, "put sticky with unknown field", w, http.StatusBadRequest)
+}
+
+func TestAPIRateLimits(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodPut, "/api/v1/ratelimits", `[{"key": "ip", "rate": 10}]`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"key":"ip"`) {
+		t.Errorf("Failed put rate limits: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/api/v1/ratelimits", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"rate":10`) {
+		t.Errorf("Failed get rate limits: got %d %s expected 200", w.Code, w.Body.String())
+	}
+
+	w = modificationRequest(m, http.MethodPut, "/api/v1/ratelimits", `[{"key": "moon phase", "rate": 10}]`)
+	checkAPIError(t, "put invalid rate limit", w, http.StatusBadRequest)
+}
+
+func TestAPIHealth(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	var health healthResponse
+	w := modificationRequest(m, http.MethodGet, "/api/v1/health", "")
+	decodeResponse(t, w, &health)
+	if w.Code != http.StatusOK || health.Status != "healthy" || health.Backends.Alive != 3 {
+		t.Errorf("Failed healthy: got %d %+v expected 200 healthy", w.Code, health)
+	}
+
+	b.backendManager.ReportBackendDead(b.backendManager.GetBackends().Get(0))
+	w = modificationRequest(m, http.MethodGet, "/api/v1/health", "")
+	decodeResponse(t, w, &health)
+	if w.Code != http.StatusOK || health.Status != "degraded" || health.Backends.Dead != 1 {
+		t.Errorf("Failed degraded: got %d %+v expected 200 degraded", w.Code, health)
+	}
+
+	b.RemoveBackends([]config.BackendInfo{infoWithID("a"), infoWithID("b"), infoWithID("c")})
+	w = modificationRequest(m, http.MethodGet, "/api/v1/health", "")
+	decodeResponse(t, w, &health)
+	if w.Code != http.StatusServiceUnavailable || health.Status != "unhealthy" {
+		t.Errorf("Failed unhealthy: got %d %+v expected 503 unhealthy", w.Code, health)
+	}
+}
+
+func TestAPIStats(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	for i := 0; i < 3; i++ {
+		serveTestRequest(b, "/")
+	}
+
+	var stats statsResponse
+	w := modificationRequest(m, http.MethodGet, "/api/v1/stats", "")
+	decodeResponse(t, w, &stats)
+
+	if stats.Requests.Total != 3 {
+		t.Errorf("Failed total requests: got %d expected 3", stats.Requests.Total)
+	}
+
+	var perBackend uint64
+	for _, s := range stats.Backends {
+		perBackend += s.Requests
+	}
+	if len(stats.Backends) != 3 || perBackend != 3 {
+		t.Errorf("Failed backend stats: got %+v expected 3 requests over 3 backends", stats.Backends)
+	}
+}
+
+func TestAPIRouting(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/nothing", "")
+	checkAPIError(t, "unknown path", w, http.StatusNotFound)
+
+	w = modificationRequest(m, http.MethodGet, "/api/backends", "")
+	checkAPIError(t, "unversioned path", w, http.StatusNotFound)
+
+	w = modificationRequest(m, http.MethodDelete, "/api/v1/strategy", "")
+	checkAPIError(t, "unknown method", w, http.StatusMethodNotAllowed)
+	if allow := w.Header().Get("Allow"); allow != "GET, PUT, OPTIONS" {
+		t.Errorf("Failed Allow header: got %s expected GET, PUT, OPTIONS", allow)
+	}
+
+	w = modificationRequest(m, http.MethodOptions, "/api/v1/backends/a", "")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "DELETE, GET, PATCH, PUT, OPTIONS" {
+		t.Errorf("Failed preflight: got %d %v expected 204 allowing DELETE, GET, PATCH, PUT", w.Code, w.Header())
+	}
+}
+
+// Tests the dashboard is served alongside the API.
+func TestDashboard(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "javascript/event-stream.js") {
+		t.Errorf("Failed dashboard index: got %d expected 200 with the dashboard", w.Code)
+	}
+
+	w = modificationRequest(m, http.MethodGet, "/javascript/dashboard.js", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed dashboard script: got %d expected 200", w.Code)
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("Failed dashboard ETag: got %s expected none", w.Header().Get("ETag"))
+	}
+}
+
+// Tests the OpenAPI document describes exactly the routes the API has.
+func TestAPIOpenAPISpec(t *testing.T) {
+	_, m := newTestAPI(t)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/openapi.yaml", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed get spec: got %d expected 200", w.Code)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `yaml:"paths"`
+	}
+	err := yaml.Unmarshal(w.Body.Bytes(), &spec)
+	if err != nil {
+		t.Fatalf("Failed parsing spec: %s", err.Error())
+	}
+
+	documented := []string{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" /"+path[1:])
+			}
+		}
+	}
+
+	routed := []string{}
+	for _, route := range m.newAPIv1().routes {
+		routed = append(routed, route.method+" /"+strings.Join(route.segments, "/"))
+	}
+	sort.Strings(documented)
+
+	checkIDs(t, "documented routes", documented, routed)
+}
+
+func TestAPIConfigExport(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	// discovered backends are left out, as discovery finds them again
+	_, discovered := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	discovered.ID = "discovered"
+	discovered.Source = "DNS name web.local"
+	b.AddBackends([]config.BackendInfo{discovered})
+
+	modificationRequest(m, http.MethodPut, "/api/v1/sticky", `{"enabled": true, "cookie": {"secret": "hunter2"}}`)
+
+	w := modificationRequest(m, http.MethodGet, "/api/v1/config", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/yaml" {
+		t.Fatalf("Failed export: got %d %s expected 200 YAML", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var exported config.Config
+	err := yaml.Unmarshal(w.Body.Bytes(), &exported)
+	if err != nil {
+		t.Fatalf("Failed parsing export: %s", err.Error())
+	}
+
+	ids := []string{}
+	for _, info := range exported.Backends {
+		ids = append(ids, info.GetID())
+	}
+	sort.Strings(ids)
+	checkIDs(t, "exported backends", ids, []string{"a", "b", "c"})
+
+	if !exported.Sticky.Enabled || exported.Sticky.Cookie.Secret != "********" {
+		t.Errorf("Failed exported sticky: got %+v expected enabled with the secret hidden", exported.Sticky)
+	}
+}
+
+func TestAPIPersistsChanges(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("# the balancer\nport: 8080\n"), 0600)
+
+	persister, err := config.NewPersister(config.PersistConfig{Mode: "config"}, path)
+	if err != nil {
+		t.Fatalf("Failed creating persister: %s", err.Error())
+	}
+	m.persister = persister
+
+	modificationRequest(m, http.MethodDelete, "/api/v1/backends/a", "")
+
+	saved, err := config.ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed reading saved config: %s", err.Error())
+	}
+
+	ids := []string{}
+	for _, info := range saved.Backends {
+		ids = append(ids, info.GetID())
+	}
+	sort.Strings(ids)
+	checkIDs(t, "saved backends", ids, []string{"b", "c"})
+
+	if saved.Port != 8080 || saved.Strategy.Name != b.GetStrategyConfig().Name {
+		t.Errorf("Failed saved config: got %+v", saved)
+	}
+
+	// rejected changes arent saved
+	data, _ := os.ReadFile(path)
+	modificationRequest(m, http.MethodDelete, "/api/v1/backends/missing", "")
+	if after, _ := os.ReadFile(path); string(after) != string(data) || !strings.Contains(string(after), "# the balancer") {
+		t.Errorf("Failed leaving config: got\n%s", after)
+	}
+}
@@ -1,45 +1,166 @@
 package balancer
 
 import (
+	"errors"
 	"fmt"
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/balancer/route"
 	"go-balancer/internal/balancer/strategy"
+	"go-balancer/internal/ratelimit"
+	"math"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type balancer struct {
 	backendManager *backend.BackendManager
 
-	// The strategy implements a load balancing algorithm which selects which backend to use next.
-	strategy strategy.BalancerStrategy
+	// The backends and strategy requests are balanced with.
+	// Modifications publish a new snapshot, so requests never wait for them (or they for requests).
+	snapshot atomic.Pointer[balancerSnapshot]
+
 	// The config that was used to build the current strategy.
-	// This is needed as when the backend list is updated, we rebuild the strategy from scratch.
+	// This is needed as when the backend list is updated, strategies which aren't a backend listener are rebuilt from scratch.
 	// Therefore we need the original config to hand.
 	strategyConfig config.StrategyConfig
 
-	sticky bool
+	// The config the current sticky sessions were built from.
+	// Requests use the sticky sessions in the snapshot.
+	stickyConfig config.StickyConfig
+
+	// Routes describing rewrites to apply to requests, matched by path prefix.
+	routes route.Table
+
+	// Rate limits applied to incoming requests before they are balanced.
+	rateLimiter *ratelimit.Limiter
+
+	// Holds requests waiting for a backend when all live backends are at their connection limit.
+	queue *requestQueue
+
+	// Counts of requests and how they were handled.
+	stats balancerStats
+
+	// Streams changes and traffic stats to subscribers, such as the dashboard.
+	events *eventHub
+
+	// Counts modifications, starting at 1, so clients can detect changes they havent seen.
+	generation atomic.Uint64
+
+	// Serialises modifications, which are rare, so snapshots are published one at a time.
+	modifyMutex sync.Mutex
+}
+
+// Counts of the requests the balancer has handled.
+type balancerStats struct {
+	started time.Time
+
+	// Every request received
+	requests atomic.Uint64
+	// Requests rejected for being over a rate limit
+	rateLimited atomic.Uint64
+	// Requests rejected as every backend was at capacity and the queue was full or timed out
+	queueRejected atomic.Uint64
+	// Requests rejected as there were no live backends
+	noBackend atomic.Uint64
+	// Requests which failed on every backend they were tried on
+	failed atomic.Uint64
+}
 
-	modifyMutex sync.RWMutex
+// An immutable set of the backend list, the strategy to choose from it with, and the sticky sessions.
+// A request uses one snapshot throughout, even if the balancer is modified while it is in flight.
+type balancerSnapshot struct {
+	backends backend.ReadonlyBackendList
+	strategy strategy.BalancerStrategy
+	// Remembers which backend clients should use, nil if sticky sessions are off.
+	sticky stickySessions
 }
 
-func NewBalancer(cfg config.Config) (balancer, error) {
+func NewBalancer(cfg config.Config) (*balancer, error) {
+	routes, err := route.NewTable(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiter, err := ratelimit.NewLimiter(cfg.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	sticky, err := newStickySessions(cfg.Sticky)
+	if err != nil {
+		return nil, err
+	}
+
 	bm := backend.NewBackendManager(cfg.Backends)
+	bm.SetCircuitBreakerConfig(cfg.CircuitBreaker)
+	bm.SetProxyConfig(cfg.Proxy)
 
 	strategy, err := strategy.NewBalancerStrategy(cfg.Strategy, bm)
 	if err != nil {
-		return balancer{}, err
+		return nil, err
 	}
 
-	return balancer{
+	b := &balancer{
 		backendManager: bm,
-		strategy:       strategy,
 		strategyConfig: cfg.Strategy,
-		sticky:         cfg.Sticky,
-	}, nil
+		stickyConfig:   cfg.Sticky,
+		routes:         routes,
+		rateLimiter:    rateLimiter,
+		queue:          newRequestQueue(cfg.Queue),
+	}
+	b.stats.started = time.Now()
+	b.publishSnapshot(strategy, sticky)
+
+	sampler := newTrafficSampler(3 * time.Second)
+	b.events = newEventHub(time.Second, func() {
+		b.events.Publish("stats", sampler.Sample(b, time.Now()))
+	})
+	bm.HealthChangeCallback = func(changed backend.BackendRef, alive bool) {
+		b.events.Publish("health", healthEvent{ID: changed.GetID(), Alive: alive})
+	}
+
+	bm.ModifyResponseCallback = newModifyResponseCallback(func() *balancerSnapshot {
+		return b.snapshot.Load()
+	}, bm.IsStreamedResponse)
+
+	return b, nil
+}
+
+// Publishes a new snapshot of the current backends and the given strategy, keeping the current sticky sessions.
+// Requests which already loaded the previous snapshot carry on using it.
+func (b *balancer) publish(strat strategy.BalancerStrategy) {
+	b.publishSnapshot(strat, b.snapshot.Load().sticky)
+}
+
+func (b *balancer) publishSnapshot(strat strategy.BalancerStrategy, sticky stickySessions) {
+	b.snapshot.Store(&balancerSnapshot{
+		backends: b.backendManager.GetBackends(),
+		strategy: strat,
+		sticky:   sticky,
+	})
+	b.generation.Add(1)
+}
+
+// Gets the number of the current configuration, which increases with every modification.
+func (b *balancer) GetGeneration() uint64 {
+	return b.generation.Load()
+}
+
+// Gets the current strategy.
+func (b *balancer) getStrategy() strategy.BalancerStrategy {
+	return b.snapshot.Load().strategy
+}
+
+// Gets the config of the current strategy.
+func (b *balancer) GetStrategyConfig() config.StrategyConfig {
+	b.modifyMutex.Lock()
+	defer b.modifyMutex.Unlock()
+
+	return b.strategyConfig
 }
 
 // Change the strategy the current balancer is using
@@ -49,56 +170,164 @@ func (b *balancer) ChangeStrategy(newStrategyCfg config.StrategyConfig) error {
 	b.modifyMutex.Lock()
 	defer b.modifyMutex.Unlock()
 
-	// keep hold of the old strategy for recovery
-	oldStrategy := b.strategy
-
-	// have to do this as go doesnt like the initialiser syntax with b.strategy
-	var err error
-	b.strategy, err = strategy.NewBalancerStrategy(newStrategyCfg, b.backendManager)
+	// if the new strategy is invalid, the old one is kept
+	newStrategy, err := strategy.NewBalancerStrategy(newStrategyCfg, b.backendManager)
 	if err != nil {
-		// if the new strategy is invalid, recover the old one
-		b.strategy = oldStrategy
+		return err
 	}
-	return err
+
+	b.publish(newStrategy)
+	b.strategyConfig = newStrategyCfg
+
+	b.events.Publish("strategy", strategyEvent{Generation: b.GetGeneration(), Strategy: newStrategyCfg})
+	return nil
 }
 
-// Handles adding backends by BackendInfo to the balancer
-//
-// Aquires a mutex which prevents other actions happening on the balancer,
-// before notifying the balancers components of the new backends
-func (b *balancer) AddBackends(infos []config.BackendInfo) error {
+// Gets the config of the current sticky sessions.
+func (b *balancer) GetStickyConfig() config.StickyConfig {
+	b.modifyMutex.Lock()
+	defer b.modifyMutex.Unlock()
+
+	return b.stickyConfig
+}
+
+// Replaces the sticky sessions with ones built from a new config.
+// Existing sessions are forgotten, unless they are kept by the client (e.g. signed cookies).
+// Returns an error, keeping the current sticky sessions, if the config is invalid.
+func (b *balancer) ChangeStickyConfig(cfg config.StickyConfig) error {
 	b.modifyMutex.Lock()
 	defer b.modifyMutex.Unlock()
 
-	err := b.backendManager.AddBackends(infos)
+	sticky, err := newStickySessions(cfg)
 	if err != nil {
 		return err
 	}
 
-	b.strategy, err = strategy.NewBalancerStrategy(b.strategyConfig, b.backendManager)
+	b.publishSnapshot(b.getStrategy(), sticky)
+	b.stickyConfig = cfg
+	return nil
+}
+
+// Gets a config of the parts of the balancer which can be changed at runtime:
+// the strategy, backends, sticky sessions and rate limits.
+// Discovered backends are left out, as they are found again when restarting.
+func (b *balancer) GetRuntimeConfig() config.Config {
+	b.modifyMutex.Lock()
+	defer b.modifyMutex.Unlock()
+
+	cfg := config.Config{
+		Strategy:   b.strategyConfig,
+		Backends:   []config.BackendInfo{},
+		Sticky:     b.stickyConfig,
+		RateLimits: b.rateLimiter.GetConfigs(),
+	}
+
+	list := b.backendManager.GetBackends()
+	for i := 0; i < list.Len(); i++ {
+		if info := list.Get(i).GetInfo(); info.Source == "" {
+			cfg.Backends = append(cfg.Backends, info)
+		}
+	}
+
+	return cfg
+}
+
+// Gets the current rate limits.
+func (b *balancer) GetRateLimits() []config.RateLimitConfig {
+	return b.rateLimiter.GetConfigs()
+}
+
+// Replaces the rate limits.
+// Returns an error, keeping the current rate limits, if any are invalid.
+func (b *balancer) SetRateLimits(cfgs []config.RateLimitConfig) error {
+	b.modifyMutex.Lock()
+	defer b.modifyMutex.Unlock()
+
+	err := b.rateLimiter.SetConfigs(cfgs)
 	if err != nil {
 		return err
 	}
 
+	b.generation.Add(1)
 	return nil
 }
 
+// Handles adding backends by BackendInfo to the balancer
+//
+// Aquires a mutex which prevents other modifications happening on the balancer,
+// before notifying the balancers components of the new backends.
+// In flight requests are not waited for.
+func (b *balancer) AddBackends(infos []config.BackendInfo) error {
+	return b.ModifyBackends(nil, infos)
+}
+
 // Handles removing backends by url
 //
-// Aquires a mutex which prevents other actions happening on the balancer,
-// before notifying the balancers components of the removed backends
+// Aquires a mutex which prevents other modifications happening on the balancer,
+// before notifying the balancers components of the removed backends.
+// Requests in flight to removed backends are allowed to finish.
 func (b *balancer) RemoveBackends(infos []config.BackendInfo) error {
+	return b.ModifyBackends(infos, nil)
+}
+
+// Removes and adds backends in one modification, publishing a single snapshot.
+// Used to update backends, and for bulk changes.
+// Backends removed and added again at the same address are updated in place (see backend.BackendManager.ModifyBackends).
+//
+// Returns a *backend.BackendExistsError, changing nothing, if an added backend clashes with one that is kept.
+func (b *balancer) ModifyBackends(remove []config.BackendInfo, add []config.BackendInfo) error {
 	b.modifyMutex.Lock()
 	defer b.modifyMutex.Unlock()
 
-	b.backendManager.RemoveBackends(infos)
+	removed, added, updated, err := b.backendManager.ModifyBackends(remove, add)
+	if err != nil {
+		return err
+	}
+
+	current := b.getStrategy()
+
+	if listener, ok := current.(strategy.BalancerStrategyBackendListener); ok {
+		if len(removed) > 0 {
+			listener.RemoveBackends(removed)
+		}
+		if len(added) > 0 {
+			listener.AddBackends(added)
+		}
+		if len(updated) > 0 {
+			listener.UpdateBackends(updated)
+		}
+		b.publish(current)
+		b.events.Publish("backends", b.getBackendsEvent())
+		return nil
+	}
 
-	var err error
-	b.strategy, err = strategy.NewBalancerStrategy(b.strategyConfig, b.backendManager)
+	newStrategy, err := strategy.NewBalancerStrategy(b.strategyConfig, b.backendManager)
 	if err != nil {
 		return err
 	}
 
+	b.publish(newStrategy)
+	b.events.Publish("backends", b.getBackendsEvent())
+	return nil
+}
+
+// Sets whether a backend is draining, by ID, without replacing it.
+// Draining backends get no new requests, while those in flight finish.
+// Returns an error if there is no backend with the ID.
+func (b *balancer) SetBackendDraining(id string, draining bool) error {
+	b.modifyMutex.Lock()
+	defer b.modifyMutex.Unlock()
+
+	list := b.backendManager.GetBackends()
+	index := list.IndexOfID(id)
+	if index == -1 {
+		return fmt.Errorf("No backend with id '%s'.", id)
+	}
+
+	if b.backendManager.SetBackendDraining(list.Get(index), draining) {
+		b.generation.Add(1)
+		b.events.Publish("backends", b.getBackendsEvent())
+	}
 	return nil
 }
 
@@ -107,69 +336,117 @@ func (b *balancer) RemoveBackends(infos []config.BackendInfo) error {
 // Selects an appropriate backend and reverse proxies the request to it.
 func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	// get a read lock for this balancer
-	// ensures there is no modification happening to this for the duration of the request
-	b.modifyMutex.RLock()
-	defer b.modifyMutex.RUnlock()
+	// use the current snapshot throughout, so indexes the strategy chooses stay valid
+	// no locks are needed, modifications publish a new snapshot rather than changing this one
+	snapshot := b.snapshot.Load()
 
-	// note no need to read lock the backend manager, as this is the only object that will ever 'write' to it
-	// this is locked by the above lock, so we are safe to use backend manager knowing the backend list wont change
+	b.stats.requests.Add(1)
 
-	if b.sticky {
-		// check for balancer session
-		cookie, err := r.Cookie(balancerSessionCookieName)
+	rt := b.routes.Match(r)
 
-		if err == nil {
-			// there is a session cookie
-			backendIndex, err := strconv.ParseInt(cookie.Value, 0, 0)
+	routePrefix := ""
+	if rt != nil {
+		routePrefix = rt.GetPathPrefix()
+	}
 
-			if err != nil {
-				fmt.Printf("Error parsing session cookie: %s", err)
-			} else {
-				// we have a session, use that backend
-				err := b.backendManager.ServeRequestWithBackend(int(backendIndex), w, r)
-
-				if err != nil {
-					// error with sessioned server, fall through to balancing strat
-					b.backendManager.ReportBackendDead(int(backendIndex))
-				} else {
-					// request served, refresh cookie and exit
-					setBalancerSessionCokie(w, int(backendIndex))
+	// reject the request if the client is over a rate limit
+	if ok, retryAfter := b.rateLimiter.Allow(r, routePrefix); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		b.stats.rateLimited.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	// apply any route timeouts and rewrites, remembering the route so the response can be rewritten too
+	if rt != nil {
+		var done func()
+		w, r, done = applyRouteTimeouts(w, r, rt.GetTimeouts())
+		defer done()
+
+		r = rt.RewriteRequest(r)
+		r = r.WithContext(route.WithRoute(r.Context(), rt))
+	}
+
+	sticky := snapshot.sticky
+
+	if sticky != nil {
+		// check for a session, using its backend if it is still available
+		if id, ok := sticky.Lookup(r); ok {
+			backendIndex := snapshot.backends.IndexOfID(id)
+
+			// the session's backend is skipped if it is full, rather than sending it more than its cap
+			if backendIndex != -1 && snapshot.backends.Get(backendIndex).GetAlive() && snapshot.backends.Get(backendIndex).ReserveConnection() {
+				sessioned := snapshot.backends.Get(backendIndex)
+
+				// refresh the session (must do this before req is served)
+				sticky.Save(w, r, id)
+
+				err := b.serveRequestWithBackend(snapshot, sessioned, w, r)
+				if err == nil {
+					return
+				}
+
+				if b.respondToRequestError(w, r, err) {
 					return
 				}
+
+				// error with sessioned server, fall through to balancing strat
+				if !errors.Is(err, backend.ErrCircuitOpen) {
+					b.backendManager.ReportBackendDead(sessioned)
+				}
 			}
 		}
 	}
 
 	success := false
 	for i := 0; i < 3; i++ {
-		backends := b.backendManager.GetBackends()
-		backendIndex := b.strategy.GetNextBackendIndex(backends, r)
+		backendIndex := b.chooseBackend(snapshot, r)
 
 		if backendIndex == -1 {
-			// no available backends
-			w.WriteHeader(http.StatusBadGateway)
-
-			fmt.Println("No available backends to service request!")
+			// no available backends, but some may just be at capacity so wait for them
+			// the queue chooses from the latest snapshot, which is then used for the rest of the request
+			var status int
+			snapshot, backendIndex, status = b.waitForBackend(r)
+
+			if backendIndex == -1 {
+				// the client went away while queued, unless the route's idle timeout passed
+				if r.Context().Err() != nil {
+					b.respondToRequestError(w, r, r.Context().Err())
+					return
+				}
 
-			return
+				if status == http.StatusServiceUnavailable {
+					b.stats.queueRejected.Add(1)
+				} else {
+					b.stats.noBackend.Add(1)
+				}
+				w.WriteHeader(status)
+				return
+			}
 		}
 
+		chosen := snapshot.backends.Get(backendIndex)
+
 		// add cookie to resp (must do this before req is served)
 		// if the backend fails, doesnt matter as will replace on retry
-		if b.sticky {
-			setBalancerSessionCokie(w, backendIndex)
+		if sticky != nil {
+			sticky.Save(w, r, chosen.GetID())
 		}
 
 		// Serve the request with the backends reverse proxy
-		err := b.backendManager.ServeRequestWithBackend(backendIndex, w, r)
+		err := b.serveRequestWithBackend(snapshot, chosen, w, r)
 
-		if err != nil {
+		if errors.Is(err, backend.ErrCircuitOpen) {
+			// the breaker opened since the strategy chose the backend, so just choose again
+			fmt.Printf("Circuit open for backend '%s', retrying.\n", chosen.GetURL().String())
+		} else if err != nil && b.respondToRequestError(w, r, err) {
+			return
+		} else if err != nil {
 			// the backend produced an error, so report it as dead
-			b.backendManager.ReportBackendDead(backendIndex)
+			b.backendManager.ReportBackendDead(chosen)
 
 			// log error
-			fmt.Printf("Error using backend '%s': %s\n", backends.Get(backendIndex).GetURL().String(), err.Error())
+			fmt.Printf("Error using backend '%s': %s\n", chosen.GetURL().String(), err.Error())
 		} else {
 			success = true
 			break
@@ -178,24 +455,153 @@ func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if !success {
 		// if we ran out of retries, failed
-		setBalancerDeleteSessionCookie(w)
+		if sticky != nil {
+			sticky.Forget(w, r)
+		}
+		b.stats.failed.Add(1)
 		w.WriteHeader(http.StatusBadGateway)
 	}
 }
 
-const balancerSessionCookieName = "balancer_session"
-const balancerSessionCookieLifetime = time.Minute * 15
+// Responds to errors caused by the request rather than the backend, which arent retried
+// and dont mark the backend dead: a body over its size limit, or the request being cancelled.
+// Returns false if the error isnt one of these.
+func (b *balancer) respondToRequestError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, backend.ErrRequestTooLarge):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+	case errors.Is(err, backend.ErrResponseTooLarge):
+		fmt.Printf("Response to '%s' is over the size limit.\n", r.URL.Path)
+		b.stats.failed.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
 
-func setBalancerSessionCokie(w http.ResponseWriter, backendIndex int) {
-	http.SetCookie(w, &http.Cookie{
-		Name:    balancerSessionCookieName,
-		Value:   fmt.Sprintf("%d", backendIndex),
-		Expires: time.Now().Add(balancerSessionCookieLifetime),
-	})
+	case r.Context().Err() != nil:
+		// the client has gone away, unless the route's idle timeout passed
+		if idleTimedOut(w) {
+			b.stats.failed.Add(1)
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// Chooses a backend with the snapshot's strategy, reserving a connection slot on it.
+//
+// Strategies skip backends at their connection cap, but many requests can choose a backend at once,
+// so one may fill up before its slot is reserved. It is then chosen again, as the strategy will see it full.
+// Returns -1 if no backend could be reserved.
+func (b *balancer) chooseBackend(snapshot *balancerSnapshot, r *http.Request) int {
+	for i := 0; i <= snapshot.backends.Len(); i++ {
+		backendIndex := snapshot.strategy.GetNextBackendIndex(snapshot.backends, r)
+		if backendIndex == -1 {
+			return -1
+		}
+
+		if snapshot.backends.Get(backendIndex).ReserveConnection() {
+			return backendIndex
+		}
+	}
+
+	return -1
 }
-func setBalancerDeleteSessionCookie(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:   balancerSessionCookieName,
-		MaxAge: 0,
+
+// Waits in the request queue for a backend with capacity, for when the strategy could not find one.
+// Each try uses the current snapshot, so backends added while waiting are used, and removed ones arent.
+//
+// Returns the snapshot the backend was chosen from, and the index of the backend in it, with a connection slot reserved on it.
+// If no backend could be found, returns -1 and the status code to respond with.
+func (b *balancer) waitForBackend(r *http.Request) (*balancerSnapshot, int, int) {
+	snapshot := b.snapshot.Load()
+
+	if !b.backendManager.HasLiveBackends() {
+		fmt.Println("No available backends to service request!")
+		return snapshot, -1, http.StatusBadGateway
+	}
+
+	backendIndex := -1
+	err := b.queue.Wait(r.Context(), func() bool {
+		snapshot = b.snapshot.Load()
+		backendIndex = b.chooseBackend(snapshot, r)
+		return backendIndex != -1
 	})
+
+	if err != nil {
+		// cancelled requests have no client left to tell
+		if !errors.Is(err, errQueueCancelled) {
+			fmt.Printf("No backend capacity to service request: %s\n", err.Error())
+		}
+		return snapshot, -1, http.StatusServiceUnavailable
+	}
+
+	return snapshot, backendIndex, 0
+}
+
+// Serves a request with a backend with a reserved connection slot, applying the request's route host rewrite first,
+// and notifying the snapshot's strategy of the connection.
+// Once served, wakes a queued request to use the freed up capacity.
+//
+// Requests upgraded to long lived connections, such as WebSockets, are counted as served
+// once the backend accepts the upgrade, so they dont hold up the strategy or queue while open.
+func (b *balancer) serveRequestWithBackend(snapshot *balancerSnapshot, chosen backend.BackendRef, w http.ResponseWriter, r *http.Request) error {
+	if rt := route.FromContext(r.Context()); rt != nil {
+		rt.RewriteHost(r, chosen.GetURL())
+	}
+
+	connections, _ := snapshot.strategy.(strategy.BalancerStrategyConnections)
+	if connections != nil {
+		connections.OnBackendConnectionStart(chosen)
+	}
+
+	// called once the request is served, or upgraded
+	ended := false
+	end := func() {
+		if ended {
+			return
+		}
+		ended = true
+
+		if connections != nil {
+			connections.OnBackendConnectionEnd(chosen)
+		}
+		b.queue.Release()
+	}
+	defer end()
+
+	if modifier, ok := snapshot.strategy.(strategy.BalancerStrategyRequestModifier); ok {
+		r = modifier.ModifyRequest(chosen, r)
+	}
+
+	return b.backendManager.ServeRequestWithBackend(chosen, w, r, end)
+}
+
+// Creates the callback run on every backend response.
+//
+// Lets the sticky sessions observe the response if they want to,
+// then applies the response rewrites of the route the request was matched to, if any,
+// then lets the strategy observe the response if it isnt streamed.
+func newModifyResponseCallback(getSnapshot func() *balancerSnapshot, isStreamed func(*http.Response) bool) func(backend.BackendRef, *http.Response) error {
+	return func(b backend.BackendRef, res *http.Response) error {
+		snapshot := getSnapshot()
+
+		if observer, ok := snapshot.sticky.(stickySessionsResponseObserver); ok {
+			observer.ObserveResponse(res, b.GetID())
+		}
+
+		if rt := route.FromContext(res.Request.Context()); rt != nil {
+			err := rt.RewriteResponse(res)
+			if err != nil {
+				return err
+			}
+		}
+
+		if observer, ok := snapshot.strategy.(strategy.BalancerStrategyResponseObserver); ok && !isStreamed(res) {
+			observer.ObserveResponse(b, res)
+		}
+
+		return nil
+	}
 }
@@ -0,0 +1,354 @@
+package balancer
+
+import (
+	"fmt"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/balancer/strategy"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Starts a backend server, returning it and its BackendInfo.
+func newTestBackend(t testing.TB, handler http.HandlerFunc) (*httptest.Server, config.BackendInfo) {
+	server := httptest.NewServer(handler)
+
+	host, portString, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed splitting test backend address: %s", err.Error())
+	}
+	port, _ := strconv.Atoi(portString)
+
+	return server, config.NewBackendInfo(host, port)
+}
+
+// Starts n backends which respond immediately.
+func newTestBackends(t testing.TB, n int) ([]*httptest.Server, []config.BackendInfo) {
+	servers := make([]*httptest.Server, n)
+	infos := make([]config.BackendInfo, n)
+
+	for i := range servers {
+		servers[i], infos[i] = newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	t.Cleanup(func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	})
+
+	return servers, infos
+}
+
+func newTestBalancer(t testing.TB, strategyName string, infos []config.BackendInfo) *balancer {
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: strategyName},
+		Backends: infos,
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+	return b
+}
+
+func serveTestRequest(b *balancer, path string) int {
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost"+path, nil))
+	return w.Code
+}
+
+// Tests that modifying the balancer doesnt wait for in flight requests, such as long polls.
+func TestModificationsDontWaitForRequests(t *testing.T) {
+	release := make(chan struct{})
+	slow, slowInfo := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	defer slow.Close()
+	defer close(release)
+
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{slowInfo})
+
+	// start a request which wont finish until the end of the test
+	go serveTestRequest(b, "/poll")
+	for b.backendManager.GetBackend(0).GetActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, infos := newTestBackends(t, 1)
+
+	done := make(chan error)
+	go func() {
+		if err := b.AddBackends(infos); err != nil {
+			done <- err
+			return
+		}
+		if err := b.ChangeStrategy(config.StrategyConfig{Name: "LEAST_CONN"}); err != nil {
+			done <- err
+			return
+		}
+		done <- b.RemoveBackends([]config.BackendInfo{slowInfo})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed modifying balancer: %s", err.Error())
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Failed modifying balancer: blocked by an in flight request")
+	}
+
+	// new requests should use the new backend straight away
+	if code := serveTestRequest(b, "/"); code != http.StatusOK {
+		t.Errorf("Failed request after modification: got %d expected 200", code)
+	}
+}
+
+// Serves requests while backends and strategies change concurrently.
+// Run with -race to check the request path is safe without locks.
+func TestConcurrentRequestsAndModifications(t *testing.T) {
+	_, infos := newTestBackends(t, 4)
+
+	b := newTestBalancer(t, "ROUND_ROBIN", infos[:2])
+
+	stop := make(chan struct{})
+	modified := make(chan struct{})
+
+	// modify the balancer in a loop, always leaving at least the first two backends
+	go func() {
+		defer close(modified)
+
+		strategies := []string{"ROUND_ROBIN", "LEAST_CONN", "LEAST_RESP", "REQUEST_HASH"}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			extra := infos[2+i%2 : 3+i%2]
+			if err := b.AddBackends(extra); err != nil {
+				t.Errorf("Failed adding backend: %s", err.Error())
+				return
+			}
+			if err := b.ChangeStrategy(config.StrategyConfig{Name: strategies[i%len(strategies)]}); err != nil {
+				t.Errorf("Failed changing strategy: %s", err.Error())
+				return
+			}
+			if err := b.RemoveBackends(extra); err != nil {
+				t.Errorf("Failed removing backend: %s", err.Error())
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var failures sync.Map
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if code := serveTestRequest(b, fmt.Sprintf("/%d/%d", i, j)); code != http.StatusOK {
+					failures.Store(code, true)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(stop)
+	<-modified
+
+	failures.Range(func(code, _ any) bool {
+		t.Errorf("Failed concurrent requests: got status %d expected 200", code)
+		return true
+	})
+}
+
+func benchmarkServeHTTP(b *testing.B, modify bool) {
+	_, infos := newTestBackends(b, 3)
+	bal := newTestBalancer(b, "ROUND_ROBIN", infos[:2])
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if modify {
+		// add and remove a backend every millisecond
+		// each change used to wait for all in flight requests, and stall new ones meanwhile
+		go func() {
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+
+				bal.AddBackends(infos[2:])
+				bal.RemoveBackends(infos[2:])
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			serveTestRequest(bal, "/")
+		}
+	})
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	b.Run("static", func(b *testing.B) {
+		benchmarkServeHTTP(b, false)
+	})
+	b.Run("modifying", func(b *testing.B) {
+		benchmarkServeHTTP(b, true)
+	})
+}
+
+// A strategy which holds the first n choices until all n have been made,
+// so the requests all choose a backend before any of them is sent to it.
+type chooseTogetherStrategy struct {
+	strategy.BalancerStrategy
+
+	waiting sync.WaitGroup
+	calls   atomic.Int64
+	n       int64
+}
+
+func newChooseTogetherStrategy(inner strategy.BalancerStrategy, n int) *chooseTogetherStrategy {
+	s := &chooseTogetherStrategy{BalancerStrategy: inner, n: int64(n)}
+	s.waiting.Add(n)
+	return s
+}
+
+func (s *chooseTogetherStrategy) GetNextBackendIndex(backends backend.ReadonlyBackendList, r *http.Request) int {
+	index := s.BalancerStrategy.GetNextBackendIndex(backends, r)
+
+	if s.calls.Add(1) <= s.n {
+		s.waiting.Done()
+		s.waiting.Wait()
+	}
+	return index
+}
+
+// Tests a backend is never sent more requests at once than its connection cap,
+// even when many requests choose it while it has room.
+func TestMaxConnectionsEnforced(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			max := maxInFlight.Load()
+			if current <= max || maxInFlight.CompareAndSwap(max, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	defer server.Close()
+	info.MaxConnections = 1
+
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+		Backends: []config.BackendInfo{info},
+		Queue:    config.QueueConfig{Size: 20, Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+
+	const requests = 10
+	b.publish(newChooseTogetherStrategy(b.getStrategy(), requests))
+
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serveTestRequest(b, "/")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Failed queued request: got %d expected 200", code)
+		}
+	}
+	if max := maxInFlight.Load(); max != 1 {
+		t.Errorf("Failed max connections: got %d requests at once expected 1", max)
+	}
+	if connections := b.backendManager.GetBackend(0).GetActiveConnections(); connections != 0 {
+		t.Errorf("Failed releasing slots: got %d connections after all requests expected 0", connections)
+	}
+}
+
+// Tests a queued request uses a backend added while it waits, rather than only those there when it arrived.
+func TestQueuedRequestSeesAddedBackends(t *testing.T) {
+	release := make(chan struct{})
+	full, fullInfo := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	defer full.Close()
+	defer close(release)
+	fullInfo.MaxConnections = 1
+
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+		Backends: []config.BackendInfo{fullInfo},
+		Queue:    config.QueueConfig{Size: 5, Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+
+	// fill the only backend, so the next request queues
+	go serveTestRequest(b, "/")
+	for b.backendManager.GetBackend(0).GetActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	codes := make(chan int, 1)
+	go func() {
+		codes <- serveTestRequest(b, "/")
+	}()
+	for b.queue.GetStats().Depth == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, infos := newTestBackends(t, 1)
+	if err := b.AddBackends(infos); err != nil {
+		t.Fatalf("Failed adding backend: %s", err.Error())
+	}
+
+	select {
+	case code := <-codes:
+		if code != http.StatusOK {
+			t.Errorf("Failed queued request: got %d expected 200", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Failed queued request: expected it to use the added backend")
+	}
+}
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"go-balancer/internal/util"
+	"net"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,34 +19,523 @@ type Config struct {
 	Strategy StrategyConfig `yaml:"strategy"`
 	Backends []BackendInfo  `yaml:"backends"`
 	Port     int            `yaml:"port"`
-	Sticky   bool           `yaml:"sticky"`
+	Server   ServerConfig   `yaml:"server"`
+	Sticky   StickyConfig   `yaml:"sticky"`
+	Routes   []RouteConfig  `yaml:"routes"`
+
+	RateLimits []RateLimitConfig `yaml:"rateLimits"`
+
+	Queue QueueConfig `yaml:"queue"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker"`
+
+	Proxy ProxyConfig `yaml:"proxy"`
+
+	Discovery DiscoveryConfig `yaml:"discovery"`
+
+	Admin AdminConfig `yaml:"admin"`
+}
+
+// Describes the timeouts of the listener requests are balanced from.
+// Zero values use defaults, negative values mean no timeout.
+type ServerConfig struct {
+	// How long a client has to send the request headers, defaults to 10s.
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	// How long a client has to send the whole request, including its body. Defaults to none.
+	ReadTimeout time.Duration `yaml:"readTimeout"`
+	// How long the response has to be written, from the end of the request headers.
+	// Defaults to none, as it would cut off long downloads and streams.
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// How long a keep-alive connection waits for the next request, defaults to 2m.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+
+	// Serve cleartext HTTP/2 (h2c) on the plain listener, to clients which know the balancer supports it
+	// or ask to upgrade to it.
+	H2C bool `yaml:"h2c"`
+	// Optionally also listen for HTTPS, serving HTTP/2 to clients which support it.
+	TLS ServerTLSConfig `yaml:"tls"`
+}
+
+// Describes the HTTPS listener, which is only started if its port is set.
+type ServerTLSConfig struct {
+	Port int `yaml:"port"`
+	// PEM files of the certificate, followed by any intermediates, and its private key.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// Describes how requests and responses are copied between clients and backends.
+type ProxyConfig struct {
+	// How often the response is flushed to the client while it is copied, 0 to only flush once it is done
+	// and negative to flush after every write.
+	// Responses without a Content-Length, such as chunked downloads, are always flushed after every write.
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Content types which are streamed, flushing after every write.
+	// Server-sent events (text/event-stream) are always streamed.
+	StreamContentTypes []string `yaml:"streamContentTypes"`
+
+	// The largest request body sent to a backend in bytes, 0 for no limit.
+	// Larger requests are rejected with 413 Request Entity Too Large.
+	MaxRequestBodySize int64 `yaml:"maxRequestBodySize"`
+	// The largest response body sent to a client in bytes, 0 for no limit.
+	// Larger responses are rejected with 502 Bad Gateway, or cut off if they are already being sent.
+	MaxResponseBodySize int64 `yaml:"maxResponseBodySize"`
+}
+
+// Describes the modification API, and the dashboard served alongside it.
+type AdminConfig struct {
+	// The address the API and dashboard listen on, defaults to ":44444".
+	// Addresses like "unix:/run/gobal.sock" listen on a unix socket.
+	Listen string `yaml:"listen"`
+	// If set, API requests must send it as a bearer token in their Authorization header.
+	Token string `yaml:"token"`
+
+	Audit   AuditConfig   `yaml:"audit"`
+	Persist PersistConfig `yaml:"persist"`
+}
+
+// Describes the audit log of changes made through the modification API.
+type AuditConfig struct {
+	// The file entries are appended to, as JSON lines. If empty, entries are only kept in memory.
+	Path string `yaml:"path"`
+	// The most recent entries kept in memory to be queried, defaults to 1000.
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+// The shortest circuit breaker window allowed, as it is split into buckets.
+// Catches windows given without a unit, which are read as nanoseconds.
+const minCircuitBreakerWindow = time.Millisecond * 10
+
+// Describes the circuit breaker applied to each backend.
+// Zero values are replaced by defaults.
+type CircuitBreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// The rolling window requests are measured over, at least 10ms.
+	Window time.Duration `yaml:"window"`
+	// The minimum number of requests in the window before the breaker can trip.
+	MinRequests int `yaml:"minRequests"`
+
+	// Trip when at least this fraction (0-1) of requests in the window fail.
+	ErrorRateThreshold float64 `yaml:"errorRateThreshold"`
+	// Trip when the average latency in the window is at least this, 0 to ignore latency.
+	LatencyThreshold time.Duration `yaml:"latencyThreshold"`
+
+	// How long the breaker stays open before allowing trial requests.
+	OpenDuration time.Duration `yaml:"openDuration"`
+	// The number of trial requests allowed at once when half-open.
+	// This many must succeed for the breaker to close again.
+	HalfOpenRequests int `yaml:"halfOpenRequests"`
+}
+
+// Describes where backends are discovered from, in addition to the static list.
+type DiscoveryConfig struct {
+	DNS  []DNSDiscoveryConfig  `yaml:"dns"`
+	File []FileDiscoveryConfig `yaml:"file"`
+	HTTP []HTTPDiscoveryConfig `yaml:"http"`
+
+	Consul []ConsulDiscoveryConfig `yaml:"consul"`
+}
+
+// Describes backends discovered from a JSON or YAML file, which is watched for changes.
+type FileDiscoveryConfig struct {
+	Path string `yaml:"path"`
+	// How often the file is checked for changes, defaults to 5s.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Describes backends discovered by polling an HTTP endpoint which returns a JSON or YAML list.
+type HTTPDiscoveryConfig struct {
+	URL string `yaml:"url"`
+	// How often the endpoint is polled, defaults to 30s.
+	Interval time.Duration `yaml:"interval"`
+	// How long to wait for a response, defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// Headers sent with each request, such as for authorization.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Describes backends discovered by resolving a DNS name.
+type DNSDiscoveryConfig struct {
+	// The name to resolve.
+	Name string `yaml:"name"`
+	// The records to resolve: "A" (A and AAAA, the default), "A4" (A only), "AAAA" or "SRV".
+	Type string `yaml:"type"`
+	// The port of backends found from A/AAAA records. SRV records give their own ports.
+	Port int `yaml:"port"`
+
+	// The longest time between resolutions, defaults to 30s.
+	// Names are resolved again sooner if their records' TTLs expire first.
+	Interval time.Duration `yaml:"interval"`
+	// How long to wait for the nameserver, defaults to 5s.
+	Timeout time.Duration `yaml:"timeout"`
+	// The nameserver to query as "host:port", defaults to those in /etc/resolv.conf.
+	Nameserver string `yaml:"nameserver"`
+}
+
+// Describes backends discovered from a service registry with a Consul compatible health API.
+type ConsulDiscoveryConfig struct {
+	// The registry's address, defaults to "http://127.0.0.1:8500".
+	Address string `yaml:"address"`
+	// The service whose instances are backends.
+	Service string `yaml:"service"`
+	// Only use instances with this tag.
+	Tag string `yaml:"tag"`
+	// The datacenter to query, defaults to the registry's own.
+	Datacenter string `yaml:"datacenter"`
+	// An ACL token sent with each query.
+	Token string `yaml:"token"`
+
+	// How registry health is used: "replace" (the default) uses only the registry's health,
+	// "supplement" also heartbeats instances the registry thinks are healthy.
+	Health string `yaml:"health"`
+
+	// The longest time a blocking query waits for changes, defaults to 5m.
+	Wait time.Duration `yaml:"wait"`
+	// How long to wait before querying again after an error, defaults to 5s.
+	RetryInterval time.Duration `yaml:"retryInterval"`
+}
+
+// Describes the queue requests wait in when every live backend is at its connection limit.
+type QueueConfig struct {
+	// The maximum number of waiting requests, 0 to reject requests straight away.
+	Size int `yaml:"size"`
+	// How long a request waits for a backend before being rejected.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Describes sticky sessions, which send requests from the same client to the same backend.
+//
+// Can also be given in YAML as a plain bool, which just sets Enabled.
+type StickyConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// How a client's backend is remembered, defaults to "cookie".
+	//  - "cookie": a signed cookie set by the balancer
+	//  - "sourceIP": the client's IP address, masked to a network
+	//  - "header": the value of a request header
+	//  - "appCookie": the value of a cookie set by the backend
+	Mode string `yaml:"mode,omitempty"`
+
+	// Used by the "cookie" mode.
+	Cookie SessionCookieConfig `yaml:"cookie,omitempty"`
+
+	// Used by the "sourceIP" mode.
+	SourceIP SourceIPStickyConfig `yaml:"sourceIP,omitempty"`
+	// The header name used by the "header" mode.
+	Header string `yaml:"header,omitempty"`
+	// The name of the backend's cookie used by the "appCookie" mode, e.g. JSESSIONID.
+	AppCookie string `yaml:"appCookie,omitempty"`
+
+	// How long an idle client is remembered for by the table based modes, defaults to 15 minutes.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// The most clients the table based modes remember, with the least recently seen forgotten first.
+	// Defaults to 100000.
+	MaxEntries int `yaml:"maxEntries,omitempty"`
+}
+
+// Describes the networks clients are grouped into for source IP affinity.
+type SourceIPStickyConfig struct {
+	// Prefix length IPv4 addresses are masked to, defaults to 32.
+	IPv4Mask int `yaml:"ipv4Mask,omitempty"`
+	// Prefix length IPv6 addresses are masked to, defaults to 128.
+	IPv6Mask int `yaml:"ipv6Mask,omitempty"`
+}
+
+// Describes the signed cookie used to store a client's backend.
+// Zero values are replaced by defaults.
+type SessionCookieConfig struct {
+	// The cookie name, defaults to "balancer_session".
+	Name string `yaml:"name,omitempty"`
+	// The key the cookie is signed with.
+	// If empty a random key is generated, so sessions do not survive restarts or work across replicas.
+	Secret string `yaml:"secret,omitempty"`
+	// How long a session lasts without requests, defaults to 15 minutes.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	Secure bool `yaml:"secure,omitempty"`
+	// Defaults to true.
+	HttpOnly *bool `yaml:"httpOnly,omitempty"`
+	// One of "lax", "strict" or "none", defaults to "lax".
+	SameSite string `yaml:"sameSite,omitempty"`
+	// Defaults to "/".
+	Path   string `yaml:"path,omitempty"`
+	Domain string `yaml:"domain,omitempty"`
+}
+
+func (s *StickyConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// support the older format of a plain bool
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*s = StickyConfig{Enabled: enabled}
+		return nil
+	}
+
+	// alias the type so its UnmarshalYAML isnt called recursively
+	type plainStickyConfig StickyConfig
+	var plain plainStickyConfig
+	err := unmarshal(&plain)
+	if err != nil {
+		return fmt.Errorf("Parsing sticky config failed: %s", err.Error())
+	}
+
+	*s = StickyConfig(plain)
+	return nil
+}
+
+// Describes special handling for requests whose path starts with a prefix.
+type RouteConfig struct {
+	// The path prefix requests must have to use this route.
+	// When several routes match, the one with the longest prefix is used.
+	PathPrefix string `yaml:"pathPrefix"`
+
+	Rewrite RewriteConfig `yaml:"rewrite"`
+
+	// Overrides the server's timeouts for requests on the route, e.g. to allow long uploads or streams.
+	Timeouts RouteTimeoutConfig `yaml:"timeouts"`
+}
+
+// Describes the timeouts of requests on a route.
+type RouteTimeoutConfig struct {
+	// How long the request body has to be read, from when the route is matched.
+	// Zero keeps the server's read timeout, negative means no timeout.
+	Read time.Duration `yaml:"read"`
+	// How long the response has to be written, from when the route is matched.
+	// Zero keeps the server's write timeout, negative means no timeout.
+	Write time.Duration `yaml:"write"`
+	// The longest the backend can go without sending anything, such as between the events of a stream.
+	// Zero or negative means no timeout.
+	Idle time.Duration `yaml:"idle"`
+}
+
+// Describes how requests and responses on a route are rewritten.
+type RewriteConfig struct {
+	// A prefix to remove from the request path.
+	StripPrefix string `yaml:"stripPrefix"`
+	// A prefix to add to the request path (after stripping and regex rewrites).
+	AddPrefix string `yaml:"addPrefix"`
+	// Regex replacements applied in order to the request path.
+	PathRegex []RegexRewriteConfig `yaml:"pathRegex"`
+
+	RequestHeaders  HeaderRewriteConfig `yaml:"requestHeaders"`
+	ResponseHeaders HeaderRewriteConfig `yaml:"responseHeaders"`
+
+	// Replace the Host header with the host of the backend serving the request.
+	RewriteHost bool `yaml:"rewriteHost"`
+}
+
+type RegexRewriteConfig struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// Header modifications, applied in the order remove, set, add.
+type HeaderRewriteConfig struct {
+	Add    map[string]string `yaml:"add"`
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
 }
 
 type backendInfo struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	// A stable identifier for the backend, defaults to "host:port".
+	// Sticky sessions and strategies use this, so it should stay the same across restarts and replicas.
+	ID string `yaml:"id,omitempty" json:"id,omitempty"`
+	// An optional human readable name.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	Host string `yaml:"host" json:"host"`
+	Port int    `yaml:"port" json:"port"`
+
+	// The backend's share of requests relative to others, defaults to 1.
+	Weight int `yaml:"weight,omitempty" json:"weight,omitempty"`
+
+	// Optional tags and labels for grouping backends.
+	Tags   []string          `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// An optional zone (e.g. datacenter or availability zone) the backend runs in.
+	Zone string `yaml:"zone,omitempty" json:"zone,omitempty"`
+
+	// Optional cap on the requests per second sent to the backend (0 for no cap).
+	MaxRequestsPerSecond float64 `yaml:"maxRequestsPerSecond,omitempty" json:"maxRequestsPerSecond,omitempty"`
+	// Optional cap on the requests the backend handles at once (0 for no cap).
+	MaxConnections int `yaml:"maxConnections,omitempty" json:"maxConnections,omitempty"`
+
+	// Overrides how the backend is health checked.
+	HealthCheck *HealthCheckConfig `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+
+	// Stops new requests being sent to the backend, letting those in flight finish, e.g. before taking it down.
+	Drain bool `yaml:"drain,omitempty" json:"drain,omitempty"`
+
+	// The protocol requests are sent to the backend with, one of ProtocolHTTP1 (the default), ProtocolH2C or ProtocolH2.
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	// How the backend's certificate is verified, for ProtocolH2.
+	TLS *BackendTLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// Protocols requests can be sent to backends with.
+const (
+	// HTTP/1.1 in cleartext.
+	ProtocolHTTP1 = "http1"
+	// HTTP/2 in cleartext, with prior knowledge that the backend supports it.
+	ProtocolH2C = "h2c"
+	// HTTP/2 over TLS.
+	ProtocolH2 = "h2"
+)
+
+// Describes how a backend's certificate is verified.
+type BackendTLSConfig struct {
+	// The name the certificate must be for, defaults to the backend's host.
+	ServerName string `yaml:"serverName,omitempty" json:"serverName,omitempty"`
+	// A PEM file of the certificate authorities trusted to sign it, defaults to the system's.
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+	// Accept any certificate, e.g. for backends with self signed certificates on a trusted network.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty" json:"insecureSkipVerify,omitempty"`
+}
+
+// Loads the certificate authorities from the CA file, or returns nil to use the system's if there isnt one.
+func (t *BackendTLSConfig) LoadRootCAs() (*x509.CertPool, error) {
+	if t.CAFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(t.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Reading backend CA file failed: %s", err.Error())
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("Reading backend CA file failed: no certificates found in '%s'.", t.CAFile)
+	}
+	return pool, nil
 }
 
 type BackendInfo struct {
 	backendInfo
 
-	URL *url.URL
+	URL *url.URL `yaml:"-" json:"-"`
+
+	// The discovery provider which found the backend.
+	// Empty for backends from the config file or the modification API, which are the only ones persisted.
+	Source string `yaml:"-" json:"-"`
+}
+
+// Describes how a backend is health checked.
+// Zero values use the balancer's defaults.
+type HealthCheckConfig struct {
+	// Don't health check the backend, it is only marked dead by failed requests.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// The path requested, defaults to the backend root.
+	Path string `yaml:"path,omitempty"`
+	// The request method, defaults to HEAD.
+	Method string `yaml:"method,omitempty"`
+	// The time between checks while the backend is alive, defaults to 15 seconds.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// How long to wait for a response, defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// The JSON form of a HealthCheckConfig, with durations as strings such as "10s".
+type healthCheckJSON struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+func (h HealthCheckConfig) MarshalJSON() ([]byte, error) {
+	j := healthCheckJSON{
+		Disabled: h.Disabled,
+		Path:     h.Path,
+		Method:   h.Method,
+	}
+	if h.Interval != 0 {
+		j.Interval = h.Interval.String()
+	}
+	if h.Timeout != 0 {
+		j.Timeout = h.Timeout.String()
+	}
+
+	return json.Marshal(j)
+}
+
+func (h *HealthCheckConfig) UnmarshalJSON(bytes []byte) error {
+	var j healthCheckJSON
+	err := json.Unmarshal(bytes, &j)
+	if err != nil {
+		return err
+	}
+
+	*h = HealthCheckConfig{
+		Disabled: j.Disabled,
+		Path:     j.Path,
+		Method:   j.Method,
+	}
+
+	if j.Interval != "" {
+		h.Interval, err = time.ParseDuration(j.Interval)
+		if err != nil {
+			return fmt.Errorf("Parsing health check interval failed: %s", err.Error())
+		}
+	}
+	if j.Timeout != "" {
+		h.Timeout, err = time.ParseDuration(j.Timeout)
+		if err != nil {
+			return fmt.Errorf("Parsing health check timeout failed: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Gets the backend's ID, which defaults to "host:port" if not set.
+func (u *BackendInfo) GetID() string {
+	if u.ID != "" {
+		return u.ID
+	}
+	return u.URL.Host
+}
+
+// Gets the protocol requests are sent to the backend with, which defaults to ProtocolHTTP1 if not set.
+func (u *BackendInfo) GetProtocol() string {
+	if u.Protocol == "" {
+		return ProtocolHTTP1
+	}
+	return u.Protocol
+}
+
+// Gets the backend's weight, which defaults to 1 if not set.
+func (u *BackendInfo) GetWeight() int {
+	if u.Weight <= 0 {
+		return 1
+	}
+	return u.Weight
+}
+
+// Creates the info of a backend at a host and port, checking they are valid.
+// IPv6 addresses are given without brackets.
+func MakeBackendInfo(host string, port int) (BackendInfo, error) {
+	var info BackendInfo
+	err := info.setFromParsed(backendInfo{
+		Host: host,
+		Port: port,
+	})
+	return info, err
 }
 
 // Just used for testing to get quick infos
 func NewBackendInfo(host string, port int) BackendInfo {
-	url, err := url.Parse(fmt.Sprintf("http://%s:%d", host, port))
+	info, err := MakeBackendInfo(host, port)
 	if err != nil {
 		panic(err)
 	}
 
-	return BackendInfo{
-		backendInfo: backendInfo{
-			Host: host,
-			Port: port,
-		},
-		URL: url,
-	}
+	return info
+}
+
+func (u BackendInfo) MarshalYAML() (interface{}, error) {
+	return u.backendInfo, nil
 }
 
 func (u *BackendInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -51,60 +545,107 @@ func (u *BackendInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("Parsing backend failed: %s", err.Error())
 	}
 
-	portValid, portErr := util.ValidatePortInt(b.Port)
-	if !portValid {
-		return fmt.Errorf("Parsing backend port failed: %s", portErr.Error())
-	}
-
-	url, err := url.Parse(fmt.Sprintf("http://%s:%d", b.Host, b.Port))
-	if err != nil {
-		return fmt.Errorf("Parsing backend host failed: %s", err.Error())
-	}
-
-	u.Host = b.Host
-	u.Port = b.Port
-	u.URL = url
-
-	return nil
+	return u.setFromParsed(b)
 }
 
-func (u *BackendInfo) UnmarshalJSON(bytes []byte) error {
+// Unknown fields are rejected, as with the rest of the modification API's request bodies.
+func (u *BackendInfo) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
 	var b backendInfo
-	err := json.Unmarshal(bytes, &b)
+	err := decoder.Decode(&b)
 	if err != nil {
 		return fmt.Errorf("Parsing backend failed: %s", err.Error())
 	}
 
+	return u.setFromParsed(b)
+}
+
+// Validates the decoded fields and builds the backend URL from them.
+func (u *BackendInfo) setFromParsed(b backendInfo) error {
 	portValid, portErr := util.ValidatePortInt(b.Port)
 	if !portValid {
 		return fmt.Errorf("Parsing backend port failed: %s", portErr.Error())
 	}
 
-	url, err := url.Parse(fmt.Sprintf("http://%s:%d", b.Host, b.Port))
+	if b.MaxRequestsPerSecond < 0 {
+		return fmt.Errorf("Parsing backend failed: maxRequestsPerSecond must not be negative.")
+	}
+	if b.MaxConnections < 0 {
+		return fmt.Errorf("Parsing backend failed: maxConnections must not be negative.")
+	}
+	if b.Weight < 0 {
+		return fmt.Errorf("Parsing backend failed: weight must not be negative.")
+	}
+	if b.HealthCheck != nil && (b.HealthCheck.Interval < 0 || b.HealthCheck.Timeout < 0) {
+		return fmt.Errorf("Parsing backend failed: health check interval and timeout must not be negative.")
+	}
+
+	switch b.Protocol {
+	case "", ProtocolHTTP1, ProtocolH2C:
+		if b.TLS != nil {
+			return fmt.Errorf("Parsing backend failed: tls is only used with protocol '%s'.", ProtocolH2)
+		}
+	case ProtocolH2:
+		if b.TLS != nil {
+			_, err := b.TLS.LoadRootCAs()
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Parsing backend failed: unknown protocol '%s'.", b.Protocol)
+	}
+
+	scheme := "http"
+	if b.Protocol == ProtocolH2 {
+		scheme = "https"
+	}
+
+	url, err := url.Parse(scheme + "://" + net.JoinHostPort(b.Host, strconv.Itoa(b.Port)))
 	if err != nil {
 		return fmt.Errorf("Parsing backend host failed: %s", err.Error())
 	}
 
-	u.Host = b.Host
-	u.Port = b.Port
+	u.backendInfo = b
 	u.URL = url
 
 	return nil
 }
 
+// Describes a token bucket rate limit applied to incoming requests.
+type RateLimitConfig struct {
+	// What requests are grouped by, each group getting its own bucket.
+	// One of "ip" (client IP), "header" (value of a request header) or "route" (matched route prefix).
+	Key string `yaml:"key" json:"key"`
+	// The header to group by, when key is "header".
+	// Requests without the header are grouped by client IP instead.
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+	// If set, only requests with paths starting with this prefix are limited.
+	// Matched on whole path segments, as route prefixes are.
+	PathPrefix string `yaml:"pathPrefix,omitempty" json:"pathPrefix,omitempty"`
+
+	// Requests allowed per second.
+	Rate float64 `yaml:"rate" json:"rate"`
+	// Maximum requests allowed in a burst, defaults to the rate rounded up.
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+}
+
 type StrategyConfig struct {
-	Name       string      `yaml:"name"`
-	Properties interface{} `yaml:"properties"`
+	Name       string      `yaml:"name" json:"name"`
+	Properties interface{} `yaml:"properties,omitempty" json:"properties,omitempty"`
 }
 
 func ReadConfig(filename string) (Config, error) {
 	var config Config
 
 	// read config
-	f, openErr := os.Open("config.yaml")
+	f, openErr := os.Open(filename)
 	if openErr != nil {
 		return config, openErr
 	}
+	defer f.Close()
 
 	// yaml decode
 	decodeErr := yaml.NewDecoder(f).Decode(&config)
@@ -112,12 +653,54 @@ func ReadConfig(filename string) (Config, error) {
 		return config, decodeErr
 	}
 
+	return config, validateConfig(config)
+}
+
+// Checks the parts of a config which arent checked as they are decoded.
+func validateConfig(config Config) error {
 	// verify port is valid
 	if config.Port < 0 || config.Port > 65535 {
-		return config, fmt.Errorf("Invalid port number '%d' in config file.", config.Port)
+		return fmt.Errorf("Invalid port number '%d' in config file.", config.Port)
 	}
 
-	return config, nil
+	if config.Queue.Size < 0 || config.Queue.Timeout < 0 {
+		return fmt.Errorf("Invalid queue config in config file: size and timeout must not be negative.")
+	}
+
+	if config.Server.TLS.Port != 0 {
+		if config.Server.TLS.Port < 0 || config.Server.TLS.Port > 65535 || config.Server.TLS.Port == config.Port {
+			return fmt.Errorf("Invalid TLS port number '%d' in config file.", config.Server.TLS.Port)
+		}
+		if config.Server.TLS.CertFile == "" || config.Server.TLS.KeyFile == "" {
+			return fmt.Errorf("Invalid TLS config in config file: certFile and keyFile must be set.")
+		}
+	}
+
+	breaker := config.CircuitBreaker
+	if breaker.Window != 0 && breaker.Window < minCircuitBreakerWindow {
+		return fmt.Errorf("Invalid circuit breaker window '%s' in config file: must be at least %s.", breaker.Window, minCircuitBreakerWindow)
+	}
+	if breaker.MinRequests < 0 || breaker.LatencyThreshold < 0 || breaker.OpenDuration < 0 || breaker.HalfOpenRequests < 0 {
+		return fmt.Errorf("Invalid circuit breaker config in config file: values must not be negative.")
+	}
+	if breaker.ErrorRateThreshold < 0 || breaker.ErrorRateThreshold > 1 {
+		return fmt.Errorf("Invalid circuit breaker error rate threshold '%g' in config file: must be between 0 and 1.", breaker.ErrorRateThreshold)
+	}
+
+	if config.Proxy.MaxRequestBodySize < 0 || config.Proxy.MaxResponseBodySize < 0 {
+		return fmt.Errorf("Invalid proxy config in config file: body sizes must not be negative.")
+	}
+
+	// backends are identified by ID, so they must be unique
+	ids := make(map[string]bool)
+	for _, b := range config.Backends {
+		if ids[b.GetID()] {
+			return fmt.Errorf("Duplicate backend ID '%s' in config file.", b.GetID())
+		}
+		ids[b.GetID()] = true
+	}
+
+	return nil
 }
 
 func CastProperties[PropsT any](props interface{}, out *PropsT) error {
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestValidateCircuitBreakerConfig(t *testing.T) {
+	cases := map[string]bool{
+		"window: 10s":             true,
+		"window: 10ms":            true,
+		"window: 10":              false,
+		"window: 9ms":             false,
+		"minRequests: -1":         false,
+		"errorRateThreshold: 1.5": false,
+		"openDuration: -1s":       false,
+	}
+
+	for breaker, valid := range cases {
+		path := writeTestConfig(t, "circuitBreaker:\n    enabled: true\n    "+breaker+"\n")
+
+		_, err := ReadConfig(path)
+		if valid && err != nil {
+			t.Errorf("Failed circuit breaker '%s': got error %s", breaker, err.Error())
+		}
+		if !valid && err == nil {
+			t.Errorf("Failed circuit breaker '%s': got no error", breaker)
+		}
+	}
+}
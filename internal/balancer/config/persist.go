@@ -0,0 +1,379 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Shown in place of secrets, such as tokens, in exported configs.
+const redactedValue = "********"
+
+// Describes how changes made at runtime are saved, so they survive restarts.
+type PersistConfig struct {
+	// Where changes are saved:
+	//  - "": they arent, the default
+	//  - "config": the config file is rewritten, keeping its comments where possible
+	//  - "state": a separate state file is written, which overrides the config file when starting
+	Mode string `yaml:"mode"`
+	// The state file used by the "state" mode, defaults to the config file's name with ".state" before its extension.
+	StatePath string `yaml:"statePath"`
+}
+
+// The sections of a config which can be changed at runtime, as saved in a state file.
+// Missing sections are left as they are in the config file.
+type runtimeSections struct {
+	Strategy   *StrategyConfig    `yaml:"strategy,omitempty"`
+	Backends   *[]BackendInfo     `yaml:"backends,omitempty"`
+	Sticky     *StickyConfig      `yaml:"sticky,omitempty"`
+	RateLimits *[]RateLimitConfig `yaml:"rateLimits,omitempty"`
+}
+
+// Saves runtime changes to a config or state file, and exports configs as YAML.
+type Persister struct {
+	mode       string
+	configPath string
+	statePath  string
+
+	// The config file's document, which runtime sections are replaced in to export or save a config.
+	document []byte
+}
+
+// Creates a persister for the config file at a path, reading the file's current document.
+func NewPersister(cfg PersistConfig, configPath string) (*Persister, error) {
+	p := &Persister{
+		mode:       cfg.Mode,
+		configPath: configPath,
+		statePath:  cfg.StatePath,
+	}
+
+	switch cfg.Mode {
+	case "", "config":
+	case "state":
+		if p.statePath == "" {
+			ext := filepath.Ext(configPath)
+			p.statePath = strings.TrimSuffix(configPath, ext) + ".state" + ext
+		}
+	default:
+		return nil, fmt.Errorf("Unrecognized persist mode '%s'.", cfg.Mode)
+	}
+
+	if configPath != "" {
+		document, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		p.document = document
+	}
+
+	return p, nil
+}
+
+// Overrides the runtime sections of a config with those saved in the state file, if there is one.
+// Does nothing unless using the "state" mode.
+func (p *Persister) Restore(cfg *Config) error {
+	if p.mode != "state" {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sections runtimeSections
+	err = yaml.Unmarshal(data, &sections)
+	if err != nil {
+		return fmt.Errorf("Parsing state file %s failed: %s", p.statePath, err.Error())
+	}
+
+	restored := *cfg
+	if sections.Strategy != nil {
+		restored.Strategy = *sections.Strategy
+	}
+	if sections.Backends != nil {
+		restored.Backends = *sections.Backends
+	}
+	if sections.Sticky != nil {
+		restored.Sticky = *sections.Sticky
+	}
+	if sections.RateLimits != nil {
+		restored.RateLimits = *sections.RateLimits
+	}
+
+	err = validateConfig(restored)
+	if err != nil {
+		return fmt.Errorf("Invalid state file %s: %s", p.statePath, err.Error())
+	}
+
+	*cfg = restored
+	return nil
+}
+
+// Saves the runtime sections of a config, so they are used when restarting.
+// Files are replaced atomically, so a crash never leaves one half written.
+// Does nothing if persistence is off.
+func (p *Persister) Save(runtime Config) error {
+	switch p.mode {
+	case "config":
+		document, err := UpdateConfigYAML(p.document, runtime)
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(p.configPath, document)
+		if err != nil {
+			return err
+		}
+		p.document = document
+		return nil
+
+	case "state":
+		data, err := yaml.Marshal(runtimeSections{
+			Strategy:   &runtime.Strategy,
+			Backends:   &runtime.Backends,
+			Sticky:     &runtime.Sticky,
+			RateLimits: &runtime.RateLimits,
+		})
+		if err != nil {
+			return err
+		}
+
+		return writeFileAtomic(p.statePath, data)
+	}
+
+	return nil
+}
+
+// Gets the config file's document with its runtime sections replaced by those of a config,
+// and the values of any "secret" or "token" keys hidden.
+func (p *Persister) Export(runtime Config) ([]byte, error) {
+	document, err := UpdateConfigYAML(p.document, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(document, &root)
+	if err != nil {
+		return nil, err
+	}
+	redactSecrets(&root)
+
+	return encodeDocument(&root)
+}
+
+// Replaces the runtime sections (strategy, backends, sticky and rateLimits) of a YAML config document
+// with those of a config, keeping everything else, including comments.
+//
+// Sections which are unchanged are left exactly as they were.
+// Backends are replaced one at a time, so comments on unchanged backends are kept too.
+func UpdateConfigYAML(document []byte, runtime Config) ([]byte, error) {
+	var root yaml.Node
+	err := yaml.Unmarshal(document, &root)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing config document failed: %s", err.Error())
+	}
+
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Config document is not a mapping.")
+	}
+	mapping := root.Content[0]
+
+	err = setSection(mapping, "strategy", runtime.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	err = setBackendsSection(mapping, runtime.Backends)
+	if err != nil {
+		return nil, err
+	}
+	err = setSection(mapping, "sticky", runtime.Sticky)
+	if err != nil {
+		return nil, err
+	}
+	err = setSection(mapping, "rateLimits", runtime.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeDocument(&root)
+}
+
+func encodeDocument(root *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(4)
+
+	err := encoder.Encode(root)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	return buf.Bytes(), err
+}
+
+// Gets the value node of a key in a mapping, or nil if it isnt there.
+func findKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Reports whether a node decodes to a value equal to the given one.
+func nodeEquals[T any](node *yaml.Node, value T) bool {
+	var decoded T
+	if node.Decode(&decoded) != nil {
+		return false
+	}
+	return reflect.DeepEqual(decoded, value)
+}
+
+// Sets the value of a section in a mapping, leaving it untouched if it is unchanged.
+// Zero valued sections which arent in the mapping arent added.
+func setSection[T any](mapping *yaml.Node, key string, value T) error {
+	existing := findKey(mapping, key)
+	if existing != nil && nodeEquals(existing, value) {
+		return nil
+	}
+	if existing == nil && isEmpty(reflect.ValueOf(&value).Elem()) {
+		return nil
+	}
+
+	var encoded yaml.Node
+	err := encoded.Encode(value)
+	if err != nil {
+		return fmt.Errorf("Encoding config section %s failed: %s", key, err.Error())
+	}
+
+	if existing == nil {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &encoded)
+		return nil
+	}
+
+	// keep comments on the value itself, such as one at the end of the line
+	encoded.HeadComment = existing.HeadComment
+	encoded.LineComment = existing.LineComment
+	encoded.FootComment = existing.FootComment
+	*existing = encoded
+	return nil
+}
+
+// Reports whether a value is zero, or an empty list.
+func isEmpty(v reflect.Value) bool {
+	if v.Kind() == reflect.Slice {
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// Sets the backends section, keeping the nodes (and so comments) of backends which are unchanged.
+func setBackendsSection(mapping *yaml.Node, backends []BackendInfo) error {
+	existing := findKey(mapping, "backends")
+	if existing == nil || existing.Kind != yaml.SequenceNode {
+		return setSection(mapping, "backends", backends)
+	}
+
+	// index the existing backends by ID
+	existingByID := make(map[string]*yaml.Node)
+	for _, item := range existing.Content {
+		var info BackendInfo
+		if item.Decode(&info) == nil {
+			existingByID[info.GetID()] = item
+		}
+	}
+
+	content := make([]*yaml.Node, 0, len(backends))
+	for _, info := range backends {
+		if item, ok := existingByID[info.GetID()]; ok && nodeEquals(item, info) {
+			content = append(content, item)
+			continue
+		}
+
+		var encoded yaml.Node
+		err := encoded.Encode(info)
+		if err != nil {
+			return fmt.Errorf("Encoding backend %s failed: %s", info.GetID(), err.Error())
+		}
+		content = append(content, &encoded)
+	}
+
+	// an empty list is written in flow style, as "[]", and a list which was empty is written in block style
+	if len(content) == 0 {
+		existing.Style = yaml.FlowStyle
+	} else if len(existing.Content) == 0 {
+		existing.Style = 0
+	}
+	existing.Content = content
+	return nil
+}
+
+// Hides the values of any "secret" or "token" keys in a document.
+func redactSecrets(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if (key.Value == "secret" || key.Value == "token") && value.Kind == yaml.ScalarNode && value.Value != "" {
+				value.Value = redactedValue
+				value.Tag = "!!str"
+				value.Style = 0
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		redactSecrets(child)
+	}
+}
+
+// Replaces a file by writing a temporary file beside it and renaming it over the original.
+// The file keeps its permissions, or is only readable by its owner if it is new.
+func writeFileAtomic(path string, data []byte) error {
+	perm := fs.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("Saving %s failed: %s", path, err.Error())
+	}
+	// does nothing once renamed
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("Saving %s failed: %s", path, err.Error())
+	}
+
+	return nil
+}
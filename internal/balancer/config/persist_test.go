@@ -0,0 +1,207 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfigDocument = `# the balancing strategy
+strategy:
+    name: ROUND_ROBIN
+backends:
+    # the primary server
+    - host: server-a
+      port: 80
+    # the secondary server
+    - host: server-b
+      port: 80
+port: 8080 # where clients connect
+discovery:
+    consul:
+        - service: web
+          token: abc123
+`
+
+// Writes a config document to a temporary file, returning its path.
+func writeTestConfig(t *testing.T, document string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(document), 0640)
+	if err != nil {
+		t.Fatalf("Failed writing config: %s", err.Error())
+	}
+	return path
+}
+
+func readTestFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed reading %s: %s", path, err.Error())
+	}
+	return string(data)
+}
+
+func TestReadConfigFilename(t *testing.T) {
+	path := writeTestConfig(t, testConfigDocument)
+
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed reading config: %s", err.Error())
+	}
+	if cfg.Port != 8080 || len(cfg.Backends) != 2 {
+		t.Errorf("Failed reading config: got port %d and %d backends expected 8080 and 2", cfg.Port, len(cfg.Backends))
+	}
+
+	_, err = ReadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Errorf("Failed missing config: got no error")
+	}
+}
+
+func TestUpdateConfigYAMLKeepsComments(t *testing.T) {
+	cfg, _ := ReadConfig(writeTestConfig(t, testConfigDocument))
+
+	// remove server-b, add server-c and change the strategy
+	cfg.Backends = []BackendInfo{cfg.Backends[0], NewBackendInfo("server-c", 8080)}
+	cfg.Backends[1].Weight = 2
+	cfg.Strategy = StrategyConfig{Name: "LEAST_CONN"}
+
+	updated, err := UpdateConfigYAML([]byte(testConfigDocument), cfg)
+	if err != nil {
+		t.Fatalf("Failed updating config: %s", err.Error())
+	}
+	document := string(updated)
+
+	for _, kept := range []string{"# the balancing strategy", "# the primary server", "# where clients connect", "token: abc123"} {
+		if !strings.Contains(document, kept) {
+			t.Errorf("Failed keeping %q: got\n%s", kept, document)
+		}
+	}
+	for _, removed := range []string{"server-b", "# the secondary server", "ROUND_ROBIN"} {
+		if strings.Contains(document, removed) {
+			t.Errorf("Failed removing %q: got\n%s", removed, document)
+		}
+	}
+
+	// the document reads back as the updated config
+	reread, err := ReadConfig(writeTestConfig(t, document))
+	if err != nil {
+		t.Fatalf("Failed reading updated config: %s", err.Error())
+	}
+	if reread.Strategy.Name != "LEAST_CONN" || len(reread.Backends) != 2 || reread.Backends[1].GetID() != "server-c:8080" || reread.Backends[1].Weight != 2 {
+		t.Errorf("Failed reading updated config: got %+v", reread)
+	}
+	if reread.Port != 8080 || len(reread.Discovery.Consul) != 1 {
+		t.Errorf("Failed keeping other sections: got %+v", reread)
+	}
+}
+
+func TestUpdateConfigYAMLUnchanged(t *testing.T) {
+	cfg, _ := ReadConfig(writeTestConfig(t, testConfigDocument))
+
+	updated, err := UpdateConfigYAML([]byte(testConfigDocument), cfg)
+	if err != nil {
+		t.Fatalf("Failed updating config: %s", err.Error())
+	}
+	if string(updated) != testConfigDocument {
+		t.Errorf("Failed unchanged config: got\n%s\nexpected\n%s", updated, testConfigDocument)
+	}
+}
+
+func TestPersisterConfigMode(t *testing.T) {
+	path := writeTestConfig(t, testConfigDocument)
+	cfg, _ := ReadConfig(path)
+
+	p, err := NewPersister(PersistConfig{Mode: "config"}, path)
+	if err != nil {
+		t.Fatalf("Failed creating persister: %s", err.Error())
+	}
+
+	cfg.Backends = cfg.Backends[:1]
+	err = p.Save(cfg)
+	if err != nil {
+		t.Fatalf("Failed saving: %s", err.Error())
+	}
+
+	document := readTestFile(t, path)
+	if strings.Contains(document, "server-b") || !strings.Contains(document, "# the primary server") {
+		t.Errorf("Failed saving config: got\n%s", document)
+	}
+
+	// the file keeps its permissions, and no temporary files are left behind
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Failed keeping permissions: got %s expected -rw-r-----", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Failed cleaning up: got %d files expected 1", len(entries))
+	}
+}
+
+func TestPersisterStateMode(t *testing.T) {
+	path := writeTestConfig(t, testConfigDocument)
+	cfg, _ := ReadConfig(path)
+
+	p, err := NewPersister(PersistConfig{Mode: "state"}, path)
+	if err != nil {
+		t.Fatalf("Failed creating persister: %s", err.Error())
+	}
+
+	changed := cfg
+	changed.Backends = []BackendInfo{NewBackendInfo("server-c", 80)}
+	changed.Sticky = StickyConfig{Enabled: true, Mode: "sourceIP"}
+	err = p.Save(changed)
+	if err != nil {
+		t.Fatalf("Failed saving: %s", err.Error())
+	}
+
+	// the config file is left alone
+	if readTestFile(t, path) != testConfigDocument {
+		t.Errorf("Failed leaving config file unchanged")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "config.state.yaml")); err != nil {
+		t.Fatalf("Failed writing state file: %s", err.Error())
+	}
+
+	// restoring overrides the config file's runtime sections
+	restored, _ := ReadConfig(path)
+	err = p.Restore(&restored)
+	if err != nil {
+		t.Fatalf("Failed restoring: %s", err.Error())
+	}
+	if len(restored.Backends) != 1 || restored.Backends[0].GetID() != "server-c:80" || restored.Sticky.Mode != "sourceIP" || restored.Port != 8080 {
+		t.Errorf("Failed restoring: got %+v", restored)
+	}
+}
+
+func TestPersisterExportRedactsSecrets(t *testing.T) {
+	path := writeTestConfig(t, testConfigDocument)
+	cfg, _ := ReadConfig(path)
+
+	p, _ := NewPersister(PersistConfig{}, path)
+
+	cfg.Sticky = StickyConfig{Enabled: true, Cookie: SessionCookieConfig{Secret: "hunter2"}}
+	exported, err := p.Export(cfg)
+	if err != nil {
+		t.Fatalf("Failed exporting: %s", err.Error())
+	}
+
+	document := string(exported)
+	if strings.Contains(document, "hunter2") || strings.Contains(document, "abc123") || !strings.Contains(document, "********") {
+		t.Errorf("Failed redacting secrets: got\n%s", document)
+	}
+
+	// exporting doesnt save anything
+	if readTestFile(t, path) != testConfigDocument {
+		t.Errorf("Failed leaving config file unchanged")
+	}
+}
+
+func TestPersisterInvalidMode(t *testing.T) {
+	_, err := NewPersister(PersistConfig{Mode: "database"}, "")
+	if err == nil {
+		t.Errorf("Failed invalid mode: got no error")
+	}
+}
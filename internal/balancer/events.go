@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"sync"
+	"time"
+)
+
+// The number of events buffered for each subscriber before it starts missing them.
+const eventBufferSize = 64
+
+// An event sent to subscribers, with its data already encoded as JSON.
+type event struct {
+	Type string
+	Data []byte
+}
+
+// Broadcasts events about the balancer to subscribers, such as dashboards streaming them from the API.
+// Subscribers which fall behind miss events, rather than slowing the balancer down.
+//
+// While there are subscribers, onTick is called every tickInterval, to publish traffic stats.
+type eventHub struct {
+	subscribers map[chan event]struct{}
+
+	tickInterval time.Duration
+	onTick       func()
+	// Closed to stop the ticker when the last subscriber leaves.
+	stopTicker chan struct{}
+
+	m sync.Mutex
+}
+
+func newEventHub(tickInterval time.Duration, onTick func()) *eventHub {
+	return &eventHub{
+		subscribers:  make(map[chan event]struct{}),
+		tickInterval: tickInterval,
+		onTick:       onTick,
+	}
+}
+
+// Subscribes to events, returning the channel they are received on and a function to unsubscribe.
+func (h *eventHub) Subscribe() (<-chan event, func()) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	events := make(chan event, eventBufferSize)
+	h.subscribers[events] = struct{}{}
+
+	if len(h.subscribers) == 1 && h.onTick != nil {
+		h.stopTicker = make(chan struct{})
+		go h.tick(h.stopTicker)
+	}
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() { h.unsubscribe(events) })
+	}
+}
+
+func (h *eventHub) unsubscribe(events chan event) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	delete(h.subscribers, events)
+
+	if len(h.subscribers) == 0 && h.stopTicker != nil {
+		close(h.stopTicker)
+		h.stopTicker = nil
+	}
+}
+
+// Reports whether anything is subscribed, so events nobody receives arent built.
+func (h *eventHub) HasSubscribers() bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return len(h.subscribers) > 0
+}
+
+// Sends an event to every subscriber, encoding its data as JSON.
+// Never blocks: subscribers whose buffers are full miss the event.
+func (h *eventHub) Publish(eventType string, data interface{}) {
+	if !h.HasSubscribers() {
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Encoding %s event failed: %s\n", eventType, err.Error())
+		return
+	}
+	e := event{Type: eventType, Data: encoded}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for events := range h.subscribers {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) tick(stop chan struct{}) {
+	ticker := time.NewTicker(h.tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.onTick()
+		}
+	}
+}
+
+type backendsEvent struct {
+	Generation uint64                      `json:"generation"`
+	Backends   backend.ReadonlyBackendList `json:"backends"`
+}
+
+type strategyEvent struct {
+	Generation uint64                `json:"generation"`
+	Strategy   config.StrategyConfig `json:"strategy"`
+}
+
+type healthEvent struct {
+	ID    string `json:"id"`
+	Alive bool   `json:"alive"`
+}
+
+// Gets the event describing the current backends.
+func (b *balancer) getBackendsEvent() backendsEvent {
+	return backendsEvent{
+		Generation: b.GetGeneration(),
+		Backends:   b.backendManager.GetBackends(),
+	}
+}
+
+// Gets the event describing the current strategy.
+func (b *balancer) getStrategyEvent() strategyEvent {
+	return strategyEvent{
+		Generation: b.GetGeneration(),
+		Strategy:   b.GetStrategyConfig(),
+	}
+}
+
+// The traffic to one backend over the last stats interval.
+type backendTraffic struct {
+	ID                string  `json:"id"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	// The average time taken to serve a request, 0 if there were none.
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+	// The fraction of requests which failed to get a response, 0 if there were none.
+	ErrorRate   float64 `json:"errorRate"`
+	Connections int     `json:"connections"`
+	// Connections upgraded to another protocol, such as WebSockets
+	UpgradedConnections int `json:"upgradedConnections"`
+}
+
+type statsEvent struct {
+	Time              time.Time         `json:"time"`
+	RequestsPerSecond float64           `json:"requestsPerSecond"`
+	Queue             requestQueueStats `json:"queue"`
+	Backends          []backendTraffic  `json:"backends"`
+}
+
+// The counters of a backend when it was last sampled.
+type trafficSample struct {
+	time     time.Time
+	requests uint64
+	failures uint64
+	latency  time.Duration
+}
+
+// Turns the balancer's request counters into rates, by comparing them with the previous sample.
+// Only used by the event hub's ticker, so it isnt locked.
+type trafficSampler struct {
+	// Samples older than this are ignored, such as those from before the ticker was last stopped.
+	maxAge time.Duration
+
+	total    trafficSample
+	backends map[backend.BackendRef]trafficSample
+}
+
+func newTrafficSampler(maxAge time.Duration) *trafficSampler {
+	return &trafficSampler{
+		maxAge:   maxAge,
+		backends: make(map[backend.BackendRef]trafficSample),
+	}
+}
+
+// Samples the counters, getting the traffic since the previous sample.
+// Backends without a recent previous sample have no traffic yet.
+func (s *trafficSampler) Sample(b *balancer, now time.Time) statsEvent {
+	e := statsEvent{Time: now, Queue: b.queue.GetStats(), Backends: []backendTraffic{}}
+
+	total := trafficSample{time: now, requests: b.stats.requests.Load()}
+	if elapsed := now.Sub(s.total.time); elapsed > 0 && elapsed <= s.maxAge {
+		e.RequestsPerSecond = float64(total.requests-s.total.requests) / elapsed.Seconds()
+	}
+	s.total = total
+
+	current := make(map[backend.BackendRef]trafficSample)
+	list := b.backendManager.GetBackends()
+	for i := 0; i < list.Len(); i++ {
+		be := list.Get(i)
+
+		sample := trafficSample{time: now, latency: be.GetTotalLatency()}
+		sample.requests, sample.failures = be.GetRequestCounts()
+		current[be] = sample
+
+		traffic := backendTraffic{
+			ID:                  be.GetID(),
+			Connections:         be.GetActiveConnections(),
+			UpgradedConnections: be.GetUpgradedConnections(),
+		}
+
+		previous, ok := s.backends[be]
+		if elapsed := now.Sub(previous.time); ok && elapsed > 0 && elapsed <= s.maxAge {
+			requests := sample.requests - previous.requests
+			traffic.RequestsPerSecond = float64(requests) / elapsed.Seconds()
+
+			if requests > 0 {
+				traffic.AverageLatencyMs = float64(sample.latency-previous.latency) / float64(time.Millisecond) / float64(requests)
+				traffic.ErrorRate = float64(sample.failures-previous.failures) / float64(requests)
+			}
+		}
+
+		e.Backends = append(e.Backends, traffic)
+	}
+
+	// forget removed backends
+	s.backends = current
+
+	return e
+}
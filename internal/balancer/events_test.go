@@ -0,0 +1,248 @@
+package balancer
+
+import (
+	"bufio"
+	"encoding/json"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits for an event of a type, skipping others, failing the test if none arrives in time.
+func waitForEvent(t *testing.T, events <-chan event, eventType string) event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == eventType {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Failed waiting for %s event: none received", eventType)
+			return event{}
+		}
+	}
+}
+
+func TestEventHubPublish(t *testing.T) {
+	h := newEventHub(time.Hour, nil)
+
+	// nothing is built without subscribers
+	h.Publish("test", func() {})
+
+	first, unsubscribeFirst := h.Subscribe()
+	second, unsubscribeSecond := h.Subscribe()
+	defer unsubscribeSecond()
+
+	h.Publish("test", map[string]int{"a": 1})
+	for _, events := range []<-chan event{first, second} {
+		e := waitForEvent(t, events, "test")
+		if string(e.Data) != `{"a":1}` {
+			t.Errorf("Failed event data: got %s expected {\"a\":1}", e.Data)
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	h.Publish("test", 2)
+	if len(first) != 0 {
+		t.Errorf("Failed unsubscribe: got %d events expected 0", len(first))
+	}
+	if len(second) != 1 {
+		t.Errorf("Failed publish after unsubscribe: got %d events expected 1", len(second))
+	}
+}
+
+// Tests publishing never blocks on a subscriber which isnt receiving.
+func TestEventHubSlowSubscriber(t *testing.T) {
+	h := newEventHub(time.Hour, nil)
+
+	events, unsubscribe := h.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventBufferSize*2; i++ {
+			h.Publish("test", i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed publishing to a slow subscriber: publish blocked")
+	}
+
+	if len(events) != eventBufferSize {
+		t.Errorf("Failed buffering events: got %d expected %d", len(events), eventBufferSize)
+	}
+}
+
+// Tests the ticker only runs while there are subscribers.
+func TestEventHubTicker(t *testing.T) {
+	ticks := make(chan struct{}, 100)
+	h := newEventHub(time.Millisecond, func() {
+		ticks <- struct{}{}
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	if len(ticks) != 0 {
+		t.Errorf("Failed ticker without subscribers: got %d ticks expected 0", len(ticks))
+	}
+
+	_, unsubscribe := h.Subscribe()
+	select {
+	case <-ticks:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed ticker with a subscriber: no tick")
+	}
+
+	unsubscribe()
+	time.Sleep(20 * time.Millisecond)
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(ticks) != 0 {
+		t.Errorf("Failed stopping ticker: got %d ticks expected 0", len(ticks))
+	}
+}
+
+func TestTrafficSampler(t *testing.T) {
+	_, infos := newTestBackends(t, 2)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+	s := newTrafficSampler(time.Minute)
+
+	now := time.Now()
+	first := s.Sample(b, now)
+	if first.RequestsPerSecond != 0 || len(first.Backends) != 2 || first.Backends[0].RequestsPerSecond != 0 {
+		t.Errorf("Failed first sample: got %+v expected no traffic for 2 backends", first)
+	}
+
+	for i := 0; i < 4; i++ {
+		serveTestRequest(b, "/")
+	}
+
+	second := s.Sample(b, now.Add(2*time.Second))
+	if second.RequestsPerSecond != 2 {
+		t.Errorf("Failed request rate: got %f expected 2", second.RequestsPerSecond)
+	}
+	for _, traffic := range second.Backends {
+		if traffic.RequestsPerSecond != 1 || traffic.ErrorRate != 0 || traffic.AverageLatencyMs <= 0 {
+			t.Errorf("Failed backend traffic: got %+v expected 1 request per second without errors", traffic)
+		}
+	}
+
+	// samples older than the max age are ignored
+	stale := s.Sample(b, now.Add(time.Hour))
+	if stale.RequestsPerSecond != 0 || stale.Backends[0].RequestsPerSecond != 0 {
+		t.Errorf("Failed stale sample: got %+v expected no traffic", stale)
+	}
+}
+
+// Tests changes are published as they are made.
+func TestBalancerEvents(t *testing.T) {
+	_, infos := newTestBackends(t, 2)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+
+	events, unsubscribe := b.events.Subscribe()
+	defer unsubscribe()
+
+	b.RemoveBackends(infos[:1])
+	var backends struct {
+		Generation uint64
+		Backends   []struct{ ID string }
+	}
+	json.Unmarshal(waitForEvent(t, events, "backends").Data, &backends)
+	if backends.Generation != b.GetGeneration() || len(backends.Backends) != 1 || backends.Backends[0].ID != infos[1].GetID() {
+		t.Errorf("Failed backends event: got %+v expected only %s", backends, infos[1].GetID())
+	}
+
+	b.ChangeStrategy(config.StrategyConfig{Name: "LEAST_CONN"})
+	var strategy strategyEvent
+	json.Unmarshal(waitForEvent(t, events, "strategy").Data, &strategy)
+	if strategy.Strategy.Name != "LEAST_CONN" {
+		t.Errorf("Failed strategy event: got %s expected LEAST_CONN", strategy.Strategy.Name)
+	}
+
+	b.backendManager.ReportBackendDead(b.backendManager.GetBackends().Get(0))
+	var health healthEvent
+	json.Unmarshal(waitForEvent(t, events, "health").Data, &health)
+	if health.ID != infos[1].GetID() || health.Alive {
+		t.Errorf("Failed health event: got %+v expected %s dead", health, infos[1].GetID())
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	b, m := newTestAPI(t)
+
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/api/v1/events")
+	if err != nil {
+		t.Fatalf("Failed connecting to event stream: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Failed content type: got %s expected text/event-stream", contentType)
+	}
+
+	// read events from the stream as "type data" lines
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		eventType := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "event: ") {
+				eventType = strings.TrimPrefix(line, "event: ")
+			} else if strings.HasPrefix(line, "data: ") {
+				lines <- eventType + " " + strings.TrimPrefix(line, "data: ")
+			}
+		}
+		close(lines)
+	}()
+
+	waitForLine := func(eventType string) string {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("Failed waiting for %s event: stream closed", eventType)
+				}
+				if strings.HasPrefix(line, eventType+" ") {
+					return strings.TrimPrefix(line, eventType+" ")
+				}
+			case <-timeout:
+				t.Fatalf("Failed waiting for %s event: none received", eventType)
+			}
+		}
+	}
+
+	// the stream starts with the current state
+	var backends testBackendList
+	json.Unmarshal([]byte(waitForLine("backends")), &backends)
+	checkIDs(t, "initial backends event", backends.ids(), []string{"a", "b", "c"})
+	waitForLine("strategy")
+
+	w := modificationRequest(m, http.MethodDelete, "/api/v1/backends/a", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Failed deleting backend: got %d expected %d", w.Code, http.StatusNoContent)
+	}
+	json.Unmarshal([]byte(waitForLine("backends")), &backends)
+	checkIDs(t, "backends event after delete", backends.ids(), []string{"b", "c"})
+
+	serveTestRequest(b, "/")
+	var stats statsEvent
+	json.Unmarshal([]byte(waitForLine("stats")), &stats)
+	if len(stats.Backends) != 2 {
+		t.Errorf("Failed stats event: got %+v expected 2 backends", stats)
+	}
+}
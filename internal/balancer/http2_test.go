@@ -0,0 +1,253 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Handles requests like a gRPC server: echoing the body, and sending the status in trailers.
+// Fails requests which arent HTTP/2 or dont accept trailers, as gRPC servers do.
+func grpcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Header.Get("Te") != "trailers" {
+		w.WriteHeader(http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.Write(body)
+
+	w.Header().Set("Grpc-Status", "0")
+	// undeclared trailers are forwarded too
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+}
+
+// Creates a client which speaks cleartext HTTP/2 with prior knowledge.
+func newH2CClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// Parses a backend from JSON, as the config and the modification API do.
+func parseTestBackendInfo(t *testing.T, data string) config.BackendInfo {
+	var info config.BackendInfo
+	err := json.Unmarshal([]byte(data), &info)
+	if err != nil {
+		t.Fatalf("Failed parsing backend: %s", err.Error())
+	}
+	return info
+}
+
+// Writes a test server's certificate and key to files, returning their paths.
+func writeTestCertificate(t *testing.T, server *httptest.Server) (string, string) {
+	cert := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed encoding key: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+
+	return certFile, keyFile
+}
+
+// Sends a gRPC style request, checking the response and its trailers made it through.
+func checkGRPCRequest(t *testing.T, name string, client *http.Client, url string) {
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader("message"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed %s: %s", name, err.Error())
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 || string(body) != "message" {
+		t.Errorf("Failed %s: got %d over HTTP/%d with %q expected 200 over HTTP/2 with the message", name, res.StatusCode, res.ProtoMajor, body)
+	}
+	if res.Trailer.Get("Grpc-Status") != "0" || res.Trailer.Get("Grpc-Message") != "ok" {
+		t.Errorf("Failed %s trailers: got %v expected Grpc-Status and Grpc-Message", name, res.Trailer)
+	}
+}
+
+// Tests gRPC over h2c, from the client through to an h2c backend.
+func TestH2CProxy(t *testing.T) {
+	backendServer, info := newTestBackend(t, h2c.NewHandler(http.HandlerFunc(grpcHandler), &http2.Server{}).ServeHTTP)
+	defer backendServer.Close()
+	info = parseTestBackendInfo(t, fmt.Sprintf(`{"host": "%s", "port": %d, "protocol": "h2c"}`, info.Host, info.Port))
+
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = NewServer(config.ServerConfig{H2C: true}, 0, b)
+	server.Start()
+	defer server.Close()
+
+	checkGRPCRequest(t, "h2c with prior knowledge", newH2CClient(), server.URL+"/echo.Echo/Echo")
+
+	// HTTP/1.1 clients are still served
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed HTTP/1.1 request: %s", err.Error())
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 1 || res.StatusCode != http.StatusHTTPVersionNotSupported {
+		t.Errorf("Failed HTTP/1.1 request: got %d over HTTP/%d expected the backend's 505 over HTTP/1", res.StatusCode, res.ProtoMajor)
+	}
+}
+
+// Tests WebSockets to an h2c backend are sent over HTTP/1.1, as HTTP/2 cant upgrade connections.
+func TestH2CBackendWebSocket(t *testing.T) {
+	backendServer, info := newTestBackend(t, h2c.NewHandler(http.HandlerFunc(echoWebSocket), &http2.Server{}).ServeHTTP)
+	defer backendServer.Close()
+	info = parseTestBackendInfo(t, fmt.Sprintf(`{"host": "%s", "port": %d, "protocol": "h2c"}`, info.Host, info.Port))
+
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	checkEcho(t, "echo through h2c backend", conn, "hello")
+
+	if !b.backendManager.GetBackend(0).GetHealthy() {
+		t.Errorf("Failed WebSocket to h2c backend: got the backend marked dead expected it alive")
+	}
+}
+
+// Tests clients can upgrade to h2c, rather than the upgrade being passed to the backend.
+func TestH2CUpgrade(t *testing.T) {
+	_, infos := newTestBackends(t, 1)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = NewServer(config.ServerConfig{H2C: true}, 0, b)
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed dialing: %s", err.Error())
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed reading upgrade response: %s", err.Error())
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("Failed upgrade: got %d upgrading to %q expected 101 upgrading to h2c", res.StatusCode, res.Header.Get("Upgrade"))
+	}
+
+	// the request which asked to upgrade is answered over HTTP/2, as stream 1
+	io.WriteString(conn, http2.ClientPreface)
+	framer := http2.NewFramer(conn, reader)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	framer.WriteSettings()
+
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed reading HTTP/2 response: %s", err.Error())
+		}
+
+		if headers, ok := frame.(*http2.MetaHeadersFrame); ok && headers.StreamID == 1 {
+			if status := headers.PseudoValue("status"); status != "200" {
+				t.Errorf("Failed upgraded request: got %s expected 200", status)
+			}
+			break
+		}
+	}
+}
+
+// Tests gRPC over HTTPS, from the TLS listener through to an h2 backend.
+func TestH2Proxy(t *testing.T) {
+	backendServer := httptest.NewUnstartedServer(http.HandlerFunc(grpcHandler))
+	backendServer.EnableHTTP2 = true
+	backendServer.StartTLS()
+	defer backendServer.Close()
+
+	certFile, keyFile := writeTestCertificate(t, backendServer)
+
+	addr := backendServer.Listener.Addr().(*net.TCPAddr)
+	info := parseTestBackendInfo(t, fmt.Sprintf(`{"host": "127.0.0.1", "port": %d, "protocol": "h2", "tls": {"caFile": "%s"}}`, addr.Port, certFile))
+
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+
+	// the balancer's listener uses the same certificate as the backend, to save making another
+	s, err := NewTLSServer(config.ServerConfig{TLS: config.ServerTLSConfig{CertFile: certFile, KeyFile: keyFile}}, b)
+	if err != nil {
+		t.Fatalf("Failed creating TLS server: %s", err.Error())
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed listening: %s", err.Error())
+	}
+	go s.ServeTLS(listener, "", "")
+	defer s.Close()
+
+	client := backendServer.Client()
+	checkGRPCRequest(t, "h2", client, "https://"+listener.Addr().String()+"/echo.Echo/Echo")
+}
+
+func TestBackendProtocolConfig(t *testing.T) {
+	cases := []struct {
+		data  string
+		valid bool
+	}{
+		{`{"host": "localhost", "port": 80}`, true},
+		{`{"host": "localhost", "port": 80, "protocol": "h2c"}`, true},
+		{`{"host": "localhost", "port": 443, "protocol": "h2", "tls": {"insecureSkipVerify": true}}`, true},
+		{`{"host": "localhost", "port": 80, "protocol": "spdy"}`, false},
+		{`{"host": "localhost", "port": 80, "protocol": "h2c", "tls": {"serverName": "backend"}}`, false},
+		{`{"host": "localhost", "port": 443, "protocol": "h2", "tls": {"caFile": "/does/not/exist.pem"}}`, false},
+	}
+
+	for _, c := range cases {
+		var info config.BackendInfo
+		err := json.Unmarshal([]byte(c.data), &info)
+
+		if (err == nil) != c.valid {
+			t.Errorf("Failed %s: got %v expected valid %t", c.data, err, c.valid)
+		}
+	}
+
+	info := parseTestBackendInfo(t, `{"host": "localhost", "port": 443, "protocol": "h2"}`)
+	if info.URL.Scheme != "https" {
+		t.Errorf("Failed h2 backend url: got %s expected https", info.URL.String())
+	}
+}
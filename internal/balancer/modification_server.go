@@ -1,16 +1,23 @@
 package balancer
 
 import (
+	"crypto/subtle"
 	"embed"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"go-balancer/internal/audit"
 	"go-balancer/internal/balancer/config"
 	"io/fs"
 	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 )
 
+// The address the modification server listens on if none is configured.
+const defaultAdminListen = ":44444"
+
 // embed the static website frontend files
 //
 //go:embed static/*
@@ -19,15 +26,37 @@ var staticContent embed.FS
 type modificationServer struct {
 	balancer *balancer
 
+	// The address the API and dashboard are served on.
+	listen string
+	// The bearer token API requests must have, empty if they dont need one.
+	token string
+
+	// Records the changes made through the API.
+	auditLog *audit.Log
+	// Saves changes made through the API, and exports the config.
+	persister *config.Persister
+	// Serialises changes, so If-Match checks and audit diffs see no other API change in between.
+	mutationMutex *sync.Mutex
+
 	running bool
 	port    int
 
 	Close func() error
 }
 
-func NewModificationServer(b *balancer) modificationServer {
+func NewModificationServer(b *balancer, cfg config.AdminConfig, auditLog *audit.Log, persister *config.Persister) modificationServer {
+	listen := cfg.Listen
+	if listen == "" {
+		listen = defaultAdminListen
+	}
+
 	return modificationServer{
-		balancer: b,
+		balancer:      b,
+		listen:        listen,
+		token:         cfg.Token,
+		auditLog:      auditLog,
+		persister:     persister,
+		mutationMutex: &sync.Mutex{},
 	}
 }
 
@@ -39,156 +68,95 @@ func (m *modificationServer) IsRunning() bool {
 	return m.running
 }
 
-// Runs a http server on a new free port to handle runtime balancer state modification.
-// Returns the port the server is listening on, as well as a function that closes the server.
+// Runs a http server on the configured address, serving the modification API and the dashboard.
+// Addresses starting "unix:" are unix socket paths, replacing any socket left at the path.
+// Once started, GetPort gets the port it is listening on (0 for a unix socket) and Close closes it.
 func (m *modificationServer) Start() error {
-	listener, err := net.Listen("tcp", ":0")
+	network, address := "tcp", m.listen
+	if strings.HasPrefix(m.listen, "unix:") {
+		network, address = "unix", strings.TrimPrefix(m.listen, "unix:")
+
+		// a socket left by a previous run would stop us listening
+		if info, err := os.Stat(address); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
 	if err != nil {
 		return err
 	}
 
-	m.port = listener.Addr().(*net.TCPAddr).Port
+	if network == "unix" {
+		// only the owner and their group can connect
+		err = os.Chmod(address, 0660)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+	} else {
+		m.port = listener.Addr().(*net.TCPAddr).Port
+	}
+
 	m.Close = listener.Close
+	m.running = true
 
 	go m.serve(listener)
-	fmt.Printf("Started modification server on port %d\n", m.GetPort())
-
-	go m.startDashboardServer(44444)
+	fmt.Printf("Started modification server and dashboard on %s\n", listener.Addr().String())
 
 	return nil
 }
 
-// Starts a http server to serve the static files for a simple web dashboard
-func (m *modificationServer) startDashboardServer(port int) {
-	staticFs, err := fs.Sub(staticContent, "static")
-	if err != nil {
-		panic(errors.New("Failed to get static subdir of static files."))
-	}
-	fs := http.FS(staticFs)
-
-	mux := http.NewServeMux()
-
-	fileServer := http.FileServer(fs)
-
-	// intercept a certain path to give it the port of the modifcation http api
-	handleGetBackendPort := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "text/javascript")
-		w.WriteHeader(200)
-		w.Write([]byte(fmt.Sprintf("const BACKEND_PORT = %d", m.port)))
-	})
-
-	mux.HandleFunc("/javascript/request-url.js", handleGetBackendPort)
-	mux.HandleFunc("/", fileServer.ServeHTTP)
-
-	fmt.Printf("Dashboard server listening on port %d\n", port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
-	if err != nil {
-		fmt.Printf("Dashboard server crashed: %s\n", err.Error())
-	}
-}
-
-func addCorsHeader(res http.ResponseWriter) {
-	headers := res.Header()
-	headers.Add("Vary", "Origin")
-	headers.Add("Vary", "Access-Control-Request-Method")
-	headers.Add("Vary", "Access-Control-Request-Headers")
-	headers.Add("Access-Control-Allow-Headers", "Content-Type, Origin, Accept")
-	headers.Add("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
-}
-
 // Actually start serving connections.
 // TODO: make it retry if server crashes
 func (m *modificationServer) serve(listener net.Listener) {
-	err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		switch r.URL.Path {
-		case "/backends":
-			switch r.Method {
-			case "OPTIONS":
-				addCorsHeader(w)
-				w.WriteHeader(200)
-				break
-			case "GET":
-				m.getBackends(w)
-				break
-			case "PUT":
-				m.putBackend(w, r)
-				break
-			case "DELETE":
-				m.deleteBackend(w, r)
-				break
-			}
-		}
-	}))
+	err := http.Serve(listener, m.handler())
+	m.running = false
 
 	fmt.Printf("Modification server crashed: %s\n", err.Error())
 }
 
-// Writes the list of backends in json format.
-func (m *modificationServer) getBackends(w http.ResponseWriter) {
-	// specify its json encoded
-	w.Header().Set("Content-Type", "application/json")
-
-	// empty if empty
-	if m.balancer.backendManager.GetBackendCount() == 0 {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+// Creates the handler serving the versioned API under /api, and the dashboard everywhere else.
+// Every API response has the current config generation as its ETag, which changes replace once made.
+func (m *modificationServer) handler() http.Handler {
+	router := m.newAPIv1()
 
-	encoded, err := json.Marshal(m.balancer.backendManager.GetBackends())
+	staticFs, err := fs.Sub(staticContent, "static")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
+		panic(errors.New("Failed to get static subdir of static files."))
 	}
+	dashboard := http.FileServer(http.FS(staticFs))
 
-	w.WriteHeader(http.StatusOK)
-
-	// write list to resp
-	w.Write(encoded)
-}
-
-// Creates a backend from the data in the req
-func (m *modificationServer) putBackend(w http.ResponseWriter, r *http.Request) {
-	var info config.BackendInfo
-
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	err := decoder.Decode(&info)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api" && !strings.HasPrefix(r.URL.Path, "/api/") {
+			dashboard.ServeHTTP(w, r)
+			return
+		}
 
-	err = m.balancer.AddBackends([]config.BackendInfo{info})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
-	}
+		if r.Method != http.MethodOptions && !m.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gobal"`)
+			writeAPIError(w, http.StatusUnauthorized, "Missing or invalid admin token.")
+			return
+		}
 
-	w.WriteHeader(http.StatusOK)
+		w.Header().Set("ETag", generationETag(m.balancer.GetGeneration()))
+		router.ServeHTTP(w, r)
+	})
 }
 
-// Delete a backend from the data in the req
-func (m *modificationServer) deleteBackend(w http.ResponseWriter, r *http.Request) {
-	var info config.BackendInfo
-
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	err := decoder.Decode(&info)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
+// Reports whether a request has the admin token, if one is needed.
+// The event stream can also be given it in the token query parameter, as browsers cant set headers on one.
+func (m *modificationServer) authorized(r *http.Request) bool {
+	if m.token == "" {
+		return true
 	}
 
-	// doesnt throw errors
-	m.balancer.RemoveBackends([]config.BackendInfo{info})
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if r.URL.Path == "/api/v1/events" {
+		token = r.URL.Query().Get("token")
+	}
 
-	w.WriteHeader(http.StatusOK)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) == 1
 }
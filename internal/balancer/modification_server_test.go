@@ -0,0 +1,127 @@
+package balancer
+
+import (
+	"context"
+	"go-balancer/internal/audit"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTokenServer(t *testing.T, cfg config.AdminConfig) *modificationServer {
+	_, infos := newTestBackends(t, 1)
+	b := newTestBalancer(t, "ROUND_ROBIN", infos)
+
+	auditLog, err := audit.NewLog(config.AuditConfig{})
+	if err != nil {
+		t.Fatalf("Failed creating audit log: %s", err.Error())
+	}
+	persister, err := config.NewPersister(config.PersistConfig{}, "")
+	if err != nil {
+		t.Fatalf("Failed creating persister: %s", err.Error())
+	}
+
+	m := NewModificationServer(b, cfg, auditLog, persister)
+	return &m
+}
+
+func TestModificationServerToken(t *testing.T) {
+	m := newTestTokenServer(t, config.AdminConfig{Token: "secret"})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"no token", http.MethodGet, "/api/v1/strategy", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/v1/strategy", "Bearer wrong", http.StatusUnauthorized},
+		{"not bearer", http.MethodGet, "/api/v1/strategy", "secret", http.StatusUnauthorized},
+		{"token", http.MethodGet, "/api/v1/strategy", "Bearer secret", http.StatusOK},
+		{"query token", http.MethodGet, "/api/v1/strategy?token=secret", "", http.StatusUnauthorized},
+		{"preflight", http.MethodOptions, "/api/v1/strategy", "", http.StatusNoContent},
+		{"dashboard", http.MethodGet, "/", "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		m.handler().ServeHTTP(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("Failed %s: got %d expected %d", c.name, w.Code, c.status)
+		}
+		if c.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Failed %s: got no WWW-Authenticate header expected one", c.name)
+		}
+	}
+
+	// the event stream takes the token in the url, so it is tested against a server it can end
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/events?token=secret", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed event stream request: %s", err.Error())
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Failed event stream query token: got %d expected 200", res.StatusCode)
+	}
+}
+
+func TestModificationServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// a socket left by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed creating stale socket: %s", err.Error())
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	m := newTestTokenServer(t, config.AdminConfig{Listen: "unix:" + path})
+	err = m.Start()
+	if err != nil {
+		t.Fatalf("Failed starting on unix socket: %s", err.Error())
+	}
+	defer m.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed finding socket: %s", err.Error())
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("Failed socket permissions: got %v expected 0660", info.Mode().Perm())
+	}
+	if m.GetPort() != 0 {
+		t.Errorf("Failed unix socket port: got %d expected 0", m.GetPort())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get("http://gobal/api/v1/strategy")
+	if err != nil {
+		t.Fatalf("Failed request over unix socket: %s", err.Error())
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Failed request over unix socket: got %d expected 200", res.StatusCode)
+	}
+}
@@ -0,0 +1,185 @@
+package balancer
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/util"
+	"sync"
+	"time"
+)
+
+// The default time a request waits in the queue, if none is configured.
+const defaultQueueTimeout = time.Second * 30
+
+// How often a waiting request rechecks for a backend without being woken.
+// Catches backends becoming available for reasons other than a request finishing (e.g. coming back alive).
+const queueRecheckInterval = time.Millisecond * 100
+
+// The number of recent wait times averaged for the queue stats.
+const queueWaitMeasurements = 100
+
+var errQueueFull = errors.New("Request queue is full.")
+var errQueueTimeout = errors.New("Timed out waiting in request queue.")
+var errQueueCancelled = errors.New("Request was cancelled while waiting in request queue.")
+
+// A bounded FIFO queue of requests waiting for a backend to have capacity.
+//
+// Waiting requests are woken in order as other requests finish.
+type requestQueue struct {
+	maxSize int
+	timeout time.Duration
+
+	// Wake channels of the requests currently waiting, in arrival order
+	waiters *list.List
+
+	// Recent wait times, and their average
+	waitMeasurements util.Queue[time.Duration]
+	averageWait      time.Duration
+
+	// Counts of requests rejected because the queue was full, or they timed out,
+	// and of requests which left the queue as they were cancelled (e.g. by the client going away)
+	rejected  int
+	timedOut  int
+	cancelled int
+
+	m sync.Mutex
+}
+
+type requestQueueStats struct {
+	Depth         int   `json:"depth"`
+	MaxDepth      int   `json:"maxDepth"`
+	TimeoutMs     int64 `json:"timeoutMs"`
+	AverageWaitMs int64 `json:"averageWaitMs"`
+	Rejected      int   `json:"rejected"`
+	TimedOut      int   `json:"timedOut"`
+	Cancelled     int   `json:"cancelled"`
+}
+
+func newRequestQueue(cfg config.QueueConfig) *requestQueue {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultQueueTimeout
+	}
+
+	return &requestQueue{
+		maxSize:          cfg.Size,
+		timeout:          timeout,
+		waiters:          list.New(),
+		waitMeasurements: util.NewRingBufferQueue[time.Duration](queueWaitMeasurements),
+	}
+}
+
+// Waits in the queue until tryAcquire succeeds, the queue timeout is reached, or ctx is done.
+//
+// tryAcquire is called each time the request is woken, and should return true once a backend has been found.
+// Returns errQueueFull straight away if the queue is full, errQueueTimeout if the timeout is reached,
+// or errQueueCancelled if ctx is done first, so requests whose client has gone away give up their place.
+func (q *requestQueue) Wait(ctx context.Context, tryAcquire func() bool) error {
+	q.m.Lock()
+	if q.waiters.Len() >= q.maxSize {
+		q.rejected++
+		q.m.Unlock()
+		return errQueueFull
+	}
+
+	wake := make(chan struct{}, 1)
+	elem := q.waiters.PushBack(wake)
+	q.m.Unlock()
+
+	start := time.Now()
+	deadline := time.NewTimer(q.timeout)
+	defer deadline.Stop()
+
+	recheck := time.NewTicker(queueRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-wake:
+		case <-recheck.C:
+		case <-deadline.C:
+			q.m.Lock()
+			q.waiters.Remove(elem)
+			q.timedOut++
+			q.m.Unlock()
+			return errQueueTimeout
+		case <-ctx.Done():
+			q.m.Lock()
+			q.waiters.Remove(elem)
+			q.cancelled++
+			// pass on a wake the request wont use
+			select {
+			case <-wake:
+				q.wakeFront()
+			default:
+			}
+			q.m.Unlock()
+			return errQueueCancelled
+		}
+
+		// a cancelled request is never given a backend, even if it was woken at the same time
+		if ctx.Err() == nil && tryAcquire() {
+			q.m.Lock()
+			q.waiters.Remove(elem)
+			q.recordWait(time.Since(start))
+			q.m.Unlock()
+			return nil
+		}
+	}
+}
+
+// Wakes the request at the front of the queue.
+// Called whenever a request finishes, freeing up capacity on a backend.
+func (q *requestQueue) Release() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.wakeFront()
+}
+
+// Wakes the request at the front of the queue, if there is one.
+// Assumes the caller holds the lock.
+func (q *requestQueue) wakeFront() {
+	front := q.waiters.Front()
+	if front == nil {
+		return
+	}
+
+	// non-blocking: if the waiter already has a pending wake, it doesnt need another
+	select {
+	case front.Value.(chan struct{}) <- struct{}{}:
+	default:
+	}
+}
+
+// Updates the moving average of wait times.
+// Assumes the caller holds the lock.
+func (q *requestQueue) recordWait(wait time.Duration) {
+	count := time.Duration(q.waitMeasurements.Count())
+
+	if count == queueWaitMeasurements {
+		old := q.waitMeasurements.Dequeue()
+		q.averageWait += (wait - old) / queueWaitMeasurements
+	} else {
+		q.averageWait = (q.averageWait*count + wait) / (count + 1)
+	}
+
+	q.waitMeasurements.Enqueue(wait)
+}
+
+func (q *requestQueue) GetStats() requestQueueStats {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return requestQueueStats{
+		Depth:         q.waiters.Len(),
+		MaxDepth:      q.maxSize,
+		TimeoutMs:     q.timeout.Milliseconds(),
+		AverageWaitMs: q.averageWait.Milliseconds(),
+		Rejected:      q.rejected,
+		TimedOut:      q.timedOut,
+		Cancelled:     q.cancelled,
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"context"
+	"go-balancer/internal/balancer/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestQueueFull(t *testing.T) {
+	q := newRequestQueue(config.QueueConfig{Size: 1, Timeout: time.Second})
+
+	// fill the single slot with a request that never gets a backend
+	go q.Wait(context.Background(), func() bool { return false })
+	time.Sleep(time.Millisecond * 10)
+
+	err := q.Wait(context.Background(), func() bool { return true })
+	if err != errQueueFull {
+		t.Errorf("Failed queue full: got %v expected %v", err, errQueueFull)
+	}
+
+	stats := q.GetStats()
+	if stats.Depth != 1 || stats.Rejected != 1 {
+		t.Errorf("Failed queue full stats: got depth %d rejected %d expected 1 and 1", stats.Depth, stats.Rejected)
+	}
+}
+
+func TestRequestQueueTimeout(t *testing.T) {
+	q := newRequestQueue(config.QueueConfig{Size: 1, Timeout: time.Millisecond * 50})
+
+	err := q.Wait(context.Background(), func() bool { return false })
+	if err != errQueueTimeout {
+		t.Errorf("Failed queue timeout: got %v expected %v", err, errQueueTimeout)
+	}
+
+	stats := q.GetStats()
+	if stats.Depth != 0 || stats.TimedOut != 1 {
+		t.Errorf("Failed queue timeout stats: got depth %d timed out %d expected 0 and 1", stats.Depth, stats.TimedOut)
+	}
+}
+
+func TestRequestQueueRelease(t *testing.T) {
+	q := newRequestQueue(config.QueueConfig{Size: 1, Timeout: time.Second * 5})
+
+	var capacity atomic.Bool
+
+	done := make(chan error)
+	go func() {
+		done <- q.Wait(context.Background(), capacity.Load)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	// free up capacity and wake the waiter
+	capacity.Store(true)
+	q.Release()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed wait after release: got %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Error("Failed wait after release: waiter not woken")
+	}
+
+	if stats := q.GetStats(); stats.Depth != 0 {
+		t.Errorf("Failed queue depth after release: got %d expected 0", stats.Depth)
+	}
+}
+
+// Tests a request whose client goes away leaves the queue straight away, and is never given a backend.
+func TestRequestQueueCancelled(t *testing.T) {
+	q := newRequestQueue(config.QueueConfig{Size: 1, Timeout: time.Second * 5})
+
+	var capacity, acquired atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- q.Wait(ctx, func() bool {
+			if capacity.Load() {
+				acquired.Store(true)
+			}
+			return capacity.Load()
+		})
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != errQueueCancelled {
+			t.Errorf("Failed cancelled wait: got %v expected %v", err, errQueueCancelled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Failed cancelled wait: waiter still waiting after its context was cancelled")
+	}
+
+	// capacity freeing up afterwards doesnt go to the cancelled request
+	capacity.Store(true)
+	q.Release()
+	time.Sleep(time.Millisecond * 10)
+	if acquired.Load() {
+		t.Error("Failed cancelled wait: got a backend for the cancelled request expected none")
+	}
+
+	stats := q.GetStats()
+	if stats.Depth != 0 || stats.Cancelled != 1 || stats.TimedOut != 0 {
+		t.Errorf("Failed cancelled stats: got depth %d cancelled %d timed out %d expected 0, 1 and 0", stats.Depth, stats.Cancelled, stats.TimedOut)
+	}
+}
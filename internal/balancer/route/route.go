@@ -0,0 +1,192 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type regexRewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// A route built from a config.RouteConfig, with its regexes compiled.
+type Route struct {
+	pathPrefix string
+
+	stripPrefix string
+	addPrefix   string
+	pathRegex   []regexRewrite
+
+	requestHeaders  config.HeaderRewriteConfig
+	responseHeaders config.HeaderRewriteConfig
+
+	rewriteHost bool
+
+	timeouts config.RouteTimeoutConfig
+}
+
+// Builds a route from a config.RouteConfig.
+// Returns an error if any of the path regexes fail to compile.
+func NewRoute(cfg config.RouteConfig) (*Route, error) {
+	regexes := make([]regexRewrite, len(cfg.Rewrite.PathRegex))
+	for i, rc := range cfg.Rewrite.PathRegex {
+		re, err := regexp.Compile(rc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Error compiling path regex '%s' for route '%s': %s", rc.Match, cfg.PathPrefix, err.Error())
+		}
+
+		regexes[i] = regexRewrite{
+			match:   re,
+			replace: rc.Replace,
+		}
+	}
+
+	return &Route{
+		pathPrefix:      cfg.PathPrefix,
+		stripPrefix:     cfg.Rewrite.StripPrefix,
+		addPrefix:       cfg.Rewrite.AddPrefix,
+		pathRegex:       regexes,
+		requestHeaders:  cfg.Rewrite.RequestHeaders,
+		responseHeaders: cfg.Rewrite.ResponseHeaders,
+		rewriteHost:     cfg.Rewrite.RewriteHost,
+		timeouts:        cfg.Timeouts,
+	}, nil
+}
+
+func (rt *Route) GetPathPrefix() string {
+	return rt.pathPrefix
+}
+
+// Gets the timeouts of requests on the route, which override the server's.
+func (rt *Route) GetTimeouts() config.RouteTimeoutConfig {
+	return rt.timeouts
+}
+
+// Produces a copy of the request with the path and header rewrites applied.
+func (rt *Route) RewriteRequest(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+
+	r.URL.Path = rt.rewritePath(r.URL.Path)
+	// the raw path no longer matches, so let the url re-encode from Path
+	r.URL.RawPath = ""
+
+	applyHeaderRewrite(r.Header, rt.requestHeaders)
+
+	return r
+}
+
+func (rt *Route) rewritePath(path string) string {
+	if rt.stripPrefix != "" && HasPathPrefix(path, rt.stripPrefix) {
+		path = path[len(rt.stripPrefix):]
+	}
+
+	for _, rw := range rt.pathRegex {
+		path = rw.match.ReplaceAllString(path, rw.replace)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if rt.addPrefix != "" {
+		path = strings.TrimSuffix(rt.addPrefix, "/") + path
+	}
+
+	return path
+}
+
+// Sets the Host header of the request to the backend's host, if the route asks for it.
+func (rt *Route) RewriteHost(r *http.Request, backendURL *url.URL) {
+	if rt.rewriteHost {
+		r.Host = backendURL.Host
+	}
+}
+
+// Applies the response header rewrites.
+// Matches the signature of httputil.ReverseProxy.ModifyResponse.
+func (rt *Route) RewriteResponse(res *http.Response) error {
+	applyHeaderRewrite(res.Header, rt.responseHeaders)
+
+	return nil
+}
+
+func applyHeaderRewrite(h http.Header, cfg config.HeaderRewriteConfig) {
+	for _, name := range cfg.Remove {
+		h.Del(name)
+	}
+	for name, value := range cfg.Set {
+		h.Set(name, value)
+	}
+	for name, value := range cfg.Add {
+		h.Add(name, value)
+	}
+}
+
+// A set of routes, matched by longest path prefix.
+type Table struct {
+	routes []*Route
+}
+
+// Builds a route table from a list of config.RouteConfig's.
+func NewTable(cfgs []config.RouteConfig) (Table, error) {
+	routes := make([]*Route, len(cfgs))
+
+	for i, cfg := range cfgs {
+		rt, err := NewRoute(cfg)
+		if err != nil {
+			return Table{}, err
+		}
+		routes[i] = rt
+	}
+
+	return Table{
+		routes: routes,
+	}, nil
+}
+
+// Finds the route with the longest prefix matching the request path.
+// Returns nil if no route matches.
+func (t Table) Match(r *http.Request) *Route {
+	var best *Route = nil
+
+	for _, rt := range t.routes {
+		if !HasPathPrefix(r.URL.Path, rt.pathPrefix) {
+			continue
+		}
+
+		if best == nil || len(rt.pathPrefix) > len(best.pathPrefix) {
+			best = rt
+		}
+	}
+
+	return best
+}
+
+// Reports whether a path starts with a prefix made of whole segments,
+// so /api matches /api and /api/users but not /apiary.
+func HasPathPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+type contextKey struct{}
+
+// Attaches a route to a context, so it can be found again from the proxied request/response.
+func WithRoute(ctx context.Context, rt *Route) context.Context {
+	return context.WithValue(ctx, contextKey{}, rt)
+}
+
+// Gets the route attached to a context, or nil if there is none.
+func FromContext(ctx context.Context) *Route {
+	rt, _ := ctx.Value(contextKey{}).(*Route)
+	return rt
+}
@@ -0,0 +1,159 @@
+package route
+
+import (
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRouteTableMatch(t *testing.T) {
+	table, err := NewTable([]config.RouteConfig{
+		{PathPrefix: "/"},
+		{PathPrefix: "/api"},
+		{PathPrefix: "/api/v2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating table: %s", err.Error())
+	}
+
+	tests := map[string]string{
+		"/index.html":   "/",
+		"/api/users":    "/api",
+		"/api/v2/user":  "/api/v2",
+		"/api":          "/api",
+		"/apiary":       "/",
+		"/api-internal": "/",
+		"/api/v2x":      "/api",
+	}
+
+	for path, expected := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+
+		rt := table.Match(r)
+		if rt == nil || rt.GetPathPrefix() != expected {
+			t.Errorf("Failed match for '%s': got %v expected prefix '%s'", path, rt, expected)
+		}
+	}
+
+	empty, _ := NewTable(nil)
+	r, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	if rt := empty.Match(r); rt != nil {
+		t.Errorf("Failed match on empty table: got prefix '%s' expected nil", rt.GetPathPrefix())
+	}
+}
+
+func TestRouteRewritePath(t *testing.T) {
+	rt, err := NewRoute(config.RouteConfig{
+		PathPrefix: "/api",
+		Rewrite: config.RewriteConfig{
+			StripPrefix: "/api",
+			AddPrefix:   "/v1",
+			PathRegex: []config.RegexRewriteConfig{
+				{Match: "^/old/(.*)$", Replace: "/new/$1"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating route: %s", err.Error())
+	}
+
+	tests := map[string]string{
+		"/api/users":     "/v1/users",
+		"/api/old/thing": "/v1/new/thing",
+		"/api":           "/v1/",
+		"/apix/y":        "/v1/apix/y",
+	}
+
+	for path, expected := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+
+		rewritten := rt.RewriteRequest(r)
+		if rewritten.URL.Path != expected {
+			t.Errorf("Failed path rewrite for '%s': got '%s' expected '%s'", path, rewritten.URL.Path, expected)
+		}
+		if r.URL.Path != path {
+			t.Errorf("Failed rewrite modified original request: got '%s' expected '%s'", r.URL.Path, path)
+		}
+	}
+
+	_, err = NewRoute(config.RouteConfig{
+		Rewrite: config.RewriteConfig{
+			PathRegex: []config.RegexRewriteConfig{{Match: "("}},
+		},
+	})
+	if err == nil {
+		t.Error("Failed invalid regex: expected an error")
+	}
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	cases := []struct {
+		path     string
+		prefix   string
+		expected bool
+	}{
+		{"/api", "/api", true},
+		{"/api/", "/api", true},
+		{"/api/x", "/api", true},
+		{"/apix", "/api", false},
+		{"/apix/y", "/api", false},
+		{"/api-internal/x", "/api", false},
+		{"/api/x", "/api/", true},
+		{"/api", "/api/", false},
+		{"/anything", "/", true},
+		{"/other", "/api", false},
+	}
+
+	for _, c := range cases {
+		if got := HasPathPrefix(c.path, c.prefix); got != c.expected {
+			t.Errorf("Failed prefix '%s' of '%s': got %t expected %t", c.prefix, c.path, got, c.expected)
+		}
+	}
+}
+
+func TestRouteRewriteHeaders(t *testing.T) {
+	headers := config.HeaderRewriteConfig{
+		Add:    map[string]string{"X-Added": "a"},
+		Set:    map[string]string{"X-Set": "s"},
+		Remove: []string{"X-Removed"},
+	}
+
+	rt, _ := NewRoute(config.RouteConfig{
+		Rewrite: config.RewriteConfig{
+			RequestHeaders:  headers,
+			ResponseHeaders: headers,
+			RewriteHost:     true,
+		},
+	})
+
+	r, _ := http.NewRequest("GET", "http://localhost/", nil)
+	r.Header.Set("X-Added", "original")
+	r.Header.Set("X-Set", "original")
+	r.Header.Set("X-Removed", "original")
+
+	checkHeaders := func(name string, h http.Header) {
+		if v := h.Values("X-Added"); len(v) != 2 {
+			t.Errorf("Failed %s header add: got %v expected 2 values", name, v)
+		}
+		if v := h.Get("X-Set"); v != "s" {
+			t.Errorf("Failed %s header set: got '%s' expected 's'", name, v)
+		}
+		if v := h.Get("X-Removed"); v != "" {
+			t.Errorf("Failed %s header remove: got '%s' expected none", name, v)
+		}
+	}
+
+	rewritten := rt.RewriteRequest(r)
+	checkHeaders("request", rewritten.Header)
+
+	backendURL, _ := url.Parse("http://backend:8080")
+	rt.RewriteHost(rewritten, backendURL)
+	if rewritten.Host != "backend:8080" {
+		t.Errorf("Failed host rewrite: got '%s' expected 'backend:8080'", rewritten.Host)
+	}
+
+	res := &http.Response{Header: r.Header.Clone()}
+	rt.RewriteResponse(res)
+	checkHeaders("response", res.Header)
+}
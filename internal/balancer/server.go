@@ -0,0 +1,77 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// Creates the plain server requests are balanced from, with the timeouts the config describes.
+// Routes can override the read and write timeouts, see config.RouteTimeoutConfig.
+//
+// If h2c is enabled the server also speaks cleartext HTTP/2, to clients with prior knowledge
+// or which ask to upgrade to it.
+func NewServer(cfg config.ServerConfig, port int, handler http.Handler) *http.Server {
+	if cfg.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	return newServer(cfg, port, handler)
+}
+
+// Creates the HTTPS server requests are balanced from, which serves HTTP/2 to clients which support it.
+// Returns an error if the certificate couldnt be loaded.
+func NewTLSServer(cfg config.ServerConfig, handler http.Handler) (*http.Server, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Loading TLS certificate failed: %s", err.Error())
+	}
+
+	s := newServer(cfg, cfg.TLS.Port, handler)
+	s.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	// adds h2 to the protocols negotiated with clients
+	err = http2.ConfigureServer(s, &http2.Server{})
+	if err != nil {
+		return nil, fmt.Errorf("Configuring HTTP/2 failed: %s", err.Error())
+	}
+
+	return s, nil
+}
+
+func newServer(cfg config.ServerConfig, port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
+
+		ReadHeaderTimeout: serverTimeout(cfg.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       serverTimeout(cfg.ReadTimeout, 0),
+		WriteTimeout:      serverTimeout(cfg.WriteTimeout, 0),
+		IdleTimeout:       serverTimeout(cfg.IdleTimeout, defaultIdleTimeout),
+	}
+}
+
+// Gets a server timeout from the config, using the default for 0.
+// Negative timeouts mean none, which the server takes as 0.
+func serverTimeout(timeout time.Duration, defaultTimeout time.Duration) time.Duration {
+	switch {
+	case timeout == 0:
+		return defaultTimeout
+	case timeout < 0:
+		return 0
+	default:
+		return timeout
+	}
+}
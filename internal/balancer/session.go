@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionCookieName = "balancer_session"
+	defaultSessionCookieTTL  = time.Minute * 15
+	defaultSessionCookiePath = "/"
+)
+
+// Reads and writes signed session cookies, storing the ID of a client's backend.
+//
+// The cookie value is "{base64 backend id}.{unix expiry}.{base64 signature}",
+// where the signature is a HMAC-SHA256 of the id and expiry.
+// This stops clients forging a cookie to choose a backend, or extending a session past its TTL.
+type sessionCookies struct {
+	name   string
+	secret []byte
+	ttl    time.Duration
+
+	secure   bool
+	httpOnly bool
+	sameSite http.SameSite
+	path     string
+	domain   string
+}
+
+// Creates the session cookie handler from config, filling in defaults.
+// Returns an error if the config is invalid.
+func newSessionCookies(cfg config.SessionCookieConfig) (*sessionCookies, error) {
+	s := &sessionCookies{
+		name:     cfg.Name,
+		secret:   []byte(cfg.Secret),
+		ttl:      cfg.TTL,
+		secure:   cfg.Secure,
+		httpOnly: true,
+		path:     cfg.Path,
+		domain:   cfg.Domain,
+	}
+
+	if s.name == "" {
+		s.name = defaultSessionCookieName
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultSessionCookieTTL
+	}
+	if s.path == "" {
+		s.path = defaultSessionCookiePath
+	}
+	if cfg.HttpOnly != nil {
+		s.httpOnly = *cfg.HttpOnly
+	}
+
+	switch strings.ToLower(cfg.SameSite) {
+	case "", "lax":
+		s.sameSite = http.SameSiteLaxMode
+	case "strict":
+		s.sameSite = http.SameSiteStrictMode
+	case "none":
+		s.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("Unrecognized session cookie sameSite '%s'.", cfg.SameSite)
+	}
+
+	if len(s.secret) == 0 {
+		fmt.Println("No session cookie secret configured, generating a random one. Sessions will not survive restarts.")
+
+		s.secret = make([]byte, 32)
+		if _, err := rand.Read(s.secret); err != nil {
+			return nil, fmt.Errorf("Error generating session cookie secret: %s", err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+func (s *sessionCookies) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Gets the backend ID from a request's session cookie.
+// Returns false if there is no cookie, or it is invalid or expired.
+func (s *sessionCookies) Lookup(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(s.name)
+	if err != nil {
+		return "", false
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		fmt.Println("Rejected session cookie with invalid signature.")
+		return "", false
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", false
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+
+	return string(id), true
+}
+
+// Sets a session cookie for a backend in the response, replacing any set previously.
+// Must be called before the response is written.
+func (s *sessionCookies) Save(w http.ResponseWriter, r *http.Request, backendID string) {
+	expiry := time.Now().Add(s.ttl)
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(backendID)) + "." + strconv.FormatInt(expiry.Unix(), 10)
+
+	s.write(w, &http.Cookie{
+		Value:   payload + "." + s.sign(payload),
+		Expires: expiry,
+		MaxAge:  int(s.ttl.Seconds()),
+	})
+}
+
+// Sets a cookie in the response deleting the client's session.
+func (s *sessionCookies) Forget(w http.ResponseWriter, r *http.Request) {
+	s.write(w, &http.Cookie{
+		MaxAge: -1,
+	})
+}
+
+// Fills in the cookie attributes and sets it, removing any session cookie set earlier (e.g. by a failed attempt).
+func (s *sessionCookies) write(w http.ResponseWriter, cookie *http.Cookie) {
+	cookie.Name = s.name
+	cookie.Path = s.path
+	cookie.Domain = s.domain
+	cookie.Secure = s.secure
+	cookie.HttpOnly = s.httpOnly
+	cookie.SameSite = s.sameSite
+
+	headers := w.Header()
+	existing := headers.Values("Set-Cookie")
+	headers.Del("Set-Cookie")
+	for _, c := range existing {
+		if !strings.HasPrefix(c, s.name+"=") {
+			headers.Add("Set-Cookie", c)
+		}
+	}
+
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,126 @@
+package balancer
+
+import (
+	"encoding/base64"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sends the cookies set in a recorded response back in a new request.
+func requestWithResponseCookies(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "http://localhost/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionCookieRoundTrip(t *testing.T) {
+	s, err := newSessionCookies(config.SessionCookieConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Failed creating session cookies: %s", err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	s.Save(w, nil, "first:80")
+	s.Save(w, nil, "localhost:8080")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Failed replace earlier session cookie: got %d cookies expected 1", len(cookies))
+	}
+
+	c := cookies[0]
+	if c.Name != "balancer_session" || c.Path != "/" || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Failed default cookie attributes: got %+v", c)
+	}
+
+	id, ok := s.Lookup(requestWithResponseCookies(w))
+	if !ok || id != "localhost:8080" {
+		t.Errorf("Failed get session: got '%s' %t expected 'localhost:8080'", id, ok)
+	}
+
+	// another instance with the same secret should accept it
+	other, _ := newSessionCookies(config.SessionCookieConfig{Secret: "secret"})
+	if id, ok := other.Lookup(requestWithResponseCookies(w)); !ok || id != "localhost:8080" {
+		t.Errorf("Failed get session with same secret: got '%s' %t expected 'localhost:8080'", id, ok)
+	}
+
+	// but not one with a different secret
+	different, _ := newSessionCookies(config.SessionCookieConfig{Secret: "other"})
+	if _, ok := different.Lookup(requestWithResponseCookies(w)); ok {
+		t.Error("Failed reject session signed with different secret")
+	}
+}
+
+func TestSessionCookieRejectsInvalid(t *testing.T) {
+	s, _ := newSessionCookies(config.SessionCookieConfig{Secret: "secret"})
+
+	encodedID := base64.RawURLEncoding.EncodeToString([]byte("localhost:8080"))
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	forgedID := base64.RawURLEncoding.EncodeToString([]byte("other:8080"))
+	validSignature := s.sign(encodedID + "." + future)
+
+	values := map[string]string{
+		"unsigned index":  "0",
+		"forged backend":  forgedID + "." + future + "." + validSignature,
+		"extended expiry": encodedID + "." + strconv.FormatInt(time.Now().Add(time.Hour*2).Unix(), 10) + "." + validSignature,
+		"expired":         encodedID + "." + past + "." + s.sign(encodedID+"."+past),
+	}
+
+	for name, value := range values {
+		r := httptest.NewRequest("GET", "http://localhost/", nil)
+		r.AddCookie(&http.Cookie{Name: "balancer_session", Value: value})
+
+		if id, ok := s.Lookup(r); ok {
+			t.Errorf("Failed reject %s cookie: got '%s'", name, id)
+		}
+	}
+}
+
+func TestSessionCookieConfig(t *testing.T) {
+	httpOnly := false
+	s, err := newSessionCookies(config.SessionCookieConfig{
+		Name:     "sticky",
+		Secret:   "secret",
+		TTL:      time.Minute,
+		Secure:   true,
+		HttpOnly: &httpOnly,
+		SameSite: "strict",
+		Path:     "/app",
+		Domain:   "example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed creating session cookies: %s", err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	s.Save(w, nil, "localhost:8080")
+
+	header := w.Header().Get("Set-Cookie")
+	for _, attr := range []string{"sticky=", "Path=/app", "Domain=example.com", "Max-Age=60", "Secure", "SameSite=Strict"} {
+		if !strings.Contains(header, attr) {
+			t.Errorf("Failed cookie attribute '%s': got '%s'", attr, header)
+		}
+	}
+	if strings.Contains(header, "HttpOnly") {
+		t.Errorf("Failed disable HttpOnly: got '%s'", header)
+	}
+
+	w = httptest.NewRecorder()
+	s.Forget(w, nil)
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge != -1 {
+		t.Errorf("Failed clear session: got %+v", c)
+	}
+
+	if _, err := newSessionCookies(config.SessionCookieConfig{SameSite: "sometimes"}); err == nil {
+		t.Error("Failed invalid sameSite: expected an error")
+	}
+}
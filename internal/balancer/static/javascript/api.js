@@ -0,0 +1,81 @@
+// The modification API, served from the same address as the dashboard.
+const API_URL = "/api/v1"
+
+// The admin token, if the balancer needs one. Kept for the browser session only.
+function apiToken() {
+    return sessionStorage.getItem("gobal-token") || ""
+}
+
+// Asks for the admin token after a request was refused without it, returning whether one was given.
+function askForToken() {
+    const token = prompt("The balancer needs its admin token:")
+    if (token == null || token.trim() == "") {
+        return false
+    }
+    sessionStorage.setItem("gobal-token", token.trim())
+    return true
+}
+
+// Sends a request to the API, with a JSON body unless body is null.
+// Calls onSuccess with the parsed response (null if it has none), or onError with a message explaining the failure.
+// If the request is refused for lacking the admin token, the token is asked for and the request retried.
+function apiRequest(method, path, body, onSuccess, onError) {
+    try {
+        var xmlHttp = new XMLHttpRequest();
+        xmlHttp.onerror = function() {
+            onError("Could not reach the balancer.")
+        }
+        xmlHttp.onreadystatechange = function() {
+            if (xmlHttp.readyState != 4 || xmlHttp.status == 0) {
+                return
+            }
+            if (xmlHttp.status == 401 && askForToken()) {
+                apiRequest(method, path, body, onSuccess, onError)
+                return
+            }
+            if (xmlHttp.status >= 200 && xmlHttp.status < 300) {
+                onSuccess(parseResponse(xmlHttp.responseText))
+            } else {
+                // the server explains why the request was rejected
+                onError(errorMessage(xmlHttp.responseText) || `Request failed with status ${xmlHttp.status}.`)
+            }
+        }
+        xmlHttp.open(method, API_URL+path, true);
+        xmlHttp.setRequestHeader("X-Actor", "dashboard")
+        if (apiToken() != "") {
+            xmlHttp.setRequestHeader("Authorization", "Bearer "+apiToken())
+        }
+        if (body != null) {
+            xmlHttp.setRequestHeader("Content-Type", "application/json")
+        }
+        xmlHttp.send(body != null ? JSON.stringify(body) : null);
+    } catch (e) {
+        onError(`Error while making request: ${e}`)
+    }
+}
+
+function parseResponse(resp) {
+    if (!resp) {
+        return null
+    }
+
+    try {
+        return JSON.parse(resp)
+    } catch {
+        return null
+    }
+}
+
+// Gets the message from an API error response, falling back to the raw text.
+function errorMessage(resp) {
+    try {
+        return JSON.parse(resp).error.message
+    } catch {
+        return resp
+    }
+}
+
+// Shows a message in an element, or clears it if the message is empty.
+function showError(elem, message) {
+    elem.textContent = message || ""
+}
@@ -0,0 +1,154 @@
+// The number of seconds of stats charted.
+const CHART_HISTORY = 60
+
+const CHART_COLORS = ["#03DAC6", "#FFB74D", "#81D4FA", "#F06292", "#AED581", "#FFF176", "#CE93D8", "#FF8A65"]
+
+// The charts drawn, each of one value from the backends' traffic in the stats events.
+const CHARTS = [
+    { canvas: "chart-requests", key: "requestsPerSecond", title: "Requests/s", scale: 1, unit: "" },
+    { canvas: "chart-latency", key: "averageLatencyMs", title: "Average latency", scale: 1, unit: "ms" },
+    { canvas: "chart-errors", key: "errorRate", title: "Error rate", scale: 100, unit: "%" },
+]
+
+// The recent traffic of each backend by id, as lists of values for each chart, oldest first.
+chartHistory = {}
+// Colors are kept for each backend id, so they dont change as others are added or removed.
+chartColors = {}
+nextChartColor = 0
+
+// Records a stats event and redraws the charts.
+function RecordStats(stats) {
+    const current = {}
+
+    stats.backends.forEach(traffic => {
+        current[traffic.id] = true
+
+        if (!(traffic.id in chartHistory)) {
+            chartHistory[traffic.id] = {}
+            CHARTS.forEach(chart => chartHistory[traffic.id][chart.key] = [])
+        }
+        if (!(traffic.id in chartColors)) {
+            chartColors[traffic.id] = CHART_COLORS[nextChartColor++ % CHART_COLORS.length]
+        }
+
+        CHARTS.forEach(chart => {
+            const values = chartHistory[traffic.id][chart.key]
+            values.push(traffic[chart.key] * chart.scale)
+            if (values.length > CHART_HISTORY) {
+                values.shift()
+            }
+        })
+    })
+
+    // forget removed backends
+    Object.keys(chartHistory).forEach(id => {
+        if (!current[id]) {
+            delete chartHistory[id]
+        }
+    })
+
+    CHARTS.forEach(drawChart)
+    constructLegend()
+}
+
+function drawChart(chart) {
+    const canvas = document.getElementById(chart.canvas)
+    const ctx = canvas.getContext("2d")
+    const width = canvas.width
+    const height = canvas.height
+
+    // leave space for the title at the top
+    const plotTop = 20
+    const plotHeight = height - plotTop - 4
+
+    ctx.clearRect(0, 0, width, height)
+    ctx.fillStyle = "#000000"
+    ctx.fillRect(0, 0, width, height)
+
+    let max = 0
+    Object.values(chartHistory).forEach(history => {
+        history[chart.key].forEach(v => max = Math.max(max, v))
+    })
+    // round the top of the scale up, so small values arent shown as huge
+    max = max > 0 ? niceCeil(max) : 1
+
+    ctx.strokeStyle = "#333333"
+    ctx.beginPath()
+    for (let i = 0; i <= 4; i++) {
+        const y = plotTop + plotHeight * i / 4
+        ctx.moveTo(0, y)
+        ctx.lineTo(width, y)
+    }
+    ctx.stroke()
+
+    ctx.fillStyle = "#FFFFFF"
+    ctx.font = "12px sans-serif"
+    ctx.textBaseline = "top"
+    ctx.fillText(chart.title, 4, 4)
+    ctx.textAlign = "right"
+    ctx.fillText(`${formatChartValue(max)}${chart.unit}`, width - 4, 4)
+    ctx.textAlign = "left"
+
+    const step = width / (CHART_HISTORY - 1)
+    Object.keys(chartHistory).forEach(id => {
+        const values = chartHistory[id][chart.key]
+
+        // the newest value is at the right edge
+        const offset = CHART_HISTORY - values.length
+
+        ctx.strokeStyle = chartColors[id]
+        ctx.lineWidth = 2
+        ctx.beginPath()
+        values.forEach((v, i) => {
+            const x = (offset + i) * step
+            const y = plotTop + plotHeight * (1 - v / max)
+            if (i == 0) {
+                ctx.moveTo(x, y)
+            } else {
+                ctx.lineTo(x, y)
+            }
+        })
+        ctx.stroke()
+        ctx.lineWidth = 1
+    })
+}
+
+// Rounds a value up to 1, 2 or 5 times a power of ten.
+function niceCeil(value) {
+    const magnitude = Math.pow(10, Math.floor(Math.log10(value)))
+    for (const m of [1, 2, 5, 10]) {
+        if (value <= m * magnitude) {
+            return m * magnitude
+        }
+    }
+    return 10 * magnitude
+}
+
+function formatChartValue(value) {
+    return value >= 10 ? value.toFixed(0) : value.toFixed(1)
+}
+
+function constructLegend() {
+    const legendElem = document.querySelector("#chart-legend")
+    legendElem.replaceChildren()
+
+    Object.keys(chartHistory).forEach(id => {
+        let name = id
+        backends.forEach(e => {
+            if (e.id == id && e.name) {
+                name = e.name
+            }
+        })
+
+        const item = document.createElement("span")
+        item.className = "legend-item"
+
+        const swatch = document.createElement("span")
+        swatch.className = "legend-swatch"
+        swatch.style.backgroundColor = chartColors[id]
+
+        item.appendChild(swatch)
+        item.appendChild(document.createTextNode(name))
+        legendElem.appendChild(item)
+    })
+}
@@ -0,0 +1,191 @@
+// The table rows of each backend by id, so rows are updated in place rather than rebuilt,
+// which would lose weights being typed in.
+backendRows = {}
+
+// Renders the backends into the table.
+// Every value is set as text, so names and hosts can never inject html.
+function RenderBackends(backendData) {
+    const tableElem = document.querySelector("#backend-table-data")
+    const seen = {}
+
+    backendData.forEach((e, index) => {
+        seen[e.id] = true
+
+        if (!(e.id in backendRows)) {
+            backendRows[e.id] = createBackendRow(e.id)
+        }
+        const row = backendRows[e.id]
+        updateBackendRow(row, e)
+
+        // only move rows which are out of order, as moving a row loses the focus of its inputs
+        if (tableElem.children[index] !== row.elem) {
+            tableElem.insertBefore(row.elem, tableElem.children[index] || null)
+        }
+    })
+
+    Object.keys(backendRows).forEach(id => {
+        if (!seen[id]) {
+            backendRows[id].elem.remove()
+            delete backendRows[id]
+        }
+    })
+}
+
+function createBackendRow(id) {
+    const row = { elem: document.createElement("tr") }
+
+    const cell = function(className) {
+        const td = document.createElement("td")
+        if (className) {
+            td.className = className
+        }
+        row.elem.appendChild(td)
+        return td
+    }
+
+    row.name = cell()
+    row.host = cell()
+    row.port = cell()
+
+    const weightCell = cell("weight-cell")
+    const weightForm = document.createElement("form")
+    row.weight = document.createElement("input")
+    row.weight.type = "number"
+    row.weight.min = "0"
+    row.weight.required = true
+    const setWeight = document.createElement("input")
+    setWeight.type = "submit"
+    setWeight.value = "Set"
+    weightForm.appendChild(row.weight)
+    weightForm.appendChild(setWeight)
+    weightForm.addEventListener("submit", function(event) {
+        event.preventDefault()
+        SetBackendWeight(id, parseInt(row.weight.value))
+        row.weight.blur()
+    })
+    weightCell.appendChild(weightForm)
+
+    row.status = cell()
+    row.connections = cell()
+    row.circuit = cell()
+
+    const actions = cell("noborder actions")
+    row.drain = document.createElement("button")
+    row.drain.addEventListener("click", function() {
+        SetBackendDraining(id, !row.draining)
+    })
+    const remove = document.createElement("button")
+    remove.textContent = "Delete"
+    remove.addEventListener("click", function() {
+        if (confirm(`Remove backend ${id}?`)) {
+            DeleteBackend(id)
+        }
+    })
+    actions.appendChild(row.drain)
+    actions.appendChild(remove)
+
+    return row
+}
+
+function updateBackendRow(row, e) {
+    row.name.textContent = e.name || e.id
+    row.host.textContent = e.host
+    row.port.textContent = e.port
+
+    // dont replace a weight being edited
+    if (document.activeElement !== row.weight) {
+        row.weight.value = e.weight
+    }
+
+    if (!e.alive) {
+        row.status.className = "dead"
+        row.status.textContent = "DEAD"
+    } else if (e.draining) {
+        row.status.className = "draining"
+        row.status.textContent = "DRAINING"
+    } else {
+        row.status.className = "alive"
+        row.status.textContent = "ALIVE"
+    }
+
+    row.connections.textContent = `${e.connections}${e.maxConnections > 0 ? "/" + e.maxConnections : ""}`
+    if (e.upgradedConnections > 0) {
+        row.connections.textContent += ` (+${e.upgradedConnections} upgraded)`
+    }
+
+    row.circuit.className = `circuit-${e.circuit}`
+    row.circuit.textContent = String(e.circuit).toUpperCase()
+
+    row.draining = e.draining
+    row.drain.textContent = e.draining ? "Enable" : "Drain"
+}
+
+function RenderQueueStats(queue) {
+    const queueElem = document.querySelector("#queue-stats")
+
+    if (queue == null) {
+        queueElem.textContent = ""
+        return
+    }
+
+    queueElem.textContent = `Queued requests: ${queue.depth}/${queue.maxDepth} | Average wait: ${queue.averageWaitMs}ms | Rejected: ${queue.rejected} | Timed out: ${queue.timedOut} | Cancelled: ${queue.cancelled}`
+}
+
+// Changes made here are shown by the event stream, so responses are only checked for errors.
+
+function backendPath(id) {
+    return "/backends/"+encodeURIComponent(id)
+}
+
+function showBackendError(message) {
+    showError(document.querySelector("#backend-error"), message)
+}
+
+function SetBackendWeight(id, weight) {
+    if (isNaN(weight) || weight < 0) {
+        showBackendError(`Invalid weight for ${id}.`)
+        return
+    }
+
+    apiRequest("PATCH", backendPath(id), { weight: weight }, () => showBackendError(""), showBackendError)
+}
+
+function SetBackendDraining(id, drain) {
+    apiRequest("PATCH", backendPath(id), { drain: drain }, () => showBackendError(""), showBackendError)
+}
+
+function DeleteBackend(id) {
+    apiRequest("DELETE", backendPath(id), null, () => showBackendError(""), showBackendError)
+}
+
+function handleAddBackendForm(event) {
+    event.preventDefault()
+
+    const form = event.target
+    const errorElem = document.querySelector("#add-backend-error")
+
+    const backend = {
+        host: form.elements["host"].value.trim(),
+        port: parseInt(form.elements["port"].value),
+    }
+    if (form.elements["name"].value.trim() != "") {
+        backend.name = form.elements["name"].value.trim()
+    }
+    if (form.elements["weight"].value != "") {
+        backend.weight = parseInt(form.elements["weight"].value)
+    }
+
+    if (backend.host == "" || isNaN(backend.port)) {
+        showError(errorElem, "A hostname and port are needed.")
+        return
+    }
+
+    apiRequest("POST", "/backends", backend, function() {
+        showError(errorElem, "")
+        form.reset()
+    }, message => showError(errorElem, message))
+}
+
+document.addEventListener("DOMContentLoaded", function() {
+    document.getElementById("addbackendform").addEventListener("submit", handleAddBackendForm)
+})
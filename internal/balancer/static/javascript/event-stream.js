@@ -0,0 +1,99 @@
+// How long to wait before reconnecting once the browser gives up on the stream, doubling up to the max.
+const MIN_RECONNECT_DELAY = 1000
+const MAX_RECONNECT_DELAY = 30000
+
+eventSource = null
+reconnectDelay = MIN_RECONNECT_DELAY
+
+// The current backends, as sent by the stream.
+backends = []
+
+// Connects to the balancer's event stream, which sends the current state and then every change.
+// The browser reconnects by itself after most errors, the rest are retried here with a backoff.
+function ConnectEvents() {
+    if (eventSource != null) {
+        eventSource.close()
+    }
+
+    setConnectionStatus("connecting", "Connecting...")
+
+    // browsers cant send headers with an event stream, so the token goes in the url
+    const token = apiToken()
+    eventSource = new EventSource(API_URL+"/events"+(token != "" ? "?token="+encodeURIComponent(token) : ""))
+
+    eventSource.onopen = function() {
+        reconnectDelay = MIN_RECONNECT_DELAY
+        setConnectionStatus("live", "Live")
+    }
+    eventSource.onerror = function() {
+        if (eventSource.readyState != EventSource.CLOSED) {
+            setConnectionStatus("connecting", "Reconnecting...")
+            return
+        }
+
+        // closed for good, e.g. by an error response, so the browser wont retry
+        setConnectionStatus("disconnected", `Disconnected, retrying in ${reconnectDelay/1000}s`)
+        setTimeout(reconnectEvents, reconnectDelay)
+        reconnectDelay = Math.min(reconnectDelay*2, MAX_RECONNECT_DELAY)
+    }
+
+    onEvent("backends", function(data) {
+        backends = data.backends
+        RenderBackends(backends)
+    })
+    onEvent("health", function(data) {
+        backends.forEach(e => {
+            if (e.id == data.id) {
+                e.alive = data.alive
+            }
+        })
+        RenderBackends(backends)
+    })
+    onEvent("strategy", function(data) {
+        ShowStrategy(data.strategy)
+    })
+    onEvent("stats", function(data) {
+        RenderQueueStats(data.queue)
+
+        // keep the connection counts in the table live too
+        data.backends.forEach(traffic => {
+            backends.forEach(e => {
+                if (e.id == traffic.id) {
+                    e.connections = traffic.connections
+                    e.upgradedConnections = traffic.upgradedConnections
+                }
+            })
+        })
+        RenderBackends(backends)
+
+        RecordStats(data)
+    })
+}
+
+// Reconnects once the API can be reached, which also asks for the admin token if it was refused.
+function reconnectEvents() {
+    apiRequest("GET", "/strategy", null, ConnectEvents, function(message) {
+        setConnectionStatus("disconnected", `Disconnected, retrying in ${reconnectDelay/1000}s: ${message}`)
+        setTimeout(reconnectEvents, reconnectDelay)
+        reconnectDelay = Math.min(reconnectDelay*2, MAX_RECONNECT_DELAY)
+    })
+}
+
+// Handles an event type from the stream, parsing its json data.
+function onEvent(type, handle) {
+    eventSource.addEventListener(type, function(e) {
+        try {
+            handle(JSON.parse(e.data))
+        } catch (err) {
+            console.log(`Invalid ${type} event: ${err}`)
+        }
+    })
+}
+
+function setConnectionStatus(state, text) {
+    const statusElem = document.querySelector("#connection-status")
+    statusElem.className = `connection-${state}`
+    statusElem.textContent = text
+}
+
+document.addEventListener("DOMContentLoaded", reconnectEvents)
@@ -0,0 +1,49 @@
+const strategyFormElem = document.getElementById("strategyform")
+const strategyNameElem = document.getElementById("strategy-name")
+const strategyPropertiesElem = document.getElementById("strategy-properties")
+const strategyErrorElem = document.getElementById("strategy-error")
+const currentStrategyElem = document.getElementById("current-strategy")
+
+// Set while the form has changes which havent been submitted, so they arent replaced by the stream.
+strategyEdited = false
+
+// Shows the current strategy, filling in the form unless it is being edited.
+function ShowStrategy(strategy) {
+    currentStrategyElem.textContent = `Current: ${strategy.name}`
+
+    if (strategyEdited) {
+        return
+    }
+    strategyNameElem.value = strategy.name
+    strategyPropertiesElem.value = strategy.properties ? JSON.stringify(strategy.properties, null, 2) : ""
+}
+
+function ChangeStrategy(name, properties) {
+    apiRequest("PUT", "/strategy", { name: name, properties: properties }, function(strategy) {
+        showError(strategyErrorElem, "")
+        strategyEdited = false
+        ShowStrategy(strategy)
+    }, message => showError(strategyErrorElem, message))
+}
+
+function handleStrategyForm(event) {
+    event.preventDefault()
+
+    let properties = null
+    const text = strategyPropertiesElem.value.trim()
+    if (text != "") {
+        try {
+            properties = JSON.parse(text)
+        } catch (e) {
+            showError(strategyErrorElem, `Invalid properties JSON: ${e.message}`)
+            return
+        }
+    }
+
+    ChangeStrategy(strategyNameElem.value, properties)
+}
+
+strategyFormElem.addEventListener('submit', handleStrategyForm)
+strategyFormElem.addEventListener('input', function() {
+    strategyEdited = true
+})
@@ -0,0 +1,227 @@
+package balancer
+
+import (
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultAffinityTTL        = time.Minute * 15
+	defaultAffinityMaxEntries = 100000
+)
+
+// Remembers which backend a client's requests should be sent to.
+type stickySessions interface {
+	// Gets the ID of the backend the client's session is with.
+	// Returns false if the client has no session.
+	Lookup(r *http.Request) (string, bool)
+	// Saves the client's session with a backend.
+	// Called before the request is served, so headers can still be set.
+	Save(w http.ResponseWriter, r *http.Request, backendID string)
+	// Forgets the client's session, as it could not be served.
+	Forget(w http.ResponseWriter, r *http.Request)
+}
+
+// Sticky sessions can also implement this to see backend responses.
+type stickySessionsResponseObserver interface {
+	// Called with each backend response, and the ID of the backend that sent it.
+	ObserveResponse(res *http.Response, backendID string)
+}
+
+// Creates sticky sessions of the mode described by the config.
+// Returns nil if sticky sessions are not enabled, or an error if the config is invalid.
+func newStickySessions(cfg config.StickyConfig) (stickySessions, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultAffinityTTL
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultAffinityMaxEntries
+	}
+
+	switch cfg.Mode {
+	case "", "cookie":
+		return newSessionCookies(cfg.Cookie)
+	case "sourceIP":
+		return newSourceIPAffinity(cfg.SourceIP, newAffinityTable(ttl, maxEntries))
+	case "header":
+		if cfg.Header == "" {
+			return nil, fmt.Errorf("Sticky sessions in header mode are missing a header name.")
+		}
+		return &headerAffinity{
+			header: cfg.Header,
+			table:  newAffinityTable(ttl, maxEntries),
+		}, nil
+	case "appCookie":
+		if cfg.AppCookie == "" {
+			return nil, fmt.Errorf("Sticky sessions in appCookie mode are missing a cookie name.")
+		}
+		return &appCookieAffinity{
+			cookieName: cfg.AppCookie,
+			table:      newAffinityTable(ttl, maxEntries),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized sticky session mode '%s'.", cfg.Mode)
+}
+
+// Sends clients from the same network to the same backend.
+type sourceIPAffinity struct {
+	ipv4Mask net.IPMask
+	ipv6Mask net.IPMask
+
+	table *affinityTable
+}
+
+func newSourceIPAffinity(cfg config.SourceIPStickyConfig, table *affinityTable) (*sourceIPAffinity, error) {
+	ipv4Bits := cfg.IPv4Mask
+	if ipv4Bits == 0 {
+		ipv4Bits = 32
+	}
+	ipv6Bits := cfg.IPv6Mask
+	if ipv6Bits == 0 {
+		ipv6Bits = 128
+	}
+
+	if ipv4Bits < 0 || ipv4Bits > 32 || ipv6Bits < 0 || ipv6Bits > 128 {
+		return nil, fmt.Errorf("Invalid source IP mask /%d or /%d.", ipv4Bits, ipv6Bits)
+	}
+
+	return &sourceIPAffinity{
+		ipv4Mask: net.CIDRMask(ipv4Bits, 32),
+		ipv6Mask: net.CIDRMask(ipv6Bits, 128),
+		table:    table,
+	}, nil
+}
+
+// Gets the masked network of the client, or false if its address cannot be parsed.
+func (s *sourceIPAffinity) key(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(s.ipv4Mask).String(), true
+	}
+	return ip.Mask(s.ipv6Mask).String(), true
+}
+
+func (s *sourceIPAffinity) Lookup(r *http.Request) (string, bool) {
+	key, ok := s.key(r)
+	if !ok {
+		return "", false
+	}
+	return s.table.Get(key)
+}
+
+func (s *sourceIPAffinity) Save(w http.ResponseWriter, r *http.Request, backendID string) {
+	if key, ok := s.key(r); ok {
+		s.table.Set(key, backendID)
+	}
+}
+
+func (s *sourceIPAffinity) Forget(w http.ResponseWriter, r *http.Request) {
+	if key, ok := s.key(r); ok {
+		s.table.Delete(key)
+	}
+}
+
+// Sends requests with the same value of a header to the same backend.
+// Requests without the header are not sticky.
+type headerAffinity struct {
+	header string
+
+	table *affinityTable
+}
+
+func (h *headerAffinity) Lookup(r *http.Request) (string, bool) {
+	value := r.Header.Get(h.header)
+	if value == "" {
+		return "", false
+	}
+	return h.table.Get(value)
+}
+
+func (h *headerAffinity) Save(w http.ResponseWriter, r *http.Request, backendID string) {
+	if value := r.Header.Get(h.header); value != "" {
+		h.table.Set(value, backendID)
+	}
+}
+
+func (h *headerAffinity) Forget(w http.ResponseWriter, r *http.Request) {
+	if value := r.Header.Get(h.header); value != "" {
+		h.table.Delete(value)
+	}
+}
+
+// Learns which backend issued a session cookie of the application's (e.g. JSESSIONID),
+// and sends requests carrying that cookie back to it.
+type appCookieAffinity struct {
+	cookieName string
+
+	table *affinityTable
+}
+
+func (a *appCookieAffinity) value(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(a.cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+func (a *appCookieAffinity) Lookup(r *http.Request) (string, bool) {
+	value, ok := a.value(r)
+	if !ok {
+		return "", false
+	}
+	return a.table.Get(value)
+}
+
+// Refreshes the session if the client already has the cookie.
+// New sessions are learnt from the response instead.
+func (a *appCookieAffinity) Save(w http.ResponseWriter, r *http.Request, backendID string) {
+	if value, ok := a.value(r); ok {
+		a.table.Set(value, backendID)
+	}
+}
+
+func (a *appCookieAffinity) Forget(w http.ResponseWriter, r *http.Request) {
+	if value, ok := a.value(r); ok {
+		a.table.Delete(value)
+	}
+}
+
+// Learns sessions from the backend setting the cookie, and forgets them when it deletes it.
+func (a *appCookieAffinity) ObserveResponse(res *http.Response, backendID string) {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name != a.cookieName {
+			continue
+		}
+
+		if cookie.Value == "" || cookie.MaxAge < 0 {
+			if res.Request != nil {
+				if value, ok := a.value(res.Request); ok {
+					a.table.Delete(value)
+				}
+			}
+			continue
+		}
+
+		a.table.Set(cookie.Value, backendID)
+	}
+}
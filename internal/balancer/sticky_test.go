@@ -0,0 +1,144 @@
+package balancer
+
+import (
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRequestFrom(remoteAddr string) *http.Request {
+	r := httptest.NewRequest("GET", "http://localhost/", nil)
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestStickySourceIP(t *testing.T) {
+	sticky, err := newStickySessions(config.StickyConfig{
+		Enabled: true,
+		Mode:    "sourceIP",
+		SourceIP: config.SourceIPStickyConfig{
+			IPv4Mask: 24,
+			IPv6Mask: 64,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating sticky sessions: %s", err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	sticky.Save(w, newRequestFrom("10.0.0.1:5000"), "a:80")
+	sticky.Save(w, newRequestFrom("[2001:db8::1]:5000"), "b:80")
+
+	tests := map[string]string{
+		"10.0.0.200:6000":        "a:80",
+		"[2001:db8::ffff]:6000":  "b:80",
+		"10.0.1.1:5000":          "",
+		"[2001:db8:0:1::1]:5000": "",
+	}
+
+	for addr, expected := range tests {
+		id, ok := sticky.Lookup(newRequestFrom(addr))
+		if id != expected || ok != (expected != "") {
+			t.Errorf("Failed source ip lookup for %s: got '%s' %t expected '%s'", addr, id, ok, expected)
+		}
+	}
+
+	sticky.Forget(w, newRequestFrom("10.0.0.2:5000"))
+	if _, ok := sticky.Lookup(newRequestFrom("10.0.0.1:5000")); ok {
+		t.Error("Failed forget source ip network")
+	}
+
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Failed source ip mode: cookies were set")
+	}
+
+	_, err = newStickySessions(config.StickyConfig{
+		Enabled:  true,
+		Mode:     "sourceIP",
+		SourceIP: config.SourceIPStickyConfig{IPv4Mask: 33},
+	})
+	if err == nil {
+		t.Error("Failed invalid mask: expected an error")
+	}
+}
+
+func TestStickyHeader(t *testing.T) {
+	sticky, _ := newStickySessions(config.StickyConfig{
+		Enabled: true,
+		Mode:    "header",
+		Header:  "X-Api-Key",
+	})
+
+	r := httptest.NewRequest("GET", "http://localhost/", nil)
+	r.Header.Set("X-Api-Key", "key")
+
+	sticky.Save(httptest.NewRecorder(), r, "a:80")
+	if id, ok := sticky.Lookup(r); !ok || id != "a:80" {
+		t.Errorf("Failed header lookup: got '%s' %t expected 'a:80'", id, ok)
+	}
+
+	noHeader := httptest.NewRequest("GET", "http://localhost/", nil)
+	sticky.Save(httptest.NewRecorder(), noHeader, "b:80")
+	if id, ok := sticky.Lookup(noHeader); ok {
+		t.Errorf("Failed header lookup without header: got '%s'", id)
+	}
+
+	if _, err := newStickySessions(config.StickyConfig{Enabled: true, Mode: "header"}); err == nil {
+		t.Error("Failed missing header name: expected an error")
+	}
+}
+
+func TestStickyAppCookie(t *testing.T) {
+	sticky, _ := newStickySessions(config.StickyConfig{
+		Enabled:   true,
+		Mode:      "appCookie",
+		AppCookie: "JSESSIONID",
+	})
+	observer := sticky.(stickySessionsResponseObserver)
+
+	// the backend starts a session
+	first := httptest.NewRequest("GET", "http://localhost/", nil)
+	res := &http.Response{Header: http.Header{}, Request: first}
+	res.Header.Add("Set-Cookie", "JSESSIONID=abc123; Path=/")
+	observer.ObserveResponse(res, "a:80")
+
+	// the client comes back with it
+	next := httptest.NewRequest("GET", "http://localhost/", nil)
+	next.AddCookie(&http.Cookie{Name: "JSESSIONID", Value: "abc123"})
+
+	if id, ok := sticky.Lookup(next); !ok || id != "a:80" {
+		t.Errorf("Failed app cookie lookup: got '%s' %t expected 'a:80'", id, ok)
+	}
+
+	// the backend ends the session
+	res = &http.Response{Header: http.Header{}, Request: next}
+	res.Header.Add("Set-Cookie", "JSESSIONID=; Max-Age=0")
+	observer.ObserveResponse(res, "a:80")
+
+	if _, ok := sticky.Lookup(next); ok {
+		t.Error("Failed forget app cookie deleted by backend")
+	}
+}
+
+func TestStickyModes(t *testing.T) {
+	if sticky, err := newStickySessions(config.StickyConfig{}); sticky != nil || err != nil {
+		t.Errorf("Failed disabled sticky sessions: got %v %v", sticky, err)
+	}
+
+	if _, ok := mustNewStickySessions(t, config.StickyConfig{Enabled: true}).(*sessionCookies); !ok {
+		t.Error("Failed default cookie mode")
+	}
+
+	if _, err := newStickySessions(config.StickyConfig{Enabled: true, Mode: "magic"}); err == nil {
+		t.Error("Failed unrecognized mode: expected an error")
+	}
+}
+
+func mustNewStickySessions(t *testing.T, cfg config.StickyConfig) stickySessions {
+	sticky, err := newStickySessions(cfg)
+	if err != nil {
+		t.Fatalf("Failed creating sticky sessions: %s", err.Error())
+	}
+	return sticky
+}
@@ -0,0 +1,240 @@
+package strategy
+
+import (
+	"fmt"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestBackendInfos(hosts ...string) []config.BackendInfo {
+	infos := make([]config.BackendInfo, len(hosts))
+	for i, host := range hosts {
+		infos[i] = config.NewBackendInfo(host, 80)
+	}
+	return infos
+}
+
+// Removes backends from the manager, then notifies the strategy, as the balancer does.
+func removeTestBackends(bm *backend.BackendManager, listener BalancerStrategyBackendListener, hosts ...string) {
+	listener.RemoveBackends(bm.RemoveBackends(newTestBackendInfos(hosts...)))
+}
+
+func addTestBackends(t *testing.T, bm *backend.BackendManager, listener BalancerStrategyBackendListener, hosts ...string) {
+	if err := bm.AddBackends(newTestBackendInfos(hosts...)); err != nil {
+		t.Fatalf("Failed adding backends: %s", err.Error())
+	}
+
+	added := make([]backend.BackendRef, len(hosts))
+	for i := range added {
+		added[i] = bm.GetBackend(bm.GetBackendCount() - len(hosts) + i)
+	}
+	listener.AddBackends(added)
+}
+
+// Checks every strategy can be notified of backend changes.
+func TestStrategiesAreBackendListeners(t *testing.T) {
+	for _, name := range []string{"ROUND_ROBIN", "LEAST_CONN", "LEAST_RESP", "REQUEST_HASH"} {
+		bm := backend.NewBackendManager(newTestBackendInfos("abc"))
+
+		strat, err := NewBalancerStrategy(config.StrategyConfig{Name: name}, bm)
+		if err != nil {
+			t.Fatalf("Failed creating %s: %s", name, err.Error())
+		}
+
+		if _, ok := strat.(BalancerStrategyBackendListener); !ok {
+			t.Errorf("Failed %s backend listener: expected it to implement BalancerStrategyBackendListener", name)
+		}
+	}
+}
+
+func TestRoundRobinBackendListener(t *testing.T) {
+	bm := backend.NewBackendManager(newTestBackendInfos("abc", "def", "ghi"))
+	rr, _ := newRoundRobin(config.StrategyConfig{Name: "ROUND_ROBIN"}, bm)
+	r, _ := http.NewRequest("GET", "http://localhost", nil)
+
+	// move the rotation on to def
+	rr.GetNextBackendIndex(bm.GetBackends(), r)
+
+	// removing abc shouldnt change the next backend
+	removeTestBackends(bm, rr, "abc")
+	if next := bm.GetBackend(rr.GetNextBackendIndex(bm.GetBackends(), r)).GetURL().Hostname(); next != "def" {
+		t.Errorf("Failed round robin after removing an earlier backend: got %s expected def", next)
+	}
+
+	// removing the next backend, which was last, should wrap round to the start
+	removeTestBackends(bm, rr, "ghi")
+	addTestBackends(t, bm, rr, "jkl", "mno")
+
+	order := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		order = append(order, bm.GetBackend(rr.GetNextBackendIndex(bm.GetBackends(), r)).GetURL().Hostname())
+	}
+	if !reflect.DeepEqual(order, []string{"def", "jkl", "mno"}) {
+		t.Errorf("Failed round robin after changes: got %v expected [def jkl mno]", order)
+	}
+
+	removeTestBackends(bm, rr, "def", "jkl", "mno")
+	if next := rr.GetNextBackendIndex(bm.GetBackends(), r); next != -1 {
+		t.Errorf("Failed round robin with no backends: got %d expected -1", next)
+	}
+}
+
+func TestRoundRobinWeightPadding(t *testing.T) {
+	bm := backend.NewBackendManager(newTestBackendInfos("abc", "def", "ghi"))
+
+	rr, err := newRoundRobin(config.StrategyConfig{
+		Name:       "ROUND_ROBIN",
+		Properties: map[string]interface{}{"weights": []int{2}},
+	}, bm)
+	if err != nil {
+		t.Fatalf("Failed creating round robin: %s", err.Error())
+	}
+	if !reflect.DeepEqual(rr.weights, []int{2, 1, 1}) {
+		t.Errorf("Failed round robin weight padding: got %v expected [2 1 1]", rr.weights)
+	}
+
+	rr, _ = newRoundRobin(config.StrategyConfig{
+		Name:       "ROUND_ROBIN",
+		Properties: map[string]interface{}{"weights": []int{1, 2, 3, 4}},
+	}, bm)
+	if !reflect.DeepEqual(rr.weights, []int{1, 2, 3}) {
+		t.Errorf("Failed round robin weight truncating: got %v expected [1 2 3]", rr.weights)
+	}
+}
+
+func TestLeastConnectionsBackendListener(t *testing.T) {
+	bm := backend.NewBackendManager(newTestBackendInfos("abc", "def", "ghi"))
+	lc := newLeastConnections(config.StrategyConfig{}, bm)
+
+	abc, def, ghi := bm.GetBackend(0), bm.GetBackend(1), bm.GetBackend(2)
+
+	lc.OnBackendConnectionStart(abc)
+	lc.OnBackendConnectionStart(def)
+	lc.OnBackendConnectionStart(ghi)
+	lc.OnBackendConnectionStart(ghi)
+
+	removeTestBackends(bm, lc, "def")
+	addTestBackends(t, bm, lc, "jkl")
+
+	// ghi now has the most connections, abc and the new jkl the fewest
+	r, _ := http.NewRequest("GET", "http://localhost", nil)
+	for i := 0; i < 10; i++ {
+		index := lc.GetNextBackendIndex(bm.GetBackends(), r)
+		if host := bm.GetBackend(index).GetURL().Hostname(); host != "jkl" {
+			t.Errorf("Failed least connections after changes: got %s expected jkl", host)
+		}
+	}
+
+	// ending a connection to a removed backend should be ignored
+	lc.OnBackendConnectionEnd(def)
+	lc.OnBackendConnectionEnd(ghi)
+	if lc.connectionCounts[def] != 0 || lc.connectionCounts[ghi] != 1 {
+		t.Errorf("Failed least connections end after changes: got %v", lc.connectionCounts)
+	}
+}
+
+func TestLeastResponseBackendListener(t *testing.T) {
+	bm := backend.NewBackendManager(newTestBackendInfos("abc", "def", "ghi"))
+	lr := newLeastResponse(config.StrategyConfig{}, bm)
+
+	abc := bm.GetBackend(0)
+	for i := 0; i < 3; i++ {
+		lr.applyResponseTimeUpdate(bm.GetBackend(i), time.Duration(i+1)*time.Millisecond)
+	}
+
+	removeTestBackends(bm, lr, "abc")
+	addTestBackends(t, bm, lr, "jkl")
+
+	// a late measurement from a request to the removed backend should be ignored
+	lr.applyResponseTimeUpdate(abc, time.Millisecond)
+
+	expected := []time.Duration{2 * time.Millisecond, 3 * time.Millisecond, 0}
+	for i, duration := range expected {
+		if got := lr.responseTimes[bm.GetBackend(i)].average; got != duration {
+			t.Errorf("Failed least response time %d after changes: got %s expected %s", i, got, duration)
+		}
+	}
+	if _, ok := lr.responseTimes[abc]; ok {
+		t.Errorf("Failed least response remove: expected the removed backend's measurements to be forgotten")
+	}
+
+	// the new backend has no measurements, so should be tried first
+	r, _ := http.NewRequest("GET", "http://localhost", nil)
+	if index := lr.GetNextBackendIndex(bm.GetBackends(), r); index != 2 {
+		t.Errorf("Failed least response after changes: got %d expected 2", index)
+	}
+}
+
+func TestRequestHashBackendListener(t *testing.T) {
+	hosts := make([]string, 10)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("backend-%d", i)
+	}
+
+	bm := backend.NewBackendManager(newTestBackendInfos(hosts...))
+	rh, err := newRequestHash(config.StrategyConfig{
+		Name:       "REQUEST_HASH",
+		Properties: map[string]interface{}{"key": "path"},
+	}, bm)
+	if err != nil {
+		t.Fatalf("Failed creating request hash: %s", err.Error())
+	}
+
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/%d", i)
+	}
+
+	lookupHosts := func() []string {
+		chosen := make([]string, len(paths))
+		for i, path := range paths {
+			r, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+			chosen[i] = bm.GetBackend(rh.GetNextBackendIndex(bm.GetBackends(), r)).GetURL().Hostname()
+		}
+		return chosen
+	}
+
+	before := lookupHosts()
+
+	removeTestBackends(bm, rh, "backend-3")
+	afterRemove := lookupHosts()
+	for i := range paths {
+		if before[i] != afterRemove[i] && before[i] != "backend-3" {
+			t.Errorf("Failed request hash remove: '%s' moved from %s to %s", paths[i], before[i], afterRemove[i])
+			break
+		}
+	}
+
+	addTestBackends(t, bm, rh, "backend-3")
+	afterAdd := lookupHosts()
+	if !reflect.DeepEqual(before, afterAdd) {
+		t.Errorf("Failed request hash add: expected the original mapping after adding the backend back")
+	}
+}
+
+func TestRoundRobinUpdateBackends(t *testing.T) {
+	bm := backend.NewBackendManager(newTestBackendInfos("abc", "def"))
+	rr, _ := newRoundRobin(config.StrategyConfig{Name: "ROUND_ROBIN"}, bm)
+	r, _ := http.NewRequest("GET", "http://localhost", nil)
+
+	reweighted := newTestBackendInfos("abc")
+	reweighted[0].Weight = 2
+	_, _, updated, err := bm.ModifyBackends(newTestBackendInfos("abc"), reweighted)
+	if err != nil || len(updated) != 1 {
+		t.Fatalf("Failed updating backend: got %d updated with error %v expected 1", len(updated), err)
+	}
+	rr.UpdateBackends(updated)
+
+	// abc keeps its place at the front, now used twice
+	order := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		order = append(order, bm.GetBackend(rr.GetNextBackendIndex(bm.GetBackends(), r)).GetURL().Hostname())
+	}
+	if !reflect.DeepEqual(order, []string{"abc", "abc", "def"}) {
+		t.Errorf("Failed round robin after update: got %v expected [abc abc def]", order)
+	}
+}
@@ -29,6 +29,10 @@ func NewBalancerStrategy(cfg config.StrategyConfig, backendManager *backend.Back
 		fmt.Println("Created least response time balancer.")
 		strat = newLeastResponse(cfg, backendManager)
 		break
+	case "REQUEST_HASH":
+		fmt.Println("Created request hash balancer.")
+		strat, err = newRequestHash(cfg, backendManager)
+		break
 	}
 
 	if err != nil {
@@ -36,39 +40,58 @@ func NewBalancerStrategy(cfg config.StrategyConfig, backendManager *backend.Back
 	}
 
 	if strat == nil {
-		return strat, fmt.Errorf("Unrecognized strategy name '%s' in config file.", cfg.Name)
-	}
-
-	// attach strategy methods to backend manager
-	var untypedStrat interface{} = strat
-
-	connectionMethods, ok := untypedStrat.(BalancerStrategyConnections)
-	if ok {
-		fmt.Println("Attaching connection methods.")
-		backendManager.ConnectionStartCallback = connectionMethods.OnBackendConnectionStart
-		backendManager.ConnectionEndCallback = connectionMethods.OnBackendConnectionEnd
-	}
-
-	modifyRequestMethods, ok := untypedStrat.(BalancerStrategyRequestModifier)
-	if ok {
-		fmt.Println("Attaching request modifier methods.")
-		backendManager.ModifyRequestCallback = modifyRequestMethods.ModifyRequest
+		return strat, fmt.Errorf("Unrecognized strategy name '%s'.", cfg.Name)
 	}
 
 	return strat, nil
 }
 
 // Now, there are some extra interfaces BalancerStrategy implementations can choose to implement to recieve extra information from the backends.
+//
+// Strategies are used concurrently, and backends can be added or removed while requests are in flight.
+// So these identify backends by reference rather than index, and requests may still finish on a removed backend.
 
 // Recieve notifications about connection events.
 type BalancerStrategyConnections interface {
-	OnBackendConnectionStart(backendIndex int)
-	OnBackendConnectionEnd(backendIndex int)
+	OnBackendConnectionStart(b backend.BackendRef)
+	OnBackendConnectionEnd(b backend.BackendRef)
 }
 
 // Modify the incoming requests
 type BalancerStrategyRequestModifier interface {
 	// Produces a new (or modifies the old) request.
 	// Called just before the request is served.
-	ModifyRequest(backendIndex int, r *http.Request) *http.Request
+	ModifyRequest(b backend.BackendRef, r *http.Request) *http.Request
+}
+
+// Observe the responses of backends, e.g. to time them.
+type BalancerStrategyResponseObserver interface {
+	// Called on each response before it is written back to the client.
+	// Streamed and upgraded responses, which last as long as the client wants them to, are not observed.
+	// The body may be wrapped, e.g. to find when the whole response has been sent.
+	ObserveResponse(b backend.BackendRef, res *http.Response)
+}
+
+// Recieve notifications about backends being added or removed, so the strategy can update its state
+// rather than being rebuilt from scratch (losing e.g. connection counts and response times).
+//
+// Called after the backend manager has been updated, but possibly while requests are still
+// choosing from the previous backend list.
+type BalancerStrategyBackendListener interface {
+	// Backends were appended to the end of the backend list.
+	AddBackends(added []backend.BackendRef)
+	// Backends were removed. The remaining backends keep their order.
+	RemoveBackends(removed []backend.BackendRef)
+	// Backends were changed in place (e.g. their weight), keeping their place in the list.
+	UpdateBackends(updated []backend.BackendRef)
+}
+
+// Reports whether a backend is in a list of backends.
+func containsBackend(backends []backend.BackendRef, b backend.BackendRef) bool {
+	for _, other := range backends {
+		if other == b {
+			return true
+		}
+	}
+	return false
 }
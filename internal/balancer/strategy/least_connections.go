@@ -1,7 +1,6 @@
 package strategy
 
 import (
-	"errors"
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
 	"math"
@@ -10,48 +9,71 @@ import (
 	"sync"
 )
 
+// Chooses the backend with the fewest requests in flight, relative to its weight.
+// A backend of weight 2 is chosen over one of weight 1 until it has twice the connections.
+//
+// Upgrade requests, such as WebSockets, are instead balanced by the upgraded connections each backend has open.
+// Those last far longer than requests, so arent counted as requests in flight once upgraded.
 type leastConnections struct {
-	connectionCounts     []int
+	// The number of requests in flight to each backend, backends without any are absent
+	connectionCounts     map[backend.BackendRef]int
 	connectionCountsLock sync.RWMutex
 }
 
 func newLeastConnections(cfg config.StrategyConfig, backendManager *backend.BackendManager) *leastConnections {
 	return &leastConnections{
-		connectionCounts: make([]int, backendManager.GetBackendCount()),
+		connectionCounts: make(map[backend.BackendRef]int),
 	}
 }
 
-func (lc *leastConnections) OnBackendConnectionStart(backendIndex int) {
+func (lc *leastConnections) OnBackendConnectionStart(b backend.BackendRef) {
 	lc.connectionCountsLock.Lock()
 
-	lc.connectionCounts[backendIndex]++
+	lc.connectionCounts[b]++
 
 	lc.connectionCountsLock.Unlock()
 }
 
-func (lc *leastConnections) OnBackendConnectionEnd(backendIndex int) {
+func (lc *leastConnections) OnBackendConnectionEnd(b backend.BackendRef) {
 	lc.connectionCountsLock.Lock()
 
-	lc.connectionCounts[backendIndex]--
+	// the count is missing if the backend was removed while the request was in flight
+	if count, ok := lc.connectionCounts[b]; ok {
+		if count <= 1 {
+			delete(lc.connectionCounts, b)
+		} else {
+			lc.connectionCounts[b] = count - 1
+		}
+	}
 
 	lc.connectionCountsLock.Unlock()
 }
 
 func (lc *leastConnections) GetNextBackendIndex(backendList backend.ReadonlyBackendList, r *http.Request) int {
-	// the lowest connection count we have seen
-	lowestConnCount := math.MaxInt
+	lc.connectionCountsLock.RLock()
+	defer lc.connectionCountsLock.RUnlock()
+
+	// the lowest connection count per unit of weight we have seen
+	lowestConnCount := math.Inf(1)
 	// the number of backends which have lowestConnCount
 	numLeastConnBackends := 0
 	// list of backends with lowestConnCount [0,numLeastConnBackends)
-	leastConnBackendIndexes := make([]int, len(lc.connectionCounts))
+	leastConnBackendIndexes := make([]int, backendList.Len())
+
+	upgrade := backend.IsUpgradeRequest(r)
 
 	// loop over the backends, checking connection counts
-	for i := 0; i < len(lc.connectionCounts); i++ {
-		if !backendList.Get(i).GetAlive() {
+	for i := 0; i < backendList.Len(); i++ {
+		b := backendList.Get(i)
+		if !b.GetAlive() {
 			continue
 		}
 
-		connCount := lc.connectionCounts[i]
+		count := lc.connectionCounts[b]
+		if upgrade {
+			count = b.GetUpgradedConnections()
+		}
+		connCount := float64(count) / float64(b.GetWeight())
 
 		if connCount <= lowestConnCount {
 
@@ -68,9 +90,30 @@ func (lc *leastConnections) GetNextBackendIndex(backendList backend.ReadonlyBack
 	}
 
 	if numLeastConnBackends == 0 {
-		panic(errors.New("No backends to choose from in least connections."))
+		// no available backends
+		return -1
 	}
 
 	// pick randomly out of the lowest connection backends
 	return leastConnBackendIndexes[rand.Intn(numLeastConnBackends)]
 }
+
+// New backends start with no connections, so need no state.
+func (lc *leastConnections) AddBackends(added []backend.BackendRef) {
+
+}
+
+// Updated backends keep their counts.
+func (lc *leastConnections) UpdateBackends(updated []backend.BackendRef) {
+
+}
+
+// Forgets the removed backends' counts. Requests still in flight to them are ignored when they end.
+func (lc *leastConnections) RemoveBackends(removed []backend.BackendRef) {
+	lc.connectionCountsLock.Lock()
+	defer lc.connectionCountsLock.Unlock()
+
+	for _, b := range removed {
+		delete(lc.connectionCounts, b)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -12,8 +13,8 @@ import (
 )
 
 // create a simple server that sleeps for a minute on each request
-func createLoopingServer(addr string) http.Server {
-	return http.Server{
+func createLoopingServer(addr string) *http.Server {
+	return &http.Server{
 		Addr: addr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			time.Sleep(time.Second * 60)
@@ -21,19 +22,22 @@ func createLoopingServer(addr string) http.Server {
 	}
 }
 
-func setupServersAndBackends() ([]http.Server, *backend.BackendManager) {
+func setupServersAndBackends() ([]*http.Server, *backend.BackendManager) {
 	// create a few looping servers
-	servers := []http.Server{
+	servers := []*http.Server{
 		createLoopingServer(":9000"),
 		createLoopingServer(":9001"),
 		createLoopingServer(":9002"),
 	}
 
 	// start all the servers on different goroutines
+	// listen first so the servers are accepting connections before any request is made
 	for _, s := range servers {
-		go func(s http.Server) {
-			s.ListenAndServe()
-		}(s)
+		listener, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			panic(err)
+		}
+		go s.Serve(listener)
 	}
 
 	bm := backend.NewBackendManager([]config.BackendInfo{
@@ -45,17 +49,23 @@ func setupServersAndBackends() ([]http.Server, *backend.BackendManager) {
 	return servers, bm
 }
 
-func teardownServers(servers []http.Server) {
+func teardownServers(servers []*http.Server) {
 	for _, s := range servers {
 		s.Close()
 	}
 }
 
-func requestBackend(bm *backend.BackendManager, i int) {
+// Requests a backend, notifying the strategy of the connection as the balancer does.
+func requestBackend(bm *backend.BackendManager, connections BalancerStrategyConnections, i int) {
+	b := bm.GetBackend(i)
 	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, bm.GetBackend(i).GetURL().String(), nil)
+	r := httptest.NewRequest(http.MethodGet, b.GetURL().String(), nil)
 	go func() {
-		err := bm.ServeRequestWithBackend(i, w, r)
+		connections.OnBackendConnectionStart(b)
+		defer connections.OnBackendConnectionEnd(b)
+
+		b.ReserveConnection()
+		err := bm.ServeRequestWithBackend(b, w, r, nil)
 		if err != nil {
 			fmt.Printf("Err requesting: %s\n", err.Error())
 		} else {
@@ -78,11 +88,8 @@ func TestLeastConnections(t *testing.T) {
 
 	r, _ := http.NewRequest("GET", "http://localhost:9000", nil)
 
-	bm.ConnectionStartCallback = lc.OnBackendConnectionStart
-	bm.ConnectionEndCallback = lc.OnBackendConnectionEnd
-
 	// test exclude not lowest
-	requestBackend(bm, 0)
+	requestBackend(bm, lc, 0)
 	// allow connection count to update
 	time.Sleep(time.Millisecond * 5)
 	for i := 0; i < 5; i++ {
@@ -93,7 +100,7 @@ func TestLeastConnections(t *testing.T) {
 	}
 
 	// test pick only lowest
-	requestBackend(bm, 2)
+	requestBackend(bm, lc, 2)
 	// allow connection count to update
 	time.Sleep(time.Millisecond * 5)
 	for i := 0; i < 5; i++ {
@@ -103,3 +110,32 @@ func TestLeastConnections(t *testing.T) {
 		}
 	}
 }
+
+func TestLeastConnectionsWeighted(t *testing.T) {
+	heavy := config.NewBackendInfo("abc", 80)
+	heavy.Weight = 2
+
+	bm := backend.NewBackendManager([]config.BackendInfo{
+		heavy,
+		config.NewBackendInfo("def", 80),
+	})
+	lc := newLeastConnections(config.StrategyConfig{Name: "LEAST_CONN"}, bm)
+
+	r, _ := http.NewRequest("GET", "http://abc:80", nil)
+
+	// one connection each, so the heavier backend has less load per unit of weight
+	lc.OnBackendConnectionStart(bm.GetBackend(0))
+	lc.OnBackendConnectionStart(bm.GetBackend(1))
+
+	if res := lc.GetNextBackendIndex(bm.GetBackends(), r); res != 0 {
+		t.Errorf("Failed weighted least connections: got %d expected 0", res)
+	}
+
+	// three connections on the heavier backend is more load per unit of weight than one on the other
+	lc.OnBackendConnectionStart(bm.GetBackend(0))
+	lc.OnBackendConnectionStart(bm.GetBackend(0))
+
+	if res := lc.GetNextBackendIndex(bm.GetBackends(), r); res != 1 {
+		t.Errorf("Failed weighted least connections: got %d expected 1", res)
+	}
+}
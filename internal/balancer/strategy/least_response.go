@@ -1,96 +1,165 @@
 package strategy
 
 import (
+	"context"
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
 	"go-balancer/internal/util"
+	"io"
 	"math"
 	"net/http"
-	"net/http/httptrace"
+	"sync"
 	"time"
 )
 
 const MEASUREMENT_QUEUE_SIE = 10
 
-// Chooses backends based on recorded TTFB from previous requests.
+// Chooses backends based on how long previous requests took, up to the end of their response.
 // Tracked with a simple moving average.
+//
+// Streamed and upgraded responses, such as server-sent events and WebSockets, last as long as the client wants them to,
+// so are not measured and dont make their backend look slow.
 type leastResponse struct {
-	// A list of queues of the last few TTFB measurements
-	responseTimeMeasurements []util.Queue[time.Duration]
+	// The response times of each backend
+	responseTimes map[backend.BackendRef]*responseTimeAverage
 
-	// the simple moving average of TTFBs
-	responseTimes []time.Duration
+	// Response times are recorded concurrently by in flight requests
+	m sync.RWMutex
+}
+
+// A simple moving average of a backend's response times
+type responseTimeAverage struct {
+	// A queue of the last few response time measurements
+	measurements util.Queue[time.Duration]
+
+	// the simple moving average of the measurements
+	average time.Duration
 }
 
 func newLeastResponse(cfg config.StrategyConfig, bm *backend.BackendManager) *leastResponse {
-	queues := make([]util.Queue[time.Duration], bm.GetBackendCount())
-	for i := range queues {
-		queues[i] = util.NewRingBufferQueue[time.Duration](MEASUREMENT_QUEUE_SIE)
+	lr := &leastResponse{
+		responseTimes: make(map[backend.BackendRef]*responseTimeAverage),
 	}
 
-	return &leastResponse{
-		responseTimeMeasurements: queues,
-		responseTimes:            make([]time.Duration, bm.GetBackendCount()),
+	backends := bm.GetBackends()
+	for i := 0; i < backends.Len(); i++ {
+		lr.responseTimes[backends.Get(i)] = newResponseTimeAverage()
 	}
+
+	return lr
 }
 
-func (lr *leastResponse) applyResponseTimeUpdate(backendIndex int, responseTime time.Duration) {
-	count := int64(lr.responseTimeMeasurements[backendIndex].Count())
+func newResponseTimeAverage() *responseTimeAverage {
+	return &responseTimeAverage{
+		measurements: util.NewRingBufferQueue[time.Duration](MEASUREMENT_QUEUE_SIE),
+	}
+}
+
+func (lr *leastResponse) applyResponseTimeUpdate(b backend.BackendRef, responseTime time.Duration) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	rt, ok := lr.responseTimes[b]
+	if !ok {
+		// the backend was removed while the request was in flight
+		return
+	}
+
+	count := int64(rt.measurements.Count())
 
 	if count == 0 {
 		// if no measurements, use measurement as average
-		lr.responseTimes[backendIndex] = responseTime
-		lr.responseTimeMeasurements[backendIndex].Enqueue(responseTime)
+		rt.average = responseTime
+		rt.measurements.Enqueue(responseTime)
 	} else if count == MEASUREMENT_QUEUE_SIE {
 		// if queue full, discard oldest measurement
-		old := lr.responseTimeMeasurements[backendIndex].Dequeue()
+		old := rt.measurements.Dequeue()
 
 		// calculate change in average
 		change := (responseTime - old) / MEASUREMENT_QUEUE_SIE
 
 		// apply change
-		lr.responseTimes[backendIndex] += change
+		rt.average += change
 
-		lr.responseTimeMeasurements[backendIndex].Enqueue(responseTime)
+		rt.measurements.Enqueue(responseTime)
 	} else {
 		// queue not quite full, update avg with others
 
 		// current avg will be (x_i+...+x_{count-1})/count
 		// new is therefore ((curr*count)+new)/(count+1)
-		lr.responseTimes[backendIndex] = time.Duration(((int64(lr.responseTimes[backendIndex]) * count) + int64(responseTime)) / (count + 1))
+		rt.average = time.Duration(((int64(rt.average) * count) + int64(responseTime)) / (count + 1))
 
-		lr.responseTimeMeasurements[backendIndex].Enqueue(responseTime)
+		rt.measurements.Enqueue(responseTime)
 	}
 }
 
-// Modify the request to attach a client trace and measure TTFB
-func (lr *leastResponse) ModifyRequest(backendIndex int, r *http.Request) *http.Request {
-	// declare the start time so we can capture in the trace
-	// wait to initialise until after the trace context is set up to make timing more accurate
-	var startTime time.Time
-	defer func() { startTime = time.Now() }()
+// The context key of the time a request was started.
+// Holds the strategy, so responses to requests started by a previous strategy are ignored.
+type responseStartKey struct {
+	lr *leastResponse
+}
+
+// Modify the request to record when it was started
+func (lr *leastResponse) ModifyRequest(b backend.BackendRef, r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), responseStartKey{lr}, time.Now()))
+}
 
-	// attach a function to record the time once the first response byte is recieved
-	trace := &httptrace.ClientTrace{
-		GotFirstResponseByte: func() {
-			ttfb := time.Since(startTime)
+// Wraps the response body to record the response time once it has all been read, to be sent back to the client.
+func (lr *leastResponse) ObserveResponse(b backend.BackendRef, res *http.Response) {
+	start, ok := res.Request.Context().Value(responseStartKey{lr}).(time.Time)
+	if !ok {
+		return
+	}
 
-			lr.applyResponseTimeUpdate(backendIndex, ttfb)
+	res.Body = &timedBody{
+		body: res.Body,
+		done: func() {
+			lr.applyResponseTimeUpdate(b, time.Since(start))
 		},
 	}
+}
+
+// A response body which calls done once it has been read to the end.
+// Bodies closed before the end, e.g. as the client went away, arent whole responses so arent timed.
+type timedBody struct {
+	body io.ReadCloser
+	done func()
+	// Set once done has been called
+	finished bool
+}
+
+func (t *timedBody) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	if err == io.EOF && !t.finished {
+		t.finished = true
+		t.done()
+	}
+	return n, err
+}
 
-	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+func (t *timedBody) Close() error {
+	return t.body.Close()
 }
 
+// Backends without measurements have an average of 0, so are tried first.
 func (lr *leastResponse) GetNextBackendIndex(backendList backend.ReadonlyBackendList, r *http.Request) int {
+	lr.m.RLock()
+	defer lr.m.RUnlock()
+
 	lowestDuration := time.Duration(math.MaxInt)
 	lowestDurationIndex := -1
 
-	for i, duration := range lr.responseTimes {
-		if !backendList.Get(i).GetAlive() {
+	for i := 0; i < backendList.Len(); i++ {
+		b := backendList.Get(i)
+		if !b.GetAlive() {
 			continue
 		}
 
+		duration := time.Duration(0)
+		if rt, ok := lr.responseTimes[b]; ok {
+			duration = rt.average
+		}
+
 		if duration < lowestDuration {
 			lowestDuration = duration
 			lowestDurationIndex = i
@@ -99,3 +168,28 @@ func (lr *leastResponse) GetNextBackendIndex(backendList backend.ReadonlyBackend
 
 	return lowestDurationIndex
 }
+
+// New backends have no measurements, so have an average of 0 and are tried first.
+func (lr *leastResponse) AddBackends(added []backend.BackendRef) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	for _, b := range added {
+		lr.responseTimes[b] = newResponseTimeAverage()
+	}
+}
+
+// Updated backends keep their measurements.
+func (lr *leastResponse) UpdateBackends(updated []backend.BackendRef) {
+
+}
+
+// Forgets the removed backends' measurements.
+func (lr *leastResponse) RemoveBackends(removed []backend.BackendRef) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	for _, b := range removed {
+		delete(lr.responseTimes, b)
+	}
+}
@@ -3,6 +3,7 @@ package strategy
 import (
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -22,8 +23,8 @@ func TestLeastResponse(t *testing.T) {
 
 	// populate fake response times (hard to test real ones)
 	// only 1 result each, so will be directly used
-	lr.applyResponseTimeUpdate(0, time.Millisecond*500)
-	lr.applyResponseTimeUpdate(1, time.Millisecond*100)
+	lr.applyResponseTimeUpdate(bm.GetBackend(0), time.Millisecond*500)
+	lr.applyResponseTimeUpdate(bm.GetBackend(1), time.Millisecond*100)
 
 	x := lr.GetNextBackendIndex(bm.GetBackends(), r)
 	if x != 1 {
@@ -32,7 +33,7 @@ func TestLeastResponse(t *testing.T) {
 
 	// apply a longer measurement to 1
 	// avg of 100 and 1000 is 550, which is bigger than 500 for 0
-	lr.applyResponseTimeUpdate(1, time.Millisecond*1000)
+	lr.applyResponseTimeUpdate(bm.GetBackend(1), time.Millisecond*1000)
 
 	x = lr.GetNextBackendIndex(bm.GetBackends(), r)
 	if x != 0 {
@@ -44,16 +45,14 @@ func TestLeastResponse(t *testing.T) {
 		Name: "LEAST_RESP",
 	}, bm)
 
-	bm.ModifyRequestCallback = lr.ModifyRequest
-
 	// this time, testing if after 10 updates we forget oldest
 	// done by starting with a massive time and then adding some smaller ones until it changes
-	lr.applyResponseTimeUpdate(0, time.Millisecond*100)
-	lr.applyResponseTimeUpdate(1, time.Hour*100)
+	lr.applyResponseTimeUpdate(bm.GetBackend(0), time.Millisecond*100)
+	lr.applyResponseTimeUpdate(bm.GetBackend(1), time.Hour*100)
 
 	// add 9 small times, but the avg shouldnt fall under 100 microsec
 	for i := 0; i < 9; i++ {
-		lr.applyResponseTimeUpdate(1, time.Millisecond*10)
+		lr.applyResponseTimeUpdate(bm.GetBackend(1), time.Millisecond*10)
 		x = lr.GetNextBackendIndex(bm.GetBackends(), r)
 		if x != 0 {
 			t.Errorf("Failed average converges too fast: got %d, expected 0", x)
@@ -61,9 +60,62 @@ func TestLeastResponse(t *testing.T) {
 	}
 
 	// now we should forget the oldest
-	lr.applyResponseTimeUpdate(1, time.Millisecond*10)
+	lr.applyResponseTimeUpdate(bm.GetBackend(1), time.Millisecond*10)
 	x = lr.GetNextBackendIndex(bm.GetBackends(), r)
 	if x != 1 {
 		t.Errorf("Failed forget old measurements: got %d, expected 1", x)
 	}
 }
+
+// A body which takes a while to read, like a large download.
+type slowBody struct {
+	delay time.Duration
+	read  bool
+}
+
+func (s *slowBody) Read(p []byte) (int, error) {
+	if s.read {
+		return 0, io.EOF
+	}
+	time.Sleep(s.delay)
+	s.read = true
+	return copy(p, "done"), nil
+}
+
+func (s *slowBody) Close() error {
+	return nil
+}
+
+// Tests the response time is measured to the end of the response, rather than to the first byte.
+func TestLeastResponseMeasuresWholeResponse(t *testing.T) {
+	bm := backend.NewBackendManager([]config.BackendInfo{config.NewBackendInfo("localhost", 9000)})
+	lr := newLeastResponse(config.StrategyConfig{Name: "LEAST_RESP"}, bm)
+	b := bm.GetBackend(0)
+
+	r, _ := http.NewRequest("GET", "http://localhost:9000", nil)
+	res := &http.Response{Request: lr.ModifyRequest(b, r), Body: &slowBody{delay: time.Millisecond * 50}}
+	lr.ObserveResponse(b, res)
+
+	if count := lr.responseTimes[b].measurements.Count(); count != 0 {
+		t.Errorf("Failed measure before body read: got %d measurements expected 0", count)
+	}
+
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	if average := lr.responseTimes[b].average; average < time.Millisecond*50 {
+		t.Errorf("Failed measure whole response: got %s expected at least 50ms", average)
+	}
+
+	// a body closed before the end, or a response to a request the strategy didnt start, isnt measured
+	res = &http.Response{Request: lr.ModifyRequest(b, r), Body: &slowBody{}}
+	lr.ObserveResponse(b, res)
+	res.Body.Close()
+
+	res = &http.Response{Request: r, Body: &slowBody{}}
+	lr.ObserveResponse(b, res)
+	io.ReadAll(res.Body)
+
+	if count := lr.responseTimes[b].measurements.Count(); count != 1 {
+		t.Errorf("Failed ignore partial responses: got %d measurements expected 1", count)
+	}
+}
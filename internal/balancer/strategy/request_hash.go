@@ -5,17 +5,52 @@ import (
 	"go-balancer/internal/backend"
 	"go-balancer/internal/balancer/config"
 	"go-balancer/internal/hashing"
+	"net"
 	"net/http"
+	"sync"
 )
 
+const defaultDuplicationFactor = 100
+const defaultBoundedLoadEpsilon = 0.25
+
+// Chooses backends by consistent hashing a key taken from the request.
+//
+// Optionally uses consistent hashing with bounded loads,
+// where a request moves on to another backend if its backend has too many requests in flight.
 type requestHash struct {
-	ring hashing.ConsistentHash[backend.BackendRef]
+	ring hashing.Lookup[backend.BackendRef]
+	// The weight each backend is added to the ring with, multiplied by the backend's own weight
+	weight int
+
+	// Turns a request into the key to hash
+	requestKey func(*http.Request) string
+
+	boundedLoad bool
+	epsilon     float64
 
-	requestHasher func(*http.Request) uint64
+	// The lookup isnt safe for concurrent use
+	m sync.Mutex
 }
 
 type requestHashProps struct {
+	// The hashing algorithm: "ring" (the default), "maglev" or "rendezvous"
+	Algorithm string `yaml:"algorithm"`
+
+	// The number of virtual nodes per backend on the ring
 	DuplicationFactor int `yaml:"duplicationFactor"`
+
+	// The hash function, "xxhash" (the default) or "fnv", and its seed.
+	// Replicas with the same settings and backends send each key to the same backend.
+	HashFunction string `yaml:"hashFunction"`
+	Seed         uint64 `yaml:"seed"`
+
+	// What part of the request is hashed: "ip", "path", "url" or "header"
+	Key    string `yaml:"key"`
+	Header string `yaml:"header"`
+
+	// Use consistent hashing with bounded loads, allowing up to (1+epsilon) times the average load per backend.
+	BoundedLoad bool     `yaml:"boundedLoad"`
+	Epsilon     *float64 `yaml:"epsilon"`
 }
 
 func newRequestHash(cfg config.StrategyConfig, backendManager *backend.BackendManager) (*requestHash, error) {
@@ -25,34 +60,170 @@ func newRequestHash(cfg config.StrategyConfig, backendManager *backend.BackendMa
 		return nil, fmt.Errorf("Error reading request hash properties: %s", err.Error())
 	}
 
-	ch := hashing.NewConsistentHash[backend.BackendRef](func(b backend.BackendRef) string {
-		return b.GetURL().String()
-	})
+	if props.DuplicationFactor <= 0 {
+		props.DuplicationFactor = defaultDuplicationFactor
+	}
+
+	epsilon := defaultBoundedLoadEpsilon
+	if props.Epsilon != nil {
+		epsilon = *props.Epsilon
+	}
+	if epsilon < 0 {
+		return nil, fmt.Errorf("Request hash epsilon must not be negative, got %g.", epsilon)
+	}
+
+	requestKey, err := newRequestKeyFunc(props)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := hashing.NewHashFunc(props.HashFunction, props.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	ring, err := hashing.NewLookup(props.Algorithm, func(b backend.BackendRef) string {
+		return b.GetID()
+	}, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the ring uses the weight as a number of virtual nodes, the others share keys by weight
+	weight := 1
+	if props.Algorithm == "" || props.Algorithm == "ring" {
+		weight = props.DuplicationFactor
+	}
 
 	backends := backendManager.GetBackends()
 	for i := 0; i < backends.Len(); i++ {
-		ch.Add(backends.Get(i), props.DuplicationFactor)
+		b := backends.Get(i)
+		ring.Add(b, weight*b.GetWeight())
 	}
 
 	return &requestHash{
-		ring: ch,
-		// TODO: change based on props
-		requestHasher: func(r *http.Request) uint64 {
-			return 1
-		},
+		ring:        ring,
+		weight:      weight,
+		requestKey:  requestKey,
+		boundedLoad: props.BoundedLoad,
+		epsilon:     epsilon,
 	}, nil
 }
 
+// Creates the function to get the key to hash from a request.
+func newRequestKeyFunc(props requestHashProps) (func(*http.Request) string, error) {
+	switch props.Key {
+	case "", "ip":
+		return func(r *http.Request) string {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				return r.RemoteAddr
+			}
+			return host
+		}, nil
+	case "path":
+		return func(r *http.Request) string {
+			return r.URL.Path
+		}, nil
+	case "url":
+		return func(r *http.Request) string {
+			return r.URL.RequestURI()
+		}, nil
+	case "header":
+		if props.Header == "" {
+			return nil, fmt.Errorf("Request hash keyed by header is missing a header name.")
+		}
+		return func(r *http.Request) string {
+			return r.Header.Get(props.Header)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized request hash key '%s'.", props.Key)
+}
+
 func (h *requestHash) GetNextBackendIndex(backendList backend.ReadonlyBackendList, r *http.Request) int {
-	hashed := h.requestHasher(r)
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	hashed := h.ring.Hash(h.requestKey(r))
+
+	chosen, found := h.lookup(hashed, backend.BackendRef.GetAlive)
+	if !found {
+		return -1
+	}
+
+	index := backendList.IndexOf(chosen)
+	if index == -1 {
+		// the ring and backend list are briefly out of step while backends are changed,
+		// so choose only from backends in the list
+		chosen, found = h.lookup(hashed, func(b backend.BackendRef) bool {
+			return b.GetAlive() && backendList.IndexOf(b) != -1
+		})
+		if !found {
+			return -1
+		}
+		index = backendList.IndexOf(chosen)
+	}
+
+	return index
+}
 
-	return backendList.IndexOf(h.ring.RingLookup(hashed))
+// Finds the backend for a hashed key, respecting bounded loads if enabled.
+// Assumes the caller holds the lock.
+func (h *requestHash) lookup(hashed uint64, accept func(backend.BackendRef) bool) (backend.BackendRef, bool) {
+	if h.boundedLoad {
+		return h.ring.BoundedLookup(hashed, h.epsilon, accept)
+	}
+	return h.ring.LookupFunc(hashed, accept)
 }
 
-func (h *requestHash) AddBackends(n int) {
+func (h *requestHash) OnBackendConnectionStart(b backend.BackendRef) {
+	h.m.Lock()
+	defer h.m.Unlock()
 
+	h.ring.IncrementLoad(b)
 }
 
-func (h *requestHash) RemoveBackends(indexes []int) {
+func (h *requestHash) OnBackendConnectionEnd(b backend.BackendRef) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.ring.DecrementLoad(b)
+}
 
+// Adds the new backends to the ring. Only keys which now hash to them move.
+func (h *requestHash) AddBackends(added []backend.BackendRef) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for _, b := range added {
+		h.ring.Add(b, h.weight*b.GetWeight())
+	}
+}
+
+// Removes the backends from the ring. Only keys which hashed to them move.
+func (h *requestHash) RemoveBackends(removed []backend.BackendRef) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for _, b := range removed {
+		h.ring.Remove(b)
+	}
+}
+
+// Adds the updated backends back to the ring with their new weights, keeping their loads.
+// Keys only move to or from the updated backends.
+func (h *requestHash) UpdateBackends(updated []backend.BackendRef) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for _, b := range updated {
+		load := h.ring.GetLoad(b)
+		h.ring.Remove(b)
+		h.ring.Add(b, h.weight*b.GetWeight())
+
+		for i := 0; i < load; i++ {
+			h.ring.IncrementLoad(b)
+		}
+	}
 }
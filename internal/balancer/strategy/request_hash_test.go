@@ -0,0 +1,88 @@
+package strategy
+
+import (
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"testing"
+)
+
+func TestRequestHash(t *testing.T) {
+	bm := backend.NewBackendManager([]config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+		config.NewBackendInfo("def", 80),
+		config.NewBackendInfo("ghi", 80),
+	})
+
+	for _, algorithm := range []string{"ring", "maglev", "rendezvous"} {
+		rh, err := newRequestHash(config.StrategyConfig{
+			Name: "REQUEST_HASH",
+			Properties: map[string]interface{}{
+				"key":       "path",
+				"algorithm": algorithm,
+			},
+		}, bm)
+		if err != nil {
+			t.Fatalf("Failed creating %s request hash: %s", algorithm, err.Error())
+		}
+
+		// the same key should always go to the same backend
+		for _, path := range []string{"/a", "/b", "/c", "/d"} {
+			r, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+
+			first := rh.GetNextBackendIndex(bm.GetBackends(), r)
+			for i := 0; i < 5; i++ {
+				if next := rh.GetNextBackendIndex(bm.GetBackends(), r); next != first {
+					t.Errorf("Failed %s consistent choice for '%s': got %d expected %d", algorithm, path, next, first)
+				}
+			}
+		}
+	}
+
+	_, err := newRequestHash(config.StrategyConfig{
+		Properties: map[string]interface{}{"algorithm": "jump"},
+	}, bm)
+	if err == nil {
+		t.Error("Failed unrecognized algorithm: expected an error")
+	}
+
+	_, err = newRequestHash(config.StrategyConfig{
+		Properties: map[string]interface{}{"key": "header"},
+	}, bm)
+	if err == nil {
+		t.Error("Failed header key without header name: expected an error")
+	}
+}
+
+func TestRequestHashBoundedLoad(t *testing.T) {
+	bm := backend.NewBackendManager([]config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+		config.NewBackendInfo("def", 80),
+	})
+
+	rh, err := newRequestHash(config.StrategyConfig{
+		Name: "REQUEST_HASH",
+		Properties: map[string]interface{}{
+			"key":         "path",
+			"boundedLoad": true,
+			"epsilon":     0,
+		},
+	}, bm)
+	if err != nil {
+		t.Fatalf("Failed creating request hash: %s", err.Error())
+	}
+
+	r, _ := http.NewRequest("GET", "http://localhost/hot", nil)
+
+	// with epsilon 0, in flight requests for a hot key should alternate between the backends
+	counts := make([]int, 2)
+	for i := 0; i < 10; i++ {
+		index := rh.GetNextBackendIndex(bm.GetBackends(), r)
+		rh.OnBackendConnectionStart(bm.GetBackend(index))
+		counts[index]++
+	}
+
+	if counts[0] != 5 || counts[1] != 5 {
+		t.Errorf("Failed bounded load balance: got %v expected [5 5]", counts)
+	}
+}
@@ -12,8 +12,8 @@ import (
 //
 // Optionally uses weighted round robin, where each backend is used a number of times before moving on.
 type roundRobin struct {
-	// Number of backends
-	backendCount int
+	// The backends in the order they are rotated through
+	backends []backend.BackendRef
 
 	// The index of the next backend to use
 	i int
@@ -44,65 +44,143 @@ func newRoundRobin(cfg config.StrategyConfig, backendManager *backend.BackendMan
 		return nil, fmt.Errorf("Error reading round robin properties: %s", err.Error())
 	}
 
-	backendCount := backendManager.GetBackendCount()
+	backendList := backendManager.GetBackends()
+	backendCount := backendList.Len()
 
-	// if there was no weights, use unweighted (1's)
+	// if there was no weights, use the backends' own weights
 	if props.Weights == nil {
-		props.Weights = make([]int, backendCount)
-		for i := 0; i < backendCount; i++ {
-			props.Weights[i] = 1
-		}
+		props.Weights = make([]int, 0, backendCount)
 	}
 
-	// if weights incomplete, pad with 1's
+	// if weights incomplete, pad with the backends' own weights
 	if len(props.Weights) < backendCount {
-		fmt.Println("Round robin weights too short, padding with 1's.")
+		if len(props.Weights) > 0 {
+			fmt.Println("Round robin weights too short, padding with the backends' weights.")
+		}
 		for i := len(props.Weights); i < backendCount; i++ {
-			props.Weights[i] = 1
+			props.Weights = append(props.Weights, backendList.Get(i).GetWeight())
 		}
 	}
 
 	// if weights too long, truncate
-	if len(props.Weights) < backendCount {
+	if len(props.Weights) > backendCount {
 		fmt.Println("Round robin weights too long, truncating.")
 		props.Weights = props.Weights[:backendCount:backendCount]
 	}
 
+	backends := make([]backend.BackendRef, backendCount)
+	for i := range backends {
+		backends[i] = backendList.Get(i)
+	}
+
 	return &roundRobin{
-		backendCount: backendCount,
-		weights:      props.Weights,
+		backends: backends,
+		weights:  props.Weights,
 	}, nil
 }
 
+// Finds the index of the ith backend of the rotation in a backend list, or -1 if it is not in the list.
+// The list is normally the same as the rotation, but can be an older or newer snapshot while backends are changed.
+func (rr *roundRobin) indexIn(backendList backend.ReadonlyBackendList, i int) int {
+	b := rr.backends[i]
+	if i < backendList.Len() && backendList.Get(i) == b {
+		return i
+	}
+	return backendList.IndexOf(b)
+}
+
 func (rr *roundRobin) GetNextBackendIndex(backendList backend.ReadonlyBackendList, r *http.Request) int {
 	// aquire the object lock, to avoid 2 concurrent invocations reading the same index
 	rr.m.Lock()
+	defer rr.m.Unlock()
 
-	// defer incrementing j,i and unlocking
-	defer func() {
-		// inc j
-		rr.j = rr.j + 1
-		// if we have used this backend (j) up to its weight, increment i
-		if rr.j >= rr.weights[rr.i] {
-			rr.i = (rr.i + 1) % rr.backendCount
-			rr.j = 0
-		}
-		rr.m.Unlock()
-	}()
+	if len(rr.backends) == 0 {
+		return -1
+	}
 
 	// prevent infinite looping
 	firsti := rr.i
 
 	// ensure we are choosing a 'live' backend
-	for !backendList.Get(rr.i).GetAlive() {
+	index := rr.indexIn(backendList, rr.i)
+	for index == -1 || !backendList.Get(index).GetAlive() {
 		rr.j = 0
-		rr.i = (rr.i + 1) % rr.backendCount
+		rr.i = (rr.i + 1) % len(rr.backends)
 
 		if rr.i == firsti {
 			// reached the first i we used, so no live backends
 			return -1
 		}
+
+		index = rr.indexIn(backendList, rr.i)
 	}
 
-	return rr.i
+	// inc j
+	rr.j = rr.j + 1
+	// if we have used this backend (j) up to its weight, increment i
+	if rr.j >= rr.weights[rr.i] {
+		rr.i = (rr.i + 1) % len(rr.backends)
+		rr.j = 0
+	}
+
+	return index
+}
+
+// New backends use their own weights, and are reached once the rotation gets to the end of the list.
+func (rr *roundRobin) AddBackends(added []backend.BackendRef) {
+	rr.m.Lock()
+	defer rr.m.Unlock()
+
+	for _, b := range added {
+		rr.backends = append(rr.backends, b)
+		rr.weights = append(rr.weights, b.GetWeight())
+	}
+}
+
+// Updated backends use their new weights, keeping their place in the rotation.
+func (rr *roundRobin) UpdateBackends(updated []backend.BackendRef) {
+	rr.m.Lock()
+	defer rr.m.Unlock()
+
+	for i, b := range rr.backends {
+		if containsBackend(updated, b) {
+			rr.weights[i] = b.GetWeight()
+		}
+	}
+}
+
+// Keeps the rotation's place, moving on to the next remaining backend if the current one was removed.
+func (rr *roundRobin) RemoveBackends(removed []backend.BackendRef) {
+	rr.m.Lock()
+	defer rr.m.Unlock()
+
+	kept := 0
+	newi := -1
+
+	for i, b := range rr.backends {
+		if i == rr.i {
+			// the current backend, or the next remaining one if it is removed, keeps the rotation's place
+			newi = kept
+		}
+
+		if containsBackend(removed, b) {
+			if i == rr.i {
+				rr.j = 0
+			}
+			continue
+		}
+
+		rr.backends[kept] = b
+		rr.weights[kept] = rr.weights[i]
+		kept++
+	}
+
+	rr.backends = rr.backends[:kept]
+	rr.weights = rr.weights[:kept]
+
+	rr.i = newi
+	if rr.i == -1 || rr.i >= kept {
+		rr.i = 0
+		rr.j = 0
+	}
 }
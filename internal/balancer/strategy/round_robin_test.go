@@ -38,3 +38,25 @@ func TestRoundRobin(t *testing.T) {
 		t.Errorf("Loop index fail, expected 0 got %d.", lst)
 	}
 }
+
+func TestRoundRobinBackendWeights(t *testing.T) {
+	heavy := config.NewBackendInfo("abc", 80)
+	heavy.Weight = 2
+
+	bm := backend.NewBackendManager([]config.BackendInfo{
+		heavy,
+		config.NewBackendInfo("def", 80),
+	})
+
+	rr, _ := newRoundRobin(config.StrategyConfig{Name: "ROUND_ROBIN"}, bm)
+
+	r, _ := http.NewRequest("GET", "http://abc:80", nil)
+
+	expected := []int{0, 0, 1, 0, 0, 1}
+	for i, e := range expected {
+		got := rr.GetNextBackendIndex(bm.GetBackends(), r)
+		if got != e {
+			t.Errorf("Failed backend weights at request %d: got %d expected %d", i, got, e)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Starts a backend which sends a line, then waits for release before finishing the response.
+// The response has a Content-Length, so the proxy only flushes it early if it is configured to.
+func newStreamingBackend(t *testing.T, contentType string, release chan struct{}) config.BackendInfo {
+	first := "data: first\n"
+	rest := "data: rest\n"
+
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(first)+len(rest)))
+
+		io.WriteString(w, first)
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+			io.WriteString(w, rest)
+		case <-r.Context().Done():
+		}
+	})
+	t.Cleanup(server.Close)
+
+	return info
+}
+
+// Starts a request, returning a channel which gets the first line of the response once it arrives.
+func readFirstLine(url string) <-chan string {
+	lines := make(chan string, 1)
+
+	go func() {
+		res, err := http.Get(url)
+		if err != nil {
+			lines <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+
+		line, _ := bufio.NewReader(res.Body).ReadString('\n')
+		lines <- line
+	}()
+
+	return lines
+}
+
+func TestProxyStreaming(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		proxy       config.ProxyConfig
+		streamed    bool
+	}{
+		{"event stream", "text/event-stream", config.ProxyConfig{}, true},
+		{"stream content type", "application/x-ndjson", config.ProxyConfig{StreamContentTypes: []string{"application/x-ndjson"}}, true},
+		{"flush interval", "application/octet-stream", config.ProxyConfig{FlushInterval: 10 * time.Millisecond}, true},
+		{"buffered", "application/octet-stream", config.ProxyConfig{}, false},
+	}
+
+	for _, c := range cases {
+		release := make(chan struct{})
+		info := newStreamingBackend(t, c.contentType, release)
+
+		b, err := NewBalancer(config.Config{
+			Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+			Backends: []config.BackendInfo{info},
+			Proxy:    c.proxy,
+		})
+		if err != nil {
+			t.Fatalf("Failed creating balancer: %s", err.Error())
+		}
+		server := httptest.NewServer(b)
+
+		lines := readFirstLine(server.URL + "/stream")
+
+		select {
+		case line := <-lines:
+			if !c.streamed {
+				t.Errorf("Failed %s: got %q before the backend finished expected nothing", c.name, line)
+			} else if line != "data: first\n" {
+				t.Errorf("Failed %s: got %q expected the first line", c.name, line)
+			}
+		case <-time.After(300 * time.Millisecond):
+			if c.streamed {
+				t.Errorf("Failed %s: got nothing before the backend finished expected the first line", c.name)
+			}
+		}
+
+		close(release)
+		if !c.streamed {
+			<-lines
+		}
+		server.Close()
+	}
+}
+
+func TestProxyBodyLimits(t *testing.T) {
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		w.Write(bytes.Repeat([]byte("x"), size))
+	})
+	defer server.Close()
+
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+		Backends: []config.BackendInfo{info},
+		Proxy:    config.ProxyConfig{MaxRequestBodySize: 1000, MaxResponseBodySize: 1000},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+	balancerServer := httptest.NewServer(b)
+	defer balancerServer.Close()
+
+	cases := []struct {
+		name     string
+		body     int
+		chunked  bool
+		query    string
+		status   int
+		complete bool
+	}{
+		{"within limits", 1000, false, "size=1000", http.StatusOK, true},
+		{"request too large", 1001, false, "size=10", http.StatusRequestEntityTooLarge, false},
+		{"chunked request too large", 5000, true, "size=10", http.StatusRequestEntityTooLarge, false},
+		{"response too large", 10, false, "size=1001", http.StatusBadGateway, false},
+		// the headers are already sent by the time the limit is passed, so the response is cut off
+		{"chunked response too large", 10, false, "size=5000&chunked=1", http.StatusOK, false},
+	}
+
+	for _, c := range cases {
+		var body io.Reader = bytes.NewReader(bytes.Repeat([]byte("x"), c.body))
+		if c.chunked {
+			// hide the length, so the body is sent chunked
+			body = io.MultiReader(body)
+		}
+
+		res, err := http.Post(balancerServer.URL+"/?"+c.query, "text/plain", body)
+		if err != nil {
+			t.Fatalf("Failed %s: %s", c.name, err.Error())
+		}
+		_, readErr := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != c.status {
+			t.Errorf("Failed %s: got %d expected %d", c.name, res.StatusCode, c.status)
+		}
+		if c.complete && readErr != nil {
+			t.Errorf("Failed %s: got %s reading expected the whole response", c.name, readErr.Error())
+		}
+		if c.status == http.StatusOK && !c.complete && readErr == nil {
+			t.Errorf("Failed %s: got the whole response expected it cut off", c.name)
+		}
+
+		// the request was at fault, not the backend
+		if !b.backendManager.GetBackend(0).GetHealthy() {
+			t.Fatalf("Failed %s: got the backend marked dead expected it alive", c.name)
+		}
+	}
+}
+
+func TestRouteIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stalled-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: first\n")
+			w.(http.Flusher).Flush()
+		}
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer server.Close()
+
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+		Backends: []config.BackendInfo{info},
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/",
+			Timeouts:   config.RouteTimeoutConfig{Idle: 100 * time.Millisecond},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+	balancerServer := httptest.NewServer(b)
+	defer balancerServer.Close()
+
+	// a backend which never responds times out
+	res, err := http.Get(balancerServer.URL + "/stalled")
+	if err != nil {
+		t.Fatalf("Failed stalled request: %s", err.Error())
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Failed stalled request: got %d expected 504", res.StatusCode)
+	}
+
+	// as does a stream which stops sending
+	res, err = http.Get(balancerServer.URL + "/stalled-stream")
+	if err != nil {
+		t.Fatalf("Failed stalled stream: %s", err.Error())
+	}
+	received, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(received) != "data: first\n" || err == nil {
+		t.Errorf("Failed stalled stream: got %q %v expected the first line then an error", received, err)
+	}
+
+	if !b.backendManager.GetBackend(0).GetHealthy() {
+		t.Errorf("Failed idle timeout: got the backend marked dead expected it alive")
+	}
+}
+
+// Tests a route can lift the server's write timeout, so its streams can outlast it.
+func TestRouteWriteTimeout(t *testing.T) {
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 5; i++ {
+			io.WriteString(w, "data: tick\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	defer server.Close()
+
+	b, err := NewBalancer(config.Config{
+		Strategy: config.StrategyConfig{Name: "ROUND_ROBIN"},
+		Backends: []config.BackendInfo{info},
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/stream",
+			Timeouts:   config.RouteTimeoutConfig{Write: -1},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating balancer: %s", err.Error())
+	}
+
+	balancerServer := httptest.NewUnstartedServer(b)
+	balancerServer.Config = NewServer(config.ServerConfig{WriteTimeout: 100 * time.Millisecond}, 0, b)
+	balancerServer.Start()
+	defer balancerServer.Close()
+
+	cases := []struct {
+		path     string
+		complete bool
+	}{
+		{"/stream", true},
+		{"/other", false},
+	}
+
+	for _, c := range cases {
+		res, err := http.Get(balancerServer.URL + c.path)
+		if err != nil {
+			t.Fatalf("Failed %s: %s", c.path, err.Error())
+		}
+		received, err := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		complete := err == nil && strings.Count(string(received), "tick") == 5
+		if complete != c.complete {
+			t.Errorf("Failed %s: got %d ticks %v expected complete %t", c.path, strings.Count(string(received), "tick"), err, c.complete)
+		}
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	s := NewServer(config.ServerConfig{}, 8080, nil)
+	if s.Addr != ":8080" || s.ReadHeaderTimeout != defaultReadHeaderTimeout || s.IdleTimeout != defaultIdleTimeout {
+		t.Errorf("Failed defaults: got %s %s %s expected :8080 %s %s", s.Addr, s.ReadHeaderTimeout, s.IdleTimeout, defaultReadHeaderTimeout, defaultIdleTimeout)
+	}
+	if s.ReadTimeout != 0 || s.WriteTimeout != 0 {
+		t.Errorf("Failed defaults: got read %s write %s expected none", s.ReadTimeout, s.WriteTimeout)
+	}
+
+	s = NewServer(config.ServerConfig{ReadHeaderTimeout: -1, WriteTimeout: time.Minute}, 8080, nil)
+	if s.ReadHeaderTimeout != 0 || s.WriteTimeout != time.Minute {
+		t.Errorf("Failed config: got read header %s write %s expected none and 1m", s.ReadHeaderTimeout, s.WriteTimeout)
+	}
+}
+
+// A strategy always choosing the first backend, which counts the responses it observes.
+type observingStrategy struct {
+	observed atomic.Int32
+}
+
+func (s *observingStrategy) GetNextBackendIndex(backendList backend.ReadonlyBackendList, r *http.Request) int {
+	return 0
+}
+
+func (s *observingStrategy) ObserveResponse(b backend.BackendRef, res *http.Response) {
+	s.observed.Add(1)
+}
+
+// Tests strategies only observe responses which arent streamed, so they can time them.
+func TestStrategyObservesResponses(t *testing.T) {
+	server, info := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		io.WriteString(w, "data: hello\n")
+	})
+	defer server.Close()
+
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	observer := &observingStrategy{}
+	b.publish(observer)
+
+	serveTestRequest(b, "/?type=text/plain")
+	serveTestRequest(b, "/?type=text/event-stream")
+
+	if observed := observer.observed.Load(); observed != 1 {
+		t.Errorf("Failed observe responses: got %d observed expected 1", observed)
+	}
+}
@@ -0,0 +1,165 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Sets the deadlines of the connection a request arrived on.
+// The server's response writers implement this, wrappers give access to theirs with Unwrap.
+type deadlineSetter interface {
+	SetReadDeadline(deadline time.Time) error
+	SetWriteDeadline(deadline time.Time) error
+}
+
+// Finds the deadlines of a response writer's connection, unwrapping it if needed.
+// Returns nil if it has none, such as when testing with a recorder.
+func findDeadlineSetter(w http.ResponseWriter) deadlineSetter {
+	for {
+		if setter, ok := w.(deadlineSetter); ok {
+			return setter
+		}
+
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// Gets the deadline a timeout from now gives, or no deadline if it is negative.
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// Applies a route's timeouts to a request, overriding the server's.
+// Returns the writer and request to serve it with, and a func to call once it has been served.
+func applyRouteTimeouts(w http.ResponseWriter, r *http.Request, timeouts config.RouteTimeoutConfig) (http.ResponseWriter, *http.Request, func()) {
+	deadlines := findDeadlineSetter(w)
+
+	if deadlines != nil && timeouts.Read != 0 {
+		hasBody := r.Body != nil && r.Body != http.NoBody
+
+		// once the body is read the server waits in the background for the client to go away,
+		// so a deadline left afterwards would end the request as if it had
+		if timeouts.Read < 0 {
+			deadlines.SetReadDeadline(time.Time{})
+		} else if hasBody {
+			deadlines.SetReadDeadline(deadlineAfter(timeouts.Read))
+			r.Body = &deadlineBody{ReadCloser: r.Body, deadlines: deadlines}
+		}
+	}
+
+	if deadlines != nil && timeouts.Write != 0 {
+		deadlines.SetWriteDeadline(deadlineAfter(timeouts.Write))
+	}
+
+	done := func() {
+		// keep-alive connections dont reset the deadline for the next request unless the server has a write timeout
+		if deadlines != nil && timeouts.Write != 0 {
+			deadlines.SetWriteDeadline(time.Time{})
+		}
+	}
+
+	if timeouts.Idle <= 0 {
+		return w, r, done
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	idle := newIdleTimeoutWriter(w, timeouts.Idle, cancel)
+
+	return idle, r.WithContext(ctx), func() {
+		idle.stop()
+		cancel()
+		done()
+	}
+}
+
+// A request body which clears the connection's read deadline once it has been read.
+type deadlineBody struct {
+	io.ReadCloser
+	deadlines deadlineSetter
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.deadlines.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// Cancels a request if the response goes too long without anything being written to it,
+// from when the request is sent to the backend until the response is done.
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+
+	timeout time.Duration
+	timer   *time.Timer
+
+	// Set once the timeout has passed and the request was cancelled
+	timedOut atomic.Bool
+}
+
+func newIdleTimeoutWriter(w http.ResponseWriter, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutWriter {
+	idle := &idleTimeoutWriter{
+		ResponseWriter: w,
+		timeout:        timeout,
+	}
+	idle.timer = time.AfterFunc(timeout, func() {
+		idle.timedOut.Store(true)
+		cancel()
+	})
+	return idle
+}
+
+func (w *idleTimeoutWriter) stop() {
+	w.timer.Stop()
+}
+
+func (w *idleTimeoutWriter) WriteHeader(statusCode int) {
+	w.timer.Reset(w.timeout)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idleTimeoutWriter) Write(p []byte) (int, error) {
+	w.timer.Reset(w.timeout)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *idleTimeoutWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Upgraded connections arent requests, so the timeout stops once the connection is taken over.
+func (w *idleTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	w.stop()
+	return hijacker.Hijack()
+}
+
+func (w *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Reports whether a request was cancelled by its route's idle timeout.
+func idleTimedOut(w http.ResponseWriter) bool {
+	idle, ok := w.(*idleTimeoutWriter)
+	return ok && idle.timedOut.Load()
+}
@@ -0,0 +1,231 @@
+package balancer
+
+import (
+	"errors"
+	"go-balancer/internal/backend"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Upgrades requests to WebSockets which echo every message back.
+func echoWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		err = conn.WriteMessage(messageType, message)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Starts a WebSocket backend which echoes every message back.
+func newEchoBackend(t *testing.T) config.BackendInfo {
+	server, info := newTestBackend(t, echoWebSocket)
+	t.Cleanup(server.Close)
+
+	return info
+}
+
+// Connects a WebSocket through the balancer.
+func dialWebSocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+
+	conn, res, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed dialing WebSocket: %s", err.Error())
+	}
+	res.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// Sends a message and checks it is echoed back.
+func checkEcho(t *testing.T, name string, conn *websocket.Conn, message string) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	err := conn.WriteMessage(websocket.TextMessage, []byte(message))
+	if err != nil {
+		t.Fatalf("Failed %s: writing got %s", name, err.Error())
+	}
+
+	_, echoed, err := conn.ReadMessage()
+	if err != nil || string(echoed) != message {
+		t.Fatalf("Failed %s: got %s %v expected %s", name, echoed, err, message)
+	}
+}
+
+// Checks the balancer closed a WebSocket with a going away close frame.
+func checkGoingAway(t *testing.T, name string, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, _, err := conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("Failed %s: got %v expected close %d", name, err, websocket.CloseGoingAway)
+	}
+}
+
+// Waits for a backend to have a number of upgraded connections.
+func waitForUpgraded(t *testing.T, b backend.BackendRef, expected int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for b.GetUpgradedConnections() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed upgraded connections: got %d expected %d", b.GetUpgradedConnections(), expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	info := newEchoBackend(t)
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	checkEcho(t, "echo", conn, "hello")
+	checkEcho(t, "second echo", conn, strings.Repeat("x", 100000))
+
+	// the connection is counted as upgraded, not as a request in flight
+	chosen := b.backendManager.GetBackend(0)
+	waitForUpgraded(t, chosen, 1)
+	if chosen.GetActiveConnections() != 0 {
+		t.Errorf("Failed active connections: got %d expected 0", chosen.GetActiveConnections())
+	}
+
+	// changes dont wait for the connection
+	done := make(chan error)
+	go func() {
+		done <- b.AddBackends([]config.BackendInfo{config.NewBackendInfo("localhost", 1)})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed adding backend: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Failed adding backend: blocked by the open WebSocket")
+	}
+	checkEcho(t, "echo after change", conn, "still here")
+
+	conn.Close()
+	waitForUpgraded(t, chosen, 0)
+}
+
+func TestWebSocketClosedOnRemove(t *testing.T) {
+	info := newEchoBackend(t)
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	checkEcho(t, "echo", conn, "hello")
+	removed := b.backendManager.GetBackend(0)
+
+	err := b.RemoveBackends([]config.BackendInfo{info})
+	if err != nil {
+		t.Fatalf("Failed removing backend: %s", err.Error())
+	}
+
+	checkGoingAway(t, "close on remove", conn)
+	waitForUpgraded(t, removed, 0)
+}
+
+func TestWebSocketClosedOnDrain(t *testing.T) {
+	info := newEchoBackend(t)
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	checkEcho(t, "echo", conn, "hello")
+
+	err := b.SetBackendDraining(info.GetID(), true)
+	if err != nil {
+		t.Fatalf("Failed draining backend: %s", err.Error())
+	}
+
+	checkGoingAway(t, "close on drain", conn)
+	waitForUpgraded(t, b.backendManager.GetBackend(0), 0)
+}
+
+// Tests changing a backend, e.g. its weight, keeps its connections open.
+func TestWebSocketKeptOnReplace(t *testing.T) {
+	info := newEchoBackend(t)
+	b := newTestBalancer(t, "ROUND_ROBIN", []config.BackendInfo{info})
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	checkEcho(t, "echo", conn, "hello")
+
+	reweighted := info
+	reweighted.Weight = 3
+	err := b.ModifyBackends([]config.BackendInfo{info}, []config.BackendInfo{reweighted})
+	if err != nil {
+		t.Fatalf("Failed replacing backend: %s", err.Error())
+	}
+
+	checkEcho(t, "echo after replace", conn, "still here")
+
+	replacement := b.backendManager.GetBackend(0)
+	if replacement.GetWeight() != 3 || replacement.GetUpgradedConnections() != 1 {
+		t.Errorf("Failed replacement: got weight %d with %d upgraded expected weight 3 with 1", replacement.GetWeight(), replacement.GetUpgradedConnections())
+	}
+
+	// the replacement closes them once removed itself
+	err = b.RemoveBackends([]config.BackendInfo{reweighted})
+	if err != nil {
+		t.Fatalf("Failed removing backend: %s", err.Error())
+	}
+	checkGoingAway(t, "close on removing replacement", conn)
+}
+
+// Tests least connections balances WebSockets by the upgraded connections, which requests ignore.
+func TestWebSocketLeastConnections(t *testing.T) {
+	infos := []config.BackendInfo{newEchoBackend(t), newEchoBackend(t)}
+	b := newTestBalancer(t, "LEAST_CONN", infos)
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	for i := 0; i < 4; i++ {
+		conn := dialWebSocket(t, server)
+		checkEcho(t, "echo", conn, "hello")
+	}
+
+	for i := 0; i < 2; i++ {
+		chosen := b.backendManager.GetBackend(i)
+		waitForUpgraded(t, chosen, 2)
+
+		if chosen.GetActiveConnections() != 0 {
+			t.Errorf("Failed active connections of %d: got %d expected 0", i, chosen.GetActiveConnections())
+		}
+	}
+
+	if code := serveTestRequest(b, "/"); code != http.StatusOK {
+		t.Errorf("Failed request alongside WebSockets: got %d expected 200", code)
+	}
+}
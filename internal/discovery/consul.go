@@ -0,0 +1,222 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultConsulAddress       = "http://127.0.0.1:8500"
+	defaultConsulWait          = time.Minute * 5
+	defaultConsulRetryInterval = time.Second * 5
+)
+
+// Discovers backends from the instances of a service in a Consul compatible registry.
+//
+// Uses blocking queries, so each fetch waits until the service's instances or their health change.
+// Instances the registry reports as critical are not backends, so they are removed as soon as they fail.
+type consulProvider struct {
+	cfg config.ConsulDiscoveryConfig
+
+	client *http.Client
+
+	// The registry index of the last response, which the next query waits to change
+	index uint64
+}
+
+// The parts of an entry in the health API's response that backends are made from.
+type consulServiceEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+			Warning int
+		}
+	}
+	Checks []struct {
+		Status string
+	}
+}
+
+// Creates a Consul provider from its config, checking it is valid.
+func NewConsulProvider(cfg config.ConsulDiscoveryConfig) (Provider, error) {
+	if cfg.Service == "" {
+		return nil, fmt.Errorf("Consul discovery is missing a service name.")
+	}
+
+	if cfg.Address == "" {
+		cfg.Address = defaultConsulAddress
+	}
+	u, err := url.Parse(cfg.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Consul discovery needs an http or https address, got '%s'.", cfg.Address)
+	}
+
+	switch cfg.Health {
+	case "":
+		cfg.Health = "replace"
+	case "replace", "supplement":
+	default:
+		return nil, fmt.Errorf("Unrecognized Consul health mode '%s'.", cfg.Health)
+	}
+
+	if cfg.Wait <= 0 {
+		cfg.Wait = defaultConsulWait
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultConsulRetryInterval
+	}
+
+	return &consulProvider{
+		cfg: cfg,
+		// the registry adds up to wait/16 of jitter to blocking queries
+		client: &http.Client{Timeout: cfg.Wait + cfg.Wait/16 + time.Second*10},
+	}, nil
+}
+
+func (c *consulProvider) String() string {
+	return fmt.Sprintf("Consul service %s", c.cfg.Service)
+}
+
+// Makes a blocking query for the service's instances, returning once they have changed or the wait is over.
+// Asks to be called again straight away, as the next query blocks until there is a change.
+func (c *consulProvider) Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	query := url.Values{}
+	query.Set("wait", fmt.Sprintf("%dms", c.cfg.Wait.Milliseconds()))
+	if c.index > 0 {
+		query.Set("index", strconv.FormatUint(c.index, 10))
+	}
+	if c.cfg.Tag != "" {
+		query.Set("tag", c.cfg.Tag)
+	}
+	if c.cfg.Datacenter != "" {
+		query.Set("dc", c.cfg.Datacenter)
+	}
+
+	target := fmt.Sprintf("%s/v1/health/service/%s?%s", c.cfg.Address, url.PathEscape(c.cfg.Service), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, c.cfg.RetryInterval, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, c.cfg.RetryInterval, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, c.cfg.RetryInterval, fmt.Errorf("Unexpected status '%s' from %s.", res.Status, c.cfg.Address)
+	}
+
+	var entries []consulServiceEntry
+	err = json.NewDecoder(res.Body).Decode(&entries)
+	if err != nil {
+		return nil, c.cfg.RetryInterval, fmt.Errorf("Parsing Consul response failed: %s", err.Error())
+	}
+
+	backends, err := c.toBackends(entries)
+	if err != nil {
+		return nil, c.cfg.RetryInterval, err
+	}
+
+	// without a new index to block on the next query would return straight away, so wait before making it
+	if !c.updateIndex(res.Header.Get("X-Consul-Index")) {
+		return backends, c.cfg.RetryInterval, nil
+	}
+
+	return backends, 0, nil
+}
+
+// Records the index to wait on next, reporting whether it advanced.
+// The index going backwards (e.g. the registry was restored) or missing means starting again from 0.
+func (c *consulProvider) updateIndex(header string) bool {
+	index, err := strconv.ParseUint(header, 10, 64)
+	if err != nil || index < c.index {
+		c.index = 0
+		return false
+	}
+
+	advanced := index > c.index
+	c.index = index
+	return advanced
+}
+
+// Turns the healthy service entries into backends.
+func (c *consulProvider) toBackends(entries []consulServiceEntry) ([]config.BackendInfo, error) {
+	backends := []config.BackendInfo{}
+
+	for _, entry := range entries {
+		status := entryStatus(entry)
+		if status == "critical" {
+			continue
+		}
+
+		// services without their own address are reached at their node's
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+
+		info, err := config.MakeBackendInfo(host, entry.Service.Port)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid instance '%s' of Consul service %s: %s", entry.Service.ID, c.cfg.Service, err.Error())
+		}
+
+		// service IDs are only unique on their node
+		info.ID = entry.Node.Node + "/" + entry.Service.ID
+		info.Name = entry.Service.ID
+		info.Tags = entry.Service.Tags
+		info.Labels = entry.Service.Meta
+		info.Zone = entry.Node.Datacenter
+
+		info.Weight = entry.Service.Weights.Passing
+		if status == "warning" {
+			info.Weight = entry.Service.Weights.Warning
+		}
+
+		if c.cfg.Health == "replace" {
+			info.HealthCheck = &config.HealthCheckConfig{Disabled: true}
+		}
+
+		backends = append(backends, info)
+	}
+
+	return backends, nil
+}
+
+// Gets the worst status of an entry's checks: "passing", "warning" or "critical".
+// Maintenance checks are reported as critical.
+func entryStatus(entry consulServiceEntry) string {
+	status := "passing"
+	for _, check := range entry.Checks {
+		switch check.Status {
+		case "passing":
+		case "warning":
+			status = "warning"
+		default:
+			return "critical"
+		}
+	}
+	return status
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake registry serving the health API of one service, with blocking queries.
+type fakeRegistry struct {
+	// instance ID to its port and check status
+	instances map[string]fakeInstance
+	index     uint64
+	// closed and replaced whenever the instances change
+	changed chan struct{}
+
+	m sync.Mutex
+}
+
+type fakeInstance struct {
+	port   int
+	status string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		instances: make(map[string]fakeInstance),
+		index:     1,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Registers or updates an instance, or deregisters it if status is empty.
+func (f *fakeRegistry) set(id string, port int, status string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if status == "" {
+		delete(f.instances, id)
+	} else {
+		f.instances[id] = fakeInstance{port: port, status: status}
+	}
+
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/health/service/web" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+
+	f.m.Lock()
+	if index == f.index {
+		// block until the instances change, or the wait is over
+		changed := f.changed
+		f.m.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		}
+		f.m.Lock()
+	}
+
+	entries := []map[string]interface{}{}
+	for id, instance := range f.instances {
+		entries = append(entries, map[string]interface{}{
+			"Node": map[string]interface{}{"Node": "node-1", "Address": "10.0.0.1", "Datacenter": "dc1"},
+			"Service": map[string]interface{}{
+				"ID": id, "Service": "web", "Port": instance.port,
+				"Tags": []string{"v2"}, "Weights": map[string]int{"Passing": 3, "Warning": 1},
+			},
+			"Checks": []map[string]string{{"Status": "passing"}, {"Status": instance.status}},
+		})
+	}
+	w.Header().Set("X-Consul-Index", fmt.Sprint(f.index))
+	f.m.Unlock()
+
+	json.NewEncoder(w).Encode(entries)
+}
+
+func TestConsulProvider(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.set("web-1", 8001, "passing")
+	registry.set("web-2", 8002, "warning")
+	registry.set("web-3", 8003, "critical")
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	p, err := NewConsulProvider(config.ConsulDiscoveryConfig{Address: server.URL, Service: "web", Wait: time.Second * 5})
+	if err != nil {
+		t.Fatalf("Failed creating provider: %s", err.Error())
+	}
+	target := newStubTarget()
+	r := NewReconciler(p, target)
+
+	wait, err := r.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("Failed first query: %s", err.Error())
+	}
+	if wait != 0 {
+		t.Errorf("Failed blocking query wait: got %s expected 0s", wait)
+	}
+
+	// critical instances are not backends
+	checkIDs(t, "first query", target.ids(), []string{"node-1/web-1", "node-1/web-2"})
+
+	web1 := target.backends["node-1/web-1"]
+	if web1.Host != "10.0.0.1" || web1.Port != 8001 || web1.Zone != "dc1" || web1.GetWeight() != 3 {
+		t.Errorf("Failed instance mapping: got %s:%d in %s with weight %d", web1.Host, web1.Port, web1.Zone, web1.GetWeight())
+	}
+	if web1.HealthCheck == nil || !web1.HealthCheck.Disabled {
+		t.Error("Failed replacing health checks: backend health checks still enabled")
+	}
+	web2 := target.backends["node-1/web-2"]
+	if web2.GetWeight() != 1 {
+		t.Errorf("Failed warning weight: got %d expected 1", web2.GetWeight())
+	}
+
+	// the next query blocks until the registry changes
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		registry.set("web-1", 0, "")
+		registry.set("web-3", 8003, "passing")
+	}()
+
+	start := time.Now()
+	r.Refresh(context.Background())
+	if time.Since(start) < time.Millisecond*50 {
+		t.Error("Failed blocking query: returned before the registry changed")
+	}
+
+	// the query may have returned after only the first change
+	if len(target.ids()) == 1 {
+		r.Refresh(context.Background())
+	}
+	checkIDs(t, "changed registry", target.ids(), []string{"node-1/web-2", "node-1/web-3"})
+}
+
+func TestConsulProviderSupplement(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.set("web-1", 8001, "passing")
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	p, _ := NewConsulProvider(config.ConsulDiscoveryConfig{Address: server.URL, Service: "web", Health: "supplement"})
+
+	backends, _, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Failed query: %s", err.Error())
+	}
+	if len(backends) != 1 || backends[0].HealthCheck != nil {
+		t.Error("Failed supplementing health checks: backend health checks changed")
+	}
+
+	_, err = NewConsulProvider(config.ConsulDiscoveryConfig{Service: "web", Health: "ignore"})
+	if err == nil {
+		t.Error("Failed invalid health mode: got no error")
+	}
+}
+
+func TestConsulProviderErrors(t *testing.T) {
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	p, _ := NewConsulProvider(config.ConsulDiscoveryConfig{Address: server.URL, Service: "missing", RetryInterval: time.Second})
+
+	_, wait, err := p.Fetch(context.Background())
+	if err == nil {
+		t.Error("Failed unknown service: got no error")
+	}
+	if wait != time.Second {
+		t.Errorf("Failed retry interval: got %s expected 1s", wait)
+	}
+}
+
+// Tests queries without an index to block on wait before the next one, rather than querying the registry in a loop.
+func TestConsulProviderMissingIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	p, _ := NewConsulProvider(config.ConsulDiscoveryConfig{Address: server.URL, Service: "web", RetryInterval: time.Second})
+
+	for i := 0; i < 2; i++ {
+		_, wait, err := p.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Failed query without index: %s", err.Error())
+		}
+		if wait != time.Second {
+			t.Errorf("Failed query without index: got wait %s expected 1s", wait)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Somewhere backends can be added to and removed from, such as the balancer.
+type Target interface {
+	AddBackends(infos []config.BackendInfo) error
+	RemoveBackends(infos []config.BackendInfo) error
+	// Removes and adds backends in one step, updating those at the same address in place.
+	ModifyBackends(remove []config.BackendInfo, add []config.BackendInfo) error
+}
+
+// A source of the backends that should exist, such as DNS or a file.
+type Provider interface {
+	// Gets the backends that should currently exist.
+	// Can block until they change, returning early if the context is done.
+	// Returns how long to wait before fetching again, even if there was an error.
+	Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error)
+
+	// Describes the provider in logs.
+	String() string
+}
+
+// Creates a provider for each source of backends in the config.
+func NewProviders(cfg config.DiscoveryConfig) ([]Provider, error) {
+	providers := []Provider{}
+
+	for _, dnsCfg := range cfg.DNS {
+		p, err := NewDNSProvider(dnsCfg, NewDNSResolver(dnsCfg.Nameserver))
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	for _, fileCfg := range cfg.File {
+		p, err := NewFileProvider(fileCfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	for _, httpCfg := range cfg.HTTP {
+		p, err := NewHTTPProvider(httpCfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	for _, consulCfg := range cfg.Consul {
+		p, err := NewConsulProvider(consulCfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+// Keeps a target's backends in line with a provider.
+//
+// Each fetch, the provider's desired backends are diffed against those the reconciler added,
+// and the differences are applied to the target.
+// Only backends it added are ever removed, so it can be used alongside static backends and other providers.
+// If a fetch fails, the last known good set of backends is kept.
+type Reconciler struct {
+	provider Provider
+	target   Target
+
+	// The backends from the last successful fetch that were added to the target, by ID
+	current map[string]config.BackendInfo
+
+	// Held while refreshing, so refreshes dont overlap
+	m sync.Mutex
+}
+
+func NewReconciler(provider Provider, target Target) *Reconciler {
+	return &Reconciler{
+		provider: provider,
+		target:   target,
+		current:  make(map[string]config.BackendInfo),
+	}
+}
+
+// Starts fetching and reconciling in the background, until the context is cancelled.
+func (r *Reconciler) Start(ctx context.Context) {
+	fmt.Printf("Started discovery from %s.\n", r.provider)
+
+	go func() {
+		for true {
+			wait, err := r.Refresh(ctx)
+			if err != nil {
+				fmt.Printf("Discovery from %s failed, keeping %d backends: %s\n", r.provider, len(r.GetBackends()), err.Error())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Fetches the desired backends once and reconciles the target's backends with them.
+// Returns how long to wait before refreshing again.
+func (r *Reconciler) Refresh(ctx context.Context) (time.Duration, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	desired, wait, err := r.provider.Fetch(ctx)
+	if err != nil {
+		return wait, err
+	}
+
+	desiredByID := make(map[string]config.BackendInfo, len(desired))
+	for _, info := range desired {
+		// marks the backend as discovered, so it isnt persisted
+		info.Source = r.provider.String()
+
+		if _, ok := desiredByID[info.GetID()]; ok {
+			return wait, fmt.Errorf("Duplicate backend ID '%s' discovered.", info.GetID())
+		}
+		desiredByID[info.GetID()] = info
+	}
+
+	r.reconcile(desiredByID)
+
+	return wait, nil
+}
+
+// Gets the backends the reconciler currently has in the target, ordered by ID.
+func (r *Reconciler) GetBackends() []config.BackendInfo {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return sortedInfos(r.current)
+}
+
+// Removes backends which are no longer desired, and adds new ones.
+// Backends whose info changed are modified, so they are updated in place where the target can.
+//
+// Backends are added and modified one at a time, so one clashing with an existing backend doesnt stop the rest.
+// Assumes the caller holds the lock.
+func (r *Reconciler) reconcile(desired map[string]config.BackendInfo) {
+	removed := []config.BackendInfo{}
+	changed := map[string]config.BackendInfo{}
+	for id, info := range r.current {
+		if wanted, ok := desired[id]; !ok {
+			removed = append(removed, info)
+			delete(r.current, id)
+		} else if !reflect.DeepEqual(wanted, info) {
+			changed[id] = info
+		}
+	}
+
+	if len(removed) > 0 {
+		err := r.target.RemoveBackends(removed)
+		if err != nil {
+			fmt.Printf("Discovery from %s failed to remove backends: %s\n", r.provider, err.Error())
+		}
+	}
+
+	for _, info := range sortedInfos(desired) {
+		if old, ok := changed[info.GetID()]; ok {
+			// if this fails nothing is changed, so the old backend is kept and tried again next time
+			err := r.target.ModifyBackends([]config.BackendInfo{old}, []config.BackendInfo{info})
+			if err != nil {
+				fmt.Printf("Discovery from %s failed to update backend: %s\n", r.provider, err.Error())
+				continue
+			}
+
+			r.current[info.GetID()] = info
+			continue
+		}
+
+		if _, ok := r.current[info.GetID()]; ok {
+			continue
+		}
+
+		err := r.target.AddBackends([]config.BackendInfo{info})
+		if err != nil {
+			fmt.Printf("Discovery from %s failed to add backend: %s\n", r.provider, err.Error())
+			continue
+		}
+
+		r.current[info.GetID()] = info
+	}
+}
+
+// Gets the infos of a map ordered by ID, so backends are always added in the same order.
+func sortedInfos(infos map[string]config.BackendInfo) []config.BackendInfo {
+	sorted := make([]config.BackendInfo, 0, len(infos))
+	for _, info := range infos {
+		sorted = append(sorted, info)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetID() < sorted[j].GetID()
+	})
+	return sorted
+}
+
+// A group of "host:port" targets sharing labels, as in Prometheus file_sd.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// Parses a JSON or YAML list of backends, as used by the file and HTTP providers.
+//
+// Each entry is either a backend, in the same format as the config file,
+// or a group of targets with shared labels, in the format of Prometheus file_sd.
+func parseBackendList(data []byte) ([]config.BackendInfo, error) {
+	// YAML is a superset of JSON, so this reads both
+	var entries []yaml.Node
+	err := yaml.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing backend list failed: %s", err.Error())
+	}
+
+	infos := []config.BackendInfo{}
+
+	for _, entry := range entries {
+		if !hasKey(&entry, "targets") {
+			var info config.BackendInfo
+			err := entry.Decode(&info)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+			continue
+		}
+
+		var group targetGroup
+		err := entry.Decode(&group)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing target group failed: %s", err.Error())
+		}
+
+		for _, target := range group.Targets {
+			info, err := parseTarget(target)
+			if err != nil {
+				return nil, err
+			}
+			info.Labels = group.Labels
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// Parses a "host:port" target into a backend.
+func parseTarget(target string) (config.BackendInfo, error) {
+	var info config.BackendInfo
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return info, fmt.Errorf("Parsing target '%s' failed: %s", target, err.Error())
+	}
+
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return info, fmt.Errorf("Parsing target '%s' failed: invalid port.", target)
+	}
+
+	return config.MakeBackendInfo(host, portNum)
+}
+
+// Reports whether a YAML mapping node has a key.
+func hasKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
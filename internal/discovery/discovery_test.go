@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"sort"
+	"testing"
+	"time"
+)
+
+// A target recording its backends by ID.
+type stubTarget struct {
+	backends map[string]config.BackendInfo
+	// The number of times ModifyBackends was called
+	modified int
+}
+
+func newStubTarget(infos ...config.BackendInfo) *stubTarget {
+	target := &stubTarget{backends: make(map[string]config.BackendInfo)}
+	target.AddBackends(infos)
+	return target
+}
+
+func (s *stubTarget) AddBackends(infos []config.BackendInfo) error {
+	for _, info := range infos {
+		if _, ok := s.backends[info.GetID()]; ok {
+			return fmt.Errorf("Error adding backends: id '%s' already exists.", info.GetID())
+		}
+	}
+	for _, info := range infos {
+		s.backends[info.GetID()] = info
+	}
+	return nil
+}
+
+func (s *stubTarget) RemoveBackends(infos []config.BackendInfo) error {
+	for _, info := range infos {
+		delete(s.backends, info.GetID())
+	}
+	return nil
+}
+
+func (s *stubTarget) ModifyBackends(remove []config.BackendInfo, add []config.BackendInfo) error {
+	s.modified++
+	s.RemoveBackends(remove)
+	return s.AddBackends(add)
+}
+
+func (s *stubTarget) ids() []string {
+	ids := []string{}
+	for id := range s.backends {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func checkIDs(t *testing.T, step string, got []string, expected []string) {
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Failed %s: got %v expected %v", step, got, expected)
+	}
+}
+
+// A provider returning a fixed list of backends, or an error if fail is set.
+type stubProvider struct {
+	backends []config.BackendInfo
+	fail     bool
+}
+
+func (s *stubProvider) Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	if s.fail {
+		return nil, time.Second, fmt.Errorf("Stub fetch failure.")
+	}
+	return s.backends, time.Second, nil
+}
+
+func (s *stubProvider) String() string {
+	return "stub"
+}
+
+func TestReconcilerChanges(t *testing.T) {
+	provider := &stubProvider{backends: []config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+		config.NewBackendInfo("def", 80),
+	}}
+	target := newStubTarget(config.NewBackendInfo("static", 80))
+	r := NewReconciler(provider, target)
+
+	r.Refresh(context.Background())
+	checkIDs(t, "initial fetch", target.ids(), []string{"abc:80", "def:80", "static:80"})
+
+	// a changed backend is modified, a missing one removed and a new one added
+	changed := config.NewBackendInfo("abc", 80)
+	changed.Zone = "eu-west-1b"
+	provider.backends = []config.BackendInfo{changed, config.NewBackendInfo("ghi", 80)}
+
+	r.Refresh(context.Background())
+	checkIDs(t, "changed fetch", target.ids(), []string{"abc:80", "ghi:80", "static:80"})
+	if zone := target.backends["abc:80"].Zone; zone != "eu-west-1b" || target.modified != 1 {
+		t.Errorf("Failed changed backend: got zone %s modified %d times expected eu-west-1b modified once", zone, target.modified)
+	}
+
+	// failures keep the last known good backends
+	provider.fail = true
+	_, err := r.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed fetch failure: got no error")
+	}
+	checkIDs(t, "failed fetch", target.ids(), []string{"abc:80", "ghi:80", "static:80"})
+}
+
+func TestReconcilerClashes(t *testing.T) {
+	// a backend which already exists isnt the reconciler's, so is never removed by it
+	provider := &stubProvider{backends: []config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+		config.NewBackendInfo("static", 80),
+	}}
+	target := newStubTarget(config.NewBackendInfo("static", 80))
+	r := NewReconciler(provider, target)
+
+	r.Refresh(context.Background())
+	checkIDs(t, "clashing fetch", ids(r.GetBackends()), []string{"abc:80"})
+
+	provider.backends = []config.BackendInfo{}
+	r.Refresh(context.Background())
+	checkIDs(t, "empty fetch", target.ids(), []string{"static:80"})
+
+	provider.backends = []config.BackendInfo{
+		config.NewBackendInfo("abc", 80),
+		config.NewBackendInfo("abc", 80),
+	}
+	_, err := r.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed duplicate IDs: got no error")
+	}
+}
+
+func ids(infos []config.BackendInfo) []string {
+	ids := []string{}
+	for _, info := range infos {
+		ids = append(ids, info.GetID())
+	}
+	return ids
+}
+
+func TestParseBackendList(t *testing.T) {
+	data := []byte(`[
+		{"host": "abc", "port": 80, "weight": 2},
+		{"targets": ["10.0.0.1:8080", "[fd00::1]:8080"], "labels": {"env": "prod"}}
+	]`)
+
+	infos, err := parseBackendList(data)
+	if err != nil {
+		t.Fatalf("Failed parsing backend list: %s", err.Error())
+	}
+
+	checkIDs(t, "parsing backend list", ids(infos), []string{"abc:80", "10.0.0.1:8080", "[fd00::1]:8080"})
+	if w := infos[0].GetWeight(); w != 2 {
+		t.Errorf("Failed parsing backend weight: got %d expected 2", w)
+	}
+	if env := infos[2].Labels["env"]; env != "prod" {
+		t.Errorf("Failed parsing target group labels: got %s expected prod", env)
+	}
+
+	_, err = parseBackendList([]byte(`[{"targets": ["no-port"]}]`))
+	if err == nil {
+		t.Error("Failed parsing invalid target: got no error")
+	}
+}
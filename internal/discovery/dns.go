@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"time"
+)
+
+const (
+	defaultDNSInterval = time.Second * 30
+	defaultDNSTimeout  = time.Second * 5
+	// The shortest time between resolutions, so records with tiny TTLs dont flood the nameserver
+	minimumDNSInterval = time.Second
+)
+
+// Discovers backends by resolving a DNS name.
+// Names are resolved again when their records' TTLs expire, up to the configured interval.
+type dnsProvider struct {
+	cfg config.DNSDiscoveryConfig
+
+	resolver Resolver
+}
+
+// Creates a DNS provider from its config, checking it is valid.
+func NewDNSProvider(cfg config.DNSDiscoveryConfig, resolver Resolver) (Provider, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("DNS discovery is missing a name to resolve.")
+	}
+
+	switch cfg.Type {
+	case "":
+		cfg.Type = "A"
+		fallthrough
+	case "A", "A4", "AAAA":
+		if cfg.Port <= 0 || cfg.Port > 65535 {
+			return nil, fmt.Errorf("DNS discovery of '%s' needs a valid port, got %d.", cfg.Name, cfg.Port)
+		}
+	case "SRV":
+	default:
+		return nil, fmt.Errorf("Unrecognized DNS discovery type '%s'.", cfg.Type)
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultDNSInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultDNSTimeout
+	}
+
+	return &dnsProvider{
+		cfg:      cfg,
+		resolver: resolver,
+	}, nil
+}
+
+func (d *dnsProvider) String() string {
+	return fmt.Sprintf("DNS %s", d.cfg.Name)
+}
+
+// Resolves the name once.
+// Returns how long to wait before resolving again, which is the shortest record TTL up to the interval.
+func (d *dnsProvider) Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	desired, ttl, err := d.resolve(ctx)
+	if err != nil {
+		return nil, d.cfg.Interval, err
+	}
+
+	wait := d.cfg.Interval
+	if ttl < wait {
+		wait = ttl
+	}
+	if wait < minimumDNSInterval {
+		wait = minimumDNSInterval
+	}
+
+	return desired, wait, nil
+}
+
+// Resolves the backends the name currently points to, along with the shortest TTL of their records.
+// An empty answer is an error, as it is more likely a DNS problem than every backend going away.
+func (d *dnsProvider) resolve(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	var records []Record
+
+	switch d.cfg.Type {
+	case "A":
+		// either family may be missing, but not both
+		ipv4, err4 := d.resolver.Lookup(ctx, d.cfg.Name, "A")
+		ipv6, err6 := d.resolver.Lookup(ctx, d.cfg.Name, "AAAA")
+		if err4 != nil && err6 != nil {
+			return nil, 0, err4
+		}
+		records = append(ipv4, ipv6...)
+	case "A4":
+		var err error
+		records, err = d.resolver.Lookup(ctx, d.cfg.Name, "A")
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		var err error
+		records, err = d.resolver.Lookup(ctx, d.cfg.Name, d.cfg.Type)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if d.cfg.Type == "SRV" {
+		records = lowestPriority(records)
+	}
+
+	if len(records) == 0 {
+		return nil, 0, fmt.Errorf("No %s records found for '%s'.", d.cfg.Type, d.cfg.Name)
+	}
+
+	desired := make([]config.BackendInfo, 0, len(records))
+	ttl := records[0].TTL
+
+	for _, record := range records {
+		port := d.cfg.Port
+		if d.cfg.Type == "SRV" {
+			port = record.Port
+		}
+
+		info, err := config.MakeBackendInfo(record.Host, port)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Invalid record '%s' found for '%s': %s", record.Host, d.cfg.Name, err.Error())
+		}
+		info.Weight = record.Weight
+
+		desired = append(desired, info)
+
+		if record.TTL < ttl {
+			ttl = record.TTL
+		}
+	}
+
+	return desired, ttl, nil
+}
+
+// Gets the SRV records with the lowest priority. Others are only meant to be used if these are all unreachable.
+func lowestPriority(records []Record) []Record {
+	lowest := []Record{}
+	for _, record := range records {
+		if len(lowest) > 0 && record.Priority > lowest[0].Priority {
+			continue
+		}
+		if len(lowest) > 0 && record.Priority < lowest[0].Priority {
+			lowest = lowest[:0]
+		}
+		lowest = append(lowest, record)
+	}
+	return lowest
+}
@@ -0,0 +1,215 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// A resolver answering from a map of "name type" to records, or failing if fail is set.
+type stubResolver struct {
+	records map[string][]Record
+	fail    bool
+}
+
+func (s *stubResolver) Lookup(ctx context.Context, name string, recordType string) ([]Record, error) {
+	if s.fail {
+		return nil, fmt.Errorf("Stub resolution failure.")
+	}
+
+	records, ok := s.records[name+" "+recordType]
+	if !ok {
+		return nil, fmt.Errorf("DNS name '%s' does not exist.", name)
+	}
+	return records, nil
+}
+
+func TestDNSDiscoveryReconciles(t *testing.T) {
+	resolver := &stubResolver{records: map[string][]Record{
+		"web.local A": {
+			{Host: "10.0.0.1", TTL: time.Second * 10},
+			{Host: "10.0.0.2", TTL: time.Second * 5},
+		},
+		"web.local AAAA": {
+			{Host: "fd00::1", TTL: time.Second * 60},
+		},
+	}}
+	// a static backend, which discovery must leave alone
+	target := newStubTarget(config.NewBackendInfo("static", 80))
+
+	p, err := NewDNSProvider(config.DNSDiscoveryConfig{Name: "web.local", Port: 8080}, resolver)
+	if err != nil {
+		t.Fatalf("Failed creating provider: %s", err.Error())
+	}
+	d := NewReconciler(p, target)
+
+	wait, err := d.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("Failed refresh: %s", err.Error())
+	}
+	checkIDs(t, "initial resolution", target.ids(), []string{"10.0.0.1:8080", "10.0.0.2:8080", "[fd00::1]:8080", "static:80"})
+
+	// the shortest TTL decides when to resolve again
+	if wait != time.Second*5 {
+		t.Errorf("Failed TTL: got %s expected 5s", wait)
+	}
+
+	resolver.records["web.local A"] = []Record{
+		{Host: "10.0.0.2", TTL: time.Second * 10},
+		{Host: "10.0.0.3", TTL: time.Second * 10},
+	}
+	delete(resolver.records, "web.local AAAA")
+
+	d.Refresh(context.Background())
+	checkIDs(t, "changed resolution", target.ids(), []string{"10.0.0.2:8080", "10.0.0.3:8080", "static:80"})
+}
+
+func TestDNSDiscoveryKeepsLastKnownGood(t *testing.T) {
+	resolver := &stubResolver{records: map[string][]Record{
+		"web.local A": {{Host: "10.0.0.1", TTL: time.Second}},
+	}}
+	target := newStubTarget()
+
+	p, _ := NewDNSProvider(config.DNSDiscoveryConfig{Name: "web.local", Type: "A4", Port: 80, Interval: time.Minute}, resolver)
+	d := NewReconciler(p, target)
+	d.Refresh(context.Background())
+
+	resolver.fail = true
+	wait, err := d.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed resolution failure: got no error")
+	}
+	if wait != time.Minute {
+		t.Errorf("Failed retry interval: got %s expected 1m0s", wait)
+	}
+	checkIDs(t, "failed resolution", target.ids(), []string{"10.0.0.1:80"})
+
+	// an empty answer is treated as a failure too
+	resolver.fail = false
+	resolver.records["web.local A"] = []Record{}
+	_, err = d.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed empty resolution: got no error")
+	}
+	checkIDs(t, "empty resolution", target.ids(), []string{"10.0.0.1:80"})
+}
+
+func TestDNSDiscoverySRV(t *testing.T) {
+	resolver := &stubResolver{records: map[string][]Record{
+		"_http._tcp.web.local SRV": {
+			{Host: "a.web.local", Port: 8001, Priority: 10, Weight: 3, TTL: time.Second * 30},
+			{Host: "b.web.local", Port: 8002, Priority: 10, Weight: 1, TTL: time.Second * 30},
+			// a backup, only used if the others are gone
+			{Host: "c.web.local", Port: 8003, Priority: 20, Weight: 1, TTL: time.Second * 30},
+		},
+	}}
+	target := newStubTarget()
+
+	p, _ := NewDNSProvider(config.DNSDiscoveryConfig{Name: "_http._tcp.web.local", Type: "SRV"}, resolver)
+	d := NewReconciler(p, target)
+	d.Refresh(context.Background())
+
+	checkIDs(t, "SRV resolution", target.ids(), []string{"a.web.local:8001", "b.web.local:8002"})
+	a := target.backends["a.web.local:8001"]
+	if w := a.GetWeight(); w != 3 {
+		t.Errorf("Failed SRV weight: got %d expected 3", w)
+	}
+
+	// a weight change re-adds the backend with its new weight
+	resolver.records["_http._tcp.web.local SRV"][0].Weight = 5
+	d.Refresh(context.Background())
+
+	a = target.backends["a.web.local:8001"]
+	if w := a.GetWeight(); w != 5 {
+		t.Errorf("Failed SRV weight change: got %d expected 5", w)
+	}
+}
+
+func TestDNSDiscoveryConfigValidation(t *testing.T) {
+	invalid := []config.DNSDiscoveryConfig{
+		{Port: 80},
+		{Name: "web.local"},
+		{Name: "web.local", Type: "MX", Port: 80},
+	}
+
+	for _, cfg := range invalid {
+		_, err := NewDNSProvider(cfg, &stubResolver{})
+		if err == nil {
+			t.Errorf("Failed validation: got no error for %+v", cfg)
+		}
+	}
+}
+
+// Starts a nameserver on a local UDP port answering every query with the given resources.
+func startTestNameserver(t *testing.T, answers func(q dnsmessage.Question) []dnsmessage.Resource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed starting nameserver: %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true},
+				Questions: query.Questions,
+				Answers:   answers(query.Questions[0]),
+			}
+			packed, _ := response.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolverTTLs(t *testing.T) {
+	nameserver := startTestNameserver(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 42}
+		switch q.Type {
+		case dnsmessage.TypeA:
+			return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}}}
+		case dnsmessage.TypeSRV:
+			target, _ := dnsmessage.NewName("a.web.local.")
+			return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.SRVResource{Target: target, Port: 8001, Priority: 1, Weight: 2}}}
+		}
+		return nil
+	})
+
+	resolver := NewDNSResolver(nameserver)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	records, err := resolver.Lookup(ctx, "web.local", "A")
+	if err != nil {
+		t.Fatalf("Failed A lookup: %s", err.Error())
+	}
+	if len(records) != 1 || records[0].Host != "10.0.0.1" || records[0].TTL != time.Second*42 {
+		t.Errorf("Failed A lookup: got %+v expected 10.0.0.1 with TTL 42s", records)
+	}
+
+	records, err = resolver.Lookup(ctx, "_http._tcp.web.local", "SRV")
+	if err != nil {
+		t.Fatalf("Failed SRV lookup: %s", err.Error())
+	}
+	expected := Record{Host: "a.web.local", Port: 8001, Priority: 1, Weight: 2, TTL: time.Second * 42}
+	if len(records) != 1 || records[0] != expected {
+		t.Errorf("Failed SRV lookup: got %+v expected %+v", records, expected)
+	}
+}
@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"os"
+	"time"
+)
+
+const defaultFileInterval = time.Second * 5
+
+// Discovers backends from a JSON or YAML file, like Prometheus file_sd.
+// The file is checked for changes on an interval, and only read again when it has changed.
+type fileProvider struct {
+	cfg config.FileDiscoveryConfig
+
+	// The modification time and size of the file when it was last read successfully
+	modTime time.Time
+	size    int64
+	// The backends last read from the file
+	backends []config.BackendInfo
+}
+
+// Creates a file provider from its config, checking it is valid.
+func NewFileProvider(cfg config.FileDiscoveryConfig) (Provider, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("File discovery is missing a path.")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultFileInterval
+	}
+
+	return &fileProvider{cfg: cfg}, nil
+}
+
+func (f *fileProvider) String() string {
+	return fmt.Sprintf("file %s", f.cfg.Path)
+}
+
+func (f *fileProvider) Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	stat, err := os.Stat(f.cfg.Path)
+	if err != nil {
+		return nil, f.cfg.Interval, err
+	}
+
+	if f.backends != nil && stat.ModTime().Equal(f.modTime) && stat.Size() == f.size {
+		return f.backends, f.cfg.Interval, nil
+	}
+
+	data, err := os.ReadFile(f.cfg.Path)
+	if err != nil {
+		return nil, f.cfg.Interval, err
+	}
+
+	backends, err := parseBackendList(data)
+	if err != nil {
+		return nil, f.cfg.Interval, err
+	}
+
+	f.modTime = stat.ModTime()
+	f.size = stat.Size()
+	f.backends = backends
+
+	return backends, f.cfg.Interval, nil
+}
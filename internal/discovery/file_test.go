@@ -0,0 +1,49 @@
+package discovery
+
+import (
+	"context"
+	"go-balancer/internal/balancer/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	os.WriteFile(path, []byte("- host: abc\n  port: 80\n- host: def\n  port: 80\n"), 0644)
+
+	p, err := NewFileProvider(config.FileDiscoveryConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed creating provider: %s", err.Error())
+	}
+	target := newStubTarget()
+	r := NewReconciler(p, target)
+
+	r.Refresh(context.Background())
+	checkIDs(t, "reading file", target.ids(), []string{"abc:80", "def:80"})
+
+	// rewrite the file as JSON, with a later modification time so the change is seen
+	os.WriteFile(path, []byte(`[{"targets": ["def:80", "ghi:80"]}]`), 0644)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	r.Refresh(context.Background())
+	checkIDs(t, "changed file", target.ids(), []string{"def:80", "ghi:80"})
+
+	// an invalid or missing file keeps the last known good backends
+	os.WriteFile(path, []byte("not: [a list"), 0644)
+	os.Chtimes(path, later.Add(time.Second), later.Add(time.Second))
+
+	_, err = r.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed invalid file: got no error")
+	}
+
+	os.Remove(path)
+	_, err = r.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed missing file: got no error")
+	}
+	checkIDs(t, "missing file", target.ids(), []string{"def:80", "ghi:80"})
+}
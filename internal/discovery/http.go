@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultHTTPInterval = time.Second * 30
+	defaultHTTPTimeout  = time.Second * 10
+)
+
+// Discovers backends by polling an HTTP endpoint returning a JSON or YAML list of backends.
+// The endpoint's ETag is sent back with If-None-Match, so unchanged lists arent sent again.
+type httpProvider struct {
+	cfg config.HTTPDiscoveryConfig
+
+	client *http.Client
+
+	// The ETag of the last list received, and the backends in it
+	etag     string
+	backends []config.BackendInfo
+}
+
+// Creates an HTTP provider from its config, checking it is valid.
+func NewHTTPProvider(cfg config.HTTPDiscoveryConfig) (Provider, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("HTTP discovery needs an http or https url, got '%s'.", cfg.URL)
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultHTTPInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHTTPTimeout
+	}
+
+	return &httpProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func (h *httpProvider) String() string {
+	return fmt.Sprintf("HTTP %s", h.cfg.URL)
+}
+
+func (h *httpProvider) Fetch(ctx context.Context) ([]config.BackendInfo, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.cfg.URL, nil)
+	if err != nil {
+		return nil, h.cfg.Interval, err
+	}
+
+	for name, value := range h.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Accept", "application/json, application/yaml")
+	if h.etag != "" && h.backends != nil {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, h.cfg.Interval, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && h.backends != nil {
+		return h.backends, h.cfg.Interval, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, h.cfg.Interval, fmt.Errorf("Unexpected status '%s' from %s.", res.Status, h.cfg.URL)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, h.cfg.Interval, err
+	}
+
+	backends, err := parseBackendList(data)
+	if err != nil {
+		return nil, h.cfg.Interval, err
+	}
+
+	h.etag = res.Header.Get("ETag")
+	h.backends = backends
+
+	return backends, h.cfg.Interval, nil
+}
@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"context"
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPProviderETag(t *testing.T) {
+	body := `[{"host": "abc", "port": 80}, {"host": "def", "port": 80}]`
+	etag := `"v1"`
+	requests, notModified := 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	p, err := NewHTTPProvider(config.HTTPDiscoveryConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating provider: %s", err.Error())
+	}
+	target := newStubTarget()
+	r := NewReconciler(p, target)
+
+	r.Refresh(context.Background())
+	checkIDs(t, "first poll", target.ids(), []string{"abc:80", "def:80"})
+
+	// unchanged, so the server only confirms the ETag
+	r.Refresh(context.Background())
+	if notModified != 1 {
+		t.Errorf("Failed ETag: got %d not modified responses expected 1", notModified)
+	}
+	checkIDs(t, "unchanged poll", target.ids(), []string{"abc:80", "def:80"})
+
+	body = `[{"host": "def", "port": 80}]`
+	etag = `"v2"`
+
+	r.Refresh(context.Background())
+	checkIDs(t, "changed poll", target.ids(), []string{"def:80"})
+
+	// errors keep the last known good backends
+	server.Close()
+	_, err = r.Refresh(context.Background())
+	if err == nil {
+		t.Error("Failed unreachable endpoint: got no error")
+	}
+	checkIDs(t, "failed poll", target.ids(), []string{"def:80"})
+
+	if requests != 3 {
+		t.Errorf("Failed request count: got %d expected 3", requests)
+	}
+}
+
+func TestHTTPProviderStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	p, _ := NewHTTPProvider(config.HTTPDiscoveryConfig{URL: server.URL})
+
+	_, _, err := p.Fetch(context.Background())
+	if err == nil {
+		t.Error("Failed error status: got no error")
+	}
+
+	_, err = NewHTTPProvider(config.HTTPDiscoveryConfig{URL: "ftp://example.com"})
+	if err == nil {
+		t.Error("Failed invalid url: got no error")
+	}
+}
@@ -0,0 +1,251 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// A resolved DNS record.
+type Record struct {
+	// The address for A/AAAA records, or the target name for SRV records.
+	Host string
+	// The port, priority and weight of SRV records.
+	Port     int
+	Priority int
+	Weight   int
+
+	// How long the record can be cached for.
+	TTL time.Duration
+}
+
+// Resolves DNS records, including their TTLs.
+type Resolver interface {
+	// Looks up records of a type ("A", "AAAA" or "SRV") for a name.
+	// Returns an error if the name doesnt exist or couldnt be resolved.
+	Lookup(ctx context.Context, name string, recordType string) ([]Record, error)
+}
+
+// Resolves records by querying nameservers directly, as the standard library resolver doesnt give TTLs.
+type dnsResolver struct {
+	// Nameservers as "host:port", tried in order until one answers
+	nameservers []string
+}
+
+// The nameserver used when none are configured, and /etc/resolv.conf has none.
+const defaultNameserver = "127.0.0.1:53"
+
+// Creates a resolver querying a nameserver, or those in /etc/resolv.conf if it is empty.
+func NewDNSResolver(nameserver string) Resolver {
+	if nameserver != "" {
+		return &dnsResolver{nameservers: []string{nameserver}}
+	}
+
+	nameservers, err := readResolvConf("/etc/resolv.conf")
+	if err != nil || len(nameservers) == 0 {
+		nameservers = []string{defaultNameserver}
+	}
+
+	return &dnsResolver{nameservers: nameservers}
+}
+
+// Reads the nameservers from a resolv.conf file.
+func readResolvConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nameservers := []string{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			nameservers = append(nameservers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+
+	return nameservers, scanner.Err()
+}
+
+func (r *dnsResolver) Lookup(ctx context.Context, name string, recordType string) ([]Record, error) {
+	var qtype dnsmessage.Type
+	switch recordType {
+	case "A":
+		qtype = dnsmessage.TypeA
+	case "AAAA":
+		qtype = dnsmessage.TypeAAAA
+	case "SRV":
+		qtype = dnsmessage.TypeSRV
+	default:
+		return nil, fmt.Errorf("Unsupported DNS record type '%s'.", recordType)
+	}
+
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DNS name '%s': %s", name, err.Error())
+	}
+
+	var lastErr error
+	for _, nameserver := range r.nameservers {
+		records, err := r.query(ctx, nameserver, qname, qtype)
+		if err == nil {
+			return records, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Makes a query to a nameserver over UDP, retrying over TCP if the answer was truncated.
+func (r *dnsResolver) query(ctx context.Context, nameserver string, qname dnsmessage.Name, qtype dnsmessage.Type) ([]Record, error) {
+	id := uint16(rand.Intn(1 << 16))
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := exchange(ctx, "udp", nameserver, packed)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dnsmessage.Message
+	err = msg.Unpack(answer)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DNS answer from %s: %s", nameserver, err.Error())
+	}
+
+	if msg.Truncated {
+		answer, err = exchange(ctx, "tcp", nameserver, packed)
+		if err != nil {
+			return nil, err
+		}
+		err = msg.Unpack(answer)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DNS answer from %s: %s", nameserver, err.Error())
+		}
+	}
+
+	if msg.ID != id {
+		return nil, fmt.Errorf("Mismatched DNS answer ID from %s.", nameserver)
+	}
+	if msg.RCode == dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("DNS name '%s' does not exist.", qname.String())
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DNS query for '%s' failed: %s", qname.String(), msg.RCode.String())
+	}
+
+	return parseAnswers(msg.Answers, qtype), nil
+}
+
+// Sends a packed query to a nameserver and reads the answer.
+// TCP messages are prefixed by their length.
+func exchange(ctx context.Context, network string, nameserver string, packed []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		_, err = conn.Write(packed)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	framed := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(framed, uint16(len(packed)))
+	copy(framed[2:], packed)
+
+	_, err = conn.Write(framed)
+	if err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	_, err = io.ReadFull(conn, length[:])
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Gets the records of a type from a DNS answer.
+// Other records, such as the CNAMEs followed to reach them, are ignored.
+func parseAnswers(answers []dnsmessage.Resource, qtype dnsmessage.Type) []Record {
+	records := []Record{}
+
+	for _, answer := range answers {
+		if answer.Header.Type != qtype {
+			continue
+		}
+
+		record := Record{TTL: time.Duration(answer.Header.TTL) * time.Second}
+
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			record.Host = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			record.Host = net.IP(body.AAAA[:]).String()
+		case *dnsmessage.SRVResource:
+			record.Host = strings.TrimSuffix(body.Target.String(), ".")
+			record.Port = int(body.Port)
+			record.Priority = int(body.Priority)
+			record.Weight = int(body.Weight)
+		default:
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// Makes a name fully qualified, as queries need names ending in a dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -3,7 +3,6 @@ package hashing
 import (
 	"errors"
 	"fmt"
-	"hash/maphash"
 )
 
 type ringNode struct {
@@ -11,21 +10,27 @@ type ringNode struct {
 	valueHash uint64
 }
 
+// Consistent hashing using a ring of virtual nodes.
 type ConsistentHash[T any] struct {
 	ring []ringNode
 
 	values map[uint64]T
 
+	// The current load on each value, used for lookups with bounded loads.
+	loads loadTracker
+
 	// A function that turns a value into a string representation
 	stringifier func(T) string
 
-	seed maphash.Seed
+	// Hashes keys, values and virtual nodes
+	hash HashFunc
 }
 
-func NewConsistentHash[T any](stringifier func(T) string) ConsistentHash[T] {
+func NewConsistentHash[T any](stringifier func(T) string, hash HashFunc) ConsistentHash[T] {
 	return ConsistentHash[T]{
 		values:      make(map[uint64]T),
-		seed:        maphash.MakeSeed(),
+		loads:       newLoadTracker(),
+		hash:        hash,
 		stringifier: stringifier,
 	}
 }
@@ -67,14 +72,15 @@ func (c *ConsistentHash[T]) findRingIndexGreaterThan(hash uint64) int {
 func (c *ConsistentHash[T]) Add(value T, replicas int) {
 	// calculate string and hashes for value, insert to map
 	valueString := c.stringifier(value)
-	valueHash := maphash.String(c.seed, valueString)
+	valueHash := c.hash(valueString)
 
 	c.values[valueHash] = value
 
 	// insert virtual nodes into ring
+	// the separator stops the nodes of values like "a1" and "a" colliding, which would make the ring depend on insertion order
 	for i := 0; i < replicas; i++ {
 		node := ringNode{
-			hash:      maphash.String(c.seed, valueString+fmt.Sprint(i)),
+			hash:      c.hash(valueString + "#" + fmt.Sprint(i)),
 			valueHash: valueHash,
 		}
 
@@ -101,7 +107,14 @@ func (c *ConsistentHash[T]) insertNode(node ringNode) {
 
 func (c *ConsistentHash[T]) Remove(value T) {
 	valueString := c.stringifier(value)
-	valueHash := maphash.String(c.seed, valueString)
+	valueHash := c.hash(valueString)
+
+	if _, ok := c.values[valueHash]; !ok {
+		return
+	}
+
+	delete(c.values, valueHash)
+	c.loads.forget(valueHash)
 
 	// walk through ring, removing all vnodes
 	toRemove := make([]int, 0, len(c.ring))
@@ -111,6 +124,10 @@ func (c *ConsistentHash[T]) Remove(value T) {
 		}
 	}
 
+	if len(toRemove) == 0 {
+		return
+	}
+
 	newRing := make([]ringNode, 0, len(c.ring)-len(toRemove))
 	if toRemove[0] != 0 {
 		newRing = append(newRing, c.ring[0:toRemove[0]]...)
@@ -145,4 +162,73 @@ func (c *ConsistentHash[T]) RingLookup(i uint64) T {
 	return c.values[c.ring[ringIndex].valueHash]
 }
 
+// Hashes a string with the same function used to place nodes on the ring.
+// Used to turn lookup keys into hashes.
+func (c *ConsistentHash[T]) Hash(s string) uint64 {
+	return c.hash(s)
+}
+
+// The number of distinct values in the ring.
+func (c *ConsistentHash[T]) Len() int {
+	return len(c.values)
+}
+
+// Walks the ring from a hash, returning the first value accept returns true for.
+// accept may be nil to accept any value.
+//
+// Returns false if the ring is empty, or no value was accepted.
+func (c *ConsistentHash[T]) LookupFunc(i uint64, accept func(T) bool) (T, bool) {
+	var zero T
+
+	if len(c.ring) == 0 {
+		return zero, false
+	}
+
+	start := c.findRingIndexGreaterThan(i)
+
+	for step := 0; step < len(c.ring); step++ {
+		value := c.values[c.ring[(start+step)%len(c.ring)].valueHash]
+
+		if accept == nil || accept(value) {
+			return value, true
+		}
+	}
+
+	return zero, false
+}
+
+// Lookup a hash in the ring, using consistent hashing with bounded loads.
+//
+// Walks the ring from the hash to the first value whose load is under (1+epsilon) times the average load,
+// counting the load of the request being looked up.
+// This keeps most of the locality of plain consistent hashing while capping how overloaded a value can get.
+// accept may be nil, else values it returns false for are skipped.
+//
+// Returns false if the ring is empty, or no value was accepted.
+func (c *ConsistentHash[T]) BoundedLookup(i uint64, epsilon float64, accept func(T) bool) (T, bool) {
+	return boundedLookup(c.LookupFunc, &c.loads, c.valueHash, len(c.values), i, epsilon, accept)
+}
+
+func (c *ConsistentHash[T]) valueHash(value T) uint64 {
+	return c.hash(c.stringifier(value))
+}
+
+// Adds to the load on a value, e.g. when a request is sent to it.
+func (c *ConsistentHash[T]) IncrementLoad(value T) {
+	valueHash := c.valueHash(value)
+	if _, ok := c.values[valueHash]; ok {
+		c.loads.increment(valueHash)
+	}
+}
+
+// Removes from the load on a value, e.g. when a request to it finishes.
+func (c *ConsistentHash[T]) DecrementLoad(value T) {
+	c.loads.decrement(c.valueHash(value))
+}
+
+// Gets the current load on a value.
+func (c *ConsistentHash[T]) GetLoad(value T) int {
+	return c.loads.get(c.valueHash(value))
+}
+
 ///// TODO: could make insertNode into bulk operation inserting lists of nodes at once
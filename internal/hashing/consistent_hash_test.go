@@ -8,7 +8,7 @@ import (
 func TestConsistentHashAdd(t *testing.T) {
 	ch := NewConsistentHash[int](func(i int) string {
 		return fmt.Sprint(i)
-	})
+	}, XXHash(0))
 
 	ch.Add(10, 1)
 
@@ -32,7 +32,7 @@ func TestConsistentHashAdd(t *testing.T) {
 func TestConsistentHashRemove(t *testing.T) {
 	ch := NewConsistentHash[int](func(i int) string {
 		return fmt.Sprint(i)
-	})
+	}, XXHash(0))
 
 	ch.Add(10, 1)
 	ch.Add(20, 1)
@@ -50,3 +50,74 @@ func TestConsistentHashRemove(t *testing.T) {
 		t.Errorf("Failed ring len after remove: expected 1 got %d\n", len(ch.ring))
 	}
 }
+
+func TestConsistentHashBoundedLookup(t *testing.T) {
+	ch := NewConsistentHash[int](func(i int) string {
+		return fmt.Sprint(i)
+	}, XXHash(0))
+
+	for i := 0; i < 4; i++ {
+		ch.Add(i, 50)
+	}
+
+	// every lookup is for the same key, so plain consistent hashing would put all the load on one value
+	const requests = 100
+	const epsilon = 0.25
+	for i := 0; i < requests; i++ {
+		v, ok := ch.BoundedLookup(12345, epsilon, nil)
+		if !ok {
+			t.Fatalf("Failed bounded lookup: no value found")
+		}
+		ch.IncrementLoad(v)
+	}
+
+	// each value can hold at most ceil(1.25 * 100 / 4) = 32
+	for i := 0; i < 4; i++ {
+		if load := ch.GetLoad(i); load > 32 {
+			t.Errorf("Failed bounded load for %d: got %d expected at most 32", i, load)
+		}
+	}
+
+	// the key's own value should be the one filled first
+	plain, _ := ch.LookupFunc(12345, nil)
+	if load := ch.GetLoad(plain); load != 32 {
+		t.Errorf("Failed fill nearest value first: got load %d expected 32", load)
+	}
+
+	for i := 0; i < 10; i++ {
+		ch.DecrementLoad(plain)
+	}
+	if load := ch.GetLoad(plain); load != 22 {
+		t.Errorf("Failed decrement load: got %d expected 22", load)
+	}
+}
+
+func TestConsistentHashLookupFunc(t *testing.T) {
+	ch := NewConsistentHash[int](func(i int) string {
+		return fmt.Sprint(i)
+	}, XXHash(0))
+
+	if _, ok := ch.LookupFunc(100, nil); ok {
+		t.Error("Failed lookup on empty ring: expected not found")
+	}
+
+	ch.Add(10, 5)
+	ch.Add(20, 5)
+
+	for i := uint64(0); i < 20; i++ {
+		v, ok := ch.LookupFunc(i*1000003, func(v int) bool { return v != 10 })
+		if !ok || v != 20 {
+			t.Errorf("Failed lookup skipping value: got %d %t expected 20", v, ok)
+		}
+	}
+
+	if _, ok := ch.BoundedLookup(100, 0.25, func(v int) bool { return false }); ok {
+		t.Error("Failed bounded lookup accepting nothing: expected not found")
+	}
+
+	ch.IncrementLoad(10)
+	ch.Remove(10)
+	if ch.Len() != 1 || ch.loads.total != 0 {
+		t.Errorf("Failed remove clears value and load: got len %d total load %d", ch.Len(), ch.loads.total)
+	}
+}
@@ -0,0 +1,66 @@
+package hashing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Hashes a string into 64 bits.
+//
+// Lookups use the same function for keys and values, so it must be the same on every replica
+// for them to agree on which value a key maps to.
+type HashFunc func(s string) uint64
+
+// Creates a hash function by name: "xxhash" (the default) or "fnv".
+// Both give the same hashes in every process, and across restarts, for the same seed.
+func NewHashFunc(name string, seed uint64) (HashFunc, error) {
+	switch name {
+	case "", "xxhash":
+		return XXHash(seed), nil
+	case "fnv":
+		return FNV(seed), nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized hash function '%s'.", name)
+}
+
+// Seeded 64 bit xxHash.
+func XXHash(seed uint64) HashFunc {
+	if seed == 0 {
+		return xxhash.Sum64String
+	}
+
+	return func(s string) uint64 {
+		d := xxhash.NewWithSeed(seed)
+		d.WriteString(s)
+		return d.Sum64()
+	}
+}
+
+// 64 bit FNV-1a of the seed followed by the string.
+// FNV mixes the last bytes poorly, so the result is put through a finaliser
+// to spread similar strings (e.g. "backend-1" and "backend-2") around the ring.
+func FNV(seed uint64) HashFunc {
+	var seedBytes [8]byte
+	binary.LittleEndian.PutUint64(seedBytes[:], seed)
+
+	return func(s string) uint64 {
+		h := fnv.New64a()
+		h.Write(seedBytes[:])
+		h.Write([]byte(s))
+		return mix64(h.Sum64())
+	}
+}
+
+// Mixes the bits of x, so each input bit affects every output bit (the splitmix64 finaliser).
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
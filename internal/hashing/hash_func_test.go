@@ -0,0 +1,104 @@
+package hashing
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Hashes must never change between versions, or replicas running different versions
+// (and restarts after upgrading) would map keys differently.
+func TestHashFuncGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     uint64
+		expected uint64
+	}{
+		{"xxhash", 0, 0x98e0dddf79a56881},
+		{"xxhash", 42, 0xfa43d98b49b7a2cd},
+		{"fnv", 0, 0x5972e84859e63632},
+		{"fnv", 42, 0x761dc931f6532d1},
+	}
+
+	for _, test := range tests {
+		hash, err := NewHashFunc(test.name, test.seed)
+		if err != nil {
+			t.Fatalf("Failed creating %s hash: %s", test.name, err.Error())
+		}
+
+		if got := hash("gobal"); got != test.expected {
+			t.Errorf("Failed %s hash with seed %d: got %#x expected %#x", test.name, test.seed, got, test.expected)
+		}
+	}
+
+	if _, err := NewHashFunc("md5", 0); err == nil {
+		t.Errorf("Failed new hash func: expected an error for an unrecognized function")
+	}
+}
+
+// Tests that separately created lookups, as on two replicas or before and after a restart,
+// map every key to the same value, even when values are added in a different order.
+func TestLookupCrossInstanceStability(t *testing.T) {
+	keys := testKeys(10000)
+
+	for _, name := range []string{"xxhash", "fnv"} {
+		for _, algorithm := range lookupAlgorithms {
+			instances := make([]Lookup[string], 2)
+			for i := range instances {
+				hash, _ := NewHashFunc(name, 7)
+				lookup, err := NewLookup(algorithm, func(s string) string {
+					return s
+				}, hash)
+				if err != nil {
+					t.Fatalf("Failed creating %s lookup: %s", algorithm, err.Error())
+				}
+
+				for j := 0; j < 10; j++ {
+					// the second instance adds values in reverse order
+					value := j
+					if i == 1 {
+						value = 9 - j
+					}
+					lookup.Add(fmt.Sprintf("10.0.0.%d:8080", value+1), lookupWeight(algorithm))
+				}
+
+				instances[i] = lookup
+			}
+
+			first := lookupAll(instances[0], keys)
+			second := lookupAll(instances[1], keys)
+			for i := range keys {
+				if first[i] != second[i] {
+					t.Errorf("Failed %s %s stability for '%s': got %s and %s expected the same value", name, algorithm, keys[i], first[i], second[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+// Pins some key to value mappings, so a change to any algorithm that would remap keys across versions is caught.
+func TestLookupGoldenMappings(t *testing.T) {
+	keys := []string{"user-1", "user-2", "user-3", "user-4", "user-5", "user-6"}
+
+	expected := map[string][]string{
+		"ring":       {"10.0.0.2:8080", "10.0.0.4:8080", "10.0.0.3:8080", "10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.4:8080"},
+		"maglev":     {"10.0.0.1:8080", "10.0.0.5:8080", "10.0.0.3:8080", "10.0.0.5:8080", "10.0.0.1:8080", "10.0.0.5:8080"},
+		"rendezvous": {"10.0.0.5:8080", "10.0.0.3:8080", "10.0.0.1:8080", "10.0.0.5:8080", "10.0.0.4:8080", "10.0.0.4:8080"},
+	}
+
+	for _, algorithm := range lookupAlgorithms {
+		lookup, _ := NewLookup(algorithm, func(s string) string {
+			return s
+		}, XXHash(7))
+		for i := 0; i < 5; i++ {
+			lookup.Add(fmt.Sprintf("10.0.0.%d:8080", i+1), lookupWeight(algorithm))
+		}
+
+		got := lookupAll(lookup, keys)
+		for i := range keys {
+			if got[i] != expected[algorithm][i] {
+				t.Errorf("Failed %s mapping for '%s': got %s expected %s", algorithm, keys[i], got[i], expected[algorithm][i])
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package hashing
+
+import (
+	"fmt"
+	"math"
+)
+
+// Maps hashed keys onto a set of values, such that adding or removing a value moves few keys.
+//
+// Implemented by ConsistentHash (a ring of virtual nodes), Maglev (a lookup table) and Rendezvous (highest random weight).
+// None of the implementations are safe for concurrent use.
+type Lookup[T any] interface {
+	// Adds a value. A higher weight gives the value a bigger share of keys.
+	Add(value T, weight int)
+	// Removes a value, if present.
+	Remove(value T)
+	// The number of distinct values.
+	Len() int
+
+	// Hashes a string into a key for lookups.
+	Hash(s string) uint64
+
+	// Finds the value for a key, skipping values accept returns false for.
+	// accept may be nil to accept any value.
+	// Returns false if there are no values, or none are accepted.
+	LookupFunc(key uint64, accept func(T) bool) (T, bool)
+
+	// Like LookupFunc, but also skips values whose load is at least (1+epsilon) times the average load.
+	BoundedLookup(key uint64, epsilon float64, accept func(T) bool) (T, bool)
+
+	// Track the load on each value, for BoundedLookup.
+	IncrementLoad(value T)
+	DecrementLoad(value T)
+	GetLoad(value T) int
+}
+
+// Creates a Lookup using the named algorithm: "ring" (the default), "maglev" or "rendezvous".
+//
+// Lookups built with the same algorithm, hash function and values map keys identically,
+// whichever order the values were added in.
+func NewLookup[T any](algorithm string, stringifier func(T) string, hash HashFunc) (Lookup[T], error) {
+	switch algorithm {
+	case "", "ring":
+		ch := NewConsistentHash(stringifier, hash)
+		return &ch, nil
+	case "maglev":
+		return NewMaglev(stringifier, hash, DefaultMaglevTableSize), nil
+	case "rendezvous":
+		return NewRendezvous(stringifier, hash), nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized hashing algorithm '%s'.", algorithm)
+}
+
+// Tracks the load on each value, by value hash.
+type loadTracker struct {
+	loads map[uint64]int
+	total int
+}
+
+func newLoadTracker() loadTracker {
+	return loadTracker{
+		loads: make(map[uint64]int),
+	}
+}
+
+func (lt *loadTracker) increment(valueHash uint64) {
+	lt.loads[valueHash]++
+	lt.total++
+}
+
+func (lt *loadTracker) decrement(valueHash uint64) {
+	if lt.loads[valueHash] <= 0 {
+		return
+	}
+
+	lt.loads[valueHash]--
+	lt.total--
+}
+
+func (lt *loadTracker) get(valueHash uint64) int {
+	return lt.loads[valueHash]
+}
+
+// Forgets a value's load, e.g. when it is removed.
+func (lt *loadTracker) forget(valueHash uint64) {
+	lt.total -= lt.loads[valueHash]
+	delete(lt.loads, valueHash)
+}
+
+// The most load a value can have while still accepting more, when there are count values.
+// Counts the load of the request being looked up.
+func (lt *loadTracker) capacity(epsilon float64, count int) int {
+	return int(math.Ceil((1 + epsilon) * float64(lt.total+1) / float64(count)))
+}
+
+// Implements bounded lookups on top of a LookupFunc.
+//
+// Finds the first value under capacity, falling back to ignoring load if every accepted value is over it.
+// This can only happen when some values are not accepted.
+func boundedLookup[T any](
+	lookupFunc func(uint64, func(T) bool) (T, bool),
+	lt *loadTracker,
+	valueHash func(T) uint64,
+	count int,
+	key uint64,
+	epsilon float64,
+	accept func(T) bool,
+) (T, bool) {
+	if count == 0 {
+		var zero T
+		return zero, false
+	}
+
+	capacity := lt.capacity(epsilon, count)
+
+	value, found := lookupFunc(key, func(v T) bool {
+		if lt.get(valueHash(v)) >= capacity {
+			return false
+		}
+		return accept == nil || accept(v)
+	})
+
+	if !found {
+		return lookupFunc(key, accept)
+	}
+
+	return value, true
+}
@@ -0,0 +1,262 @@
+package hashing
+
+import (
+	"fmt"
+	"testing"
+)
+
+var lookupAlgorithms = []string{"ring", "maglev", "rendezvous"}
+
+// The weight each algorithm needs for an even spread, the ring needs many virtual nodes.
+func lookupWeight(algorithm string) int {
+	if algorithm == "ring" {
+		return 100
+	}
+	return 1
+}
+
+func newTestLookup(t testing.TB, algorithm string, values int) Lookup[string] {
+	lookup, err := NewLookup(algorithm, func(s string) string {
+		return s
+	}, XXHash(0))
+	if err != nil {
+		t.Fatalf("Failed creating %s lookup: %s", algorithm, err.Error())
+	}
+
+	for i := 0; i < values; i++ {
+		lookup.Add(fmt.Sprintf("backend-%d", i), lookupWeight(algorithm))
+	}
+
+	return lookup
+}
+
+// Looks up every key, returning the value each maps to.
+func lookupAll(lookup Lookup[string], keys []string) []string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i], _ = lookup.LookupFunc(lookup.Hash(key), nil)
+	}
+	return values
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
+
+func TestNewLookupUnrecognized(t *testing.T) {
+	_, err := NewLookup("jump", func(s string) string { return s }, XXHash(0))
+	if err == nil {
+		t.Errorf("Failed new lookup: expected an error for an unrecognized algorithm")
+	}
+}
+
+func TestLookupEmpty(t *testing.T) {
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 0)
+
+		if _, found := lookup.LookupFunc(lookup.Hash("a"), nil); found {
+			t.Errorf("Failed %s empty lookup: expected nothing found", algorithm)
+		}
+		if _, found := lookup.BoundedLookup(lookup.Hash("a"), 0.25, nil); found {
+			t.Errorf("Failed %s empty bounded lookup: expected nothing found", algorithm)
+		}
+	}
+}
+
+func TestLookupAccept(t *testing.T) {
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 5)
+
+		for _, key := range testKeys(100) {
+			v, found := lookup.LookupFunc(lookup.Hash(key), func(s string) bool {
+				return s == "backend-3"
+			})
+			if !found || v != "backend-3" {
+				t.Errorf("Failed %s lookup with accept: got %s expected backend-3", algorithm, v)
+				break
+			}
+		}
+
+		_, found := lookup.LookupFunc(lookup.Hash("a"), func(s string) bool { return false })
+		if found {
+			t.Errorf("Failed %s lookup rejecting everything: expected nothing found", algorithm)
+		}
+	}
+}
+
+func TestLookupRemove(t *testing.T) {
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 3)
+
+		lookup.Remove("backend-0")
+		lookup.Remove("backend-1")
+		lookup.Remove("missing")
+
+		if lookup.Len() != 1 {
+			t.Errorf("Failed %s len after remove: got %d expected 1", algorithm, lookup.Len())
+		}
+
+		for _, v := range lookupAll(lookup, testKeys(100)) {
+			if v != "backend-2" {
+				t.Errorf("Failed %s lookup after remove: got %s expected backend-2", algorithm, v)
+				break
+			}
+		}
+	}
+}
+
+func TestLookupBoundedLoad(t *testing.T) {
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 4)
+
+		// every request has the same key and stays in flight, so it has to be spread by the load bound
+		for i := 0; i < 40; i++ {
+			v, found := lookup.BoundedLookup(lookup.Hash("same"), 0.25, nil)
+			if !found {
+				t.Fatalf("Failed %s bounded lookup: expected a value", algorithm)
+			}
+			lookup.IncrementLoad(v)
+		}
+
+		for i := 0; i < 4; i++ {
+			load := lookup.GetLoad(fmt.Sprintf("backend-%d", i))
+			if load > 13 {
+				t.Errorf("Failed %s bounded load: got %d expected at most 13", algorithm, load)
+			}
+		}
+	}
+}
+
+// Tests that keys are spread evenly across values.
+func TestLookupDistribution(t *testing.T) {
+	keys := testKeys(10000)
+
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 10)
+
+		counts := make(map[string]int)
+		for _, v := range lookupAll(lookup, keys) {
+			counts[v]++
+		}
+
+		for v, count := range counts {
+			// each value should get about 1000 keys
+			if count < 700 || count > 1300 {
+				t.Errorf("Failed %s distribution: %s got %d keys expected about 1000", algorithm, v, count)
+			}
+		}
+	}
+}
+
+// Tests how many keys move when a value is added or removed.
+// Ideally only 1/11 of keys move to an added value, and only the removed value's keys move.
+func TestLookupDisruption(t *testing.T) {
+	keys := testKeys(10000)
+
+	for _, algorithm := range lookupAlgorithms {
+		lookup := newTestLookup(t, algorithm, 10)
+		before := lookupAll(lookup, keys)
+
+		lookup.Add("backend-10", lookupWeight(algorithm))
+		afterAdd := lookupAll(lookup, keys)
+
+		moved := 0
+		unnecessary := 0
+		for i := range keys {
+			if before[i] != afterAdd[i] {
+				moved++
+				if afterAdd[i] != "backend-10" {
+					unnecessary++
+				}
+			}
+		}
+		addFraction := float64(moved) / float64(len(keys))
+		checkUnnecessaryMoves(t, algorithm, "add", unnecessary, len(keys))
+
+		lookup.Remove("backend-10")
+		lookup.Remove("backend-3")
+		afterRemove := lookupAll(lookup, keys)
+
+		moved = 0
+		unnecessary = 0
+		for i := range keys {
+			if before[i] != afterRemove[i] {
+				moved++
+				if before[i] != "backend-3" {
+					unnecessary++
+				}
+			}
+		}
+		removeFraction := float64(moved) / float64(len(keys))
+		checkUnnecessaryMoves(t, algorithm, "remove", unnecessary, len(keys))
+
+		t.Logf("%s: %.3f of keys moved on add, %.3f on remove", algorithm, addFraction, removeFraction)
+
+		if addFraction > 0.15 {
+			t.Errorf("Failed %s disruption on add: got %.3f of keys moved expected at most 0.15", algorithm, addFraction)
+		}
+		if removeFraction > 0.15 {
+			t.Errorf("Failed %s disruption on remove: got %.3f of keys moved expected at most 0.15", algorithm, removeFraction)
+		}
+	}
+}
+
+// Checks how many keys moved between values that were not added or removed.
+// Maglev may move a few, the others should move none.
+func checkUnnecessaryMoves(t *testing.T, algorithm string, change string, unnecessary int, keys int) {
+	if algorithm != "maglev" && unnecessary > 0 {
+		t.Errorf("Failed %s disruption on %s: got %d keys moved between other values expected 0", algorithm, change, unnecessary)
+	}
+
+	fraction := float64(unnecessary) / float64(keys)
+	if fraction > 0.02 {
+		t.Errorf("Failed %s disruption on %s: got %.3f of keys moved between other values expected at most 0.02", algorithm, change, fraction)
+	}
+}
+
+func benchmarkLookup(b *testing.B, algorithm string, values int) {
+	lookup := newTestLookup(b, algorithm, values)
+	keys := testKeys(1024)
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = lookup.Hash(key)
+	}
+
+	// build any lazy state before timing
+	lookup.LookupFunc(0, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup.LookupFunc(hashes[i%len(hashes)], nil)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, algorithm := range lookupAlgorithms {
+		for _, values := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/%d", algorithm, values), func(b *testing.B) {
+				benchmarkLookup(b, algorithm, values)
+			})
+		}
+	}
+}
+
+func BenchmarkLookupAdd(b *testing.B) {
+	for _, algorithm := range lookupAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			lookup := newTestLookup(b, algorithm, 10)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// adding and looking up includes rebuilding maglev's table
+				lookup.Add("extra", lookupWeight(algorithm))
+				lookup.LookupFunc(0, nil)
+				lookup.Remove("extra")
+			}
+		})
+	}
+}
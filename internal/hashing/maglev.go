@@ -0,0 +1,202 @@
+package hashing
+
+import (
+	"sort"
+)
+
+// The default Maglev lookup table size. Should be prime, and much larger than the number of values.
+const DefaultMaglevTableSize = 65537
+
+type maglevValue struct {
+	hash   uint64
+	name   string
+	weight int
+}
+
+// Maglev consistent hashing, from Google's Maglev load balancer.
+//
+// Each value generates a permutation of the slots in a lookup table, and values take turns
+// claiming their next preferred free slot until the table is full.
+// Lookups are a single table index, and keys are spread almost perfectly evenly,
+// at the cost of slightly more keys moving than with a ring when values change.
+type Maglev[T any] struct {
+	tableSize int
+	// Slot to value hash, rebuilt lazily after values change
+	table []uint64
+	dirty bool
+
+	values  map[uint64]T
+	weights map[uint64]int
+
+	loads loadTracker
+
+	// A function that turns a value into a string representation
+	stringifier func(T) string
+
+	// Hashes keys, values and their permutations
+	hash HashFunc
+}
+
+// Creates an empty Maglev lookup, with a table of tableSize slots.
+// tableSize should be prime for every permutation to cover the whole table.
+func NewMaglev[T any](stringifier func(T) string, hash HashFunc, tableSize int) *Maglev[T] {
+	return &Maglev[T]{
+		tableSize:   tableSize,
+		values:      make(map[uint64]T),
+		weights:     make(map[uint64]int),
+		loads:       newLoadTracker(),
+		stringifier: stringifier,
+		hash:        hash,
+	}
+}
+
+func (m *Maglev[T]) valueHash(value T) uint64 {
+	return m.hash(m.stringifier(value))
+}
+
+// Adds a value. Each turn of populating the table, the value claims weight slots.
+func (m *Maglev[T]) Add(value T, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+
+	valueHash := m.valueHash(value)
+	m.values[valueHash] = value
+	m.weights[valueHash] = weight
+	m.dirty = true
+}
+
+func (m *Maglev[T]) Remove(value T) {
+	valueHash := m.valueHash(value)
+	if _, ok := m.values[valueHash]; !ok {
+		return
+	}
+
+	delete(m.values, valueHash)
+	delete(m.weights, valueHash)
+	m.loads.forget(valueHash)
+	m.dirty = true
+}
+
+func (m *Maglev[T]) Len() int {
+	return len(m.values)
+}
+
+func (m *Maglev[T]) Hash(s string) uint64 {
+	return m.hash(s)
+}
+
+// Fills the lookup table from the current values.
+func (m *Maglev[T]) populate() {
+	m.dirty = false
+
+	if len(m.values) == 0 {
+		m.table = nil
+		return
+	}
+
+	// order values by name, so the table only depends on the set of values and not the order they were added
+	values := make([]maglevValue, 0, len(m.values))
+	for valueHash, value := range m.values {
+		values = append(values, maglevValue{
+			hash:   valueHash,
+			name:   m.stringifier(value),
+			weight: m.weights[valueHash],
+		})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].name < values[j].name
+	})
+
+	size := uint64(m.tableSize)
+
+	// each value's permutation is offset + j*skip, for its jth preference
+	offsets := make([]uint64, len(values))
+	skips := make([]uint64, len(values))
+	next := make([]uint64, len(values))
+	for i, v := range values {
+		offsets[i] = m.hash(v.name+"#offset") % size
+		skips[i] = m.hash(v.name+"#skip")%(size-1) + 1
+	}
+
+	table := make([]uint64, m.tableSize)
+	filled := make([]bool, m.tableSize)
+	remaining := m.tableSize
+
+	for remaining > 0 {
+		for i, v := range values {
+			for turn := 0; turn < v.weight && remaining > 0; turn++ {
+				// find the value's next preferred slot that is still free
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for filled[slot] {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+
+				table[slot] = v.hash
+				filled[slot] = true
+				next[i]++
+				remaining--
+			}
+		}
+	}
+
+	m.table = table
+}
+
+// Finds the value in the key's table slot.
+// If accept rejects it, walks forward through the table to the next accepted value.
+func (m *Maglev[T]) LookupFunc(key uint64, accept func(T) bool) (T, bool) {
+	var zero T
+
+	if m.dirty {
+		m.populate()
+	}
+
+	if len(m.table) == 0 {
+		return zero, false
+	}
+
+	start := key % uint64(len(m.table))
+
+	// remember rejected values, so each is only checked once
+	var rejected map[uint64]bool
+
+	for step := uint64(0); step < uint64(len(m.table)) && len(rejected) < len(m.values); step++ {
+		valueHash := m.table[(start+step)%uint64(len(m.table))]
+		if rejected[valueHash] {
+			continue
+		}
+
+		value := m.values[valueHash]
+		if accept == nil || accept(value) {
+			return value, true
+		}
+
+		if rejected == nil {
+			rejected = make(map[uint64]bool)
+		}
+		rejected[valueHash] = true
+	}
+
+	return zero, false
+}
+
+func (m *Maglev[T]) BoundedLookup(key uint64, epsilon float64, accept func(T) bool) (T, bool) {
+	return boundedLookup(m.LookupFunc, &m.loads, m.valueHash, len(m.values), key, epsilon, accept)
+}
+
+func (m *Maglev[T]) IncrementLoad(value T) {
+	valueHash := m.valueHash(value)
+	if _, ok := m.values[valueHash]; ok {
+		m.loads.increment(valueHash)
+	}
+}
+
+func (m *Maglev[T]) DecrementLoad(value T) {
+	m.loads.decrement(m.valueHash(value))
+}
+
+func (m *Maglev[T]) GetLoad(value T) int {
+	return m.loads.get(m.valueHash(value))
+}
@@ -0,0 +1,126 @@
+package hashing
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous, or highest random weight (HRW), hashing.
+//
+// Every value is scored against the key, and the highest score wins.
+// Only keys whose winner is added or removed move, but lookups are linear in the number of values.
+type Rendezvous[T any] struct {
+	values  map[uint64]T
+	weights map[uint64]int
+
+	loads loadTracker
+
+	// A function that turns a value into a string representation
+	stringifier func(T) string
+
+	// Hashes keys and values
+	hash HashFunc
+}
+
+func NewRendezvous[T any](stringifier func(T) string, hash HashFunc) *Rendezvous[T] {
+	return &Rendezvous[T]{
+		values:      make(map[uint64]T),
+		weights:     make(map[uint64]int),
+		loads:       newLoadTracker(),
+		stringifier: stringifier,
+		hash:        hash,
+	}
+}
+
+func (rv *Rendezvous[T]) valueHash(value T) uint64 {
+	return rv.hash(rv.stringifier(value))
+}
+
+// Adds a value. Its score against every key is scaled by weight.
+func (rv *Rendezvous[T]) Add(value T, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+
+	valueHash := rv.valueHash(value)
+	rv.values[valueHash] = value
+	rv.weights[valueHash] = weight
+}
+
+func (rv *Rendezvous[T]) Remove(value T) {
+	valueHash := rv.valueHash(value)
+	if _, ok := rv.values[valueHash]; !ok {
+		return
+	}
+
+	delete(rv.values, valueHash)
+	delete(rv.weights, valueHash)
+	rv.loads.forget(valueHash)
+}
+
+func (rv *Rendezvous[T]) Len() int {
+	return len(rv.values)
+}
+
+func (rv *Rendezvous[T]) Hash(s string) uint64 {
+	return rv.hash(s)
+}
+
+// Scores a value against a key, using the weighted scoring -weight/ln(u) for a uniform u in (0,1).
+// This gives each value a share of keys proportional to its weight.
+func (rv *Rendezvous[T]) score(key uint64, valueHash uint64) float64 {
+	// take the top 53 bits for a float in (0,1)
+	u := (float64(mix64(key^valueHash)>>11) + 0.5) / (1 << 53)
+	return -float64(rv.weights[valueHash]) / math.Log(u)
+}
+
+// Finds the highest scoring value for the key.
+// If accept rejects it, tries the values in order of decreasing score.
+func (rv *Rendezvous[T]) LookupFunc(key uint64, accept func(T) bool) (T, bool) {
+	var zero T
+
+	if len(rv.values) == 0 {
+		return zero, false
+	}
+
+	type scored struct {
+		valueHash uint64
+		score     float64
+	}
+
+	scores := make([]scored, 0, len(rv.values))
+	for valueHash := range rv.values {
+		scores = append(scores, scored{valueHash, rv.score(key, valueHash)})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+
+	for _, s := range scores {
+		value := rv.values[s.valueHash]
+		if accept == nil || accept(value) {
+			return value, true
+		}
+	}
+
+	return zero, false
+}
+
+func (rv *Rendezvous[T]) BoundedLookup(key uint64, epsilon float64, accept func(T) bool) (T, bool) {
+	return boundedLookup(rv.LookupFunc, &rv.loads, rv.valueHash, len(rv.values), key, epsilon, accept)
+}
+
+func (rv *Rendezvous[T]) IncrementLoad(value T) {
+	valueHash := rv.valueHash(value)
+	if _, ok := rv.values[valueHash]; ok {
+		rv.loads.increment(valueHash)
+	}
+}
+
+func (rv *Rendezvous[T]) DecrementLoad(value T) {
+	rv.loads.decrement(rv.valueHash(value))
+}
+
+func (rv *Rendezvous[T]) GetLoad(value T) int {
+	return rv.loads.get(rv.valueHash(value))
+}
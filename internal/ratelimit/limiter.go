@@ -0,0 +1,202 @@
+package ratelimit
+
+import (
+	"fmt"
+	"go-balancer/internal/balancer/config"
+	"go-balancer/internal/balancer/route"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How often idle buckets are swept from a rule.
+const bucketSweepInterval = time.Minute
+
+// A single rate limit rule, holding a token bucket for each key seen.
+type rule struct {
+	cfg config.RateLimitConfig
+
+	buckets map[string]*TokenBucket
+	// The last time full buckets were swept from the map
+	lastSweep time.Time
+
+	m sync.Mutex
+}
+
+// Gets the key of the bucket a request falls into.
+// Returns false if the rule does not apply to the request.
+func (rl *rule) keyOf(r *http.Request, routePrefix string) (string, bool) {
+	// matched on whole path segments, in the same way as route prefixes
+	if !route.HasPathPrefix(r.URL.Path, rl.cfg.PathPrefix) {
+		return "", false
+	}
+
+	switch rl.cfg.Key {
+	case "ip":
+		return clientIP(r), true
+	case "header":
+		// requests without the header are limited by client IP instead, so they dont all share one bucket
+		// the keys are kept apart, so a header value cant use up the bucket of an IP
+		value := r.Header.Get(rl.cfg.Header)
+		if value == "" {
+			return "ip:" + clientIP(r), true
+		}
+		return "header:" + value, true
+	case "route":
+		return routePrefix, true
+	}
+
+	return "", false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Gets the bucket for a key, creating it if there is none.
+func (rl *rule) bucket(key string) *TokenBucket {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	// forget about buckets which have refilled, as they are the same as a fresh one
+	if time.Since(rl.lastSweep) > bucketSweepInterval {
+		for k, tb := range rl.buckets {
+			if tb.full() {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = time.Now()
+	}
+
+	tb, ok := rl.buckets[key]
+	if !ok {
+		tb = NewTokenBucket(rl.cfg.Rate, rl.cfg.Burst)
+		rl.buckets[key] = tb
+	}
+
+	return tb
+}
+
+// Applies a set of rate limit rules to incoming requests.
+//
+// The rules can be replaced at runtime, which resets all buckets.
+type Limiter struct {
+	rules []*rule
+
+	m sync.RWMutex
+}
+
+// Creates a new limiter from a list of rate limit configs.
+// Returns an error if any config is invalid.
+func NewLimiter(cfgs []config.RateLimitConfig) (*Limiter, error) {
+	l := &Limiter{}
+
+	err := l.SetConfigs(cfgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Checks a config is valid, filling in the default burst if missing.
+func validateConfig(cfg *config.RateLimitConfig) error {
+	switch cfg.Key {
+	case "ip", "route":
+	case "header":
+		if cfg.Header == "" {
+			return fmt.Errorf("Rate limit keyed by header is missing a header name.")
+		}
+	default:
+		return fmt.Errorf("Unrecognized rate limit key '%s'.", cfg.Key)
+	}
+
+	if cfg.Rate <= 0 {
+		return fmt.Errorf("Rate limit rate must be positive, got %g.", cfg.Rate)
+	}
+
+	if cfg.Burst < 0 {
+		return fmt.Errorf("Rate limit burst must not be negative, got %d.", cfg.Burst)
+	}
+	if cfg.Burst == 0 {
+		cfg.Burst = int(math.Ceil(cfg.Rate))
+	}
+
+	return nil
+}
+
+// Replaces the current rules with new ones built from configs.
+// If any config is invalid, returns an error and keeps the current rules.
+func (l *Limiter) SetConfigs(cfgs []config.RateLimitConfig) error {
+	rules := make([]*rule, len(cfgs))
+
+	for i, cfg := range cfgs {
+		err := validateConfig(&cfg)
+		if err != nil {
+			return err
+		}
+
+		rules[i] = &rule{
+			cfg:       cfg,
+			buckets:   make(map[string]*TokenBucket),
+			lastSweep: time.Now(),
+		}
+	}
+
+	l.m.Lock()
+	l.rules = rules
+	l.m.Unlock()
+
+	return nil
+}
+
+// Gets the configs of the current rules.
+func (l *Limiter) GetConfigs() []config.RateLimitConfig {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	cfgs := make([]config.RateLimitConfig, len(l.rules))
+	for i, rl := range l.rules {
+		cfgs[i] = rl.cfg
+	}
+
+	return cfgs
+}
+
+// Checks whether a request is within all rate limits, taking a token from each applicable bucket.
+// If any rule rejects the request, the tokens taken from the others are given back,
+// so rejected requests dont use up the other buckets.
+//
+// routePrefix is the path prefix of the route the request matched, used by rules keyed by route.
+// If the request is rejected, also returns how long the client should wait before retrying.
+func (l *Limiter) Allow(r *http.Request, routePrefix string) (bool, time.Duration) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	taken := make([]*TokenBucket, 0, len(l.rules))
+
+	for _, rl := range l.rules {
+		key, applies := rl.keyOf(r, routePrefix)
+		if !applies {
+			continue
+		}
+
+		tb := rl.bucket(key)
+		ok, retryAfter := tb.Take()
+		if !ok {
+			for _, t := range taken {
+				t.Return()
+			}
+			return false, retryAfter
+		}
+		taken = append(taken, tb)
+	}
+
+	return true, 0
+}
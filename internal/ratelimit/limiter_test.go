@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"go-balancer/internal/balancer/config"
+	"net/http"
+	"testing"
+)
+
+func newRequest(remoteAddr string, path string, apiKey string) *http.Request {
+	r, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+	r.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		r.Header.Set("X-Api-Key", apiKey)
+	}
+	return r
+}
+
+func TestLimiterKeys(t *testing.T) {
+	l, err := NewLimiter([]config.RateLimitConfig{
+		{Key: "ip", Rate: 1, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating limiter: %s", err.Error())
+	}
+
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/", ""), ""); !ok {
+		t.Error("Failed ip limit: first request rejected")
+	}
+	// same ip, different port
+	if ok, retryAfter := l.Allow(newRequest("1.1.1.1:2000", "/", ""), ""); ok || retryAfter <= 0 {
+		t.Errorf("Failed ip limit: second request allowed, retry after %s", retryAfter)
+	}
+	if ok, _ := l.Allow(newRequest("2.2.2.2:1000", "/", ""), ""); !ok {
+		t.Error("Failed ip limit: other client rejected")
+	}
+
+	l.SetConfigs([]config.RateLimitConfig{
+		{Key: "header", Header: "X-Api-Key", Rate: 1, Burst: 1, PathPrefix: "/api"},
+	})
+
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/api/a", "key-a"), ""); !ok {
+		t.Error("Failed header limit: first request rejected")
+	}
+	if ok, _ := l.Allow(newRequest("2.2.2.2:1000", "/api/a", "key-a"), ""); ok {
+		t.Error("Failed header limit: same key from other client allowed")
+	}
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/api/a", "key-b"), ""); !ok {
+		t.Error("Failed header limit: other key rejected")
+	}
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/static", "key-a"), ""); !ok {
+		t.Error("Failed path prefix: request outside prefix rejected")
+	}
+	// the prefix is matched on whole segments, so /apiary isnt under /api
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/apiary", "key-a"), ""); !ok {
+		t.Error("Failed path prefix: request to /apiary limited by /api")
+	}
+
+	// requests without the header are limited by client IP
+	if ok, _ := l.Allow(newRequest("3.3.3.3:1000", "/api/a", ""), ""); !ok {
+		t.Error("Failed missing header: first request rejected")
+	}
+	if ok, _ := l.Allow(newRequest("3.3.3.3:2000", "/api/a", ""), ""); ok {
+		t.Error("Failed missing header: second request from same client allowed")
+	}
+	if ok, _ := l.Allow(newRequest("4.4.4.4:1000", "/api/a", ""), ""); !ok {
+		t.Error("Failed missing header: other client without the header rejected")
+	}
+	// a header value matching an IP doesnt share its bucket
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/api/a", "5.5.5.5"), ""); !ok {
+		t.Error("Failed header key: header value rejected")
+	}
+	if ok, _ := l.Allow(newRequest("5.5.5.5:1000", "/api/a", ""), ""); !ok {
+		t.Error("Failed missing header: client sharing a bucket with a header value")
+	}
+
+	l.SetConfigs([]config.RateLimitConfig{
+		{Key: "route", Rate: 1, Burst: 1},
+	})
+
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/a", ""), "/a"); !ok {
+		t.Error("Failed route limit: first request rejected")
+	}
+	if ok, _ := l.Allow(newRequest("2.2.2.2:1000", "/a", ""), "/a"); ok {
+		t.Error("Failed route limit: same route allowed")
+	}
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/b", ""), "/b"); !ok {
+		t.Error("Failed route limit: other route rejected")
+	}
+}
+
+// Tests a request rejected by one rule doesnt use up the buckets of the others.
+func TestLimiterRejectionRefunds(t *testing.T) {
+	l, err := NewLimiter([]config.RateLimitConfig{
+		{Key: "ip", Rate: 0.001, Burst: 2},
+		{Key: "route", Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating limiter: %s", err.Error())
+	}
+
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/a", ""), "/a"); !ok {
+		t.Fatal("Failed refunds: first request rejected")
+	}
+
+	// rejected by the route rule, after the ip rule has taken a token
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/a", ""), "/a"); ok {
+			t.Fatal("Failed refunds: request over the route limit allowed")
+		}
+	}
+
+	// the client still has its second token for another route
+	if ok, _ := l.Allow(newRequest("1.1.1.1:1000", "/b", ""), "/b"); !ok {
+		t.Error("Failed refunds: got the client's bucket used up by rejected requests expected a token left")
+	}
+}
+
+func TestLimiterInvalidConfigs(t *testing.T) {
+	invalid := []config.RateLimitConfig{
+		{Key: "nope", Rate: 1},
+		{Key: "header", Rate: 1},
+		{Key: "ip", Rate: 0},
+		{Key: "ip", Rate: 1, Burst: -1},
+	}
+
+	l, _ := NewLimiter([]config.RateLimitConfig{{Key: "ip", Rate: 5}})
+
+	for _, cfg := range invalid {
+		if err := l.SetConfigs([]config.RateLimitConfig{cfg}); err == nil {
+			t.Errorf("Failed invalid config: no error for %+v", cfg)
+		}
+	}
+
+	cfgs := l.GetConfigs()
+	if len(cfgs) != 1 || cfgs[0].Burst != 5 {
+		t.Errorf("Failed keep configs after invalid set: got %+v", cfgs)
+	}
+}
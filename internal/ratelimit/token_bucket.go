@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A token bucket rate limiter.
+//
+// The bucket holds up to burst tokens, and refills at rate tokens per second.
+// Each request takes a single token, and is rejected if there are none left.
+type TokenBucket struct {
+	// Tokens added per second
+	rate float64
+	// Maximum number of tokens the bucket can hold
+	burst float64
+
+	tokens float64
+	// The last time tokens were refilled
+	last time.Time
+
+	// Gets the current time, replaceable for testing
+	now func() time.Time
+
+	m sync.Mutex
+}
+
+// Creates a new, full, token bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return newTokenBucketWithClock(rate, burst, time.Now)
+}
+
+func newTokenBucketWithClock(rate float64, burst int, now func() time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+	}
+}
+
+// Adds the tokens accumulated since the last refill.
+// Assumes the caller holds the lock.
+func (tb *TokenBucket) refill() {
+	now := tb.now()
+
+	elapsed := now.Sub(tb.last).Seconds()
+	if elapsed > 0 {
+		tb.tokens = math.Min(tb.burst, tb.tokens+elapsed*tb.rate)
+		tb.last = now
+	}
+}
+
+// Attempts to take a token from the bucket.
+//
+// Returns true if a token was taken.
+// Otherwise returns false, with the time until a token will be available.
+func (tb *TokenBucket) Take() (bool, time.Duration) {
+	tb.m.Lock()
+	defer tb.m.Unlock()
+
+	tb.refill()
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true, 0
+	}
+
+	wait := (1 - tb.tokens) / tb.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Gives back a token taken from the bucket, for a request which was rejected by something else.
+func (tb *TokenBucket) Return() {
+	tb.m.Lock()
+	defer tb.m.Unlock()
+
+	tb.refill()
+
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+// Reports whether a token could currently be taken, without taking it.
+func (tb *TokenBucket) Available() bool {
+	tb.m.Lock()
+	defer tb.m.Unlock()
+
+	tb.refill()
+
+	return tb.tokens >= 1
+}
+
+// Reports whether the bucket is full, so is indistinguishable from a new bucket.
+func (tb *TokenBucket) full() bool {
+	tb.m.Lock()
+	defer tb.m.Unlock()
+
+	tb.refill()
+
+	return tb.tokens >= tb.burst
+}
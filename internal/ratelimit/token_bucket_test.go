@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	tb := newTokenBucketWithClock(2, 3, clock)
+
+	// should allow the full burst straight away
+	for i := 0; i < 3; i++ {
+		if ok, _ := tb.Take(); !ok {
+			t.Errorf("Failed take within burst: request %d rejected", i)
+		}
+	}
+
+	ok, retryAfter := tb.Take()
+	if ok {
+		t.Error("Failed take over burst: expected rejection")
+	}
+	if retryAfter != time.Millisecond*500 {
+		t.Errorf("Failed retry after: got %s expected 500ms", retryAfter)
+	}
+	if tb.Available() {
+		t.Error("Failed available when empty: expected false")
+	}
+
+	// half a second at 2/s refills a single token
+	now = now.Add(time.Millisecond * 500)
+	if !tb.Available() {
+		t.Error("Failed available after refill: expected true")
+	}
+	if ok, _ := tb.Take(); !ok {
+		t.Error("Failed take after refill: expected success")
+	}
+	if ok, _ := tb.Take(); ok {
+		t.Error("Failed take after refill: expected only one token")
+	}
+
+	// a long wait should only refill up to the burst
+	now = now.Add(time.Hour)
+	if !tb.full() {
+		t.Error("Failed refill to full after long wait")
+	}
+	for i := 0; i < 3; i++ {
+		tb.Take()
+	}
+	if ok, _ := tb.Take(); ok {
+		t.Error("Failed refill capped at burst: expected rejection")
+	}
+}